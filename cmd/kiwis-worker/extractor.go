@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/llm/anthropic"
+	"github.com/vipul43/kiwis-worker/internal/llm/ollama"
+	"github.com/vipul43/kiwis-worker/internal/llm/openai"
+	"github.com/vipul43/kiwis-worker/internal/openrouter"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// newExtractor creates the LLM backend selected by LLM_PROVIDER (validated by config.Load)
+func newExtractor(cfg *config.Config) service.Extractor {
+	log.Printf("Using %s LLM backend for payment extraction", cfg.LLMProvider)
+
+	switch cfg.LLMProvider {
+	case config.LLMProviderOpenAI:
+		return openai.NewClient(cfg.LLMBaseURL, cfg.LLMAPIKey, cfg.LLMModel)
+	case config.LLMProviderOllama:
+		return ollama.NewClient(cfg.LLMBaseURL, cfg.LLMModel)
+	case config.LLMProviderAnthropic:
+		return anthropic.NewClient(cfg.LLMBaseURL, cfg.LLMAPIKey, cfg.LLMModel)
+	default:
+		client := openrouter.NewClient(cfg.OpenRouterAPIKey)
+		if cfg.LLMModel != "" {
+			client.SetModel(cfg.LLMModel)
+		}
+		return client
+	}
+}
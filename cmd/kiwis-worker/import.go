@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/database"
+	"github.com/vipul43/kiwis-worker/internal/mailimport"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// importBatchSize is how many emails an import holds in memory and hands to the LLM processor at once
+const importBatchSize = 100
+
+// runImport runs payment extraction on .eml files and mbox archives for an account (kiwis-worker import)
+// Emails are streamed from the files in batches, only those matching the payment keywords are sent to the LLM
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	accountID := flags.String("account", "", "account ID the payments are recorded for (required)")
+	dryRun := flags.Bool("dry-run", false, "print extracted payments without recording them")
+	all := flags.Bool("all", false, "send every email to the LLM, not only those matching the payment keywords")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: kiwis-worker import -account <id> [-dry-run] [-all] <file.eml|archive.mbox|dir>...")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *accountID == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("import needs an account ID and at least one file")
+	}
+	for _, path := range flags.Args() {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Payments belong to an existing account
+	if _, err := repository.NewAccountRepository(db).GetByID(ctx, *accountID); err != nil {
+		return fmt.Errorf("failed to get account %s: %w", *accountID, err)
+	}
+
+	// Attachments are read from the archive instead of the account's mailbox
+	archive := mailimport.NewArchive()
+	paymentRepo := repository.NewPaymentRepository(db)
+	llmProcessor := service.NewLLMProcessor(
+		repository.NewLLMSyncJobRepository(db),
+		paymentRepo,
+		archive,
+		newExtractor(cfg),
+		service.NewMerchantNormaliser(repository.NewMerchantRepository(db)),
+		service.NewReconciler(paymentRepo, repository.NewPaymentLinkRepository(db)),
+		service.NewRetryPolicy(cfg.MaxRetries),
+	)
+
+	query := service.SearchQuery{Keywords: service.PaymentKeywords}
+	if *all {
+		query.Keywords = nil
+	}
+
+	var read, skipped, processed, extracted, stored, failed int
+	process := func() error {
+		if archive.Len() == 0 {
+			return nil
+		}
+		result, err := llmProcessor.ProcessEmails(ctx, *accountID, archive.Messages(), *dryRun)
+		archive.Reset()
+		if result != nil {
+			for _, payment := range result.Payments {
+				fmt.Printf("%s\t%s\t%s\t%s\n", payment.Date.Format("2006-01-02"), payment.Merchant, payment.Money(), payment.Status)
+			}
+			for messageID, failure := range result.Failed {
+				log.Printf("Failed to process %s: %v", messageID, failure)
+			}
+			processed += result.Processed
+			extracted += len(result.Payments)
+			stored += result.Stored
+			failed += len(result.Failed)
+		}
+		return err
+	}
+
+	err = mailimport.Read(flags.Args(), func(name string, raw []byte) error {
+		read++
+		msg, err := mailimport.Parse(raw)
+		if err != nil {
+			log.Printf("Failed to parse %s: %v", name, err)
+			failed++
+			return nil
+		}
+		if !mailimport.Matches(query, msg) {
+			skipped++
+			return nil
+		}
+		if err := archive.Add(msg, raw); errors.Is(err, mailimport.ErrDuplicate) {
+			log.Printf("Skipping %s: %v", name, err)
+			skipped++
+			return nil
+		}
+		if archive.Len() < importBatchSize {
+			return nil
+		}
+		return process()
+	})
+	if err == nil {
+		err = process()
+	}
+
+	log.Printf("Read %d emails, skipped %d (no payment keywords or duplicates), processed %d, failed %d, extracted %d payments, stored %d (dry run: %t)",
+		read, skipped, processed, failed, extracted, stored, *dryRun)
+	return err
+}
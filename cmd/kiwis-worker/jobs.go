@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/database"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+)
+
+const jobsUsage = `Usage:
+  kiwis-worker jobs dead [-stage account|email|llm] [-limit n]`
+
+// runJobs inspects sync jobs, listing the dead ones with their last error (kiwis-worker jobs)
+func runJobs(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, jobsUsage)
+		return fmt.Errorf("jobs needs a command")
+	}
+	command, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("jobs "+command, flag.ExitOnError)
+	stage := flags.String("stage", "", "stage whose jobs are listed (account, email or llm), empty for all (dead)")
+	limit := flags.Int("limit", 50, "maximum number of jobs listed per stage (dead)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), jobsUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if command != "dead" || flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("invalid jobs command %q", command)
+	}
+	switch *stage {
+	case "", "account", "email", "llm":
+	default:
+		flags.Usage()
+		return fmt.Errorf("invalid stage %q", *stage)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *stage == "" || *stage == "account" {
+		jobs, err := repository.NewAccountSyncJobRepository(db).GetDeadJobs(ctx, *limit)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			printDeadJob("account", job.ID, job.AccountID, "", job.Attempts, job.UpdatedAt, job.LastError)
+		}
+	}
+	if *stage == "" || *stage == "email" {
+		jobs, err := repository.NewEmailSyncJobRepository(db).GetDeadJobs(ctx, *limit)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			printDeadJob("email", job.ID, job.AccountID, "", job.Attempts, job.UpdatedAt, job.LastError)
+		}
+	}
+	if *stage == "" || *stage == "llm" {
+		jobs, err := repository.NewLLMSyncJobRepository(db).GetDeadJobs(ctx, *limit)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			printDeadJob("llm", job.ID, job.AccountID, job.MessageID, job.Attempts, job.UpdatedAt, job.LastError)
+		}
+	}
+	return nil
+}
+
+// printDeadJob prints one dead job as a tab-separated line (message ID only for LLM jobs)
+func printDeadJob(stage, id, accountID, messageID string, attempts int, updatedAt time.Time, lastError *string) {
+	errMsg := ""
+	if lastError != nil {
+		errMsg = *lastError
+	}
+	fmt.Printf("%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+		stage, id, accountID, messageID, attempts, updatedAt.Format(time.RFC3339), errMsg)
+}
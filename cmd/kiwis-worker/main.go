@@ -3,22 +3,45 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"github.com/vipul43/kiwis-worker/internal/config"
 	"github.com/vipul43/kiwis-worker/internal/database"
 	"github.com/vipul43/kiwis-worker/internal/gmail"
-	"github.com/vipul43/kiwis-worker/internal/openrouter"
+	"github.com/vipul43/kiwis-worker/internal/graph"
+	"github.com/vipul43/kiwis-worker/internal/imap"
+	"github.com/vipul43/kiwis-worker/internal/mailsource"
+	"github.com/vipul43/kiwis-worker/internal/models"
 	"github.com/vipul43/kiwis-worker/internal/repository"
 	"github.com/vipul43/kiwis-worker/internal/service"
 	"github.com/vipul43/kiwis-worker/internal/watcher"
+	"github.com/vipul43/kiwis-worker/internal/webhook"
 )
 
+// shutdownGracePeriod is how long to wait for cancelled jobs to return after the shutdown timeout
+const shutdownGracePeriod = 5 * time.Second
+
 func main() {
-	if err := run(); err != nil {
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "import":
+		err = runImport(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "reconcile":
+		err = runReconcile(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "merchants":
+		err = runMerchants(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "jobs":
+		err = runJobs(os.Args[2:])
+	default:
+		err = run()
+	}
+	if err != nil {
 		log.Fatalf("Application error: %v", err)
 	}
 }
@@ -56,24 +79,82 @@ func run() error {
 	llmJobRepo := repository.NewLLMSyncJobRepository(db)
 	accountRepo := repository.NewAccountRepository(db)
 	paymentRepo := repository.NewPaymentRepository(db)
+	imapSettingsRepo := repository.NewIMAPSettingsRepository(db)
+	paymentLinkRepo := repository.NewPaymentLinkRepository(db)
+	merchantRepo := repository.NewMerchantRepository(db)
 
 	// Initialize services
 	accountProcessor := service.NewAccountProcessor(accountRepo)
 
-	// Initialize Gmail client
-	gmailClient := gmail.NewClient(cfg.GoogleClientID, cfg.GoogleClientSecret)
-	emailProcessor := service.NewEmailProcessor(accountRepo, emailJobRepo, llmJobRepo, gmailClient)
-
-	// Initialize OpenRouter client
-	openRouterClient := openrouter.NewClient(cfg.OpenRouterAPIKey)
-	llmProcessor := service.NewLLMProcessor(accountRepo, llmJobRepo, paymentRepo, gmailClient, openRouterClient)
+	// Initialize mail sources, each account is synced from the source of its provider (account.providerId)
+	// One cached Gmail service per account, tokens are refreshed and persisted to the account automatically
+	gmailClient := gmail.NewClient(cfg.GoogleClientID, cfg.GoogleClientSecret, accountRepo)
+	graphClient := graph.NewClient(cfg.MicrosoftClientID, cfg.MicrosoftClientSecret, cfg.MicrosoftTenant, accountRepo)
+	// IMAP XOAUTH2 tokens (imap.gmail.com, outlook.office365.com) are refreshed with the same OAuth apps
+	imapClient := imap.NewClient(accountRepo, imapSettingsRepo, map[string]*oauth2.Config{
+		models.ProviderGoogle:    gmailClient.OAuthConfig(),
+		models.ProviderMicrosoft: graphClient.OAuthConfig(),
+	})
+	mailSource := mailsource.NewRouter(accountRepo, map[string]service.MailSource{
+		models.ProviderGoogle:    gmailClient,
+		models.ProviderMicrosoft: graphClient,
+		models.ProviderIMAP:      imapClient,
+	})
+	emailProcessor := service.NewEmailProcessor(emailJobRepo, llmJobRepo, mailSource, cfg.GmailPubSubTopic)
+
+	// Initialize LLM backend
+	// Payments are linked to their canonical merchants, and paid payments to the bills they settle, as they are extracted
+	normaliser := service.NewMerchantNormaliser(merchantRepo)
+	reconciler := service.NewReconciler(paymentRepo, paymentLinkRepo)
+	llmProcessor := service.NewLLMProcessor(llmJobRepo, paymentRepo, mailSource, newExtractor(cfg), normaliser, reconciler, service.NewRetryPolicy(cfg.MaxRetries))
+
+	// Initialize payment status aging (upcoming → due → overdue)
+	paymentStatusProcessor := service.NewPaymentStatusProcessor(paymentRepo)
+
+	// Initialize recurring payment detection (projected payments for the next occurrences)
+	recurrenceDetector := service.NewRecurrenceDetector(repository.NewRecurringPaymentRepository(db))
+
+	// Initialize notification listener (dedicated connection for LISTEN/NOTIFY wake-ups)
+	listener := database.NewListener(cfg.DatabaseURL,
+		repository.ChannelAccountSyncJob,
+		repository.ChannelEmailSyncJob,
+		repository.ChannelLLMSyncJob,
+	)
 
 	// Initialize watcher
-	w := watcher.New(cfg, accountJobRepo, emailJobRepo, llmJobRepo, accountProcessor, emailProcessor, llmProcessor)
+	w := watcher.New(cfg, accountJobRepo, emailJobRepo, llmJobRepo, accountProcessor, emailProcessor, llmProcessor, paymentStatusProcessor, recurrenceDetector, listener)
 
 	// Setup graceful shutdown
+	// ctx stops claiming new jobs, jobCtx is only cancelled if in-flight jobs don't drain in time
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// Start listener in goroutine (reconnects on its own, watcher falls back to polling meanwhile)
+	go func() {
+		if err := listener.Run(ctx); err != nil && err != context.Canceled {
+			log.Printf("Notification listener error: %v", err)
+		}
+	}()
+
+	// Start Pub/Sub push endpoint for Gmail notifications (only with push notifications enabled)
+	var webhookServer *http.Server
+	if cfg.GmailPubSubTopic != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/webhooks/gmail", webhook.NewHandler(cfg.WebhookToken, emailJobRepo))
+		webhookServer = &http.Server{
+			Addr:              cfg.WebhookAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("Listening for Gmail push notifications on %s", cfg.WebhookAddr)
+			if err := webhookServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Webhook server error: %v", err)
+			}
+		}()
+	}
 
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
@@ -82,22 +163,38 @@ func run() error {
 	// Start watcher in goroutine
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- w.Start(ctx)
+		errChan <- w.Start(ctx, jobCtx)
 	}()
 
 	// Wait for shutdown signal or error
 	select {
 	case <-sigChan:
-		log.Println("Shutdown signal received")
+		log.Println("Shutdown signal received, draining in-flight jobs...")
 		cancel()
 
-		// Wait for graceful shutdown
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
-		defer shutdownCancel()
+		// Stop accepting push notifications (Pub/Sub redelivers anything not acknowledged)
+		if webhookServer != nil {
+			webhookCtx, webhookCancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+			if err := webhookServer.Shutdown(webhookCtx); err != nil {
+				log.Printf("Webhook server shutdown error: %v", err)
+			}
+			webhookCancel()
+		}
+
+		// Wait for in-flight jobs to drain
+		shutdownTimer := time.NewTimer(time.Duration(cfg.ShutdownTimeout) * time.Second)
+		defer shutdownTimer.Stop()
 
 		select {
-		case <-shutdownCtx.Done():
-			log.Println("Shutdown timeout exceeded")
+		case <-shutdownTimer.C:
+			// Cancel in-flight jobs; their leases expire and another worker picks them up
+			log.Println("Shutdown timeout exceeded, cancelling in-flight jobs")
+			cancelJobs()
+			select {
+			case <-errChan:
+			case <-time.After(shutdownGracePeriod):
+				log.Println("In-flight jobs did not stop after cancellation")
+			}
 		case err := <-errChan:
 			if err != nil && err != context.Canceled {
 				log.Printf("Watcher error: %v", err)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/database"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const merchantsUsage = `Usage:
+  kiwis-worker merchants list
+  kiwis-worker merchants backfill [-all]`
+
+// runMerchants lists the canonical merchants and links stored payments to them (kiwis-worker merchants)
+func runMerchants(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, merchantsUsage)
+		return fmt.Errorf("merchants needs a command")
+	}
+	command, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("merchants "+command, flag.ExitOnError)
+	all := flags.Bool("all", false, "relink payments already linked to a merchant (backfill)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), merchantsUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	merchantRepo := repository.NewMerchantRepository(db)
+
+	switch {
+	case command == "list" && flags.NArg() == 0:
+		merchants, err := merchantRepo.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, merchant := range merchants {
+			category := ""
+			if merchant.DefaultCategory != nil {
+				category = *merchant.DefaultCategory
+			}
+			aliases := make([]string, 0, len(merchant.Aliases))
+			for _, alias := range merchant.Aliases {
+				aliases = append(aliases, alias.Alias)
+			}
+			domains := make([]string, 0, len(merchant.Domains))
+			for _, domain := range merchant.Domains {
+				domains = append(domains, domain.Domain)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", merchant.ID, merchant.Name, category,
+				strings.Join(aliases, ", "), strings.Join(domains, ", "))
+		}
+		return nil
+	case command == "backfill" && flags.NArg() == 0:
+		linked, err := service.NewMerchantNormaliser(merchantRepo).Backfill(ctx, *all)
+		log.Printf("Linked %d payments to their merchants (all: %t)", linked, *all)
+		return err
+	}
+
+	flags.Usage()
+	return fmt.Errorf("invalid merchants command %q", command)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/database"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const reconcileUsage = `Usage:
+  kiwis-worker reconcile list -account <id> [-status suggested|linked|rejected]
+  kiwis-worker reconcile link <obligation-id> <payment-id>
+  kiwis-worker reconcile confirm <link-id>
+  kiwis-worker reconcile reject <link-id>`
+
+// runReconcile reviews and overrides the links between bills and the payments settling them (kiwis-worker reconcile)
+func runReconcile(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, reconcileUsage)
+		return fmt.Errorf("reconcile needs a command")
+	}
+	command, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("reconcile "+command, flag.ExitOnError)
+	accountID := flags.String("account", "", "account ID whose links are listed (list)")
+	status := flags.String("status", models.PaymentLinkSuggested, "status of the listed links, empty for all (list)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), reconcileUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	linkRepo := repository.NewPaymentLinkRepository(db)
+	reconciler := service.NewReconciler(repository.NewPaymentRepository(db), linkRepo)
+
+	switch {
+	case command == "list" && *accountID != "":
+		links, err := linkRepo.ListByAccount(ctx, *accountID, *status)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			reasons := ""
+			if link.Reasons != nil {
+				reasons = *link.Reasons
+			}
+			fmt.Printf("%s\t%s\t%s settles %s as %s\t%.2f\t%s\n",
+				link.ID, link.Status, link.SettlementID, link.ObligationID, link.Settles, link.Confidence, reasons)
+		}
+		return nil
+	case command == "link" && flags.NArg() == 2:
+		link, err := reconciler.Link(ctx, flags.Arg(0), flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Linked %s as settling %s (%s)\n", link.SettlementID, link.ObligationID, link.Settles)
+		return nil
+	case command == "confirm" && flags.NArg() == 1:
+		if err := reconciler.Confirm(ctx, flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("Confirmed %s\n", flags.Arg(0))
+		return nil
+	case command == "reject" && flags.NArg() == 1:
+		if err := reconciler.Reject(ctx, flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("Rejected %s\n", flags.Arg(0))
+		return nil
+	}
+
+	flags.Usage()
+	return fmt.Errorf("invalid reconcile command %q", command)
+}
@@ -0,0 +1,151 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+)
+
+const (
+	MaxSize     = 5 * 1024 * 1024 // Attachments larger than 5 MB are not downloaded
+	MaxPDFPages = 10              // Invoices put the amount due on the first pages, later pages are skipped
+	MaxChars    = 5000            // Extracted text is truncated to 5000 characters
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported attachment type")
+	ErrTooLarge    = errors.New("attachment too large")
+)
+
+// Kind is the text format an attachment is extracted as
+type Kind string
+
+const (
+	KindPDF  Kind = "pdf"
+	KindText Kind = "text" // Plain text and CSV
+)
+
+// Detect returns how text is extracted from an attachment with the given filename and MIME type
+// Falls back to the file extension since mail clients often send application/octet-stream
+func Detect(filename, mimeType string) (Kind, bool) {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0])) {
+	case "application/pdf", "application/x-pdf":
+		return KindPDF, true
+	case "text/plain", "text/csv", "application/csv", "text/comma-separated-values":
+		return KindText, true
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf":
+		return KindPDF, true
+	case ".txt", ".csv":
+		return KindText, true
+	}
+	return "", false
+}
+
+// ExtractText returns the text of a PDF, plain-text or CSV attachment, truncated to MaxChars
+func ExtractText(filename, mimeType string, data []byte) (string, error) {
+	if len(data) > MaxSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrTooLarge, len(data))
+	}
+
+	kind, ok := Detect(filename, mimeType)
+	if !ok {
+		return "", fmt.Errorf("%w: %s (%s)", ErrUnsupported, filename, mimeType)
+	}
+
+	var text string
+	var err error
+	switch kind {
+	case KindPDF:
+		text, err = pdfText(data)
+	case KindText:
+		text = plainText(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return emailtext.Truncate(normalizeSpace(text), MaxChars), nil
+}
+
+// pdfText extracts the text of the first MaxPDFPages pages
+// Scanned PDFs (images only) yield no text
+func pdfText(data []byte) (text string, err error) {
+	// The PDF reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("failed to read PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	var buf strings.Builder
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= min(reader.NumPage(), MaxPDFPages); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+
+		pageText, err := page.GetPlainText(fonts)
+		if err != nil {
+			return "", fmt.Errorf("failed to extract text from PDF page %d: %w", i, err)
+		}
+		buf.WriteString(pageText)
+		buf.WriteString("\n")
+
+		if buf.Len() > MaxChars*4 {
+			break // Plenty of text already, spare the remaining pages
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// plainText returns text attachments as a string, dropping invalid UTF-8 and a byte order mark
+func plainText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "")
+}
+
+// normalizeSpace trims trailing spaces and collapses runs of blank lines
+func normalizeSpace(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,148 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF returns a minimal one-page PDF showing each line with the Helvetica font
+func buildPDF(lines ...string) []byte {
+	var content strings.Builder
+	content.WriteString("BT /F1 12 Tf 72 720 Td 14 TL\n")
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", line)
+	}
+	content.WriteString("ET")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+func TestExtractText_PDF(t *testing.T) {
+	data := buildPDF("Electricity Bill", "Amount due: 1234.50 INR", "Due date: 15 Nov 2025")
+
+	text, err := ExtractText("invoice.pdf", "application/pdf", data)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	compact := strings.Join(strings.Fields(text), " ")
+	for _, want := range []string{"Electricity Bill", "Amount due: 1234.50 INR", "Due date: 15 Nov 2025"} {
+		if !strings.Contains(compact, want) {
+			t.Errorf("expected text to contain %q, got %q", want, text)
+		}
+	}
+}
+
+func TestExtractText_PDFByExtension(t *testing.T) {
+	data := buildPDF("Invoice 42")
+
+	text, err := ExtractText("INVOICE.PDF", "application/octet-stream", data)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(text, "Invoice 42") {
+		t.Errorf("expected text to contain 'Invoice 42', got %q", text)
+	}
+}
+
+func TestExtractText_InvalidPDF(t *testing.T) {
+	if _, err := ExtractText("broken.pdf", "application/pdf", []byte("%PDF-1.4 not really a pdf")); err == nil {
+		t.Error("expected error for invalid PDF, got nil")
+	}
+}
+
+func TestExtractText_PlainText(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		mimeType string
+		data     string
+		want     string
+	}{
+		{"plain text", "bill.txt", "text/plain", "Total: 499.00\r\n\r\n\r\nThanks", "Total: 499.00\n\nThanks"},
+		{"csv", "statement.csv", "text/csv; charset=utf-8", "date,amount\n2025-11-01,120.00\n", "date,amount\n2025-11-01,120.00"},
+		{"byte order mark", "bill.txt", "text/plain", "\xef\xbb\xbfTotal: 10", "Total: 10"},
+		{"invalid utf-8", "bill.txt", "text/plain", "Total:\xff 10", "Total: 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractText(tt.filename, tt.mimeType, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractText_Truncates(t *testing.T) {
+	text, err := ExtractText("long.txt", "text/plain", []byte(strings.Repeat("é", MaxChars+100)))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := len([]rune(text)); got != MaxChars {
+		t.Errorf("expected %d characters, got %d", MaxChars, got)
+	}
+}
+
+func TestExtractText_Limits(t *testing.T) {
+	if _, err := ExtractText("logo.png", "image/png", []byte("png")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	if _, err := ExtractText("huge.txt", "text/plain", make([]byte, MaxSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		filename string
+		mimeType string
+		want     Kind
+		wantOK   bool
+	}{
+		{"invoice.pdf", "application/pdf", KindPDF, true},
+		{"invoice", "application/x-pdf", KindPDF, true},
+		{"invoice.pdf", "application/octet-stream", KindPDF, true},
+		{"statement.csv", "application/vnd.ms-excel", KindText, true},
+		{"notes.txt", "", KindText, true},
+		{"photo.jpg", "image/jpeg", "", false},
+		{"invoice.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Detect(tt.filename, tt.mimeType)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Detect(%q, %q) = %q, %v; want %q, %v", tt.filename, tt.mimeType, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -3,20 +3,52 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DatabaseURL        string
-	PollInterval       int // seconds
-	MaxRetries         int
-	ShutdownTimeout    int // seconds
-	GoogleClientID     string
-	GoogleClientSecret string
-	OpenRouterAPIKey   string
+	DatabaseURL             string
+	PollInterval            int // seconds, default for the per-stage poll intervals (POLL_INTERVAL)
+	AccountPollInterval     int // seconds
+	EmailPollInterval       int // seconds
+	LLMPollInterval         int // seconds
+	AccountWorkers          int // concurrent account sync jobs
+	EmailWorkers            int // concurrent Gmail fetchers (email sync jobs)
+	LLMWorkers              int // concurrent LLM batches
+	IncrementalSyncInterval int // seconds, synced email jobs are re-synced via Gmail History API after this
+	PaymentStatusInterval   int // seconds, how often upcoming payments are moved to due and overdue
+	RecurrenceInterval      int // seconds, how often recurring payments are detected and projected
+	MaxRetries              int
+	ShutdownTimeout         int // seconds, in-flight jobs are cancelled after this
+	JobLeaseDuration        int // seconds, claimed jobs are reclaimable once their lease expires
+	GoogleClientID          string
+	GoogleClientSecret      string
+	MicrosoftClientID       string // Optional: Azure AD app for Microsoft accounts (Microsoft Graph)
+	MicrosoftClientSecret   string
+	MicrosoftTenant         string // Azure AD tenant tokens are refreshed with, "common" for personal and work accounts
+	OpenRouterAPIKey        string
+	LLMProvider             string // LLM backend for payment extraction: openrouter, openai, ollama or anthropic
+	LLMBaseURL              string // API base URL, required for openai, optional for ollama and anthropic
+	LLMAPIKey               string // API key for openai and anthropic
+	LLMModel                string // Optional for openrouter and openai: model name, the server's default otherwise
+	GmailPubSubTopic        string // Optional: Pub/Sub topic for Gmail push notifications (projects/<project>/topics/<topic>)
+	WebhookAddr             string // listen address for the Pub/Sub push endpoint
+	WebhookToken            string // shared secret the push subscription sends as ?token=
+	WorkerID                string // identifies this worker instance when claiming jobs
 }
 
+const defaultPollInterval = 10 // seconds
+
+// LLM backends for payment extraction
+const (
+	LLMProviderOpenRouter = "openrouter"
+	LLMProviderOpenAI     = "openai" // Any OpenAI-compatible chat completions API (OpenAI, vLLM, llama.cpp, LM Studio)
+	LLMProviderOllama     = "ollama"
+	LLMProviderAnthropic  = "anthropic"
+)
+
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
 	// Load .env file if exists (ignore error in production)
@@ -33,18 +65,153 @@ func Load() (*Config, error) {
 		fmt.Println("Warning: GOOGLE_CLIENT_ID or GOOGLE_CLIENT_SECRET not set, Gmail API will not work")
 	}
 
+	// Microsoft accounts are optional, without an Azure AD app their tokens can't be refreshed
+	microsoftTenant := os.Getenv("MICROSOFT_TENANT")
+	if microsoftTenant == "" {
+		microsoftTenant = "common"
+	}
+
+	// LLM backend, OpenRouter unless configured otherwise
+	llmProvider := os.Getenv("LLM_PROVIDER")
+	if llmProvider == "" {
+		llmProvider = LLMProviderOpenRouter
+	}
+	llmBaseURL := os.Getenv("LLM_BASE_URL")
+	llmAPIKey := os.Getenv("LLM_API_KEY")
+	llmModel := os.Getenv("LLM_MODEL")
 	openRouterAPIKey := os.Getenv("OPENROUTER_API_KEY")
-	if openRouterAPIKey == "" {
-		fmt.Println("Warning: OPENROUTER_API_KEY not set, LLM payment extraction will not work")
+
+	switch llmProvider {
+	case LLMProviderOpenRouter:
+		if openRouterAPIKey == "" {
+			fmt.Println("Warning: OPENROUTER_API_KEY not set, LLM payment extraction will not work")
+		}
+	case LLMProviderOpenAI:
+		if llmBaseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required when LLM_PROVIDER is %s", llmProvider)
+		}
+	case LLMProviderOllama:
+		if llmModel == "" {
+			return nil, fmt.Errorf("LLM_MODEL is required when LLM_PROVIDER is %s", llmProvider)
+		}
+	case LLMProviderAnthropic:
+		if llmModel == "" || llmAPIKey == "" {
+			return nil, fmt.Errorf("LLM_MODEL and LLM_API_KEY are required when LLM_PROVIDER is %s", llmProvider)
+		}
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q (openrouter, openai, ollama or anthropic)", llmProvider)
+	}
+
+	// Gmail push notifications are optional, the incremental sync interval is used without them
+	gmailPubSubTopic := os.Getenv("GMAIL_PUBSUB_TOPIC")
+	webhookToken := os.Getenv("WEBHOOK_TOKEN")
+	if gmailPubSubTopic != "" && webhookToken == "" {
+		return nil, fmt.Errorf("WEBHOOK_TOKEN is required when GMAIL_PUBSUB_TOPIC is set")
+	}
+
+	webhookAddr := os.Getenv("WEBHOOK_ADDR")
+	if webhookAddr == "" {
+		webhookAddr = ":8080"
+	}
+
+	workerID := os.Getenv("WORKER_ID")
+	if workerID == "" {
+		workerID = defaultWorkerID()
+	}
+
+	// Per-stage poll intervals (POLL_INTERVAL unless set per stage) and worker pool sizes
+	pollInterval, err := getEnvInt("POLL_INTERVAL", defaultPollInterval)
+	if err != nil {
+		return nil, err
+	}
+	accountPollInterval, err := getEnvInt("ACCOUNT_POLL_INTERVAL", pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	emailPollInterval, err := getEnvInt("EMAIL_POLL_INTERVAL", pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	llmPollInterval, err := getEnvInt("LLM_POLL_INTERVAL", pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	accountWorkers, err := getEnvInt("ACCOUNT_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	emailWorkers, err := getEnvInt("EMAIL_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	llmWorkers, err := getEnvInt("LLM_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	incrementalSyncInterval, err := getEnvInt("INCREMENTAL_SYNC_INTERVAL", 300)
+	if err != nil {
+		return nil, err
+	}
+	paymentStatusInterval, err := getEnvInt("PAYMENT_STATUS_INTERVAL", 3600)
+	if err != nil {
+		return nil, err
+	}
+	recurrenceInterval, err := getEnvInt("RECURRENCE_INTERVAL", 21600)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Config{
-		DatabaseURL:        dbURL,
-		PollInterval:       10, // poll every 10 seconds
-		MaxRetries:         3,
-		ShutdownTimeout:    30,
-		GoogleClientID:     googleClientID,
-		GoogleClientSecret: googleClientSecret,
-		OpenRouterAPIKey:   openRouterAPIKey,
+		DatabaseURL:             dbURL,
+		PollInterval:            pollInterval,
+		AccountPollInterval:     accountPollInterval,
+		EmailPollInterval:       emailPollInterval,
+		LLMPollInterval:         llmPollInterval,
+		AccountWorkers:          accountWorkers,
+		EmailWorkers:            emailWorkers,
+		LLMWorkers:              llmWorkers,
+		IncrementalSyncInterval: incrementalSyncInterval,
+		PaymentStatusInterval:   paymentStatusInterval,
+		RecurrenceInterval:      recurrenceInterval,
+		MaxRetries:              3,
+		ShutdownTimeout:         30,
+		JobLeaseDuration:        120, // heartbeat extends the lease every 40 seconds
+		GoogleClientID:          googleClientID,
+		GoogleClientSecret:      googleClientSecret,
+		MicrosoftClientID:       os.Getenv("MICROSOFT_CLIENT_ID"),
+		MicrosoftClientSecret:   os.Getenv("MICROSOFT_CLIENT_SECRET"),
+		MicrosoftTenant:         microsoftTenant,
+		OpenRouterAPIKey:        openRouterAPIKey,
+		LLMProvider:             llmProvider,
+		LLMBaseURL:              llmBaseURL,
+		LLMAPIKey:               llmAPIKey,
+		LLMModel:                llmModel,
+		GmailPubSubTopic:        gmailPubSubTopic,
+		WebhookAddr:             webhookAddr,
+		WebhookToken:            webhookToken,
+		WorkerID:                workerID,
 	}, nil
 }
+
+// getEnvInt reads a positive integer from the environment, returning fallback if unset
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, value)
+	}
+	return n, nil
+}
+
+// defaultWorkerID builds a worker ID from hostname and process ID
+// Unique per process, so multiple replicas on the same host don't collide
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "kiwis-worker"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	listenerMinBackoff = 1 * time.Second
+	listenerMaxBackoff = 30 * time.Second
+)
+
+// Listener holds a dedicated connection that LISTENs on Postgres NOTIFY channels
+// Notifications are coalesced into one pending wake-up per channel
+type Listener struct {
+	databaseURL string
+	channels    []string
+	wake        map[string]chan struct{}
+}
+
+// NewListener creates a listener for the given channels (call Run to start listening)
+func NewListener(databaseURL string, channels ...string) *Listener {
+	wake := make(map[string]chan struct{}, len(channels))
+	for _, channel := range channels {
+		wake[channel] = make(chan struct{}, 1)
+	}
+	return &Listener{
+		databaseURL: databaseURL,
+		channels:    channels,
+		wake:        wake,
+	}
+}
+
+// Wake returns a channel that receives when a notification arrives on the given channel
+// Returns nil (blocks forever in a select) for a nil listener or an unknown channel
+func (l *Listener) Wake(channel string) <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.wake[channel]
+}
+
+// Run listens until ctx is cancelled, reconnecting with backoff when the connection drops
+func (l *Listener) Run(ctx context.Context) error {
+	backoff := listenerMinBackoff
+	for {
+		connected, err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			backoff = listenerMinBackoff
+		}
+
+		log.Printf("Warning: notification listener disconnected: %v (reconnecting in %s)", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, listenerMaxBackoff)
+	}
+}
+
+// listen opens a connection, LISTENs on all channels and forwards notifications until an error occurs
+// Reports whether the connection was established so Run can reset its backoff
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, l.databaseURL)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect listener: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	for _, channel := range l.channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return true, fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+
+	log.Printf("Listening for job notifications on %v", l.channels)
+
+	// Notifications sent while disconnected are lost, so wake every stage once
+	for _, channel := range l.channels {
+		l.signal(channel)
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, fmt.Errorf("failed to wait for notification: %w", err)
+		}
+		l.signal(notification.Channel)
+	}
+}
+
+// signal queues a wake-up for the channel without blocking (at most one is pending)
+func (l *Listener) signal(channel string) {
+	wake, ok := l.wake[channel]
+	if !ok {
+		return
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
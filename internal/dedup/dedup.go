@@ -0,0 +1,191 @@
+// Package dedup recognises payments extracted more than once: the same email reprocessed after a failed job,
+// or a reminder and a receipt for the same bill
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// DateWindow is how far apart two mentions of the same payment can be dated (e.g. the due date of a reminder and
+// the payment date of its receipt). Recurring payments of the same amount are further apart than this
+const DateWindow = 3 * 24 * time.Hour
+
+// referenceKeys are the metadata fields identifying a bill, in order of preference
+// Payment references (utr, transaction IDs) are left out, reminders never carry them
+var referenceKeys = []string{"invoice_number", "order_id", "bill_number"}
+
+// legalSuffixes are dropped from merchant names, "Netflix Inc." and "NETFLIX" are the same merchant
+var legalSuffixes = map[string]bool{
+	"inc": true, "llc": true, "ltd": true, "limited": true, "pvt": true, "private": true,
+	"co": true, "corp": true, "corporation": true, "plc": true, "gmbh": true, "pte": true,
+}
+
+// MerchantKey normalises a merchant name for matching: lower case letters and digits, legal suffixes dropped
+func MerchantKey(merchant string) string {
+	words := strings.FieldsFunc(strings.ToLower(merchant), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for len(words) > 1 && legalSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+// Reference returns the bill reference (invoice or order number) from a payment's metadata, nil when there is none
+func Reference(metadata models.JSONB) *string {
+	for _, key := range referenceKeys {
+		value, ok := metadata[key]
+		if !ok || value == nil {
+			continue
+		}
+		reference := strings.TrimSpace(fmt.Sprint(value))
+		if reference != "" {
+			return &reference
+		}
+	}
+	return nil
+}
+
+// Fingerprint identifies a payment: account, merchant, amount, currency, DateWindow-sized date bucket and reference
+// The same extraction always yields the same fingerprint, so reprocessing an email is idempotent
+func Fingerprint(payment models.Payment) string {
+	return ChargeFingerprint(payment, 0)
+}
+
+// ChargeFingerprint is the Fingerprint of the charge-th identical charge of an email (0 for the first), so two
+// items of one order at the same price are two payments. The first charge's fingerprint is the plain Fingerprint
+func ChargeFingerprint(payment models.Payment, charge int) string {
+	reference := ""
+	if payment.ExternalReference != nil {
+		reference = strings.ToLower(*payment.ExternalReference)
+	}
+	fields := []string{
+		payment.AccountID,
+		MerchantKey(payment.Merchant),
+		payment.Amount.String(), // Two decimals unless there is a third, as fingerprints of cent amounts always were
+		strings.ToUpper(payment.Currency),
+		fmt.Sprint(payment.Date.Unix() / int64(DateWindow/time.Second)),
+		reference,
+	}
+	if charge > 0 {
+		fields = append(fields, fmt.Sprint(charge))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Prepare sets the matching fields of a new payment: merchant key, reference (unless set) and fingerprint
+func Prepare(payment *models.Payment) {
+	payment.MerchantKey = MerchantKey(payment.Merchant)
+	if payment.ExternalReference == nil {
+		payment.ExternalReference = Reference(payment.Metadata)
+	}
+	fingerprint := Fingerprint(*payment)
+	payment.Fingerprint = &fingerprint
+}
+
+// PrepareEmail prepares the payments extracted from one email (see Prepare)
+// Identical charges are fingerprinted with their index among them (ChargeFingerprint) instead of collapsing into one
+func PrepareEmail(payments []models.Payment) {
+	charges := make(map[string]int) // Identical charges seen so far, keyed by fingerprint
+	for i := range payments {
+		Prepare(&payments[i])
+		fingerprint := *payments[i].Fingerprint
+		if charge := charges[fingerprint]; charge > 0 {
+			chargeFingerprint := ChargeFingerprint(payments[i], charge)
+			payments[i].Fingerprint = &chargeFingerprint
+		}
+		charges[fingerprint]++
+	}
+}
+
+// Matches reports whether incoming is the same payment as existing: same account, merchant, amount and currency,
+// dated within DateWindow, no conflicting references, and the same fingerprint when both come from one email
+func Matches(existing, incoming models.Payment) bool {
+	if existing.AccountID != incoming.AccountID ||
+		MerchantKey(existing.Merchant) != MerchantKey(incoming.Merchant) ||
+		existing.Amount != incoming.Amount ||
+		!strings.EqualFold(existing.Currency, incoming.Currency) {
+		return false
+	}
+	if diff := existing.Date.Sub(incoming.Date); diff > DateWindow || diff < -DateWindow {
+		return false
+	}
+	if existing.ExternalReference != nil && incoming.ExternalReference != nil &&
+		!strings.EqualFold(*existing.ExternalReference, *incoming.ExternalReference) {
+		return false
+	}
+	// Identical charges of one email are different payments, told apart by their fingerprints
+	if existing.SourceMessageID != nil && incoming.SourceMessageID != nil && *existing.SourceMessageID == *incoming.SourceMessageID &&
+		existing.Fingerprint != nil && incoming.Fingerprint != nil {
+		return *existing.Fingerprint == *incoming.Fingerprint
+	}
+	return true
+}
+
+// Merge folds a newly extracted duplicate into the stored payment
+// A status the stored one may move to (models.PaymentStatusTransitions) replaces it, with the date, description
+// and raw response of the email that reported it; fields the stored payment lacks are filled in, metadata is combined.
+// The stored payment keeps its ID, fingerprint and source email (a projected payment takes the incoming one's)
+func Merge(existing, incoming models.Payment, now time.Time) models.Payment {
+	merged := existing
+
+	if models.CanTransitionPaymentStatus(existing.Status, incoming.Status) {
+		merged.Status = incoming.Status
+		merged.Date = incoming.Date
+		merged.RawLlmResponse = incoming.RawLlmResponse
+		if incoming.Description != nil {
+			merged.Description = incoming.Description
+		}
+	}
+
+	if merged.Description == nil {
+		merged.Description = incoming.Description
+	}
+	if merged.Recurrence == nil {
+		merged.Recurrence = incoming.Recurrence
+	}
+	if merged.Category == nil {
+		merged.Category = incoming.Category
+	}
+	if merged.ExternalReference == nil {
+		merged.ExternalReference = incoming.ExternalReference
+	}
+	if merged.MerchantID == nil {
+		merged.MerchantID = incoming.MerchantID
+	}
+	if merged.SenderDomain == nil {
+		merged.SenderDomain = incoming.SenderDomain
+	}
+
+	// A projected occurrence of a recurring payment becomes the payment of the email that reports it
+	if existing.Projected && !incoming.Projected {
+		merged.Projected = false
+		merged.SourceMessageID = incoming.SourceMessageID
+		merged.SourceThreadID = incoming.SourceThreadID
+		merged.EmailReceivedAt = incoming.EmailReceivedAt
+		merged.LLMSyncJobID = incoming.LLMSyncJobID
+	}
+
+	if len(incoming.Metadata) > 0 {
+		metadata := make(models.JSONB, len(existing.Metadata)+len(incoming.Metadata))
+		for key, value := range incoming.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range existing.Metadata {
+			metadata[key] = value
+		}
+		merged.Metadata = metadata
+	}
+
+	merged.UpdatedAt = now
+	return merged
+}
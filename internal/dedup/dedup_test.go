@@ -0,0 +1,247 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestMerchantKey(t *testing.T) {
+	tests := []struct {
+		merchant string
+		want     string
+	}{
+		{"Netflix", "netflix"},
+		{"NETFLIX, Inc.", "netflix"},
+		{"Bharti Airtel Ltd", "bharti airtel"},
+		{"Tata Play Pvt. Ltd.", "tata play"},
+		{"  Amazon   Pay  ", "amazon pay"},
+		{"Co", "co"},
+		{"Müller GmbH", "müller"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.merchant, func(t *testing.T) {
+			if got := MerchantKey(tt.merchant); got != tt.want {
+				t.Errorf("MerchantKey(%q) = %q, want %q", tt.merchant, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata models.JSONB
+		want     string // empty for none
+	}{
+		{"invoice number", models.JSONB{"invoice_number": "INV-42", "order_id": "ORD-1"}, "INV-42"},
+		{"order id", models.JSONB{"order_id": "ORD-1"}, "ORD-1"},
+		{"numeric", models.JSONB{"bill_number": float64(7781)}, "7781"},
+		{"payment reference only", models.JSONB{"utr": "UTR123"}, ""},
+		{"empty", models.JSONB{"invoice_number": " "}, ""},
+		{"no metadata", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if reference := Reference(tt.metadata); reference != nil {
+				got = *reference
+			}
+			if got != tt.want {
+				t.Errorf("Reference() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	date := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	base := models.Payment{AccountID: "acc-1", Merchant: "Airtel", Amount: models.MustParseAmount("499"), Currency: "INR", Date: date}
+
+	same := base
+	same.Merchant = "AIRTEL Ltd."
+	same.Amount = models.MustParseAmount("499.000")
+	same.Currency = "inr"
+	if Fingerprint(base) != Fingerprint(same) {
+		t.Error("expected the same fingerprint for the same payment spelled differently")
+	}
+
+	// Amounts are keyed with two decimals, as before amounts had three
+	sum := sha256.Sum256([]byte("acc-1|airtel|499.00|INR|" + fmt.Sprint(date.Unix()/int64(DateWindow/time.Second)) + "|"))
+	if Fingerprint(base) != hex.EncodeToString(sum[:]) {
+		t.Error("expected the fingerprint of a cent amount to be unchanged")
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *models.Payment)
+	}{
+		{"account", func(p *models.Payment) { p.AccountID = "acc-2" }},
+		{"merchant", func(p *models.Payment) { p.Merchant = "Jio" }},
+		{"amount", func(p *models.Payment) { p.Amount = models.MustParseAmount("599") }},
+		{"currency", func(p *models.Payment) { p.Currency = "USD" }},
+		{"date a month later", func(p *models.Payment) { p.Date = date.AddDate(0, 1, 0) }},
+		{"reference", func(p *models.Payment) { p.ExternalReference = strPtr("INV-1") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := base
+			tt.change(&other)
+			if Fingerprint(base) == Fingerprint(other) {
+				t.Errorf("expected a different fingerprint when the %s differs", tt.name)
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	payment := models.Payment{AccountID: "acc-1", Merchant: "Netflix Inc.", Amount: models.MustParseAmount("15.49"), Currency: "USD", Metadata: models.JSONB{"invoice_number": "INV-9"}}
+	Prepare(&payment)
+
+	if payment.MerchantKey != "netflix" {
+		t.Errorf("Prepare() merchant key = %q, want netflix", payment.MerchantKey)
+	}
+	if payment.ExternalReference == nil || *payment.ExternalReference != "INV-9" {
+		t.Errorf("Prepare() external reference = %v, want INV-9", payment.ExternalReference)
+	}
+	if payment.Fingerprint == nil || *payment.Fingerprint != Fingerprint(payment) {
+		t.Errorf("Prepare() fingerprint = %v", payment.Fingerprint)
+	}
+}
+
+func TestPrepareEmail(t *testing.T) {
+	date := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	item := models.Payment{AccountID: "acc-1", Merchant: "Amazon", Amount: models.MustParseAmount("299"), Currency: "INR", Date: date, Metadata: models.JSONB{"order_id": "ORD-7"}}
+	other := item
+	other.Amount = models.MustParseAmount("99")
+	payments := []models.Payment{item, other, item, item}
+	PrepareEmail(payments)
+
+	fingerprints := make(map[string]bool)
+	for _, payment := range payments {
+		fingerprints[*payment.Fingerprint] = true
+	}
+	if len(fingerprints) != len(payments) {
+		t.Errorf("PrepareEmail() gave %d fingerprints for %d charges, want one each", len(fingerprints), len(payments))
+	}
+	// The first charge keeps its plain fingerprint, so a receipt of the order still matches it
+	if *payments[0].Fingerprint != Fingerprint(payments[0]) || *payments[2].Fingerprint != ChargeFingerprint(payments[2], 1) {
+		t.Errorf("PrepareEmail() fingerprints = %s, %s", *payments[0].Fingerprint, *payments[2].Fingerprint)
+	}
+
+	// Reprocessing the email yields the same fingerprints
+	again := []models.Payment{item, other, item, item}
+	PrepareEmail(again)
+	for i := range again {
+		if *again[i].Fingerprint != *payments[i].Fingerprint {
+			t.Errorf("PrepareEmail() fingerprint %d changed on reprocessing", i)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	due := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	reminder := models.Payment{AccountID: "acc-1", Merchant: "Airtel", Amount: models.MustParseAmount("499"), Currency: "INR", Date: due, Status: models.PaymentStatusDue}
+
+	tests := []struct {
+		name   string
+		change func(p *models.Payment)
+		want   bool
+	}{
+		{"receipt paid two days early", func(p *models.Payment) { p.Date = due.AddDate(0, 0, -2); p.Status = models.PaymentStatusPaid }, true},
+		{"receipt with an invoice number", func(p *models.Payment) { p.ExternalReference = strPtr("INV-1") }, true},
+		{"next month's bill", func(p *models.Payment) { p.Date = due.AddDate(0, 1, 0) }, false},
+		{"next week's payment", func(p *models.Payment) { p.Date = due.AddDate(0, 0, 7) }, false},
+		{"different amount", func(p *models.Payment) { p.Amount = models.MustParseAmount("250") }, false},
+		{"different merchant", func(p *models.Payment) { p.Merchant = "Jio" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming := reminder
+			tt.change(&incoming)
+			if got := Matches(reminder, incoming); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Different bill references are different payments
+	first, second := reminder, reminder
+	first.ExternalReference = strPtr("INV-1")
+	second.ExternalReference = strPtr("INV-2")
+	if Matches(first, second) {
+		t.Error("Matches() = true for different invoice numbers, want false")
+	}
+
+	// Identical charges of one email are told apart by their fingerprints
+	first, second = reminder, reminder
+	first.SourceMessageID, first.Fingerprint = strPtr("msg-1"), strPtr("fp-1")
+	second.SourceMessageID, second.Fingerprint = strPtr("msg-1"), strPtr("fp-2")
+	if Matches(first, second) {
+		t.Error("Matches() = true for two charges of one email, want false")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+	due := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	paidOn := due.AddDate(0, 0, -2)
+	reminder := models.Payment{
+		ID: "pay-1", Status: models.PaymentStatusDue, Date: due, Fingerprint: strPtr("fp-1"), SourceMessageID: strPtr("msg-1"),
+		Metadata: models.JSONB{"billing_period": "March"}, RawLlmResponse: models.JSONB{"id": "resp-1"},
+	}
+	receipt := models.Payment{
+		ID: "pay-2", Status: models.PaymentStatusPaid, Date: paidOn, Fingerprint: strPtr("fp-2"), SourceMessageID: strPtr("msg-2"),
+		Category: strPtr(models.CategoryUtility), ExternalReference: strPtr("INV-1"),
+		Metadata: models.JSONB{"billing_period": "Mar 2026", "utr": "UTR123"}, RawLlmResponse: models.JSONB{"id": "resp-2"},
+	}
+
+	merged := Merge(reminder, receipt, now)
+	if merged.ID != "pay-1" || *merged.Fingerprint != "fp-1" || *merged.SourceMessageID != "msg-1" {
+		t.Errorf("Merge() = %+v, want the stored payment's identity", merged)
+	}
+	if merged.Status != models.PaymentStatusPaid || !merged.Date.Equal(paidOn) || merged.RawLlmResponse["id"] != "resp-2" {
+		t.Errorf("Merge() status = %s, date = %v, want paid on %v with the receipt's raw response", merged.Status, merged.Date, paidOn)
+	}
+	if merged.Category == nil || merged.ExternalReference == nil || *merged.ExternalReference != "INV-1" {
+		t.Errorf("Merge() = %+v, want missing fields filled in", merged)
+	}
+	if merged.Metadata["billing_period"] != "March" || merged.Metadata["utr"] != "UTR123" {
+		t.Errorf("Merge() metadata = %v, want stored keys kept and new keys added", merged.Metadata)
+	}
+	if !merged.UpdatedAt.Equal(now) {
+		t.Errorf("Merge() updated_at = %v, want %v", merged.UpdatedAt, now)
+	}
+
+	// A late reminder never moves a paid payment back
+	merged = Merge(merged, reminder, now)
+	if merged.Status != models.PaymentStatusPaid || !merged.Date.Equal(paidOn) {
+		t.Errorf("Merge() status = %s, date = %v, want it to stay paid on %v", merged.Status, merged.Date, paidOn)
+	}
+}
+
+func TestMerge_Projected(t *testing.T) {
+	renewal := time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC)
+	projected := models.Payment{ID: "pay-1", Status: models.PaymentStatusScheduled, Date: renewal, Projected: true, RecurringPaymentID: strPtr("rec-1")}
+	receipt := models.Payment{ID: "pay-2", Status: models.PaymentStatusPaid, Date: renewal.AddDate(0, 0, 1), SourceMessageID: strPtr("msg-2"), LLMSyncJobID: strPtr("job-2")}
+
+	merged := Merge(projected, receipt, time.Now())
+	if merged.Projected || merged.Status != models.PaymentStatusPaid {
+		t.Errorf("Merge() projected = %v, status = %s, want a paid payment from the email", merged.Projected, merged.Status)
+	}
+	if merged.SourceMessageID == nil || *merged.SourceMessageID != "msg-2" || merged.LLMSyncJobID == nil || *merged.RecurringPaymentID != "rec-1" {
+		t.Errorf("Merge() = %+v, want the receipt's source email in the series", merged)
+	}
+}
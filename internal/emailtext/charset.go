@@ -0,0 +1,46 @@
+package emailtext
+
+import (
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// wordDecoder decodes RFC 2047 encoded words in any charset known to the WHATWG encoding list
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(label string, input io.Reader) (io.Reader, error) {
+		return charset.NewReaderLabel(label, input)
+	},
+}
+
+// DecodeCharset converts a MIME part body to UTF-8 using the charset from its Content-Type
+// Without a charset it honours a byte order mark or an HTML <meta> charset, keeps valid UTF-8
+// as is, and otherwise assumes windows-1252 (a superset of iso-8859-1)
+func DecodeCharset(data []byte, contentType string) string {
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+	if !certain && utf8.Valid(data) {
+		return string(data)
+	}
+	if name == "utf-8" {
+		return strings.ToValidUTF8(string(data), "\uFFFD")
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "\uFFFD")
+	}
+	return string(decoded)
+}
+
+// DecodeHeader decodes RFC 2047 encoded words in a header value (e.g. "=?UTF-8?B?...?=")
+// Returns the value unchanged if it is not validly encoded
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
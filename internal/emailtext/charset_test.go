@@ -0,0 +1,57 @@
+package emailtext
+
+import "testing"
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        string
+	}{
+		{"utf-8", []byte("Total: ₹1,234"), "text/plain; charset=utf-8", "Total: ₹1,234"},
+		{"iso-8859-1", []byte("Caf\xe9 bill \xa3 12"), "text/plain; charset=iso-8859-1", "Café bill £ 12"},
+		{"quoted charset", []byte("Caf\xe9"), `text/plain; charset="ISO-8859-1"`, "Café"},
+		{"windows-1252 euro sign", []byte("\x80 49,99"), "text/plain; charset=windows-1252", "€ 49,99"},
+		{"koi8-r", []byte("\xf3\xde\xc5\xd4"), "text/plain; charset=koi8-r", "Счет"},
+		{"shift_jis", []byte("\x90\xbf\x8b\x81\x8f\x91"), "text/plain; charset=shift_jis", "請求書"},
+		{"html meta charset", []byte(`<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"><p>Caf` + "\xe9</p>"), "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"><p>Café</p>`},
+		{"no charset, valid utf-8", []byte("Déjà payé"), "text/plain", "Déjà payé"},
+		{"no charset, latin-1 bytes", []byte("Caf\xe9"), "text/plain", "Café"},
+		{"unknown charset, valid utf-8", []byte("Café"), "text/plain; charset=x-unknown", "Café"},
+		{"utf-8 with invalid bytes", []byte("Caf\xe9 ok"), "text/plain; charset=utf-8", "Caf\uFFFD ok"},
+		{"us-ascii", []byte("Amount due"), "text/plain; charset=us-ascii", "Amount due"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeCharset(tt.data, tt.contentType); got != tt.want {
+				t.Errorf("DecodeCharset() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "Your bill is ready", "Your bill is ready"},
+		{"utf-8 base64", "=?UTF-8?B?4oK5IDQ5OSBkdWUgdG9kYXk=?=", "₹ 499 due today"},
+		{"iso-8859-1 quoted-printable", "=?ISO-8859-1?Q?Caf=E9_de_Paris?= <billing@cafe.example>", "Café de Paris <billing@cafe.example>"},
+		{"adjacent words are joined", "=?UTF-8?Q?Facture_?= =?UTF-8?Q?d=C3=A9cembre?=", "Facture décembre"},
+		{"mixed plain and encoded", "Re: =?utf-8?q?Re=C3=A7u?= #42", "Re: Reçu #42"},
+		{"windows-1251 via charset reader", "=?windows-1251?B?0ffl8g==?=", "Счет"},
+		{"malformed word kept", "=?UTF-8?B?not base64!?=", "=?UTF-8?B?not base64!?="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeHeader(tt.value); got != tt.want {
+				t.Errorf("DecodeHeader(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package emailtext
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose content is never shown as text
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Title:    true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Object:   true,
+	atom.Iframe:   true,
+	atom.Button:   true,
+	atom.Select:   true,
+}
+
+// Elements rendered on their own lines
+var blockElements = map[atom.Atom]bool{
+	atom.Address:    true,
+	atom.Article:    true,
+	atom.Aside:      true,
+	atom.Blockquote: true,
+	atom.Center:     true,
+	atom.Dd:         true,
+	atom.Div:        true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Fieldset:   true,
+	atom.Figure:     true,
+	atom.Footer:     true,
+	atom.Form:       true,
+	atom.Header:     true,
+	atom.Hr:         true,
+	atom.Main:       true,
+	atom.Nav:        true,
+	atom.Ol:         true,
+	atom.Pre:        true,
+	atom.Section:    true,
+	atom.Ul:         true,
+}
+
+// Elements separated from surrounding text by a blank line
+var paragraphElements = map[atom.Atom]bool{
+	atom.P:  true,
+	atom.H1: true,
+	atom.H2: true,
+	atom.H3: true,
+	atom.H4: true,
+	atom.H5: true,
+	atom.H6: true,
+}
+
+// HTMLToText converts an HTML email body into readable plain text
+// Drops scripts, styles, hidden elements and tracking pixels, keeps image alt text,
+// and flattens data tables into one "cell | cell" line per row
+func HTMLToText(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		// The parser is lenient, this only happens on read errors
+		return cleanText(body)
+	}
+
+	var b strings.Builder
+	renderChildren(&b, doc)
+	return cleanText(b.String())
+}
+
+// renderChildren renders all children of n
+func renderChildren(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		render(b, c)
+	}
+}
+
+// render writes the text of n, with line breaks for block elements
+func render(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		writeText(b, n.Data)
+		return
+	case html.DocumentNode:
+		renderChildren(b, n)
+		return
+	case html.ElementNode:
+	default:
+		return // Comments, doctype
+	}
+
+	if skippedElements[n.DataAtom] || isHidden(n) {
+		return
+	}
+
+	switch {
+	case n.DataAtom == atom.Br:
+		b.WriteString("\n")
+	case n.DataAtom == atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" && !isTrackingPixel(n) {
+			writeText(b, " "+alt+" ")
+		}
+	case n.DataAtom == atom.Table:
+		lineBreak(b, 1)
+		renderTable(b, n)
+		lineBreak(b, 1)
+	case n.DataAtom == atom.Li:
+		lineBreak(b, 1)
+		b.WriteString("- ")
+		renderChildren(b, n)
+		lineBreak(b, 1)
+	case paragraphElements[n.DataAtom]:
+		lineBreak(b, 2)
+		renderChildren(b, n)
+		lineBreak(b, 2)
+	case blockElements[n.DataAtom]:
+		lineBreak(b, 1)
+		renderChildren(b, n)
+		lineBreak(b, 1)
+	default:
+		renderChildren(b, n)
+	}
+}
+
+// renderTable writes each row of a table on its own line
+// Rows of single-line cells (data tables) become "cell | cell", rows holding
+// multi-line content or nested tables (layout tables) are written cell by cell
+func renderTable(b *strings.Builder, table *html.Node) {
+	for _, row := range tableRows(table) {
+		cells := make([]string, 0)
+		layout := false
+		for c := row.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.DataAtom != atom.Td && c.DataAtom != atom.Th) || isHidden(c) {
+				continue
+			}
+			var cell strings.Builder
+			renderChildren(&cell, c)
+			text := cleanText(cell.String())
+			if text == "" {
+				continue
+			}
+			if strings.Contains(text, "\n") {
+				layout = true
+			}
+			cells = append(cells, text)
+		}
+
+		if len(cells) == 0 {
+			continue
+		}
+		lineBreak(b, 1)
+		if layout {
+			b.WriteString(strings.Join(cells, "\n"))
+		} else {
+			b.WriteString(strings.Join(cells, " | "))
+		}
+		lineBreak(b, 1)
+	}
+}
+
+// tableRows returns the rows of table, not including rows of nested tables
+func tableRows(table *html.Node) []*html.Node {
+	rows := make([]*html.Node, 0)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				rows = append(rows, c)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// lineBreak ends the current line so that n newlines (1: next line, 2: blank line) follow the text
+// Newlines already written count towards n, so nested blocks don't stack up blank lines
+func lineBreak(b *strings.Builder, n int) {
+	written := strings.TrimRight(b.String(), " ")
+	if written == "" {
+		return
+	}
+	for i := len(written) - len(strings.TrimRight(written, "\n")); i < n; i++ {
+		b.WriteString("\n")
+	}
+}
+
+// writeText writes a text node with whitespace collapsed to single spaces
+// Leading and trailing whitespace is kept as one space so adjacent inline elements stay separated
+func writeText(b *strings.Builder, text string) {
+	if text == "" {
+		return
+	}
+	words := strings.Fields(strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, text))
+
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	if unicode.IsSpace(first) || len(words) == 0 {
+		b.WriteString(" ")
+	}
+	b.WriteString(strings.Join(words, " "))
+	if len(words) > 0 && unicode.IsSpace(last) {
+		b.WriteString(" ")
+	}
+}
+
+// isHidden reports elements that are not displayed (preheaders, tracking markup)
+func isHidden(n *html.Node) bool {
+	if _, ok := attrValue(n, "hidden"); ok {
+		return true
+	}
+	if strings.EqualFold(attr(n, "aria-hidden"), "true") && n.DataAtom != atom.Img {
+		return true
+	}
+	style := strings.ToLower(strings.ReplaceAll(attr(n, "style"), " ", ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") || strings.Contains(style, "max-height:0")
+}
+
+// isTrackingPixel reports images of at most 1x1 pixels
+func isTrackingPixel(n *html.Node) bool {
+	width, widthErr := strconv.Atoi(strings.TrimSuffix(attr(n, "width"), "px"))
+	height, heightErr := strconv.Atoi(strings.TrimSuffix(attr(n, "height"), "px"))
+	return (widthErr == nil && width <= 1) || (heightErr == nil && height <= 1)
+}
+
+// attr returns the value of the named attribute, or "" if it is missing
+func attr(n *html.Node, name string) string {
+	value, _ := attrValue(n, name)
+	return value
+}
+
+func attrValue(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,112 @@
+package emailtext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and line breaks",
+			html: "<p>Dear Customer,</p><p>Your bill of <b>INR 499</b> is due<br>on 5 Jan.</p>",
+			want: "Dear Customer,\n\nYour bill of INR 499 is due\non 5 Jan.",
+		},
+		{
+			name: "scripts styles and head are dropped",
+			html: "<html><head><title>Mailer</title><style>p{color:red}</style></head><body><script>track()</script><p>Amount: 100</p></body></html>",
+			want: "Amount: 100",
+		},
+		{
+			name: "hidden preheader is dropped",
+			html: `<div style="display: none; max-height: 0px">Don't miss our sale&zwnj;&nbsp;&zwnj;</div><p>Invoice #42</p>`,
+			want: "Invoice #42",
+		},
+		{
+			name: "tracking pixel is dropped, image alt text kept",
+			html: `<img src="https://t.example/open.gif" width="1" height="1" alt="pixel"><img src="logo.png" width="120" alt="Netflix"><p>Your membership renews today</p>`,
+			want: "Netflix\n\nYour membership renews today",
+		},
+		{
+			name: "entities and whitespace",
+			html: "<p>Total&nbsp;due:\n\t  &#8377;1,234.00   &amp; taxes</p>",
+			want: "Total due: ₹1,234.00 & taxes",
+		},
+		{
+			name: "data table rows are flattened",
+			html: `<table>
+				<tr><th>Item</th><th>Amount</th></tr>
+				<tr><td>Premium plan</td><td>$19.99</td></tr>
+				<tr><td></td><td></td></tr>
+				<tr><td>Total</td><td><b>$19.99</b></td></tr>
+			</table>`,
+			want: "Item | Amount\nPremium plan | $19.99\nTotal | $19.99",
+		},
+		{
+			name: "layout table with nested data table",
+			html: `<table><tr><td>
+				<p>Hi Alex,</p>
+				<table><tr><td>Due date</td><td>10 Feb 2026</td></tr></table>
+			</td><td><img src="x.gif" width="1" height="1"></td></tr></table>`,
+			want: "Hi Alex,\n\nDue date | 10 Feb 2026",
+		},
+		{
+			name: "lists",
+			html: "<ul><li>Electricity: 1,200</li><li>Water: 300</li></ul>",
+			want: "- Electricity: 1,200\n- Water: 300",
+		},
+		{
+			name: "links keep their text only",
+			html: `<p>Pay now at <a href="https://click.example/track?id=123">our portal</a>.</p>`,
+			want: "Pay now at our portal.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HTMLToText(tt.html)
+			if got != tt.want {
+				t.Errorf("HTMLToText() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLToText_LayoutEmail(t *testing.T) {
+	// Shape of a bank alert mailer: nested layout tables, fonts, spacer cells and images
+	body := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html><head><meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1" /><title>Bank Online</title></head>
+<body topmargin="0">
+<table width="650" border="0" align="center" cellpadding="0" cellspacing="0" style="font-size:0px;"><tbody>
+  <tr><td><img src="https://bank.example/top.jpg" width="650" height="86" /></td></tr>
+  <tr><td><table width="100%"><tbody><tr><td style="padding:15px;"><table width="580">
+    <tr><td></td></tr>
+    <tr><td height="10"><font face="Arial" color="#000000" style="font-size:14px">
+Dear Customer, <br />
+  <br /> Your Credit Card XX8000 has been used for a transaction of INR 547.34 on Dec 23, 2025 at 10:15:33. Info: ZOMATO. <br /><br />
+  The Available Credit Limit on your card is INR 2,18,107.85. </p>
+Sincerely, <br />
+Team Bank
+    </font></td></tr>
+    <tr><td height="1" bgcolor="#ded7bd"></td></tr>
+  </table></td></tr></tbody></table></td></tr>
+</tbody></table>
+<img src="https://bank.example/open?id=1" width="1" height="1" />
+</body></html>`
+
+	want := "Dear Customer,\n\n" +
+		"Your Credit Card XX8000 has been used for a transaction of INR 547.34 on Dec 23, 2025 at 10:15:33. Info: ZOMATO.\n\n" +
+		"The Available Credit Limit on your card is INR 2,18,107.85.\n\n" +
+		"Sincerely,\nTeam Bank"
+
+	if got := HTMLToText(body); got != want {
+		t.Errorf("HTMLToText() =\n%q\nwant\n%q", got, want)
+	}
+	if strings.Contains(HTMLToText(body), "<") {
+		t.Error("expected no markup in the output")
+	}
+}
@@ -0,0 +1,111 @@
+package emailtext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sentenceCutRatio is how far back Truncate looks for a sentence or word boundary (last 20% of the budget)
+const sentenceCutRatio = 0.8
+
+// htmlMarkup matches plain-text bodies that are really HTML (some senders put markup in text/plain)
+var htmlMarkup = regexp.MustCompile(`(?i)^\s*(<!doctype html|<html|<head|<body|<table|<div|<p[\s>])`)
+
+// Normalize returns readable text for an email body
+// Uses the plain-text part unless it is empty or contains HTML markup, then falls back to the HTML part
+func Normalize(bodyText, bodyHTML string) string {
+	if strings.TrimSpace(bodyText) != "" && !htmlMarkup.MatchString(bodyText) {
+		return cleanText(bodyText)
+	}
+	if strings.TrimSpace(bodyHTML) != "" {
+		return HTMLToText(bodyHTML)
+	}
+	if htmlMarkup.MatchString(bodyText) {
+		return HTMLToText(bodyText)
+	}
+	return ""
+}
+
+// Truncate cuts text to at most maxChars characters (runes), never splitting a UTF-8 sequence
+// Prefers ending at a sentence or line boundary, then a word boundary, within the last 20% of the budget
+func Truncate(text string, maxChars int) string {
+	if maxChars <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= maxChars {
+		return text
+	}
+
+	cut := string([]rune(text)[:maxChars])
+	minCut := int(float64(len(cut)) * sentenceCutRatio)
+
+	// Sentence end (keep the punctuation) or line break
+	best := -1
+	for i, r := range cut {
+		if i < minCut {
+			continue
+		}
+		switch r {
+		case '\n':
+			best = i
+		case '.', '!', '?':
+			next := i + 1
+			if next == len(cut) || cut[next] == ' ' || cut[next] == '\n' {
+				best = next
+			}
+		}
+	}
+	if best > 0 {
+		return strings.TrimSpace(cut[:best])
+	}
+
+	// Word boundary
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i >= minCut {
+		return strings.TrimSpace(cut[:i])
+	}
+	return cut
+}
+
+// cleanText collapses runs of spaces, trims every line and keeps at most one blank line in a row
+func cleanText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case isInvisible(r):
+			return -1
+		case r == '\n':
+			return r
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, text)
+
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := true // Drops leading blank lines
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// isInvisible reports zero-width characters senders use to pad preview text
+func isInvisible(r rune) bool {
+	switch r {
+	case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff', '\u034f', '\u00ad':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package emailtext
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodyText string
+		bodyHTML string
+		want     string
+	}{
+		{"plain text preferred", "Your bill: 499\r\n\r\n\r\n\r\nThanks  team", "<p>ignored</p>", "Your bill: 499\n\nThanks team"},
+		{"html fallback when text is empty", "", "<p>Amount due: <b>$20</b></p>", "Amount due: $20"},
+		{"html fallback when text is blank", " \n\t ", "<div>Paid</div>", "Paid"},
+		{"markup in the text part", "<!DOCTYPE html><html><body><p>Order total 250</p></body></html>", "", "Order total 250"},
+		{"markup in the text part with html part", "<html><body><p>text part</p></body></html>", "<p>html part</p>", "html part"},
+		{"text mentioning tags is kept", "Use the <b> tag for bold", "", "Use the <b> tag for bold"},
+		{"zero width characters are removed", "Due\u200b today\u200c\u00ad", "", "Due today"},
+		{"empty", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.bodyText, tt.bodyHTML); got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxChars int
+		want     string
+	}{
+		{"short text unchanged", "Amount due: 100.", 50, "Amount due: 100."},
+		{"sentence boundary", "First sentence here. Second sentence is long", 24, "First sentence here."},
+		{"line boundary", "Invoice 42 for you\nAmount due 100 rupees", 22, "Invoice 42 for you"},
+		{"word boundary", "alpha beta gamma delta epsilon", 23, "alpha beta gamma delta"},
+		{"hard cut without boundary", "abcdefghijklmnopqrstuvwxyz", 10, "abcdefghij"},
+		{"boundary too early is ignored", "Hi. abcdefghijklmnopqrstuvwxyz", 20, "Hi. abcdefghijklmnop"},
+		{"multibyte runes", "₹₹₹₹₹₹₹₹₹₹", 4, "₹₹₹₹"},
+		{"zero budget", "anything", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.text, tt.maxChars)
+			if got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.maxChars, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Truncate returned invalid UTF-8: %q", got)
+			}
+		})
+	}
+}
+
+func TestTruncate_NeverExceedsBudget(t *testing.T) {
+	text := strings.Repeat("Paid ₹1,234.50 to Électricité. ", 500)
+	for _, maxChars := range []int{1, 7, 100, 5000} {
+		got := Truncate(text, maxChars)
+		if n := utf8.RuneCountInString(got); n > maxChars {
+			t.Errorf("Truncate(%d) returned %d characters", maxChars, n)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package gmail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const (
+	BatchURL     = "https://gmail.googleapis.com/batch/gmail/v1"
+	MaxBatchSize = 100 // Gmail accepts up to 100 calls per batch request
+)
+
+// messages.get formats requested in batches: full messages, or only the subject header and snippet
+const (
+	formatFull     = "full"
+	formatMetadata = "metadata&metadataHeaders=Subject"
+)
+
+// FetchEmailsByIDs fetches full messages using the batch endpoint, up to MaxBatchSize messages per HTTP request
+// Messages that fail individually (e.g. deleted) are reported in the result's Errors instead of failing the call
+// Each batch costs the quota of its messages.get calls
+func (c *Client) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*service.BatchFetchResult, error) {
+	return c.fetchByIDs(ctx, accountID, messageIDs, formatFull)
+}
+
+// fetchByIDs fetches messages in the given messages.get format using the batch endpoint
+func (c *Client) fetchByIDs(ctx context.Context, accountID string, messageIDs []string, format string) (*service.BatchFetchResult, error) {
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage, len(messageIDs)),
+		Errors:   make(map[string]error),
+	}
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	// Get the account's cached HTTP client (tokens refresh and persist automatically)
+	svc, err := c.accountService(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(messageIDs); start += MaxBatchSize {
+		chunk := messageIDs[start:min(start+MaxBatchSize, len(messageIDs))]
+		err := c.call(ctx, accountID, len(chunk)*quotaMessagesGet, func() error {
+			return c.fetchBatch(ctx, svc.httpClient, chunk, format, result)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Map per-message failures to service errors (deleted messages, rate limited calls)
+	for messageID, err := range result.Errors {
+		err = c.classifyError(accountID, err)
+		var rateLimitErr *service.RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+			c.quota.block(accountID, time.Now().Add(rateLimitErr.RetryAfter))
+		}
+		result.Errors[messageID] = err
+	}
+
+	log.Printf("Gmail batch fetched %d of %d messages (format: %s)", len(result.Messages), len(messageIDs), format)
+
+	return result, nil
+}
+
+// fetchBatch sends one batch request for the given message IDs and adds the outcome to result
+func (c *Client) fetchBatch(ctx context.Context, httpClient *http.Client, messageIDs []string, format string, result *service.BatchFetchResult) error {
+	body, contentType, err := encodeBatchRequest(messageIDs, format)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.batchURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send batch request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return fmt.Errorf("batch request failed: %w", err)
+	}
+
+	return c.decodeBatchResponse(resp.Header.Get("Content-Type"), resp.Body, messageIDs, result)
+}
+
+// encodeBatchRequest builds a multipart/mixed body with one messages.get call in the given format per message ID
+// Each part's Content-ID is the index of its message ID
+func encodeBatchRequest(messageIDs []string, format string) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for i, messageID := range messageIDs {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<%d>", i))
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := fmt.Fprintf(part, "GET /gmail/v1/users/me/messages/%s?format=%s HTTP/1.1\r\n\r\n", url.PathEscape(messageID), format); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &buf, "multipart/mixed; boundary=" + writer.Boundary(), nil
+}
+
+// decodeBatchResponse parses a multipart/mixed batch response into messages and per-message errors
+// Response parts carry Content-ID "<response-N>" matching request part N
+func (c *Client) decodeBatchResponse(contentType string, body io.Reader, messageIDs []string, result *service.BatchFetchResult) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("unexpected batch response content type: %q", contentType)
+	}
+
+	answered := make(map[string]bool, len(messageIDs))
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read batch response: %w", err)
+		}
+
+		messageID, ok := batchPartMessageID(part.Header.Get("Content-ID"), messageIDs)
+		if !ok {
+			log.Printf("Warning: ignoring batch response part with unknown Content-ID %q", part.Header.Get("Content-ID"))
+			continue
+		}
+		answered[messageID] = true
+
+		emailMsg, err := c.decodeBatchPart(part)
+		if err != nil {
+			result.Errors[messageID] = err
+			continue
+		}
+		result.Messages[messageID] = emailMsg
+	}
+
+	for _, messageID := range messageIDs {
+		if !answered[messageID] {
+			result.Errors[messageID] = fmt.Errorf("message %s missing from batch response", messageID)
+		}
+	}
+
+	return nil
+}
+
+// decodeBatchPart parses one embedded HTTP response into an email message
+func (c *Client) decodeBatchPart(part io.Reader) (*service.EmailMessage, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch part: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var msg gmail.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	if msg.Payload == nil {
+		return nil, fmt.Errorf("message %s has no payload", msg.Id)
+	}
+
+	emailMsg, err := c.parseMessage(&msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+	return &emailMsg, nil
+}
+
+// batchPartMessageID maps a response Content-ID ("<response-N>") back to its message ID
+func batchPartMessageID(contentID string, messageIDs []string) (string, bool) {
+	contentID = strings.TrimSuffix(strings.TrimPrefix(contentID, "<"), ">")
+	index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-"))
+	if err != nil || index < 0 || index >= len(messageIDs) {
+		return "", false
+	}
+	return messageIDs[index], true
+}
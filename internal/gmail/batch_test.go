@@ -0,0 +1,195 @@
+package gmail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// fakeBatchServer answers Gmail batch requests: "gone" is a 404, "skip" is left out of the response
+func fakeBatchServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/mixed" {
+			t.Errorf("expected multipart/mixed request, got %q", r.Header.Get("Content-Type"))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		var out bytes.Buffer
+		writer := multipart.NewWriter(&out)
+
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read request part: %v", err)
+			}
+
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				t.Fatalf("failed to read embedded request: %v", err)
+			}
+			if format := req.URL.Query().Get("format"); format != "full" && format != "metadata" {
+				t.Errorf("expected format=full or metadata, got %s", req.URL.RawQuery)
+			}
+			messageID := strings.TrimPrefix(req.URL.Path, "/gmail/v1/users/me/messages/")
+			if messageID == "skip" {
+				continue
+			}
+
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "application/http")
+			header.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+			respPart, err := writer.CreatePart(header)
+			if err != nil {
+				t.Fatalf("failed to create response part: %v", err)
+			}
+
+			if messageID == "gone" {
+				body := `{"error":{"code":404,"message":"Requested entity was not found."}}`
+				fmt.Fprintf(respPart, "HTTP/1.1 404 Not Found\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				continue
+			}
+
+			data := base64.URLEncoding.EncodeToString([]byte("Your bill for " + messageID))
+			body := fmt.Sprintf(`{"id":%q,"threadId":"t-%s","payload":{"mimeType":"text/plain","headers":[{"name":"Subject","value":"Bill %s"}],"body":{"data":%q}}}`,
+				messageID, messageID, messageID, data)
+			fmt.Fprintf(respPart, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+
+		writer.Close()
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		w.Write(out.Bytes())
+	}))
+}
+
+func TestFetchBatch(t *testing.T) {
+	server := fakeBatchServer(t)
+	defer server.Close()
+
+	client := NewClient("client-id", "client-secret", nil)
+	client.batchURL = server.URL
+
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage),
+		Errors:   make(map[string]error),
+	}
+	err := client.fetchBatch(context.Background(), server.Client(), []string{"m1", "gone", "m2", "skip"}, formatFull, result)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, id := range []string{"m1", "m2"} {
+		msg, ok := result.Messages[id]
+		if !ok {
+			t.Fatalf("expected message %s to be fetched, errors: %v", id, result.Errors)
+		}
+		if msg.Subject != "Bill "+id {
+			t.Errorf("expected subject 'Bill %s', got %q", id, msg.Subject)
+		}
+		if msg.BodyText != "Your bill for "+id {
+			t.Errorf("expected body for %s, got %q", id, msg.BodyText)
+		}
+	}
+
+	for _, id := range []string{"gone", "skip"} {
+		if _, ok := result.Messages[id]; ok {
+			t.Errorf("expected message %s not to be fetched", id)
+		}
+		if result.Errors[id] == nil {
+			t.Errorf("expected an error for message %s", id)
+		}
+	}
+}
+
+func TestMatchingMessages(t *testing.T) {
+	server := fakeBatchServer(t)
+	defer server.Close()
+
+	client := NewClient("client-id", "client-secret", nil)
+	client.batchURL = server.URL
+	client.services["acc-1"] = &accountService{httpClient: server.Client(), createdAt: time.Now()}
+
+	tests := []struct {
+		name     string
+		keywords []string
+		want     string
+	}{
+		// Deleted messages are dropped, messages missing from the response are kept for their LLM job to decide
+		{"subject matches", []string{"BILL"}, "m1,skip"},
+		{"no match", []string{"refund"}, "skip"},
+		{"no keywords", nil, "m1,gone,skip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matching, err := client.matchingMessages(context.Background(), "acc-1", []string{"m1", "gone", "skip"},
+				service.SearchQuery{Keywords: tt.keywords})
+			if err != nil {
+				t.Fatalf("matchingMessages() error = %v", err)
+			}
+			if got := strings.Join(matching, ","); got != tt.want {
+				t.Errorf("matchingMessages() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchBatch_RequestFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":429,"message":"Too many concurrent requests for user"}}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClient("client-id", "client-secret", nil)
+	client.batchURL = server.URL
+
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage),
+		Errors:   make(map[string]error),
+	}
+	if err := client.fetchBatch(context.Background(), server.Client(), []string{"m1"}, formatFull, result); err == nil {
+		t.Fatal("expected error for failed batch request, got nil")
+	}
+}
+
+func TestBatchPartMessageID(t *testing.T) {
+	messageIDs := []string{"a", "b", "c"}
+
+	tests := []struct {
+		contentID string
+		want      string
+		wantOK    bool
+	}{
+		{"<response-0>", "a", true},
+		{"<response-2>", "c", true},
+		{"response-1", "b", true},
+		{"<response-3>", "", false},
+		{"<response-x>", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := batchPartMessageID(tt.contentID, messageIDs)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("batchPartMessageID(%q) = %q, %v; want %q, %v", tt.contentID, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
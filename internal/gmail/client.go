@@ -2,52 +2,145 @@ package gmail
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 	"google.golang.org/api/gmail/v1"
 	"google.golang.org/api/option"
 
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+	"github.com/vipul43/kiwis-worker/internal/oauth"
 	"github.com/vipul43/kiwis-worker/internal/service"
 )
 
+// serviceCacheTTL is how long a cached account service is reused before tokens are reloaded from the database
+// Picks up re-authenticated accounts without restarting the worker
+const serviceCacheTTL = 1 * time.Hour
+
 type Client struct {
 	clientID     string
 	clientSecret string
+	tokens       oauth.TokenStore
+	batchURL     string
+
+	quota          *quotaLimiter
+	retryBaseDelay time.Duration
+
+	mu       sync.Mutex
+	services map[string]*accountService // keyed by account ID
 }
 
-func NewClient(clientID, clientSecret string) *Client {
+// accountService is a Gmail service and HTTP client authorized as one account
+type accountService struct {
+	gmail      *gmail.Service
+	httpClient *http.Client
+	createdAt  time.Time
+}
+
+func NewClient(clientID, clientSecret string, tokens oauth.TokenStore) *Client {
 	return &Client{
-		clientID:     clientID,
-		clientSecret: clientSecret,
+		clientID:       clientID,
+		clientSecret:   clientSecret,
+		tokens:         tokens,
+		batchURL:       BatchURL,
+		quota:          newQuotaLimiter(),
+		retryBaseDelay: retryBaseDelay,
+		services:       make(map[string]*accountService),
 	}
 }
 
-// FetchMessageIDs fetches only message IDs from Gmail API (lightweight, fast)
-func (c *Client) FetchMessageIDs(ctx context.Context, accessToken string, query string, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
-	// Create OAuth2 token
-	token := &oauth2.Token{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
+// service returns the account's cached Gmail service
+// Its token source refreshes expired access tokens and persists them, so callers never handle tokens
+func (c *Client) service(ctx context.Context, accountID string) (*gmail.Service, error) {
+	svc, err := c.accountService(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return svc.gmail, nil
+}
+
+// accountService returns the account's cached service, creating it from the stored tokens if needed
+func (c *Client) accountService(ctx context.Context, accountID string) (*accountService, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if svc, ok := c.services[accountID]; ok && time.Since(svc.createdAt) < serviceCacheTTL {
+		return svc, nil
 	}
 
-	// Create Gmail service
-	gmailService, err := gmail.NewService(ctx, option.WithTokenSource(oauth2.StaticTokenSource(token)))
+	account, err := c.tokens.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	token, err := oauth.AccountToken(account)
+	if err != nil {
+		return nil, err
+	}
+
+	// The service outlives this call, so it must not be bound to the caller's context
+	tokenSource := oauth.NewPersistingTokenSource(accountID, token, c.OAuthConfig(), c.tokens, func() {
+		c.forget(accountID)
+	})
+	httpClient := oauth2.NewClient(context.Background(), tokenSource)
+
+	gmailService, err := gmail.NewService(context.Background(), option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
 	}
 
+	svc := &accountService{
+		gmail:      gmailService,
+		httpClient: httpClient,
+		createdAt:  time.Now(),
+	}
+	c.services[accountID] = svc
+	return svc, nil
+}
+
+// forget drops the account's cached service so the next call reloads tokens from the database
+func (c *Client) forget(accountID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.services, accountID)
+}
+
+// OAuthConfig returns the OAuth2 config used to refresh access tokens, IMAP XOAUTH2 logins share it
+func (c *Client) OAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL: "https://oauth2.googleapis.com/token",
+		},
+	}
+}
+
+// FetchMessageIDs fetches only message IDs from Gmail API (lightweight, fast)
+func (c *Client) FetchMessageIDs(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
+	gmailService, err := c.service(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
 	// List messages (only IDs, no full message fetch)
-	listCall := gmailService.Users.Messages.List("me").Q(query).MaxResults(int64(maxResults))
+	listCall := gmailService.Users.Messages.List("me").Q(buildQuery(query)).MaxResults(int64(maxResults))
 	if pageToken != "" {
 		listCall = listCall.PageToken(pageToken)
 	}
 
-	listResp, err := listCall.Do()
+	var listResp *gmail.ListMessagesResponse
+	err = c.call(ctx, accountID, quotaMessagesList, func() (err error) {
+		listResp, err = listCall.Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list messages: %w", err)
 	}
@@ -67,22 +160,33 @@ func (c *Client) FetchMessageIDs(ctx context.Context, accessToken string, query
 	}, nil
 }
 
-// FetchEmailByID fetches a single email by its Gmail message ID
-func (c *Client) FetchEmailByID(ctx context.Context, accessToken string, messageID string) (*service.EmailMessage, error) {
-	// Create OAuth2 token
-	token := &oauth2.Token{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
+// buildQuery builds the Gmail search query for a query sync
+// Only emails directly to the user (deliveredto:me excludes CC'ed emails), excluding spam, sent and the social
+// category (pure noise with no payment emails). Gmail returns the newest emails first
+func buildQuery(query service.SearchQuery) string {
+	q := "in:inbox -in:spam -category:social deliveredto:me"
+	if len(query.Keywords) > 0 {
+		q += " {" + strings.Join(query.Keywords, " OR ") + "}"
+	}
+	if !query.After.IsZero() {
+		q += " after:" + query.After.Format("2006/01/02")
 	}
+	return q
+}
 
-	// Create Gmail service
-	gmailService, err := gmail.NewService(ctx, option.WithTokenSource(oauth2.StaticTokenSource(token)))
+// FetchEmailByID fetches a single email by its Gmail message ID
+func (c *Client) FetchEmailByID(ctx context.Context, accountID string, messageID string) (*service.EmailMessage, error) {
+	gmailService, err := c.service(ctx, accountID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+		return nil, err
 	}
 
 	// Fetch full message by ID
-	fullMsg, err := gmailService.Users.Messages.Get("me", messageID).Format("full").Do()
+	var fullMsg *gmail.Message
+	err = c.call(ctx, accountID, quotaMessagesGet, func() (err error) {
+		fullMsg, err = gmailService.Users.Messages.Get("me", messageID).Format("full").Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get message: %w", err)
 	}
@@ -97,48 +201,48 @@ func (c *Client) FetchEmailByID(ctx context.Context, accessToken string, message
 }
 
 // FetchEmails fetches emails from Gmail API
-func (c *Client) FetchEmails(ctx context.Context, accessToken string, query string, maxResults int, pageToken string) (*service.EmailFetchResult, error) {
-	// Create OAuth2 token
-	token := &oauth2.Token{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
-	}
-
-	// Create Gmail service
-	gmailService, err := gmail.NewService(ctx, option.WithTokenSource(oauth2.StaticTokenSource(token)))
+func (c *Client) FetchEmails(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.EmailFetchResult, error) {
+	gmailService, err := c.service(ctx, accountID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+		return nil, err
 	}
 
 	// List messages
-	listCall := gmailService.Users.Messages.List("me").Q(query).MaxResults(int64(maxResults))
+	listCall := gmailService.Users.Messages.List("me").Q(buildQuery(query)).MaxResults(int64(maxResults))
 	if pageToken != "" {
 		listCall = listCall.PageToken(pageToken)
 	}
 
-	listResp, err := listCall.Do()
+	var listResp *gmail.ListMessagesResponse
+	err = c.call(ctx, accountID, quotaMessagesList, func() (err error) {
+		listResp, err = listCall.Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list messages: %w", err)
 	}
 
 	log.Printf("Gmail API returned %d messages (nextPageToken: %s)", len(listResp.Messages), listResp.NextPageToken)
 
-	// Fetch full message details for each message
-	messages := make([]service.EmailMessage, 0, len(listResp.Messages))
+	// Fetch full message details in batch requests
+	messageIDs := make([]string, 0, len(listResp.Messages))
 	for _, msg := range listResp.Messages {
-		fullMsg, err := gmailService.Users.Messages.Get("me", msg.Id).Format("full").Do()
-		if err != nil {
-			log.Printf("Warning: failed to get message %s: %v", msg.Id, err)
-			continue
-		}
+		messageIDs = append(messageIDs, msg.Id)
+	}
 
-		emailMsg, err := c.parseMessage(fullMsg)
-		if err != nil {
-			log.Printf("Warning: failed to parse message %s: %v", msg.Id, err)
+	fetched, err := c.FetchEmailsByIDs(ctx, accountID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]service.EmailMessage, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		emailMsg, ok := fetched.Messages[messageID]
+		if !ok {
+			log.Printf("Warning: failed to get message %s: %v", messageID, fetched.Errors[messageID])
 			continue
 		}
-
-		messages = append(messages, emailMsg)
+		messages = append(messages, *emailMsg)
 	}
 
 	return &service.EmailFetchResult{
@@ -148,6 +252,183 @@ func (c *Client) FetchEmails(ctx context.Context, accessToken string, query stri
 	}, nil
 }
 
+// FetchAttachment downloads an attachment's bytes (users.messages.attachments.get)
+func (c *Client) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	gmailService, err := c.service(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var body *gmail.MessagePartBody
+	err = c.call(ctx, accountID, quotaAttachment, func() (err error) {
+		body, err = gmailService.Users.Messages.Attachments.Get("me", messageID, attachmentID).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+
+	data, err := decodeBase64URL(body.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attachment: %w", err)
+	}
+
+	return data, nil
+}
+
+// GetProfile returns the mailbox's email address and current historyId (users.getProfile)
+func (c *Client) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	gmailService, err := c.service(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile *gmail.Profile
+	err = c.call(ctx, accountID, quotaGetProfile, func() (err error) {
+		profile, err = gmailService.Users.GetProfile("me").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	return &service.MailboxProfile{
+		EmailAddress: profile.EmailAddress,
+		HistoryID:    strconv.FormatUint(profile.HistoryId, 10),
+	}, nil
+}
+
+// Watch starts (or renews) Gmail push notifications for inbox changes to the given Pub/Sub topic (users.watch)
+func (c *Client) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	gmailService, err := c.service(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCall := gmailService.Users.Watch("me", &gmail.WatchRequest{
+		TopicName:         topicName,
+		LabelIds:          []string{"INBOX"},
+		LabelFilterAction: "include",
+	}).Context(ctx)
+
+	var watchResp *gmail.WatchResponse
+	err = c.call(ctx, accountID, quotaWatch, func() (err error) {
+		watchResp, err = watchCall.Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch mailbox: %w", err)
+	}
+
+	return &service.WatchResult{
+		HistoryID:  strconv.FormatUint(watchResp.HistoryId, 10),
+		Expiration: time.UnixMilli(watchResp.Expiration),
+	}, nil
+}
+
+// FetchHistory fetches IDs of inbox messages added since startHistoryID (users.history.list)
+// Only messages whose subject or snippet matches the query keywords are returned (see matchingMessages)
+// Returns service.ErrHistoryTooOld when Gmail no longer has history for startHistoryID (404) or it is not a Gmail historyId
+func (c *Client) FetchHistory(ctx context.Context, accountID string, startHistoryID string, query service.SearchQuery, pageToken string) (*service.HistoryFetchResult, error) {
+	historyID, err := strconv.ParseUint(startHistoryID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Gmail history ID %q", service.ErrHistoryTooOld, startHistoryID)
+	}
+
+	gmailService, err := c.service(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only messageAdded records for the inbox (label changes and deletions are not needed)
+	listCall := gmailService.Users.History.List("me").
+		StartHistoryId(historyID).
+		HistoryTypes("messageAdded").
+		LabelId("INBOX").
+		Context(ctx)
+	if pageToken != "" {
+		listCall = listCall.PageToken(pageToken)
+	}
+
+	var historyResp *gmail.ListHistoryResponse
+	err = c.call(ctx, accountID, quotaHistoryList, func() (err error) {
+		historyResp, err = listCall.Do()
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", service.ErrHistoryTooOld, err)
+		}
+		return nil, fmt.Errorf("failed to list history: %w", err)
+	}
+
+	// Extract added message IDs (a message can appear in several history records)
+	seen := make(map[string]bool)
+	messageIDs := make([]string, 0)
+	for _, history := range historyResp.History {
+		for _, added := range history.MessagesAdded {
+			if added.Message == nil || seen[added.Message.Id] || skipHistoryMessage(added.Message.LabelIds) {
+				continue
+			}
+			seen[added.Message.Id] = true
+			messageIDs = append(messageIDs, added.Message.Id)
+		}
+	}
+
+	log.Printf("Gmail API returned %d added message IDs from history (nextPageToken: %s)", len(messageIDs), historyResp.NextPageToken)
+
+	messageIDs, err = c.matchingMessages(ctx, accountID, messageIDs, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.HistoryFetchResult{
+		MessageIDs:    messageIDs,
+		NextPageToken: historyResp.NextPageToken,
+		HistoryID:     strconv.FormatUint(historyResp.HistoryId, 10),
+	}, nil
+}
+
+// skipHistoryMessage mirrors the search query filters for history results (no spam, sent or social)
+func skipHistoryMessage(labels []string) bool {
+	for _, label := range []string{"SPAM", "SENT", "CATEGORY_SOCIAL"} {
+		if slices.Contains(labels, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingMessages keeps the messages whose subject or snippet matches the query keywords
+// History records carry no content and Gmail can't search a list of IDs, so the subject and snippet are fetched
+// in batches (format=metadata). Messages whose metadata could not be fetched are kept, except deleted ones
+func (c *Client) matchingMessages(ctx context.Context, accountID string, messageIDs []string, query service.SearchQuery) ([]string, error) {
+	if len(query.Keywords) == 0 || len(messageIDs) == 0 {
+		return messageIDs, nil
+	}
+
+	result, err := c.fetchByIDs(ctx, accountID, messageIDs, formatMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message metadata: %w", err)
+	}
+
+	matching := make([]string, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		if msg, ok := result.Messages[messageID]; ok {
+			if query.Matches(msg.Subject, msg.Snippet) {
+				matching = append(matching, messageID)
+			}
+			continue
+		}
+		if !errors.Is(result.Errors[messageID], service.ErrNotFound) {
+			matching = append(matching, messageID)
+		}
+	}
+
+	log.Printf("%d of %d history messages match the search keywords", len(matching), len(messageIDs))
+	return matching, nil
+}
+
 // parseMessage parses Gmail message into EmailMessage struct with all fields
 func (c *Client) parseMessage(msg *gmail.Message) (service.EmailMessage, error) {
 	emailMsg := service.EmailMessage{
@@ -170,18 +451,19 @@ func (c *Client) parseMessage(msg *gmail.Message) (service.EmailMessage, error)
 	for _, header := range msg.Payload.Headers {
 		emailMsg.RawHeaders[header.Name] = header.Value
 
-		switch header.Name {
-		case "Subject":
-			emailMsg.Subject = header.Value
-		case "From":
-			emailMsg.From = header.Value
-		case "To":
-			emailMsg.To = header.Value
-		case "Cc":
-			emailMsg.CC = header.Value
-		case "Bcc":
-			emailMsg.BCC = header.Value
-		case "Date":
+		// Header names are case-insensitive, address and subject headers may use RFC 2047 encoded words
+		switch strings.ToLower(header.Name) {
+		case "subject":
+			emailMsg.Subject = emailtext.DecodeHeader(header.Value)
+		case "from":
+			emailMsg.From = emailtext.DecodeHeader(header.Value)
+		case "to":
+			emailMsg.To = emailtext.DecodeHeader(header.Value)
+		case "cc":
+			emailMsg.CC = emailtext.DecodeHeader(header.Value)
+		case "bcc":
+			emailMsg.BCC = emailtext.DecodeHeader(header.Value)
+		case "date":
 			parsedDate, err := parseEmailDate(header.Value)
 			if err != nil {
 				log.Printf("Warning: failed to parse date '%s': %v", header.Value, err)
@@ -213,50 +495,6 @@ func (c *Client) parseMessage(msg *gmail.Message) (service.EmailMessage, error)
 	return emailMsg, nil
 }
 
-// extractBodies extracts both text and HTML bodies from message payload
-func (c *Client) extractBodies(payload *gmail.MessagePart) (string, string) {
-	var textPlain, textHTML string
-
-	// Check if body is in the main payload
-	if payload.Body != nil && payload.Body.Data != "" {
-		decoded, err := base64.URLEncoding.DecodeString(payload.Body.Data)
-		if err == nil {
-			switch payload.MimeType {
-			case "text/plain":
-				textPlain = string(decoded)
-			case "text/html":
-				textHTML = string(decoded)
-			}
-		}
-	}
-
-	// Recursively extract from parts
-	c.extractBodiesFromParts(payload.Parts, &textPlain, &textHTML)
-
-	return textPlain, textHTML
-}
-
-// extractBodiesFromParts recursively extracts text and HTML from message parts
-func (c *Client) extractBodiesFromParts(parts []*gmail.MessagePart, textPlain, textHTML *string) {
-	for _, part := range parts {
-		if part.Body != nil && part.Body.Data != "" {
-			decoded, err := base64.URLEncoding.DecodeString(part.Body.Data)
-			if err == nil {
-				if part.MimeType == "text/plain" && *textPlain == "" {
-					*textPlain = string(decoded)
-				} else if part.MimeType == "text/html" && *textHTML == "" {
-					*textHTML = string(decoded)
-				}
-			}
-		}
-
-		// Recursively check nested parts
-		if len(part.Parts) > 0 {
-			c.extractBodiesFromParts(part.Parts, textPlain, textHTML)
-		}
-	}
-}
-
 // extractAttachments extracts attachment metadata from message payload
 func (c *Client) extractAttachments(payload *gmail.MessagePart) []map[string]interface{} {
 	attachments := []map[string]interface{}{}
@@ -265,17 +503,26 @@ func (c *Client) extractAttachments(payload *gmail.MessagePart) []map[string]int
 }
 
 // extractAttachmentsFromParts recursively extracts attachment info from parts
+// Attachments have an attachmentId for FetchAttachment, or their decoded content as data when Gmail inlined them
 func (c *Client) extractAttachmentsFromParts(parts []*gmail.MessagePart, attachments *[]map[string]interface{}) {
 	for _, part := range parts {
 		// Check if this part is an attachment
 		if part.Filename != "" && part.Body != nil {
 			attachment := map[string]interface{}{
-				"filename": part.Filename,
+				"filename": emailtext.DecodeHeader(part.Filename),
 				"mimeType": part.MimeType,
 				"size":     part.Body.Size,
 			}
 			if part.Body.AttachmentId != "" {
 				attachment["attachmentId"] = part.Body.AttachmentId
+			} else if part.Body.Data != "" {
+				// Small attachments come inline with the message and have no attachment ID to download them by
+				data, err := decodeBase64URL(part.Body.Data)
+				if err != nil {
+					log.Printf("Warning: failed to decode inline attachment %s: %v", part.PartId, err)
+				} else {
+					attachment["data"] = data
+				}
 			}
 			*attachments = append(*attachments, attachment)
 		}
@@ -287,44 +534,6 @@ func (c *Client) extractAttachmentsFromParts(parts []*gmail.MessagePart, attachm
 	}
 }
 
-// RefreshAccessToken refreshes the OAuth2 access token
-func (c *Client) RefreshAccessToken(ctx context.Context, refreshToken string) (*service.TokenRefreshResult, error) {
-	config := &oauth2.Config{
-		ClientID:     c.clientID,
-		ClientSecret: c.clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: "https://oauth2.googleapis.com/token",
-		},
-	}
-
-	token := &oauth2.Token{
-		RefreshToken: refreshToken,
-	}
-
-	// Refresh the token
-	tokenSource := config.TokenSource(ctx, token)
-	newToken, err := tokenSource.Token()
-	if err != nil {
-		return nil, fmt.Errorf("failed to refresh token: %w", err)
-	}
-
-	result := &service.TokenRefreshResult{
-		AccessToken: newToken.AccessToken,
-		ExpiresAt:   newToken.Expiry,
-	}
-
-	// Check if refresh token was rotated
-	if newToken.RefreshToken != "" && newToken.RefreshToken != refreshToken {
-		result.RefreshToken = newToken.RefreshToken
-	} else {
-		result.RefreshToken = refreshToken // Keep the same refresh token
-	}
-
-	log.Printf("Token refreshed successfully, expires at: %s", result.ExpiresAt)
-
-	return result, nil
-}
-
 // parseEmailDate parses various email date formats
 func parseEmailDate(dateStr string) (time.Time, error) {
 	// Common email date formats
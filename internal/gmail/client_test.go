@@ -0,0 +1,41 @@
+package gmail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+func TestBuildQuery(t *testing.T) {
+	after := time.Date(2025, 1, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query service.SearchQuery
+		want  string
+	}{
+		{
+			name:  "keywords and date",
+			query: service.SearchQuery{After: after, Keywords: []string{"invoice", "bill", "auto-pay"}},
+			want:  "in:inbox -in:spam -category:social deliveredto:me {invoice OR bill OR auto-pay} after:2025/01/05",
+		},
+		{
+			name:  "no keywords",
+			query: service.SearchQuery{After: after},
+			want:  "in:inbox -in:spam -category:social deliveredto:me after:2025/01/05",
+		},
+		{
+			name:  "empty",
+			query: service.SearchQuery{},
+			want:  "in:inbox -in:spam -category:social deliveredto:me",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildQuery(tt.query); got != tt.want {
+				t.Errorf("buildQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
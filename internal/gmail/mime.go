@@ -0,0 +1,122 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+)
+
+// messageBodies holds the first plain-text and HTML body found in a message
+type messageBodies struct {
+	text string
+	html string
+}
+
+// extractBodies extracts the plain-text and HTML bodies from a message payload, converted to UTF-8
+// Attachments (including text attachments) are never used as the body
+func (c *Client) extractBodies(payload *gmail.MessagePart) (string, string) {
+	var bodies messageBodies
+	collectBodies(payload, &bodies)
+	return bodies.text, bodies.html
+}
+
+// collectBodies walks the MIME tree and fills in bodies that are still empty
+//   - multipart/alternative: the last (richest) representation of each type wins
+//   - multipart/related: only the root part is a body, the rest are inline resources (images)
+//   - other multipart types (mixed, signed, ...) and message/rfc822: parts in order
+func collectBodies(part *gmail.MessagePart, bodies *messageBodies) {
+	if part == nil || isAttachmentPart(part) {
+		return
+	}
+
+	mediaType := strings.ToLower(part.MimeType)
+	switch {
+	case mediaType == "text/plain":
+		if bodies.text == "" {
+			bodies.text = decodePartBody(part)
+		}
+	case mediaType == "text/html":
+		if bodies.html == "" {
+			bodies.html = decodePartBody(part)
+		}
+	case mediaType == "multipart/alternative":
+		var alternative messageBodies
+		for i := len(part.Parts) - 1; i >= 0; i-- {
+			collectBodies(part.Parts[i], &alternative)
+		}
+		if bodies.text == "" {
+			bodies.text = alternative.text
+		}
+		if bodies.html == "" {
+			bodies.html = alternative.html
+		}
+	case mediaType == "multipart/related":
+		if len(part.Parts) > 0 {
+			collectBodies(part.Parts[0], bodies)
+		}
+	case strings.HasPrefix(mediaType, "multipart/") || mediaType == "message/rfc822":
+		for _, child := range part.Parts {
+			collectBodies(child, bodies)
+		}
+	}
+}
+
+// isAttachmentPart reports parts that are attachments rather than message bodies
+func isAttachmentPart(part *gmail.MessagePart) bool {
+	if part.Filename != "" || (part.Body != nil && part.Body.AttachmentId != "") {
+		return true
+	}
+	disposition, _, err := mime.ParseMediaType(partHeader(part, "Content-Disposition"))
+	return err == nil && disposition == "attachment"
+}
+
+// decodePartBody decodes a text part's data (Gmail has already undone the Content-Transfer-Encoding)
+// and converts it from the part's charset to UTF-8
+func decodePartBody(part *gmail.MessagePart) string {
+	if part.Body == nil || part.Body.Data == "" {
+		return ""
+	}
+
+	data, err := decodeBase64URL(part.Body.Data)
+	if err != nil {
+		log.Printf("Warning: failed to decode %s part %s: %v", part.MimeType, part.PartId, err)
+		return ""
+	}
+
+	contentType := partHeader(part, "Content-Type")
+	if contentType == "" {
+		contentType = part.MimeType
+	}
+	return emailtext.DecodeCharset(data, contentType)
+}
+
+// partHeader returns the value of a part header, matching the name case-insensitively
+func partHeader(part *gmail.MessagePart, name string) string {
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// decodeBase64URL decodes Gmail's base64url data, with or without padding
+// Tolerates line breaks and the standard alphabet some encoders use
+func decodeBase64URL(data string) ([]byte, error) {
+	data = strings.TrimRight(strings.Join(strings.Fields(data), ""), "=")
+
+	decoded, err := base64.RawURLEncoding.DecodeString(data)
+	if err == nil {
+		return decoded, nil
+	}
+	if decoded, stdErr := base64.RawStdEncoding.DecodeString(data); stdErr == nil {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("invalid base64 data: %w", err)
+}
@@ -0,0 +1,170 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// loadMessage reads a Gmail API message (format=full) from testdata
+func loadMessage(t *testing.T, name string) *gmail.Message {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var msg gmail.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	return &msg
+}
+
+func TestParseMessage_Fixtures(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		subject         string
+		from            string
+		textContains    []string
+		htmlContains    []string
+		bodyNotContains []string
+		attachments     []string
+	}{
+		{
+			fixture:      "icici_latin1_html.json",
+			subject:      "Transaction alert for your ICICI Bank Credit Card",
+			from:         "credit_cards@icicibank.com",
+			htmlContains: []string{"INR 547.34", "Info: ZOMATO.", "© ICICI Bank Limited"},
+		},
+		{
+			fixture:         "mixed_related_alternative.json",
+			subject:         "Your membership renews soon",
+			from:            "Netflix <info@account.netflix.com>",
+			textContains:    []string{"Your Netflix membership of ₹649 renews on 5 Jan 2026."},
+			htmlContains:    []string{"<b>₹649</b>"},
+			bodyNotContains: []string{"These terms are not the message body."},
+			attachments:     []string{"logo.png", "terms.txt", "Rechnung März.pdf"},
+		},
+		{
+			fixture:      "encoded_headers_windows1252.json",
+			subject:      "Votre facture de décembre – 49,99 €",
+			from:         "Café de Paris <billing@cafe.example>",
+			textContains: []string{"Montant dû : 49,99 €", "Merci de votre fidélité."},
+		},
+		{
+			fixture:         "alternative_preference.json",
+			subject:         "Reçu",
+			from:            "receipts@shop.example",
+			textContains:    []string{"Reçu : 120,00 EUR"},
+			htmlContains:    []string{"Reçu détaillé : 120,00 EUR"},
+			bodyNotContains: []string{"basic receipt"},
+		},
+	}
+
+	client := testClient()
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			msg, err := client.parseMessage(loadMessage(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("parseMessage() error = %v", err)
+			}
+
+			if msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			if msg.From != tt.from {
+				t.Errorf("From = %q, want %q", msg.From, tt.from)
+			}
+			for _, want := range tt.textContains {
+				if !strings.Contains(msg.BodyText, want) {
+					t.Errorf("BodyText = %q, want it to contain %q", msg.BodyText, want)
+				}
+			}
+			if len(tt.textContains) == 0 && msg.BodyText != "" {
+				t.Errorf("BodyText = %q, want empty", msg.BodyText)
+			}
+			for _, want := range tt.htmlContains {
+				if !strings.Contains(msg.BodyHTML, want) {
+					t.Errorf("BodyHTML = %q, want it to contain %q", msg.BodyHTML, want)
+				}
+			}
+			for _, unwanted := range tt.bodyNotContains {
+				if strings.Contains(msg.BodyText, unwanted) || strings.Contains(msg.BodyHTML, unwanted) {
+					t.Errorf("body contains %q", unwanted)
+				}
+			}
+
+			var filenames []string
+			for _, attachment := range msg.Attachments {
+				filenames = append(filenames, attachment["filename"].(string))
+			}
+			if strings.Join(filenames, ",") != strings.Join(tt.attachments, ",") {
+				t.Errorf("attachments = %v, want %v", filenames, tt.attachments)
+			}
+		})
+	}
+}
+
+func TestParseMessage_InlineAttachment(t *testing.T) {
+	pdf := []byte("%PDF-1.4 Amount due: 1,250.00 INR")
+	msg := &gmail.Message{
+		Id: "msg-1",
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/mixed",
+			Parts: []*gmail.MessagePart{
+				{PartId: "0", MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte("See attached"))}},
+				{PartId: "1", MimeType: "application/pdf", Filename: "bill.pdf", Body: &gmail.MessagePartBody{Size: int64(len(pdf)), Data: base64.URLEncoding.EncodeToString(pdf)}},
+				{PartId: "2", MimeType: "application/pdf", Filename: "statement.pdf", Body: &gmail.MessagePartBody{Size: 90000, AttachmentId: "ANGjdJ-statement"}},
+			},
+		},
+	}
+
+	parsed, err := testClient().parseMessage(msg)
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+	if len(parsed.Attachments) != 2 {
+		t.Fatalf("attachments = %v, want bill.pdf and statement.pdf", parsed.Attachments)
+	}
+
+	// Gmail inlines small attachments instead of giving them an attachment ID
+	if data, _ := parsed.Attachments[0]["data"].([]byte); string(data) != string(pdf) {
+		t.Errorf("inline attachment data = %q, want %q", data, pdf)
+	}
+	if parsed.Attachments[1]["attachmentId"] != "ANGjdJ-statement" || parsed.Attachments[1]["data"] != nil {
+		t.Errorf("attachment = %v, want it downloaded by attachment ID", parsed.Attachments[1])
+	}
+}
+
+func TestDecodeBase64URL(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{"unpadded", "Q2Fmw6k", "Café", false},
+		{"padded", "Q2Fmw6k=", "Café", false},
+		{"url alphabet", "_-8", "\xff\xef", false},
+		{"standard alphabet", "/+8", "\xff\xef", false},
+		{"line breaks", "QW1vdW50\r\nIGR1ZQ", "Amount due", false},
+		{"invalid", "!!!", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBase64URL(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBase64URL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeBase64URL(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
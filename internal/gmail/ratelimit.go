@@ -0,0 +1,244 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// Gmail quota units per method (https://developers.google.com/gmail/api/reference/quota)
+const (
+	UserQuotaPerSecond = 250 // Per-user limit, shared by all calls for the account
+
+	quotaMessagesList = 5
+	quotaMessagesGet  = 5
+	quotaAttachment   = 5
+	quotaHistoryList  = 2
+	quotaGetProfile   = 1
+	quotaWatch        = 100
+)
+
+const (
+	maxCallRetries      = 3                // Retries for transient errors (5xx, short rate limits) before giving up
+	retryBaseDelay      = 1 * time.Second  // Backoff before the first retry, doubled on every further retry
+	maxInlineRetryAfter = 10 * time.Second // Longer Retry-After delays are returned as service.RateLimitError (job is rescheduled)
+)
+
+// Error reasons Gmail uses for rate limits in 403 responses
+var rateLimitReasons = []string{"rateLimitExceeded", "userRateLimitExceeded"}
+
+// quotaLimiter budgets Gmail quota units per account and remembers when Gmail asked an account to back off
+type quotaLimiter struct {
+	mu       sync.Mutex
+	accounts map[string]*accountQuota // keyed by account ID
+}
+
+type accountQuota struct {
+	limiter      *rate.Limiter
+	blockedUntil time.Time // Set from Retry-After, no calls are made for the account before then
+}
+
+func newQuotaLimiter() *quotaLimiter {
+	return &quotaLimiter{accounts: make(map[string]*accountQuota)}
+}
+
+// account returns the account's quota state, creating it on first use
+func (q *quotaLimiter) account(accountID string) *accountQuota {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	quota, ok := q.accounts[accountID]
+	if !ok {
+		// Burst covers the largest single request (a full batch of messages.get)
+		quota = &accountQuota{
+			limiter: rate.NewLimiter(rate.Limit(UserQuotaPerSecond), max(UserQuotaPerSecond, MaxBatchSize*quotaMessagesGet)),
+		}
+		q.accounts[accountID] = quota
+	}
+	return quota
+}
+
+// wait blocks until the account has units quota units available
+// Returns a service.RateLimitError without waiting when the account is backing off for longer than maxInlineRetryAfter
+func (q *quotaLimiter) wait(ctx context.Context, accountID string, units int) error {
+	quota := q.account(accountID)
+
+	q.mu.Lock()
+	backoff := time.Until(quota.blockedUntil)
+	q.mu.Unlock()
+
+	if backoff > maxInlineRetryAfter {
+		return &service.RateLimitError{RetryAfter: backoff, Err: fmt.Errorf("account %s is backing off", accountID)}
+	}
+	if backoff > 0 {
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
+	return quota.limiter.WaitN(ctx, units)
+}
+
+// block stops calls for the account until the given time (Retry-After)
+func (q *quotaLimiter) block(accountID string, until time.Time) {
+	quota := q.account(accountID)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if until.After(quota.blockedUntil) {
+		quota.blockedUntil = until
+	}
+}
+
+// call runs a Gmail request costing units quota units for the account
+// Waits for the account's quota, retries 5xx and short rate limits with backoff, and maps failures to service errors
+// (service.RateLimitError, service.ErrTokenRevoked, service.ErrNotFound)
+func (c *Client) call(ctx context.Context, accountID string, units int, do func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := c.quota.wait(ctx, accountID, units); err != nil {
+			return err
+		}
+
+		err := do()
+		if err == nil {
+			return nil
+		}
+
+		switch {
+		case isRateLimited(err):
+			retryAfter, ok := retryAfterHeader(err)
+			if !ok {
+				retryAfter = c.backoff(attempt)
+			}
+			c.quota.block(accountID, time.Now().Add(retryAfter))
+
+			if attempt >= maxCallRetries || retryAfter > maxInlineRetryAfter {
+				log.Printf("Gmail rate limit for account %s, retry after %s", accountID, retryAfter)
+				return &service.RateLimitError{RetryAfter: retryAfter, Err: err}
+			}
+			// wait honours the block before the next attempt
+
+		case isTransient(err) && attempt < maxCallRetries:
+			delay := c.backoff(attempt)
+			log.Printf("Gmail request failed for account %s (attempt %d), retrying in %s: %v", accountID, attempt+1, delay, err)
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+
+		default:
+			return c.classifyError(accountID, err)
+		}
+	}
+}
+
+// classifyError wraps err with the matching service error so callers can use errors.Is
+func (c *Client) classifyError(accountID string, err error) error {
+	if isRateLimited(err) {
+		retryAfter, _ := retryAfterHeader(err)
+		return &service.RateLimitError{RetryAfter: retryAfter, Err: err}
+	}
+	if isTokenRevoked(err) {
+		// Reload tokens from the database next time (the user may have re-authenticated)
+		c.forget(accountID)
+		return fmt.Errorf("%w: %v", service.ErrTokenRevoked, err)
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", service.ErrNotFound, err)
+	}
+	return err
+}
+
+// backoff returns the delay before retry attempt+1 (exponential, with jitter)
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retryBaseDelay << attempt
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half+1)
+}
+
+// isRateLimited reports whether err is a 429, or a 403 with a rate limit reason
+func isRateLimited(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if slices.Contains(rateLimitReasons, item.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient reports whether err is a server error worth retrying
+func isTransient(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code >= http.StatusInternalServerError
+}
+
+// isTokenRevoked reports whether err means the account's tokens no longer work
+// A refresh failing with invalid_grant means the refresh token was revoked or expired
+func isTokenRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.ErrorCode == "invalid_grant"
+	}
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized
+}
+
+// retryAfterHeader returns the delay from the response's Retry-After header (seconds or HTTP date)
+func retryAfterHeader(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil && time.Until(at) > 0 {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
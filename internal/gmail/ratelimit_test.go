@@ -0,0 +1,215 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// testClient returns a client that retries without waiting seconds between attempts
+func testClient() *Client {
+	client := NewClient("client-id", "client-secret", nil)
+	client.retryBaseDelay = time.Millisecond
+	return client
+}
+
+func rateLimitErr(code int, reason string, retryAfter string) error {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &googleapi.Error{
+		Code:    code,
+		Message: "Rate limit exceeded",
+		Header:  header,
+		Errors:  []googleapi.ErrorItem{{Reason: reason, Message: "Rate limit exceeded"}},
+	}
+}
+
+func TestCall_RetriesTransientErrors(t *testing.T) {
+	client := testClient()
+
+	calls := 0
+	err := client.call(context.Background(), "acc-1", quotaMessagesGet, func() error {
+		calls++
+		if calls < 3 {
+			return &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "Backend Error"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestCall_GivesUpOnPersistentServerErrors(t *testing.T) {
+	client := testClient()
+
+	calls := 0
+	err := client.call(context.Background(), "acc-1", quotaMessagesGet, func() error {
+		calls++
+		return &googleapi.Error{Code: http.StatusInternalServerError, Message: "Backend Error"}
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if calls != maxCallRetries+1 {
+		t.Errorf("expected %d calls, got %d", maxCallRetries+1, calls)
+	}
+	if _, ok := service.RescheduleDelay(err); ok {
+		t.Errorf("expected server error to count as a failed attempt, got %v", err)
+	}
+}
+
+func TestCall_RateLimited(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"429", rateLimitErr(http.StatusTooManyRequests, "rateLimitExceeded", "120")},
+		{"403 userRateLimitExceeded", rateLimitErr(http.StatusForbidden, "userRateLimitExceeded", "120")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient()
+
+			calls := 0
+			err := client.call(context.Background(), "acc-1", quotaMessagesList, func() error {
+				calls++
+				return tt.err
+			})
+
+			// Retry-After beyond maxInlineRetryAfter is returned right away so the job can be rescheduled
+			if calls != 1 {
+				t.Errorf("expected 1 call, got %d", calls)
+			}
+			var rateLimitErr *service.RateLimitError
+			if !errors.As(err, &rateLimitErr) {
+				t.Fatalf("expected RateLimitError, got %v", err)
+			}
+			if rateLimitErr.RetryAfter != 120*time.Second {
+				t.Errorf("expected retry after 120s, got %s", rateLimitErr.RetryAfter)
+			}
+
+			// The account backs off without calling Gmail, other accounts are unaffected
+			err = client.call(context.Background(), "acc-1", quotaMessagesList, func() error {
+				calls++
+				return nil
+			})
+			if !errors.Is(err, service.ErrRateLimited) {
+				t.Errorf("expected ErrRateLimited while backing off, got %v", err)
+			}
+			if calls != 1 {
+				t.Errorf("expected no call while backing off, got %d calls", calls)
+			}
+
+			if err := client.call(context.Background(), "acc-2", quotaMessagesList, func() error { return nil }); err != nil {
+				t.Errorf("expected other account not to be rate limited, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCall_RetriesShortRateLimits(t *testing.T) {
+	client := testClient()
+
+	calls := 0
+	err := client.call(context.Background(), "acc-1", quotaHistoryList, func() error {
+		calls++
+		if calls == 1 {
+			return rateLimitErr(http.StatusTooManyRequests, "rateLimitExceeded", "")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestCall_OtherForbiddenIsNotRateLimited(t *testing.T) {
+	client := testClient()
+
+	err := client.call(context.Background(), "acc-1", quotaMessagesGet, func() error {
+		return rateLimitErr(http.StatusForbidden, "insufficientPermissions", "")
+	})
+	if errors.Is(err, service.ErrRateLimited) {
+		t.Errorf("expected 403 insufficientPermissions not to be rate limited, got %v", err)
+	}
+}
+
+func TestCall_ClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", &googleapi.Error{Code: http.StatusNotFound, Message: "Requested entity was not found."}, service.ErrNotFound},
+		{"unauthorized", &googleapi.Error{Code: http.StatusUnauthorized, Message: "Invalid Credentials"}, service.ErrTokenRevoked},
+		{
+			"refresh token revoked",
+			&url.Error{Op: "Get", URL: "https://gmail.googleapis.com", Err: &oauth2.RetrieveError{ErrorCode: "invalid_grant"}},
+			service.ErrTokenRevoked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient()
+
+			calls := 0
+			err := client.call(context.Background(), "acc-1", quotaMessagesGet, func() error {
+				calls++
+				return fmt.Errorf("request failed: %w", tt.err)
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if calls != 1 {
+				t.Errorf("expected no retries, got %d calls", calls)
+			}
+		})
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wantOK bool
+	}{
+		{"seconds", "30", true},
+		{"http date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), true},
+		{"past date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), false},
+		{"zero", "0", false},
+		{"invalid", "soon", false},
+		{"missing", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := retryAfterHeader(rateLimitErr(http.StatusTooManyRequests, "rateLimitExceeded", tt.value))
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok %v, got %v (delay %s)", tt.wantOK, ok, delay)
+			}
+			if ok && delay <= 0 {
+				t.Errorf("expected positive delay, got %s", delay)
+			}
+		})
+	}
+}
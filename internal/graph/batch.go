@@ -0,0 +1,145 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const MaxBatchSize = 20 // Graph accepts up to 20 requests per JSON batch
+
+// batchRequest is one request of a JSON batch (POST /$batch)
+type batchRequest struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"` // Relative to the API version, e.g. /me/messages/{id}
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// batchResponse is one response of a JSON batch, matched to its request by ID
+type batchResponse struct {
+	ID      string            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// FetchEmailsByIDs fetches full messages using JSON batching, up to MaxBatchSize messages per HTTP request
+// Messages that fail individually (e.g. deleted) are reported in the result's Errors instead of failing the call
+// Each batch counts as one request per message against the account's budget
+func (c *Client) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*service.BatchFetchResult, error) {
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage, len(messageIDs)),
+		Errors:   make(map[string]error),
+	}
+
+	for start := 0; start < len(messageIDs); start += MaxBatchSize {
+		chunk := messageIDs[start:min(start+MaxBatchSize, len(messageIDs))]
+		if err := c.fetchBatch(ctx, accountID, chunk, result); err != nil {
+			return nil, err
+		}
+	}
+
+	// Map per-message failures to service errors (deleted messages, throttled requests)
+	for messageID, err := range result.Errors {
+		err = c.classifyError(accountID, err)
+		var rateLimitErr *service.RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+			c.throttle.block(accountID, time.Now().Add(rateLimitErr.RetryAfter))
+		}
+		result.Errors[messageID] = err
+	}
+
+	log.Printf("Graph batch fetched %d of %d messages", len(result.Messages), len(messageIDs))
+
+	return result, nil
+}
+
+// fetchBatch sends one batch request for the given message IDs and adds the outcome to result
+func (c *Client) fetchBatch(ctx context.Context, accountID string, messageIDs []string, result *service.BatchFetchResult) error {
+	body, err := encodeBatchRequest(messageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch request: %w", err)
+	}
+
+	var responses struct {
+		Responses []batchResponse `json:"responses"`
+	}
+	err = c.do(ctx, accountID, len(messageIDs), func(httpClient *http.Client) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/$batch", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return httpClient.Do(req)
+	}, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&responses)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send batch request: %w", err)
+	}
+
+	for _, resp := range responses.Responses {
+		index, err := strconv.Atoi(resp.ID)
+		if err != nil || index < 0 || index >= len(messageIDs) {
+			log.Printf("Warning: unexpected Graph batch response ID %q", resp.ID)
+			continue
+		}
+		messageID := messageIDs[index]
+
+		if resp.Status < 200 || resp.Status > 299 {
+			result.Errors[messageID] = newAPIError(resp.Status, batchHeader(resp.Headers), bytes.NewReader(resp.Body))
+			continue
+		}
+
+		emailMsg, err := parseMessage(resp.Body)
+		if err != nil {
+			result.Errors[messageID] = fmt.Errorf("failed to parse message: %w", err)
+			continue
+		}
+		result.Messages[messageID] = emailMsg
+	}
+
+	// A batch response must answer every request
+	for _, messageID := range messageIDs {
+		if _, ok := result.Messages[messageID]; !ok && result.Errors[messageID] == nil {
+			result.Errors[messageID] = fmt.Errorf("no batch response for message %s", messageID)
+		}
+	}
+
+	return nil
+}
+
+// encodeBatchRequest builds the JSON batch body, request IDs are indexes into messageIDs
+func encodeBatchRequest(messageIDs []string) ([]byte, error) {
+	requests := make([]batchRequest, len(messageIDs))
+	for i, messageID := range messageIDs {
+		requests[i] = batchRequest{
+			ID:     strconv.Itoa(i),
+			Method: http.MethodGet,
+			URL: "/me/messages/" + url.PathEscape(messageID) +
+				"?$select=" + messageFields + "&$expand=attachments($select=id,name,contentType,size,isInline)",
+			Headers: map[string]string{"Prefer": preferImmutableID},
+		}
+	}
+	return json.Marshal(map[string]any{"requests": requests})
+}
+
+// batchHeader converts the headers of a batch response (e.g. Retry-After)
+func batchHeader(headers map[string]string) http.Header {
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	return header
+}
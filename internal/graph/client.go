@@ -0,0 +1,333 @@
+// Package graph is the Microsoft Graph mail source for Outlook.com and Microsoft 365 accounts
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/vipul43/kiwis-worker/internal/oauth"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const (
+	BaseURL       = "https://graph.microsoft.com/v1.0"
+	DefaultTenant = "common" // Personal and work or school accounts
+)
+
+// clientCacheTTL is how long a cached account HTTP client is reused before tokens are reloaded from the database
+const clientCacheTTL = 1 * time.Hour
+
+// messageFields are the message properties fetched for the pipeline
+const messageFields = "id,conversationId,subject,from,toRecipients,ccRecipients,bccRecipients,sentDateTime,receivedDateTime," +
+	"bodyPreview,body,categories,hasAttachments,internetMessageHeaders"
+
+// deltaFields are the message properties delta entries carry, enough to match the search keywords
+const deltaFields = "id,subject,bodyPreview"
+
+type Client struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	tokens       oauth.TokenStore
+	baseURL      string
+
+	throttle       *throttle
+	retryBaseDelay time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*accountClient // keyed by account ID
+}
+
+// accountClient is an HTTP client authorized as one account
+type accountClient struct {
+	httpClient *http.Client
+	createdAt  time.Time
+}
+
+// NewClient creates a Graph client refreshing tokens with the Azure AD app of the given tenant
+func NewClient(clientID, clientSecret, tenant string, tokens oauth.TokenStore) *Client {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	return &Client{
+		clientID:       clientID,
+		clientSecret:   clientSecret,
+		tokenURL:       "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/token",
+		tokens:         tokens,
+		baseURL:        BaseURL,
+		throttle:       newThrottle(),
+		retryBaseDelay: retryBaseDelay,
+		clients:        make(map[string]*accountClient),
+	}
+}
+
+// httpClient returns the account's cached HTTP client, creating it from the stored tokens if needed
+func (c *Client) httpClient(ctx context.Context, accountID string) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[accountID]; ok && time.Since(client.createdAt) < clientCacheTTL {
+		return client.httpClient, nil
+	}
+
+	account, err := c.tokens.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	token, err := oauth.AccountToken(account)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client outlives this call, so it must not be bound to the caller's context
+	tokenSource := oauth.NewPersistingTokenSource(accountID, token, c.OAuthConfig(), c.tokens, func() {
+		c.forget(accountID)
+	})
+	httpClient := oauth2.NewClient(context.Background(), tokenSource)
+
+	c.clients[accountID] = &accountClient{httpClient: httpClient, createdAt: time.Now()}
+	return httpClient, nil
+}
+
+// forget drops the account's cached client so the next call reloads tokens from the database
+func (c *Client) forget(accountID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, accountID)
+}
+
+// OAuthConfig returns the OAuth2 config used to refresh access tokens (Azure AD v2 endpoint), IMAP XOAUTH2 logins share it
+func (c *Client) OAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  c.tokenURL,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// messagePage is a page of a message list or delta response
+type messagePage struct {
+	Value []struct {
+		ID          string          `json:"id"`
+		Subject     *string         `json:"subject"`     // Delta entries only, nil when the delta link does not select it
+		BodyPreview string          `json:"bodyPreview"` // Delta entries only
+		Removed     json.RawMessage `json:"@removed"`    // Set on delta entries for deleted messages
+	} `json:"value"`
+	NextLink  string `json:"@odata.nextLink"`
+	DeltaLink string `json:"@odata.deltaLink"`
+}
+
+// FetchMessageIDs searches the inbox and returns only message IDs (GET /me/mailFolders/inbox/messages?$search)
+// The page token is the @odata.nextLink of the previous page
+func (c *Client) FetchMessageIDs(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
+	requestURL := pageToken
+	if requestURL == "" {
+		params := url.Values{}
+		params.Set("$select", "id")
+		params.Set("$top", strconv.Itoa(maxResults))
+		if search := buildSearch(query); search != "" {
+			params.Set("$search", search)
+		}
+		requestURL = c.baseURL + "/me/mailFolders/inbox/messages?" + params.Encode()
+	} else if !c.isGraphURL(pageToken) {
+		return nil, fmt.Errorf("invalid Graph page token")
+	}
+
+	var page messagePage
+	if err := c.getJSON(ctx, accountID, requestURL, &page); err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	messageIDs := make([]string, 0, len(page.Value))
+	for _, msg := range page.Value {
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	log.Printf("Graph API returned %d message IDs (has next page: %t)", len(messageIDs), page.NextLink != "")
+
+	return &service.MessageIDFetchResult{
+		MessageIDs:    messageIDs,
+		NextPageToken: page.NextLink,
+		TotalFetched:  len(messageIDs),
+	}, nil
+}
+
+// buildSearch builds the KQL $search value for a query sync, e.g. "received>=2025-01-31 AND (invoice OR bill)"
+// Searching the inbox folder leaves out junk, sent items and drafts. Graph returns the newest emails first
+func buildSearch(query service.SearchQuery) string {
+	var terms []string
+	if !query.After.IsZero() {
+		terms = append(terms, "received>="+query.After.Format("2006-01-02"))
+	}
+	if len(query.Keywords) > 0 {
+		keywords := make([]string, len(query.Keywords))
+		for i, keyword := range query.Keywords {
+			keywords[i] = keyword
+			if strings.ContainsFunc(keyword, isKQLSpecial) {
+				keywords[i] = `\"` + keyword + `\"` // Phrase, quotes are escaped inside the quoted $search value
+			}
+		}
+		terms = append(terms, "("+strings.Join(keywords, " OR ")+")")
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	return `"` + strings.Join(terms, " AND ") + `"`
+}
+
+// isKQLSpecial reports whether r needs the keyword to be quoted in KQL (e.g. "auto-pay")
+func isKQLSpecial(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+// FetchAttachment downloads a file attachment's bytes (GET /me/messages/{id}/attachments/{id}/$value)
+func (c *Client) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	requestURL := c.baseURL + "/me/messages/" + url.PathEscape(messageID) + "/attachments/" + url.PathEscape(attachmentID) + "/$value"
+
+	var data []byte
+	err := c.get(ctx, accountID, requestURL, 1, func(body io.Reader) (err error) {
+		data, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return data, nil
+}
+
+// GetProfile returns the mailbox's email address and a delta link tracking inbox messages received from now on
+// The delta round is filtered to messages received after now, so it only pages through mail arriving meanwhile
+// Delta entries carry the subject and preview, which FetchHistory matches against the search keywords
+func (c *Client) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	var user struct {
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := c.getJSON(ctx, accountID, c.baseURL+"/me?$select=mail,userPrincipalName", &user); err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("$select", deltaFields)
+	params.Set("$filter", "receivedDateTime ge "+time.Now().UTC().Format(time.RFC3339))
+	requestURL := c.baseURL + "/me/mailFolders/inbox/messages/delta?" + params.Encode()
+
+	for {
+		var page messagePage
+		if err := c.getJSON(ctx, accountID, requestURL, &page); err != nil {
+			return nil, fmt.Errorf("failed to start delta query: %w", err)
+		}
+		if page.DeltaLink != "" {
+			requestURL = page.DeltaLink
+			break
+		}
+		if page.NextLink == "" {
+			return nil, fmt.Errorf("failed to start delta query: response has no delta link")
+		}
+		requestURL = page.NextLink
+	}
+
+	emailAddress := user.Mail
+	if emailAddress == "" {
+		emailAddress = user.UserPrincipalName
+	}
+
+	return &service.MailboxProfile{
+		EmailAddress: emailAddress,
+		HistoryID:    requestURL,
+	}, nil
+}
+
+// FetchHistory returns the IDs of inbox messages added or changed since the delta link startHistoryID
+// whose subject or preview matches the query keywords
+// Pages are followed via the page token (@odata.nextLink), the last page returns the next delta link as HistoryID
+// Returns service.ErrHistoryTooOld when Graph expired the delta token (HTTP 410) or it is not a Graph delta link,
+// and for delta links that do not select the subject (started before keyword matching), so a fresh one is started
+func (c *Client) FetchHistory(ctx context.Context, accountID string, startHistoryID string, query service.SearchQuery, pageToken string) (*service.HistoryFetchResult, error) {
+	if !c.isGraphURL(startHistoryID) {
+		return nil, fmt.Errorf("%w: not a Graph delta link", service.ErrHistoryTooOld)
+	}
+
+	requestURL := startHistoryID
+	if pageToken != "" {
+		if !c.isGraphURL(pageToken) {
+			return nil, fmt.Errorf("invalid Graph page token")
+		}
+		requestURL = pageToken
+	}
+
+	var page messagePage
+	if err := c.getJSON(ctx, accountID, requestURL, &page); err != nil {
+		return nil, fmt.Errorf("failed to fetch delta: %w", err)
+	}
+
+	// Changed messages (read, moved back to the inbox) are listed too; their LLM jobs already exist and are skipped
+	messageIDs := make([]string, 0, len(page.Value))
+	for _, msg := range page.Value {
+		if msg.Removed != nil {
+			continue
+		}
+		if msg.Subject == nil {
+			return nil, fmt.Errorf("%w: delta link does not select the message subject", service.ErrHistoryTooOld)
+		}
+		if !query.Matches(*msg.Subject, msg.BodyPreview) {
+			continue
+		}
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	log.Printf("Graph API returned %d matching changed message IDs of %d from delta (has next page: %t)",
+		len(messageIDs), len(page.Value), page.NextLink != "")
+
+	return &service.HistoryFetchResult{
+		MessageIDs:    messageIDs,
+		NextPageToken: page.NextLink,
+		HistoryID:     page.DeltaLink,
+	}, nil
+}
+
+// Watch is not supported, Graph accounts are kept up to date by incremental sync
+// (Graph change notifications need their own subscription endpoint)
+// Their email sync jobs stay synced after catching up, also when a Pub/Sub topic is configured
+func (c *Client) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	return nil, service.ErrWatchUnsupported
+}
+
+// isGraphURL reports whether a next or delta link points at the Graph API
+// Links are sent with the account's access token, so they must not point anywhere else
+func (c *Client) isGraphURL(link string) bool {
+	return strings.HasPrefix(link, c.baseURL+"/")
+}
+
+// getJSON sends a GET request costing one request of the account's budget and decodes the JSON response into out
+func (c *Client) getJSON(ctx context.Context, accountID string, requestURL string, out any) error {
+	return c.get(ctx, accountID, requestURL, 1, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(out)
+	})
+}
+
+// get sends a GET request as the account and passes the response body to decode
+func (c *Client) get(ctx context.Context, accountID string, requestURL string, requests int, decode func(body io.Reader) error) error {
+	return c.do(ctx, accountID, requests, func(httpClient *http.Client) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Prefer", preferImmutableID)
+		return httpClient.Do(req)
+	}, decode)
+}
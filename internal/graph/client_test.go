@@ -0,0 +1,391 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// fakeTokens is an in-memory oauth.TokenStore
+type fakeTokens struct {
+	mu      sync.Mutex
+	account models.Account
+	updates int
+}
+
+func (f *fakeTokens) GetByID(ctx context.Context, accountID string) (*models.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	account := f.account
+	return &account, nil
+}
+
+func (f *fakeTokens) UpdateTokens(ctx context.Context, accountID string, accessToken string, refreshToken string, accessTokenExpiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.account.AccessToken = &accessToken
+	f.account.RefreshToken = &refreshToken
+	f.account.AccessTokenExpiresAt = &accessTokenExpiresAt
+	f.updates++
+	return nil
+}
+
+const invoiceMessage = `{
+	"id": "m1",
+	"conversationId": "conv-1",
+	"subject": "Your invoice for March",
+	"from": {"emailAddress": {"name": "Contoso Billing", "address": "billing@contoso.example"}},
+	"toRecipients": [{"emailAddress": {"name": "Anna", "address": "anna@outlook.example"}}],
+	"ccRecipients": [],
+	"sentDateTime": "2026-03-01T09:00:00Z",
+	"receivedDateTime": "2026-03-01T09:00:05Z",
+	"bodyPreview": "Amount due: 49.99 EUR",
+	"body": {"contentType": "html", "content": "<p>Amount due: <b>49.99 EUR</b></p>"},
+	"categories": ["Bills"],
+	"hasAttachments": true,
+	"internetMessageHeaders": [{"name": "Message-ID", "value": "<inv-1@contoso.example>"}],
+	"attachments": [
+		{"@odata.type": "#microsoft.graph.fileAttachment", "id": "a1", "name": "invoice.pdf", "contentType": "application/pdf", "size": 1234, "isInline": false},
+		{"@odata.type": "#microsoft.graph.fileAttachment", "id": "a2", "name": "logo.png", "contentType": "image/png", "size": 99, "isInline": true}
+	]
+}`
+
+// newTestClient returns a client talking to an httptest stand-in for Graph and the Azure AD token endpoint
+func newTestClient(t *testing.T, tokens *fakeTokens) (*Client, *httptest.Server) {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			handleToken(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer access-2" && r.Header.Get("Authorization") != "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"code": "InvalidAuthenticationToken", "message": "expired"}}`)
+			return
+		}
+
+		base := srv.URL + "/v1.0"
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/v1.0/me":
+			fmt.Fprint(w, `{"mail": null, "userPrincipalName": "anna@outlook.example"}`)
+
+		case "/v1.0/me/mailFolders/inbox/messages":
+			if r.Header.Get("Prefer") != preferImmutableID {
+				t.Errorf("Prefer = %q, want %q", r.Header.Get("Prefer"), preferImmutableID)
+			}
+			if query.Get("$skiptoken") == "page2" {
+				fmt.Fprint(w, `{"value": [{"id": "m3"}]}`)
+				return
+			}
+			if query.Get("$top") != "2" || !strings.Contains(query.Get("$search"), "invoice OR") {
+				t.Errorf("list query = %v", query)
+			}
+			fmt.Fprintf(w, `{"value": [{"id": "m1"}, {"id": "m2"}], "@odata.nextLink": %q}`,
+				base+"/me/mailFolders/inbox/messages?$skiptoken=page2")
+
+		case "/v1.0/me/mailFolders/inbox/messages/delta":
+			switch {
+			case query.Get("$deltatoken") == "expired":
+				w.WriteHeader(http.StatusGone)
+				fmt.Fprint(w, `{"error": {"code": "SyncStateNotFound", "message": "resync required"}}`)
+			case query.Get("$deltatoken") == "d1":
+				fmt.Fprintf(w, `{"value": [
+					{"id": "n1", "subject": "Your invoice is ready", "bodyPreview": "Amount due: 12.00 EUR"},
+					{"id": "n2", "subject": "Lunch tomorrow?", "bodyPreview": "See you at noon"},
+					{"id": "n0", "@removed": {"reason": "deleted"}}
+				], "@odata.deltaLink": %q}`, base+"/me/mailFolders/inbox/messages/delta?$deltatoken=d2")
+			case query.Get("$deltatoken") == "ids-only":
+				fmt.Fprint(w, `{"value": [{"id": "n1"}]}`)
+			case query.Get("$skiptoken") == "s1":
+				fmt.Fprintf(w, `{"value": [], "@odata.deltaLink": %q}`, base+"/me/mailFolders/inbox/messages/delta?$deltatoken=d1")
+			case strings.HasPrefix(query.Get("$filter"), "receivedDateTime ge "):
+				if query.Get("$select") != deltaFields {
+					t.Errorf("delta $select = %q, want %q", query.Get("$select"), deltaFields)
+				}
+				fmt.Fprintf(w, `{"value": [], "@odata.nextLink": %q}`, base+"/me/mailFolders/inbox/messages/delta?$skiptoken=s1")
+			default:
+				t.Errorf("unexpected delta query %v", query)
+			}
+
+		case "/v1.0/me/messages/m1/attachments/a1/$value":
+			fmt.Fprint(w, "%PDF-1.4\n")
+
+		case "/v1.0/$batch":
+			handleBatch(t, w, r)
+
+		case "/v1.0/me/messages/throttled":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"code": "ApplicationThrottled", "message": "slow down"}}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": "ErrorItemNotFound", "message": "not found"}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewClient("client-id", "client-secret", "", tokens)
+	c.baseURL = srv.URL + "/v1.0"
+	c.tokenURL = srv.URL + "/token"
+	c.retryBaseDelay = time.Millisecond
+	return c, srv
+}
+
+// handleToken answers refresh_token grants like the Azure AD v2 token endpoint (rotating the refresh token)
+func handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("client_id") != "client-id" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.PostForm.Get("refresh_token") != "refresh-1" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "AADSTS70008: The refresh token has expired"}`)
+		return
+	}
+	fmt.Fprint(w, `{"access_token": "access-2", "refresh_token": "refresh-2", "token_type": "Bearer", "expires_in": 3600}`)
+}
+
+// handleBatch answers a JSON batch, m1 exists and every other message is deleted
+func handleBatch(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	var batch struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		t.Fatalf("failed to decode batch: %v", err)
+	}
+
+	var responses []string
+	for _, req := range batch.Requests {
+		if req.Headers["Prefer"] != preferImmutableID || !strings.Contains(req.URL, "$expand=attachments") {
+			t.Errorf("batch request = %+v", req)
+		}
+		if strings.HasPrefix(req.URL, "/me/messages/m1?") {
+			responses = append(responses, fmt.Sprintf(`{"id": %q, "status": 200, "body": %s}`, req.ID, invoiceMessage))
+			continue
+		}
+		responses = append(responses, fmt.Sprintf(`{"id": %q, "status": 404, "body": {"error": {"code": "ErrorItemNotFound", "message": "gone"}}}`, req.ID))
+	}
+	fmt.Fprintf(w, `{"responses": [%s]}`, strings.Join(responses, ","))
+}
+
+func validTokens() *fakeTokens {
+	accessToken, refreshToken := "access-1", "refresh-1"
+	expiry := time.Now().Add(time.Hour)
+	return &fakeTokens{account: models.Account{
+		ID:                   "acc-1",
+		ProviderID:           models.ProviderMicrosoft,
+		AccessToken:          &accessToken,
+		RefreshToken:         &refreshToken,
+		AccessTokenExpiresAt: &expiry,
+	}}
+}
+
+func TestClient_FetchMessageIDs(t *testing.T) {
+	c, _ := newTestClient(t, validTokens())
+	ctx := context.Background()
+
+	query := service.SearchQuery{After: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), Keywords: service.PaymentKeywords}
+	first, err := c.FetchMessageIDs(ctx, "acc-1", query, 2, "")
+	if err != nil {
+		t.Fatalf("FetchMessageIDs() error = %v", err)
+	}
+	if strings.Join(first.MessageIDs, ",") != "m1,m2" || first.NextPageToken == "" {
+		t.Fatalf("FetchMessageIDs() = %v (next %q), want m1,m2 and a next page", first.MessageIDs, first.NextPageToken)
+	}
+
+	second, err := c.FetchMessageIDs(ctx, "acc-1", query, 2, first.NextPageToken)
+	if err != nil {
+		t.Fatalf("FetchMessageIDs() page 2 error = %v", err)
+	}
+	if strings.Join(second.MessageIDs, ",") != "m3" || second.NextPageToken != "" {
+		t.Errorf("FetchMessageIDs() page 2 = %v (next %q), want m3 and no next page", second.MessageIDs, second.NextPageToken)
+	}
+
+	// Next links are sent with the access token, they must point at Graph
+	if _, err := c.FetchMessageIDs(ctx, "acc-1", query, 2, "https://attacker.example/v1.0/me"); err == nil {
+		t.Error("FetchMessageIDs() with foreign page token error = nil, want error")
+	}
+}
+
+func TestClient_FetchEmailsByIDs(t *testing.T) {
+	c, _ := newTestClient(t, validTokens())
+
+	result, err := c.FetchEmailsByIDs(context.Background(), "acc-1", []string{"m1", "gone"})
+	if err != nil {
+		t.Fatalf("FetchEmailsByIDs() error = %v", err)
+	}
+
+	msg := result.Messages["m1"]
+	if msg == nil {
+		t.Fatalf("FetchEmailsByIDs() missing m1, errors = %v", result.Errors)
+	}
+	if msg.From != "Contoso Billing <billing@contoso.example>" || msg.To != "Anna <anna@outlook.example>" {
+		t.Errorf("From, To = %q, %q", msg.From, msg.To)
+	}
+	if msg.ThreadID != "conv-1" || msg.Subject != "Your invoice for March" || msg.BodyHTML == "" || msg.BodyText != "" {
+		t.Errorf("message = %+v", msg)
+	}
+	if !msg.InternalDate.Equal(time.Date(2026, 3, 1, 9, 0, 5, 0, time.UTC)) {
+		t.Errorf("InternalDate = %v", msg.InternalDate)
+	}
+	if msg.RawHeaders["Message-ID"] != "<inv-1@contoso.example>" {
+		t.Errorf("RawHeaders = %v", msg.RawHeaders)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0]["attachmentId"] != "a1" || !msg.HasAttachments {
+		t.Errorf("Attachments = %v, want only invoice.pdf", msg.Attachments)
+	}
+
+	if !errors.Is(result.Errors["gone"], service.ErrNotFound) {
+		t.Errorf("Errors[gone] = %v, want ErrNotFound", result.Errors["gone"])
+	}
+}
+
+func TestClient_FetchAttachment(t *testing.T) {
+	c, _ := newTestClient(t, validTokens())
+
+	data, err := c.FetchAttachment(context.Background(), "acc-1", "m1", "a1")
+	if err != nil {
+		t.Fatalf("FetchAttachment() error = %v", err)
+	}
+	if string(data) != "%PDF-1.4\n" {
+		t.Errorf("FetchAttachment() = %q", data)
+	}
+
+	if _, err := c.FetchAttachment(context.Background(), "acc-1", "m1", "missing"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("FetchAttachment() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClient_DeltaSync(t *testing.T) {
+	c, srv := newTestClient(t, validTokens())
+	ctx := context.Background()
+
+	profile, err := c.GetProfile(ctx, "acc-1")
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if profile.EmailAddress != "anna@outlook.example" || !strings.HasSuffix(profile.HistoryID, "$deltatoken=d1") {
+		t.Fatalf("GetProfile() = %+v, want userPrincipalName and the d1 delta link", profile)
+	}
+
+	query := service.SearchQuery{Keywords: service.PaymentKeywords}
+	result, err := c.FetchHistory(ctx, "acc-1", profile.HistoryID, query, "")
+	if err != nil {
+		t.Fatalf("FetchHistory() error = %v", err)
+	}
+	if strings.Join(result.MessageIDs, ",") != "n1" || !strings.HasSuffix(result.HistoryID, "$deltatoken=d2") {
+		t.Errorf("FetchHistory() = %v (history %q), want n1 and the d2 delta link", result.MessageIDs, result.HistoryID)
+	}
+
+	tests := []struct {
+		name      string
+		historyID string
+	}{
+		{"expired delta token", srv.URL + "/v1.0/me/mailFolders/inbox/messages/delta?$deltatoken=expired"},
+		{"gmail history ID", "123456"},
+		{"imap history ID", "1700000000:42"},
+		{"delta link without subjects", srv.URL + "/v1.0/me/mailFolders/inbox/messages/delta?$deltatoken=ids-only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.FetchHistory(ctx, "acc-1", tt.historyID, query, ""); !errors.Is(err, service.ErrHistoryTooOld) {
+				t.Errorf("FetchHistory() error = %v, want ErrHistoryTooOld", err)
+			}
+		})
+	}
+}
+
+func TestClient_TokenRefresh(t *testing.T) {
+	tokens := validTokens()
+	expired := time.Now().Add(-time.Minute)
+	tokens.account.AccessTokenExpiresAt = &expired
+	c, _ := newTestClient(t, tokens)
+
+	if _, err := c.GetProfile(context.Background(), "acc-1"); err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if tokens.updates != 1 || *tokens.account.AccessToken != "access-2" || *tokens.account.RefreshToken != "refresh-2" {
+		t.Errorf("stored tokens = %q, %q (%d updates), want the refreshed and rotated tokens",
+			*tokens.account.AccessToken, *tokens.account.RefreshToken, tokens.updates)
+	}
+}
+
+func TestClient_TokenRevoked(t *testing.T) {
+	tokens := validTokens()
+	revoked := "revoked"
+	expired := time.Now().Add(-time.Minute)
+	tokens.account.RefreshToken = &revoked
+	tokens.account.AccessTokenExpiresAt = &expired
+	c, _ := newTestClient(t, tokens)
+
+	if _, err := c.GetProfile(context.Background(), "acc-1"); !errors.Is(err, service.ErrTokenRevoked) {
+		t.Errorf("GetProfile() error = %v, want ErrTokenRevoked", err)
+	}
+
+	tokens.account.RefreshToken = nil
+	c.forget("acc-1")
+	if _, err := c.GetProfile(context.Background(), "acc-1"); !errors.Is(err, service.ErrTokenRevoked) {
+		t.Errorf("GetProfile() without refresh token error = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestClient_Throttled(t *testing.T) {
+	c, _ := newTestClient(t, validTokens())
+
+	err := c.getJSON(context.Background(), "acc-1", c.baseURL+"/me/messages/throttled", &struct{}{})
+	var rateLimitErr *service.RateLimitError
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 120*time.Second {
+		t.Fatalf("getJSON() error = %v, want RateLimitError retrying after 2m", err)
+	}
+
+	// The account backs off without calling Graph until Retry-After has passed
+	if _, err := c.GetProfile(context.Background(), "acc-1"); !errors.Is(err, service.ErrRateLimited) {
+		t.Errorf("GetProfile() error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestBuildSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query service.SearchQuery
+		want  string
+	}{
+		{"empty", service.SearchQuery{}, ""},
+		{
+			"date and keywords",
+			service.SearchQuery{After: time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC), Keywords: []string{"invoice", "bill"}},
+			`"received>=2025-01-31 AND (invoice OR bill)"`,
+		},
+		{"phrase keywords", service.SearchQuery{Keywords: []string{"autopay", "auto-pay"}}, `"(autopay OR \"auto-pay\")"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildSearch(tt.query); got != tt.want {
+				t.Errorf("buildSearch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := NewClient("client-id", "client-secret", "", validTokens())
+	if _, err := c.Watch(context.Background(), "acc-1", "projects/p/topics/t"); !errors.Is(err, service.ErrWatchUnsupported) {
+		t.Errorf("Watch() error = %v, want ErrWatchUnsupported", err)
+	}
+}
@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// message is a Graph message resource (only the fields in messageFields)
+type message struct {
+	ID                     string      `json:"id"`
+	ConversationID         string      `json:"conversationId"`
+	Subject                string      `json:"subject"`
+	From                   *recipient  `json:"from"`
+	ToRecipients           []recipient `json:"toRecipients"`
+	CCRecipients           []recipient `json:"ccRecipients"`
+	BCCRecipients          []recipient `json:"bccRecipients"`
+	SentDateTime           time.Time   `json:"sentDateTime"`
+	ReceivedDateTime       time.Time   `json:"receivedDateTime"`
+	BodyPreview            string      `json:"bodyPreview"`
+	Categories             []string    `json:"categories"`
+	HasAttachments         bool        `json:"hasAttachments"`
+	InternetMessageHeaders []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"internetMessageHeaders"`
+	Body struct {
+		ContentType string `json:"contentType"` // "html" or "text"
+		Content     string `json:"content"`
+	} `json:"body"`
+	Attachments []struct {
+		ODataType   string `json:"@odata.type"`
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		ContentType string `json:"contentType"`
+		Size        int64  `json:"size"`
+		IsInline    bool   `json:"isInline"`
+	} `json:"attachments"`
+}
+
+type recipient struct {
+	EmailAddress struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+// String formats the recipient like an address header ("Name <address>")
+func (r recipient) String() string {
+	if r.EmailAddress.Name == "" || r.EmailAddress.Name == r.EmailAddress.Address {
+		return r.EmailAddress.Address
+	}
+	return r.EmailAddress.Name + " <" + r.EmailAddress.Address + ">"
+}
+
+// joinRecipients formats recipients like an address list header
+func joinRecipients(recipients []recipient) string {
+	addresses := make([]string, len(recipients))
+	for i, r := range recipients {
+		addresses[i] = r.String()
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// parseMessage parses a Graph message into EmailMessage
+// Graph has already decoded charsets and encoded headers, the body is the message's HTML or text body
+func parseMessage(data []byte) (*service.EmailMessage, error) {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+
+	emailMsg := &service.EmailMessage{
+		ID:           msg.ID,
+		ThreadID:     msg.ConversationID,
+		Subject:      msg.Subject,
+		To:           joinRecipients(msg.ToRecipients),
+		CC:           joinRecipients(msg.CCRecipients),
+		BCC:          joinRecipients(msg.BCCRecipients),
+		Date:         msg.SentDateTime,
+		InternalDate: msg.ReceivedDateTime,
+		Snippet:      msg.BodyPreview,
+		Labels:       msg.Categories,
+		RawHeaders:   make(map[string]interface{}),
+		RawPayload: map[string]interface{}{
+			"contentType": msg.Body.ContentType,
+		},
+		Attachments: []map[string]interface{}{},
+	}
+	if msg.From != nil {
+		emailMsg.From = msg.From.String()
+	}
+
+	for _, header := range msg.InternetMessageHeaders {
+		emailMsg.RawHeaders[header.Name] = header.Value
+	}
+
+	if strings.EqualFold(msg.Body.ContentType, "html") {
+		emailMsg.BodyHTML = msg.Body.Content
+	} else {
+		emailMsg.BodyText = msg.Body.Content
+	}
+
+	// File attachments only; inline images and item or reference attachments have no downloadable content
+	for _, attachment := range msg.Attachments {
+		if attachment.IsInline || attachment.ODataType != "#microsoft.graph.fileAttachment" {
+			continue
+		}
+		emailMsg.Attachments = append(emailMsg.Attachments, map[string]interface{}{
+			"filename":     attachment.Name,
+			"mimeType":     attachment.ContentType,
+			"size":         attachment.Size,
+			"attachmentId": attachment.ID,
+		})
+	}
+	emailMsg.HasAttachments = len(emailMsg.Attachments) > 0
+
+	return emailMsg, nil
+}
@@ -0,0 +1,274 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// Outlook mailbox limit is 10,000 requests per 10 minutes (https://learn.microsoft.com/graph/throttling-limits)
+const MailboxRequestsPerSecond = 16
+
+const (
+	maxCallRetries      = 3                // Retries for transient errors (5xx, short throttling) before giving up
+	retryBaseDelay      = 1 * time.Second  // Backoff before the first retry, doubled on every further retry
+	maxInlineRetryAfter = 10 * time.Second // Longer Retry-After delays are returned as service.RateLimitError (job is rescheduled)
+)
+
+// preferImmutableID asks Graph for IDs that stay the same when a message is moved to another folder
+const preferImmutableID = `IdType="ImmutableId"`
+
+// apiError is a non-2xx Graph response
+type apiError struct {
+	StatusCode int
+	Code       string // Graph error code, e.g. "ErrorItemNotFound"
+	Message    string
+	Header     http.Header
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("graph: HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// newAPIError reads a Graph error response ({"error": {"code", "message"}})
+func newAPIError(statusCode int, header http.Header, body io.Reader) *apiError {
+	var payload struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&payload)
+	return &apiError{
+		StatusCode: statusCode,
+		Code:       payload.Error.Code,
+		Message:    payload.Error.Message,
+		Header:     header,
+	}
+}
+
+// throttle budgets Graph requests per account and remembers when Graph asked an account to back off
+type throttle struct {
+	mu       sync.Mutex
+	accounts map[string]*accountThrottle // keyed by account ID
+}
+
+type accountThrottle struct {
+	limiter      *rate.Limiter
+	blockedUntil time.Time // Set from Retry-After, no calls are made for the account before then
+}
+
+func newThrottle() *throttle {
+	return &throttle{accounts: make(map[string]*accountThrottle)}
+}
+
+// account returns the account's throttle state, creating it on first use
+func (t *throttle) account(accountID string) *accountThrottle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.accounts[accountID]
+	if !ok {
+		// Burst covers the largest single request (a full $batch)
+		state = &accountThrottle{
+			limiter: rate.NewLimiter(rate.Limit(MailboxRequestsPerSecond), max(MailboxRequestsPerSecond, MaxBatchSize)),
+		}
+		t.accounts[accountID] = state
+	}
+	return state
+}
+
+// wait blocks until the account has budget for the given number of requests
+// Returns a service.RateLimitError without waiting when the account is backing off for longer than maxInlineRetryAfter
+func (t *throttle) wait(ctx context.Context, accountID string, requests int) error {
+	state := t.account(accountID)
+
+	t.mu.Lock()
+	backoff := time.Until(state.blockedUntil)
+	t.mu.Unlock()
+
+	if backoff > maxInlineRetryAfter {
+		return &service.RateLimitError{RetryAfter: backoff, Err: fmt.Errorf("account %s is backing off", accountID)}
+	}
+	if backoff > 0 {
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
+	return state.limiter.WaitN(ctx, requests)
+}
+
+// block stops calls for the account until the given time (Retry-After)
+func (t *throttle) block(accountID string, until time.Time) {
+	state := t.account(accountID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until.After(state.blockedUntil) {
+		state.blockedUntil = until
+	}
+}
+
+// do sends a Graph request costing the given number of requests for the account and decodes its 2xx response
+// Waits for the account's budget, retries 5xx and short throttling with backoff, and maps failures to service errors
+// (service.RateLimitError, service.ErrTokenRevoked, service.ErrNotFound, service.ErrHistoryTooOld)
+func (c *Client) do(ctx context.Context, accountID string, requests int, send func(*http.Client) (*http.Response, error), decode func(io.Reader) error) error {
+	httpClient, err := c.httpClient(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.throttle.wait(ctx, accountID, requests); err != nil {
+			return err
+		}
+
+		err := c.send(httpClient, send, decode)
+		if err == nil {
+			return nil
+		}
+
+		switch {
+		case isThrottled(err):
+			retryAfter, ok := retryAfterHeader(err)
+			if !ok {
+				retryAfter = c.backoff(attempt)
+			}
+			c.throttle.block(accountID, time.Now().Add(retryAfter))
+
+			if attempt >= maxCallRetries || retryAfter > maxInlineRetryAfter {
+				log.Printf("Graph throttled account %s, retry after %s", accountID, retryAfter)
+				return &service.RateLimitError{RetryAfter: retryAfter, Err: err}
+			}
+			// wait honours the block before the next attempt
+
+		case isTransient(err) && attempt < maxCallRetries:
+			delay := c.backoff(attempt)
+			log.Printf("Graph request failed for account %s (attempt %d), retrying in %s: %v", accountID, attempt+1, delay, err)
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+
+		default:
+			return c.classifyError(accountID, err)
+		}
+	}
+}
+
+// send runs one HTTP attempt, returning an *apiError for non-2xx responses
+func (c *Client) send(httpClient *http.Client, send func(*http.Client) (*http.Response, error), decode func(io.Reader) error) error {
+	resp, err := send(httpClient)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp.StatusCode, resp.Header, resp.Body)
+	}
+	return decode(resp.Body)
+}
+
+// classifyError wraps err with the matching service error so callers can use errors.Is
+func (c *Client) classifyError(accountID string, err error) error {
+	if isThrottled(err) {
+		retryAfter, _ := retryAfterHeader(err)
+		return &service.RateLimitError{RetryAfter: retryAfter, Err: err}
+	}
+	if isTokenRevoked(err) {
+		// Reload tokens from the database next time (the user may have re-authenticated)
+		c.forget(accountID)
+		return fmt.Errorf("%w: %v", service.ErrTokenRevoked, err)
+	}
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %v", service.ErrNotFound, err)
+		case http.StatusGone:
+			// Only delta queries answer 410, when the delta token expired and a full resync is required
+			return fmt.Errorf("%w: %v", service.ErrHistoryTooOld, err)
+		}
+	}
+	return err
+}
+
+// backoff returns the delay before retry attempt+1 (exponential, with jitter)
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retryBaseDelay << attempt
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half+1)
+}
+
+// isThrottled reports whether err is a 429, or a 503 with Retry-After (Graph throttles with both)
+func isThrottled(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return apiErr.StatusCode == http.StatusServiceUnavailable && apiErr.Header.Get("Retry-After") != ""
+}
+
+// isTransient reports whether err is a server error worth retrying
+func isTransient(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+// isTokenRevoked reports whether err means the account's tokens no longer work
+// A refresh failing with invalid_grant means the refresh token was revoked or expired (AADSTS70008 and friends)
+func isTokenRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.ErrorCode == "invalid_grant"
+	}
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// retryAfterHeader returns the delay from the response's Retry-After header (seconds)
+func retryAfterHeader(err error) (time.Duration, bool) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+	seconds, parseErr := strconv.Atoi(apiErr.Header.Get("Retry-After"))
+	if parseErr != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,21 @@
+package imap
+
+// xoauth2Client implements the SASL XOAUTH2 mechanism used by Gmail, Outlook and Yahoo IMAP
+type xoauth2Client struct {
+	username    string
+	accessToken string
+}
+
+func newXOAuth2Client(username, accessToken string) *xoauth2Client {
+	return &xoauth2Client{username: username, accessToken: accessToken}
+}
+
+// Start returns the initial response "user=<username>^Aauth=Bearer <token>^A^A"
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.accessToken + "\x01\x01"), nil
+}
+
+// Next answers the server's error challenge (a JSON status) with an empty response, the server then rejects the login
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
@@ -0,0 +1,545 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"golang.org/x/oauth2"
+
+	"github.com/vipul43/kiwis-worker/internal/mailparse"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/oauth"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+const (
+	dialTimeout    = 30 * time.Second // Connecting, TLS handshake and greeting
+	commandTimeout = 2 * time.Minute  // Any single IMAP command (large FETCHes included)
+)
+
+// tokenSourceCacheTTL is how long a cached XOAUTH2 token source is reused before tokens are reloaded from the database
+const tokenSourceCacheTTL = 1 * time.Hour
+
+// SettingsStore loads the IMAP server settings of an account
+type SettingsStore interface {
+	GetByAccountID(ctx context.Context, accountID string) (*models.IMAPSettings, error)
+}
+
+// Client syncs IMAP mailboxes (Fastmail, Zoho, self-hosted, ...)
+// Every call opens its own connection, calls for the same account are serialized
+// since servers limit concurrent connections per user
+type Client struct {
+	accounts     oauth.TokenStore // Account holding the IMAP credentials (app password or OAuth tokens)
+	settings     SettingsStore
+	oauthConfigs map[string]*oauth2.Config // OAuth apps refreshing XOAUTH2 tokens, keyed by IMAPSettings.OAuthProvider
+	tlsConfig    *tls.Config               // Base TLS config, ServerName is set per account
+
+	mu           sync.Mutex
+	locks        map[string]*sync.Mutex        // keyed by account ID
+	tokenSources map[string]*cachedTokenSource // keyed by account ID
+}
+
+// cachedTokenSource is an account's refreshing XOAUTH2 token source
+type cachedTokenSource struct {
+	source    oauth2.TokenSource
+	createdAt time.Time
+}
+
+// session is a logged-in connection with the account's mailbox selected (read-only)
+type session struct {
+	conn     *client.Client
+	mailbox  *imap.MailboxStatus
+	settings *models.IMAPSettings
+}
+
+// NewClient creates an IMAP client, XOAUTH2 tokens are refreshed with the OAuth app of the account's OAuth provider
+func NewClient(accounts oauth.TokenStore, settings SettingsStore, oauthConfigs map[string]*oauth2.Config) *Client {
+	return &Client{
+		accounts:     accounts,
+		settings:     settings,
+		oauthConfigs: oauthConfigs,
+		tlsConfig:    &tls.Config{MinVersion: tls.VersionTLS12},
+		locks:        make(map[string]*sync.Mutex),
+		tokenSources: make(map[string]*cachedTokenSource),
+	}
+}
+
+// FetchMessageIDs searches the mailbox and returns the newest matching message IDs first
+// The page token is the oldest UID returned so far, the next page continues below it
+func (c *Client) FetchMessageIDs(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
+	criteria := searchCriteria(query)
+	if pageToken != "" {
+		before, err := strconv.ParseUint(pageToken, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page token %q: %w", pageToken, err)
+		}
+		if before <= 1 {
+			return &service.MessageIDFetchResult{}, nil
+		}
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(1, uint32(before-1))
+	}
+
+	var uids []uint32
+	var uidValidity uint32
+	err := c.withSession(ctx, accountID, func(s *session) (err error) {
+		uidValidity = s.mailbox.UidValidity
+		uids, err = s.conn.UidSearch(criteria)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	// UIDs grow with arrival order, so the highest UIDs are the newest emails
+	slices.Sort(uids)
+	slices.Reverse(uids)
+
+	result := &service.MessageIDFetchResult{}
+	if len(uids) > maxResults {
+		uids = uids[:maxResults]
+		result.NextPageToken = strconv.FormatUint(uint64(uids[len(uids)-1]), 10)
+	}
+	for _, uid := range uids {
+		result.MessageIDs = append(result.MessageIDs, messageID(uidValidity, uid))
+	}
+	result.TotalFetched = len(result.MessageIDs)
+
+	log.Printf("IMAP search returned %d message IDs for account %s (nextPageToken: %s)", len(result.MessageIDs), accountID, result.NextPageToken)
+	return result, nil
+}
+
+// FetchEmailsByIDs fetches and parses full messages with a single UID FETCH
+// Messages that were expunged or belong to an older UIDVALIDITY are reported as service.ErrNotFound
+func (c *Client) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*service.BatchFetchResult, error) {
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage, len(messageIDs)),
+		Errors:   make(map[string]error),
+	}
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	err := c.withSession(ctx, accountID, func(s *session) error {
+		uids := new(imap.SeqSet)
+		idsByUID := make(map[uint32]string, len(messageIDs))
+		for _, id := range messageIDs {
+			uidValidity, uid, err := parseMessageID(id)
+			if err != nil || uidValidity != s.mailbox.UidValidity {
+				result.Errors[id] = fmt.Errorf("%w: message %s is not in the mailbox", service.ErrNotFound, id)
+				continue
+			}
+			uids.AddNum(uid)
+			idsByUID[uid] = id
+		}
+		if uids.Empty() {
+			return nil
+		}
+
+		section := &imap.BodySectionName{Peek: true}
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, imap.FetchFlags, section.FetchItem()}
+		err := c.fetch(s, uids, items, func(msg *imap.Message) {
+			id, ok := idsByUID[msg.Uid]
+			if !ok {
+				return
+			}
+			body := msg.GetBody(section)
+			if body == nil {
+				result.Errors[id] = fmt.Errorf("server returned no body for message %s", id)
+				return
+			}
+			email, err := mailparse.Parse(body)
+			if err != nil {
+				result.Errors[id] = fmt.Errorf("failed to parse message %s: %w", id, err)
+				return
+			}
+			email.ID = id
+			email.InternalDate = msg.InternalDate
+			email.Labels = append([]string{s.settings.Mailbox}, msg.Flags...)
+			result.Messages[id] = email
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range idsByUID {
+			if _, ok := result.Messages[id]; !ok && result.Errors[id] == nil {
+				result.Errors[id] = fmt.Errorf("%w: message %s was deleted", service.ErrNotFound, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return result, nil
+}
+
+// FetchAttachment downloads one MIME part of a message, the attachment ID is its IMAP section number
+func (c *Client) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	uidValidity, uid, err := parseMessageID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrNotFound, err)
+	}
+	path, err := sectionPath(attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrNotFound, err)
+	}
+
+	header := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: path}, Peek: true}
+	body := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: path}, Peek: true}
+
+	var data []byte
+	err = c.withSession(ctx, accountID, func(s *session) error {
+		if uidValidity != s.mailbox.UidValidity {
+			return fmt.Errorf("%w: message %s is not in the mailbox", service.ErrNotFound, messageID)
+		}
+
+		uids := new(imap.SeqSet)
+		uids.AddNum(uid)
+		var headerData, bodyData imap.Literal
+		err := c.fetch(s, uids, []imap.FetchItem{header.FetchItem(), body.FetchItem()}, func(msg *imap.Message) {
+			headerData, bodyData = msg.GetBody(header), msg.GetBody(body)
+		})
+		if err != nil {
+			return err
+		}
+		if headerData == nil || bodyData == nil {
+			return fmt.Errorf("%w: attachment %s of message %s", service.ErrNotFound, attachmentID, messageID)
+		}
+
+		data, err = mailparse.DecodePart(headerData, bodyData)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return data, nil
+}
+
+// GetProfile returns the mailbox's username and its current history ID (UIDVALIDITY and last UID)
+func (c *Client) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	var profile *service.MailboxProfile
+	err := c.withSession(ctx, accountID, func(s *session) error {
+		uid, err := lastUID(s)
+		if err != nil {
+			return err
+		}
+		profile = &service.MailboxProfile{
+			EmailAddress: s.settings.Username,
+			HistoryID:    historyID(s.mailbox.UidValidity, uid),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	return profile, nil
+}
+
+// FetchHistory returns the IDs of messages that arrived after the start history ID and match the query keywords
+// (UID SEARCH UID last+1:newest TEXT ...), the history ID advances past non-matching messages too
+// Returns service.ErrHistoryTooOld when the mailbox's UIDVALIDITY changed, since all UIDs are invalid then,
+// or when the history ID is not an IMAP one
+func (c *Client) FetchHistory(ctx context.Context, accountID string, startHistoryID string, query service.SearchQuery, pageToken string) (*service.HistoryFetchResult, error) {
+	uidValidity, startUID, err := splitHistoryID(startHistoryID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrHistoryTooOld, err)
+	}
+
+	var result *service.HistoryFetchResult
+	err = c.withSession(ctx, accountID, func(s *session) error {
+		if s.mailbox.UidValidity != uidValidity {
+			return fmt.Errorf("%w: UIDVALIDITY changed from %d to %d", service.ErrHistoryTooOld, uidValidity, s.mailbox.UidValidity)
+		}
+
+		// Take the newest UID before searching, so a message arriving meanwhile is left for the next sync
+		newest, err := lastUID(s)
+		if err != nil {
+			return err
+		}
+		result = &service.HistoryFetchResult{HistoryID: historyID(uidValidity, max(startUID, newest))}
+		if newest <= startUID {
+			return nil
+		}
+
+		// Keywords only, the UID range already limits the search to new messages
+		criteria := searchCriteria(service.SearchQuery{Keywords: query.Keywords})
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(startUID+1, newest)
+		uids, err := s.conn.UidSearch(criteria)
+		if err != nil {
+			return err
+		}
+
+		slices.Sort(uids)
+		for _, uid := range uids {
+			result.MessageIDs = append(result.MessageIDs, messageID(uidValidity, uid))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch history: %w", err)
+	}
+	return result, nil
+}
+
+// Watch is not supported, IMAP mailboxes are kept up to date by incremental sync
+// Their email sync jobs stay synced after catching up, also when a Pub/Sub topic is configured
+func (c *Client) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	return nil, service.ErrWatchUnsupported
+}
+
+// withSession runs fn on a new connection to the account's mailbox and logs out afterwards
+func (c *Client) withSession(ctx context.Context, accountID string, fn func(s *session) error) error {
+	lock := c.accountLock(accountID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s, err := c.connect(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer s.conn.Logout()
+
+	// go-imap is not context aware, closing the connection aborts a running command
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.conn.Terminate()
+	})
+	defer stop()
+
+	if err := fn(s); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// connect dials the account's server, logs in and selects the mailbox
+func (c *Client) connect(ctx context.Context, accountID string) (*session, error) {
+	settings, err := c.settings.GetByAccountID(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get imap settings: %w", err)
+	}
+	account, err := c.accounts.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	addr := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.ServerName = settings.Host
+	if settings.TLS {
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	// The greeting is read before the client's command timeout applies
+	_ = netConn.SetDeadline(time.Now().Add(dialTimeout))
+	conn, err := client.New(netConn)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	conn.Timeout = commandTimeout
+
+	if err := c.login(conn, settings, account, tlsConfig); err != nil {
+		_ = conn.Logout()
+		return nil, err
+	}
+
+	mailbox, err := conn.Select(settings.Mailbox, true)
+	if err != nil {
+		_ = conn.Logout()
+		return nil, fmt.Errorf("failed to select mailbox %s: %w", settings.Mailbox, err)
+	}
+
+	return &session{conn: conn, mailbox: mailbox, settings: settings}, nil
+}
+
+// login upgrades plain connections with STARTTLS when offered and authenticates with the account's credentials
+// A rejected app password is reported as service.ErrTokenRevoked, the user has to reconnect the mailbox
+func (c *Client) login(conn *client.Client, settings *models.IMAPSettings, account *models.Account, tlsConfig *tls.Config) error {
+	if !settings.TLS {
+		if ok, _ := conn.SupportStartTLS(); ok {
+			if err := conn.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start TLS: %w", err)
+			}
+		}
+	}
+
+	if settings.AuthMethod == models.IMAPAuthXOAuth2 {
+		return c.loginXOAuth2(conn, settings, account)
+	}
+
+	if account.Password == nil {
+		return fmt.Errorf("%w: account missing app password", service.ErrTokenRevoked)
+	}
+	err := conn.Login(settings.Username, *account.Password)
+	if err == nil {
+		return nil
+	}
+	if isConnectionError(err) {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+	return fmt.Errorf("%w: login rejected: %v", service.ErrTokenRevoked, err)
+}
+
+// loginXOAuth2 authenticates with an access token from the account's refreshing token source
+// A rejected token is refreshed once and retried, the tokens are only reported as service.ErrTokenRevoked
+// when the refresh fails with invalid_grant
+func (c *Client) loginXOAuth2(conn *client.Client, settings *models.IMAPSettings, account *models.Account) error {
+	accessToken, err := c.accessToken(settings, account, false)
+	if err != nil {
+		return err
+	}
+	err = conn.Authenticate(newXOAuth2Client(settings.Username, accessToken))
+	if err == nil {
+		return nil
+	}
+	if isConnectionError(err) {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+
+	// The server rejected a token that had not expired yet (e.g. revoked on password change)
+	log.Printf("IMAP login rejected for account %s, refreshing access token: %v", account.ID, err)
+	accessToken, err = c.accessToken(settings, account, true)
+	if err != nil {
+		return err
+	}
+	if err := conn.Authenticate(newXOAuth2Client(settings.Username, accessToken)); err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns a valid access token for the account, refreshing and persisting it when expired
+// refresh discards the cached token source and refreshes the stored token even if it has not expired
+func (c *Client) accessToken(settings *models.IMAPSettings, account *models.Account, refresh bool) (string, error) {
+	source, err := c.tokenSource(settings, account, refresh)
+	if err != nil {
+		return "", err
+	}
+	token, err := source.Token()
+	if err != nil {
+		if isTokenRevoked(err) {
+			return "", fmt.Errorf("%w: %v", service.ErrTokenRevoked, err)
+		}
+		return "", fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// tokenSource returns the account's cached token source, creating it from the stored tokens if needed
+func (c *Client) tokenSource(settings *models.IMAPSettings, account *models.Account, refresh bool) (oauth2.TokenSource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.tokenSources[account.ID]; ok && !refresh && time.Since(cached.createdAt) < tokenSourceCacheTTL {
+		return cached.source, nil
+	}
+
+	if settings.OAuthProvider == nil {
+		return nil, fmt.Errorf("imap settings of account %s missing oauth provider", account.ID)
+	}
+	config, ok := c.oauthConfigs[*settings.OAuthProvider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported imap oauth provider %q", *settings.OAuthProvider)
+	}
+	token, err := oauth.AccountToken(account)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		token.Expiry = time.Now()
+	}
+
+	source := oauth.NewPersistingTokenSource(account.ID, token, config, c.accounts, func() {
+		c.forget(account.ID)
+	})
+	c.tokenSources[account.ID] = &cachedTokenSource{source: source, createdAt: time.Now()}
+	return source, nil
+}
+
+// forget drops the account's cached token source so the next login reloads tokens from the database
+func (c *Client) forget(accountID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokenSources, accountID)
+}
+
+// isTokenRevoked reports whether a refresh failed because the refresh token was revoked or expired (invalid_grant)
+func isTokenRevoked(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
+
+// fetch runs a UID FETCH and calls handle for each returned message
+func (c *Client) fetch(s *session, uids *imap.SeqSet, items []imap.FetchItem, handle func(msg *imap.Message)) error {
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.conn.UidFetch(uids, items, messages)
+	}()
+
+	// UidFetch closes the channel once the command completes
+	for msg := range messages {
+		handle(msg)
+	}
+	return <-done
+}
+
+// accountLock returns the mutex serializing connections of an account
+func (c *Client) accountLock(accountID string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lock, ok := c.locks[accountID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[accountID] = lock
+	}
+	return lock
+}
+
+// lastUID returns the highest UID in the selected mailbox (UIDNEXT - 1, searched when UIDNEXT is not reported)
+func lastUID(s *session) (uint32, error) {
+	if s.mailbox.UidNext > 0 {
+		return s.mailbox.UidNext - 1, nil
+	}
+
+	uids, err := s.conn.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return 0, err
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+	return slices.Max(uids), nil
+}
+
+// isConnectionError reports network failures, as opposed to the server rejecting a command
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,425 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+type mockAccountStore struct {
+	account *models.Account
+	updated []string // access tokens saved by UpdateTokens
+}
+
+func (m *mockAccountStore) GetByID(ctx context.Context, accountID string) (*models.Account, error) {
+	return m.account, nil
+}
+
+func (m *mockAccountStore) UpdateTokens(ctx context.Context, accountID string, accessToken string, refreshToken string, accessTokenExpiresAt time.Time) error {
+	m.updated = append(m.updated, accessToken)
+	return nil
+}
+
+// xoauth2Server accepts XOAUTH2 logins of "username" with access token "access-2"
+type xoauth2Server struct {
+	conn    server.Conn
+	backend *memory.Backend
+}
+
+func (s *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if string(response) != "user=username\x01auth=Bearer access-2\x01\x01" {
+		return nil, false, errors.New("invalid token")
+	}
+	user, err := s.backend.Login(s.conn.Info(), "username", "password")
+	if err != nil {
+		return nil, false, err
+	}
+	ctx := s.conn.Context()
+	ctx.State = imap.AuthenticatedState
+	ctx.User = user
+	return nil, true, nil
+}
+
+// tokenServer refreshes "refresh-1" to access token "access-2" and rejects any other refresh token (invalid_grant)
+func tokenServer(t *testing.T) *oauth2.Config {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("refresh_token") != "refresh-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-2",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+}
+
+type mockSettingsStore struct {
+	settings *models.IMAPSettings
+}
+
+func (m *mockSettingsStore) GetByAccountID(ctx context.Context, accountID string) (*models.IMAPSettings, error) {
+	return m.settings, nil
+}
+
+const (
+	invoiceMessage = "From: billing@fastmail.example\r\n" +
+		"Subject: Your invoice is ready\r\n" +
+		"Date: Mon, 1 Dec 2025 09:00:00 +0000\r\n" +
+		"Message-ID: <inv-1@fastmail.example>\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"\r\n" +
+		"Amount due: \xa3 12.00\r\n"
+
+	lunchMessage = "From: friend@example.com\r\n" +
+		"Subject: Lunch tomorrow?\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See you at noon\r\n"
+
+	receiptMessage = "From: shop@example.com\r\n" +
+		"Subject: Your receipt\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Thanks for your order\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=receipt.pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQK\r\n" +
+		"--b--\r\n"
+)
+
+// testServer starts an in-process IMAP server whose INBOX holds the backend's welcome message (UID 6)
+// followed by the given messages (UIDs 7, 8, ...) and returns a client connected to it
+// The server also accepts XOAUTH2 logins, refreshed by an OAuth app registered as provider "google"
+func testServer(t *testing.T, password string, messages ...string) (*Client, func(raw string)) {
+	t.Helper()
+
+	backend := memory.New()
+	srv := server.New(backend)
+	srv.AllowInsecureAuth = true
+	srv.EnableAuth("XOAUTH2", func(conn server.Conn) sasl.Server {
+		return &xoauth2Server{conn: conn, backend: backend}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	addr := listener.Addr().(*net.TCPAddr)
+	appendMessage := func(raw string) {
+		t.Helper()
+		conn, err := client.Dial(addr.String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Logout()
+		if err := conn.Login("username", "password"); err != nil {
+			t.Fatalf("failed to log in: %v", err)
+		}
+		if err := conn.Append("INBOX", nil, time.Now().Add(-24*time.Hour), bytes.NewBufferString(raw)); err != nil {
+			t.Fatalf("failed to append message: %v", err)
+		}
+	}
+	for _, raw := range messages {
+		appendMessage(raw)
+	}
+
+	accounts := &mockAccountStore{account: &models.Account{ID: "acc-1", ProviderID: models.ProviderIMAP, Password: &password}}
+	settings := &mockSettingsStore{settings: &models.IMAPSettings{
+		AccountID:  "acc-1",
+		Host:       addr.IP.String(),
+		Port:       addr.Port,
+		TLS:        false,
+		Username:   "username",
+		AuthMethod: models.IMAPAuthPassword,
+		Mailbox:    "INBOX",
+	}}
+	oauthConfigs := map[string]*oauth2.Config{models.ProviderGoogle: tokenServer(t)}
+	return NewClient(accounts, settings, oauthConfigs), appendMessage
+}
+
+func TestClient_FetchMessageIDs(t *testing.T) {
+	c, _ := testServer(t, "password", invoiceMessage, lunchMessage, receiptMessage)
+	ctx := context.Background()
+	query := service.SearchQuery{After: time.Now().AddDate(0, 0, -30), Keywords: []string{"invoice", "receipt", "order"}}
+
+	result, err := c.FetchMessageIDs(ctx, "acc-1", query, 10, "")
+	if err != nil {
+		t.Fatalf("FetchMessageIDs() error = %v", err)
+	}
+	if got := strings.Join(result.MessageIDs, ","); got != "1:9,1:7" {
+		t.Errorf("MessageIDs = %s, want newest first 1:9,1:7", got)
+	}
+	if result.NextPageToken != "" {
+		t.Errorf("NextPageToken = %q, want none", result.NextPageToken)
+	}
+
+	// Paging continues below the oldest UID of the previous page
+	first, err := c.FetchMessageIDs(ctx, "acc-1", query, 1, "")
+	if err != nil {
+		t.Fatalf("FetchMessageIDs() error = %v", err)
+	}
+	if strings.Join(first.MessageIDs, ",") != "1:9" || first.NextPageToken != "9" {
+		t.Fatalf("first page = %v (next %q), want 1:9 (next 9)", first.MessageIDs, first.NextPageToken)
+	}
+	second, err := c.FetchMessageIDs(ctx, "acc-1", query, 1, first.NextPageToken)
+	if err != nil {
+		t.Fatalf("FetchMessageIDs() error = %v", err)
+	}
+	if strings.Join(second.MessageIDs, ",") != "1:7" || second.NextPageToken != "" {
+		t.Errorf("second page = %v (next %q), want 1:7 (no next)", second.MessageIDs, second.NextPageToken)
+	}
+}
+
+func TestClient_FetchEmailsByIDs(t *testing.T) {
+	c, _ := testServer(t, "password", invoiceMessage, lunchMessage, receiptMessage)
+
+	result, err := c.FetchEmailsByIDs(context.Background(), "acc-1", []string{"1:7", "1:9", "1:99", "2:7", "bogus"})
+	if err != nil {
+		t.Fatalf("FetchEmailsByIDs() error = %v", err)
+	}
+
+	invoice := result.Messages["1:7"]
+	if invoice == nil {
+		t.Fatalf("message 1:7 missing, errors: %v", result.Errors)
+	}
+	if invoice.ID != "1:7" || invoice.Subject != "Your invoice is ready" || invoice.ThreadID != "inv-1@fastmail.example" {
+		t.Errorf("invoice = %q %q %q", invoice.ID, invoice.Subject, invoice.ThreadID)
+	}
+	if invoice.BodyText != "Amount due: £ 12.00\r\n" {
+		t.Errorf("BodyText = %q", invoice.BodyText)
+	}
+	if invoice.InternalDate.IsZero() || len(invoice.Labels) == 0 || invoice.Labels[0] != "INBOX" {
+		t.Errorf("InternalDate = %v, Labels = %v", invoice.InternalDate, invoice.Labels)
+	}
+
+	receipt := result.Messages["1:9"]
+	if receipt == nil {
+		t.Fatalf("message 1:9 missing, errors: %v", result.Errors)
+	}
+	if len(receipt.Attachments) != 1 || receipt.Attachments[0]["attachmentId"] != "2" || receipt.Attachments[0]["filename"] != "receipt.pdf" {
+		t.Errorf("Attachments = %v", receipt.Attachments)
+	}
+
+	for _, id := range []string{"1:99", "2:7", "bogus"} {
+		if !errors.Is(result.Errors[id], service.ErrNotFound) {
+			t.Errorf("Errors[%s] = %v, want ErrNotFound", id, result.Errors[id])
+		}
+	}
+}
+
+func TestClient_FetchAttachment(t *testing.T) {
+	c, _ := testServer(t, "password", invoiceMessage, lunchMessage, receiptMessage)
+
+	data, err := c.FetchAttachment(context.Background(), "acc-1", "1:9", "2")
+	if err != nil {
+		t.Fatalf("FetchAttachment() error = %v", err)
+	}
+	if string(data) != "%PDF-1.4\n" {
+		t.Errorf("FetchAttachment() = %q, want decoded PDF bytes", data)
+	}
+
+	if _, err := c.FetchAttachment(context.Background(), "acc-1", "2:9", "2"); !errors.Is(err, service.ErrNotFound) {
+		t.Errorf("FetchAttachment() with stale UIDVALIDITY error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClient_History(t *testing.T) {
+	c, appendMessage := testServer(t, "password", invoiceMessage)
+	ctx := context.Background()
+
+	profile, err := c.GetProfile(ctx, "acc-1")
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if profile.HistoryID != historyID(1, 7) || profile.EmailAddress != "username" {
+		t.Fatalf("profile = %+v, want history ID for UID 7", profile)
+	}
+
+	query := service.SearchQuery{Keywords: service.PaymentKeywords}
+
+	// Nothing new: "8:*" still matches the newest message, which must not be returned
+	result, err := c.FetchHistory(ctx, "acc-1", profile.HistoryID, query, "")
+	if err != nil {
+		t.Fatalf("FetchHistory() error = %v", err)
+	}
+	if len(result.MessageIDs) != 0 || result.HistoryID != profile.HistoryID {
+		t.Errorf("FetchHistory() = %v (history %s), want no messages", result.MessageIDs, result.HistoryID)
+	}
+
+	// Only the receipt matches the payment keywords, the history ID still moves past the lunch invite
+	appendMessage(receiptMessage)
+	appendMessage(lunchMessage)
+	result, err = c.FetchHistory(ctx, "acc-1", profile.HistoryID, query, "")
+	if err != nil {
+		t.Fatalf("FetchHistory() error = %v", err)
+	}
+	if strings.Join(result.MessageIDs, ",") != "1:8" || result.HistoryID != historyID(1, 9) {
+		t.Errorf("FetchHistory() = %v (history %s), want 1:8", result.MessageIDs, result.HistoryID)
+	}
+
+	if _, err := c.FetchHistory(ctx, "acc-1", historyID(2, 7), query, ""); !errors.Is(err, service.ErrHistoryTooOld) {
+		t.Errorf("FetchHistory() with changed UIDVALIDITY error = %v, want ErrHistoryTooOld", err)
+	}
+}
+
+func TestClient_LoginRejected(t *testing.T) {
+	c, _ := testServer(t, "wrong-password")
+
+	_, err := c.GetProfile(context.Background(), "acc-1")
+	if !errors.Is(err, service.ErrTokenRevoked) {
+		t.Errorf("GetProfile() error = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestClient_XOAuth2(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	valid := time.Now().Add(time.Hour)
+	provider := models.ProviderGoogle
+
+	tests := []struct {
+		name         string
+		accessToken  string
+		refreshToken string
+		expiresAt    time.Time
+		wantErr      error
+		wantUpdated  int
+	}{
+		{"valid token", "access-2", "refresh-1", valid, nil, 0},
+		{"expired token is refreshed", "access-1", "refresh-1", expired, nil, 1},
+		{"rejected token is refreshed", "access-1", "refresh-1", valid, nil, 1},
+		{"revoked refresh token", "access-1", "refresh-revoked", expired, service.ErrTokenRevoked, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testServer(t, "")
+			accounts := c.accounts.(*mockAccountStore)
+			accounts.account.AccessToken = &tt.accessToken
+			accounts.account.RefreshToken = &tt.refreshToken
+			accounts.account.AccessTokenExpiresAt = &tt.expiresAt
+			settings := c.settings.(*mockSettingsStore).settings
+			settings.AuthMethod = models.IMAPAuthXOAuth2
+			settings.OAuthProvider = &provider
+
+			_, err := c.GetProfile(context.Background(), "acc-1")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetProfile() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("GetProfile() error = %v", err)
+			}
+			if len(accounts.updated) != tt.wantUpdated {
+				t.Errorf("tokens persisted %d times, want %d", len(accounts.updated), tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	c := NewClient(&mockAccountStore{}, &mockSettingsStore{}, nil)
+	if _, err := c.Watch(context.Background(), "acc-1", "topic"); !errors.Is(err, service.ErrWatchUnsupported) {
+		t.Errorf("Watch() error = %v, want ErrWatchUnsupported", err)
+	}
+}
+
+func TestParseMessageID(t *testing.T) {
+	tests := []struct {
+		id          string
+		uidValidity uint32
+		uid         uint32
+		wantErr     bool
+	}{
+		{"1:7", 1, 7, false},
+		{"4294967295:4294967295", 4294967295, 4294967295, false},
+		{"1:0", 0, 0, true},
+		{"17", 0, 0, true},
+		{"18c2a1b0e6f:7", 0, 0, true}, // Gmail message ID
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			uidValidity, uid, err := parseMessageID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMessageID(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+			if uidValidity != tt.uidValidity || uid != tt.uid {
+				t.Errorf("parseMessageID(%q) = %d, %d, want %d, %d", tt.id, uidValidity, uid, tt.uidValidity, tt.uid)
+			}
+		})
+	}
+}
+
+func TestHistoryID_RoundTrip(t *testing.T) {
+	for _, uid := range []uint32{0, 1, 4294967295} {
+		uidValidity, got, err := splitHistoryID(historyID(1700000000, uid))
+		if err != nil || uidValidity != 1700000000 || got != uid {
+			t.Errorf("splitHistoryID(historyID(1700000000, %d)) = %d, %d, %v", uid, uidValidity, got, err)
+		}
+	}
+
+	// Gmail history IDs and Graph delta links are not IMAP history IDs
+	for _, id := range []string{"", "12345", "https://graph.microsoft.com/v1.0/me/mailFolders/inbox/messages/delta?$deltatoken=x"} {
+		if _, _, err := splitHistoryID(id); err == nil {
+			t.Errorf("splitHistoryID(%q) error = nil, want error", id)
+		}
+	}
+}
+
+func TestAnyKeyword_Depth(t *testing.T) {
+	keywords := make([]string, 47)
+	for i := range keywords {
+		keywords[i] = "k" + strconv.Itoa(i)
+	}
+
+	var depth func(c *imap.SearchCriteria) int
+	depth = func(c *imap.SearchCriteria) int {
+		if len(c.Or) == 0 {
+			return 0
+		}
+		return 1 + max(depth(c.Or[0][0]), depth(c.Or[0][1]))
+	}
+	if d := depth(anyKeyword(keywords)); d > 6 {
+		t.Errorf("OR nesting depth = %d, want a balanced tree (<= 6)", d)
+	}
+}
@@ -0,0 +1,100 @@
+package imap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// messageID identifies a message across sessions as "<uidvalidity>:<uid>"
+// UIDs are only stable while the mailbox's UIDVALIDITY is unchanged
+func messageID(uidValidity, uid uint32) string {
+	return fmt.Sprintf("%d:%d", uidValidity, uid)
+}
+
+// parseMessageID splits a message ID into its UIDVALIDITY and UID
+func parseMessageID(id string) (uint32, uint32, error) {
+	validityPart, uidPart, ok := strings.Cut(id, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid IMAP message ID %q", id)
+	}
+	uidValidity, err := strconv.ParseUint(validityPart, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid IMAP message ID %q: %w", id, err)
+	}
+	uid, err := strconv.ParseUint(uidPart, 10, 32)
+	if err != nil || uid == 0 {
+		return 0, 0, fmt.Errorf("invalid IMAP message ID %q", id)
+	}
+	return uint32(uidValidity), uint32(uid), nil
+}
+
+// historyID formats the mailbox's UIDVALIDITY and the last seen UID as the job's history ID
+func historyID(uidValidity, lastUID uint32) string {
+	return fmt.Sprintf("%d:%d", uidValidity, lastUID)
+}
+
+// splitHistoryID parses a history ID into UIDVALIDITY and the last seen UID (0 for an empty mailbox)
+func splitHistoryID(id string) (uint32, uint32, error) {
+	validityPart, uidPart, ok := strings.Cut(id, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid IMAP history ID %q", id)
+	}
+	uidValidity, err := strconv.ParseUint(validityPart, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid IMAP history ID %q: %w", id, err)
+	}
+	uid, err := strconv.ParseUint(uidPart, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid IMAP history ID %q: %w", id, err)
+	}
+	return uint32(uidValidity), uint32(uid), nil
+}
+
+// sectionPath parses an attachment ID (IMAP section number such as "2" or "1.2")
+func sectionPath(attachmentID string) ([]int, error) {
+	var path []int
+	for _, part := range strings.Split(attachmentID, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid IMAP attachment ID %q", attachmentID)
+		}
+		path = append(path, n)
+	}
+	return path, nil
+}
+
+// searchCriteria translates a search query to IMAP SEARCH: SINCE the day and TEXT matching any keyword
+// Deleted messages awaiting expunge are skipped
+func searchCriteria(query service.SearchQuery) *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	criteria.Since = query.After
+	criteria.WithoutFlags = []string{imap.DeletedFlag}
+
+	switch len(query.Keywords) {
+	case 0:
+	case 1:
+		criteria.Text = query.Keywords
+	default:
+		criteria.Or = anyKeyword(query.Keywords).Or
+	}
+	return criteria
+}
+
+// anyKeyword matches messages containing any of the keywords
+// IMAP OR takes exactly two keys, so the keywords are split into a balanced tree to keep the nesting shallow
+func anyKeyword(keywords []string) *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	if len(keywords) == 1 {
+		criteria.Text = keywords
+		return criteria
+	}
+
+	mid := len(keywords) / 2
+	criteria.Or = [][2]*imap.SearchCriteria{{anyKeyword(keywords[:mid]), anyKeyword(keywords[mid:])}}
+	return criteria
+}
@@ -0,0 +1,146 @@
+// Package anthropic is an LLM backend for the Anthropic Messages API (POST /v1/messages)
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+)
+
+const (
+	DefaultBaseURL = "https://api.anthropic.com"
+	APIVersion     = "2023-06-01" // anthropic-version header
+	maxTokens      = 8192         // Answer budget of one email: a payment is ~200 tokens, an EMI schedule lists dozens
+)
+
+type Client struct {
+	url          string // Messages endpoint
+	apiKey       string
+	model        string // Required, e.g. "claude-3-5-haiku-latest"
+	httpClient   *http.Client
+	unstructured atomic.Bool // Set once the API rejected tool use (e.g. a proxy without tools), answers are then parsed heuristically
+}
+
+// NewClient creates a client for the Messages API at baseURL (DefaultBaseURL when empty)
+func NewClient(baseURL, apiKey, model string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		url:    strings.TrimSuffix(baseURL, "/") + "/v1/messages",
+		apiKey: apiKey,
+		model:  model,
+		httpClient: &http.Client{
+			Timeout: 300 * time.Second, // 5 minutes timeout for LLM calls
+		},
+	}
+}
+
+// ExtractPayments extracts payment information from each email, one message per email
+func (c *Client) ExtractPayments(ctx context.Context, emails []llm.EmailData) ([]llm.Result, error) {
+	return llm.ExtractEach(ctx, emails, c.ExtractPayment)
+}
+
+// ExtractPayment extracts the payments of a single email
+// The Messages API has no response format, the answer is a forced call of a tool whose input schema is
+// llm.ResponseSchema
+func (c *Client) ExtractPayment(ctx context.Context, email llm.EmailData) ([]llm.PaymentData, llm.RawResponse, error) {
+	prompt := llm.BuildPrompt(email, time.Now())
+
+	structured := !c.unstructured.Load()
+	body, err := c.send(ctx, prompt, structured)
+	if structured && llm.IsRejectedRequest(err) {
+		// Only a rejection naming tools means the server does not support it, other rejected requests (e.g. a
+		// prompt too long) are retried once without it
+		if llm.IsRejectedParameter(err, "tools", "tool_choice") {
+			log.Printf("Anthropic API rejected tool use, falling back to parsing plain answers: %v", err)
+			c.unstructured.Store(true)
+		} else {
+			log.Printf("Anthropic API rejected the request, retrying it without tool use: %v", err)
+		}
+		structured = false
+		body, err = c.send(ctx, prompt, structured)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var apiResp struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	if apiResp.StopReason == "max_tokens" {
+		return nil, nil, fmt.Errorf("%w (%d tokens, stop reason max_tokens)", llm.ErrTruncated, maxTokens)
+	}
+
+	// The answer is the tool input, or the text blocks of the response without tool use
+	var content strings.Builder
+	for _, block := range apiResp.Content {
+		switch block.Type {
+		case "tool_use":
+			content.Reset()
+			content.Write(block.Input)
+		case "text":
+			if structured {
+				continue // Commentary next to the tool call
+			}
+			content.WriteString(block.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return nil, nil, fmt.Errorf("no response from LLM (stop reason %q)", apiResp.StopReason)
+	}
+
+	// Store raw response for audit
+	rawResponse := llm.DecodeRawResponse(body)
+
+	payments, err := llm.ParsePayments(content.String())
+	return payments, rawResponse, err
+}
+
+// send sends the prompt as a message and returns the response body
+func (c *Client) send(ctx context.Context, prompt string, structured bool) ([]byte, error) {
+	reqBody := map[string]interface{}{
+		"model":      c.model,
+		"max_tokens": maxTokens,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+
+	if structured {
+		reqBody["tools"] = []map[string]interface{}{
+			{
+				"name":         llm.SchemaName,
+				"description":  "Record the payments extracted from the email, an empty list when it is not a payment email",
+				"input_schema": llm.ResponseSchema,
+			},
+		}
+		reqBody["tool_choice"] = map[string]interface{}{"type": "tool", "name": llm.SchemaName}
+	}
+
+	headers := map[string]string{
+		"x-api-key":         c.apiKey,
+		"anthropic-version": APIVersion,
+	}
+
+	return llm.PostJSON(ctx, c.httpClient, c.url, headers, reqBody)
+}
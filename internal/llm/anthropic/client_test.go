@@ -0,0 +1,100 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+)
+
+func TestClient_ExtractPayment(t *testing.T) {
+	var gotHeader http.Header
+	var gotPath string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"content":[{"type":"text","text":"Recording the payment."},{"type":"tool_use","name":"payment_extraction","input":{"payments":[{"merchant":"Spotify","amount":9.99,"currency":"EUR","date":"2026-03-01T00:00:00Z","status":"paid"}]}}],"stop_reason":"tool_use"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "sk-ant-test", "claude-3-5-haiku-latest")
+	payments, raw, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Your receipt"})
+	if err != nil {
+		t.Fatalf("ExtractPayment() error = %v", err)
+	}
+
+	if gotPath != "/v1/messages" {
+		t.Errorf("request path = %q, want %q", gotPath, "/v1/messages")
+	}
+	if gotHeader.Get("x-api-key") != "sk-ant-test" || gotHeader.Get("anthropic-version") != APIVersion {
+		t.Errorf("unexpected headers: %v", gotHeader)
+	}
+	if gotBody["model"] != "claude-3-5-haiku-latest" || gotBody["max_tokens"] == nil {
+		t.Errorf("unexpected request body: %v", gotBody)
+	}
+	if toolChoice, _ := gotBody["tool_choice"].(map[string]interface{}); toolChoice["name"] != llm.SchemaName || gotBody["tools"] == nil {
+		t.Errorf("expected a forced %s tool call, got tools %v and tool_choice %v", llm.SchemaName, gotBody["tools"], gotBody["tool_choice"])
+	}
+	if len(payments) != 1 || payments[0].Merchant != "Spotify" || payments[0].Currency != "EUR" {
+		t.Errorf("ExtractPayment() = %+v", payments)
+	}
+	if raw["content"] == nil {
+		t.Errorf("expected raw response to be kept, got %v", raw)
+	}
+}
+
+func TestClient_ExtractPayment_ToolsRejected(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["tools"] != nil {
+			http.Error(w, `{"type":"error","error":{"type":"invalid_request_error","message":"tools: Extra inputs are not permitted"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"[]"}],"stop_reason":"end_turn"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "sk-ant-test", "claude-3-5-haiku-latest")
+	for range 2 {
+		payments, _, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Newsletter"})
+		if err != nil || len(payments) != 0 {
+			t.Fatalf("ExtractPayment() = %+v, %v, want no payment", payments, err)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("expected tools to be dropped after the first rejection (3 requests), got %d requests", requests)
+	}
+}
+
+func TestClient_ExtractPayment_MaxTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content":[{"type":"tool_use","name":"payment_extraction","input":{"payments":[]}}],"stop_reason":"max_tokens"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "sk-ant-test", "claude-3-5-haiku-latest")
+	if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{}); !errors.Is(err, llm.ErrTruncated) {
+		t.Fatalf("expected ErrTruncated for a cut off answer, got %v", err)
+	}
+}
+
+func TestClient_ExtractPayment_NoText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content":[],"stop_reason":"end_turn"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "sk-ant-test", "claude-3-5-haiku-latest")
+	if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{}); err == nil {
+		t.Fatal("expected error for a response without text, got nil")
+	}
+}
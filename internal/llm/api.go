@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrTruncated is returned when the model's answer was cut off at its token limit
+// A cut off answer drops payments or does not parse, it is not an invalid answer: the job is retried
+var ErrTruncated = errors.New("answer cut off at the token limit")
+
+// APIError is a non-200 response of an LLM API
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// IsRejectedRequest reports whether the API rejected the request itself (400 or 422), e.g. a structured output
+// parameter the server or model does not support
+func IsRejectedRequest(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity)
+}
+
+// IsRejectedParameter reports whether the API rejected the request (see IsRejectedRequest) naming one of the
+// parameters in its error, e.g. "response_format json_schema is not supported"
+// Other rejections (context too long, invalid content) say nothing about whether the parameter is supported
+func IsRejectedParameter(err error, params ...string) bool {
+	if !IsRejectedRequest(err) {
+		return false
+	}
+	var apiErr *APIError
+	errors.As(err, &apiErr)
+	body := strings.ToLower(apiErr.Body)
+	for _, param := range params {
+		if strings.Contains(body, param) {
+			return true
+		}
+	}
+	return false
+}
+
+// PostJSON sends a JSON request and returns the response body, non-200 responses are returned as *APIError
+func PostJSON(ctx context.Context, httpClient *http.Client, url string, headers map[string]string, reqBody interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return body, nil
+}
@@ -0,0 +1,279 @@
+// Package llm holds what all LLM backends share for payment extraction: the email input, the extracted payment,
+// the prompt and the parsing of the model's answer
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// EmailData represents the email data to extract payment from
+type EmailData struct {
+	From        string
+	Subject     string
+	Body        string
+	Attachments []AttachmentData // Text extracted from attachments (e.g. PDF invoices)
+}
+
+// AttachmentData is the text of one email attachment
+type AttachmentData struct {
+	Filename string
+	Text     string
+}
+
+// PaymentData represents the extracted payment information
+type PaymentData struct {
+	Merchant    string                 `json:"merchant"`
+	Description *string                `json:"description"`
+	Amount      *models.Amount         `json:"amount"` // A JSON number or a numeric string such as "1,23,456.78"
+	Currency    string                 `json:"currency"`
+	Date        string                 `json:"date"`
+	Recurrence  *string                `json:"recurrence"`
+	Status      string                 `json:"status"`
+	Category    *string                `json:"category"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// RawResponse is a backend's full API response for one email, stored with the payment for audit
+type RawResponse = map[string]interface{}
+
+// Result is the extraction result for one email
+type Result struct {
+	Payments []PaymentData // empty when the email is not a payment email
+	Raw      RawResponse
+	Err      error // the model's answer failed validation (*ValidationError) or the request failed, other emails are unaffected
+}
+
+// ExtractFunc extracts the payments of one email, none when the email is not a payment email
+type ExtractFunc func(ctx context.Context, email EmailData) ([]PaymentData, RawResponse, error)
+
+// ExtractEach runs extract for each email in order (backends have no batch API for chat completions)
+// Results are in input order; a failed request or an invalid answer is reported on its email's result, so the
+// payments of the other emails are kept. Emails left when ctx is cancelled are reported with the context's error
+func ExtractEach(ctx context.Context, emails []EmailData, extract ExtractFunc) ([]Result, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	results := make([]Result, 0, len(emails))
+	for _, email := range emails {
+		if err := ctx.Err(); err != nil {
+			results = append(results, Result{Err: err})
+			continue
+		}
+
+		payments, rawResp, err := extract(ctx, email)
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			results = append(results, Result{Raw: rawResp, Err: err})
+			continue
+		}
+		if err != nil {
+			results = append(results, Result{Err: fmt.Errorf("failed to extract payment: %w", err)})
+			continue
+		}
+		results = append(results, Result{Payments: payments, Raw: rawResp})
+	}
+
+	return results, nil
+}
+
+// ParsePayments parses the model's answer into the payments of one email, none for an empty array or null
+// The answer is either a structured output ({"payments": [...]}, see ResponseSchema), a bare array or a single
+// payment object; models without structured output may wrap it in markdown or text. Every payment is validated
+// against PaymentSchema, one invalid payment rejects the whole answer
+func ParsePayments(content string) ([]PaymentData, error) {
+	content = strings.TrimSpace(content)
+	if !json.Valid([]byte(content)) {
+		// Not a structured output, fall back to slicing the JSON out of the answer
+		content = cleanJSONResponse(content)
+	}
+
+	items, err := paymentItems(content)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Message: err.Error()}}}
+	}
+
+	var payments []PaymentData
+	var fields []FieldError
+	for i, item := range items {
+		if string(item) == "null" {
+			continue
+		}
+		prefix := fmt.Sprintf("%s[%d]", envelopeField, i)
+
+		// Parse payment data from LLM response, rejecting fields outside the schema
+		var paymentData PaymentData
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&paymentData); err != nil {
+			fields = append(fields, decodeFieldError(err).in(prefix))
+			continue
+		}
+
+		var validationErr *ValidationError
+		if errors.As(ValidatePayment(paymentData), &validationErr) {
+			for _, field := range validationErr.Fields {
+				fields = append(fields, field.in(prefix))
+			}
+			continue
+		}
+		payments = append(payments, paymentData)
+	}
+
+	if len(fields) > 0 {
+		return nil, &ValidationError{Fields: fields}
+	}
+	return payments, nil
+}
+
+// paymentItems splits the answer into one JSON value per payment
+func paymentItems(content string) ([]json.RawMessage, error) {
+	// Check if LLM returned null (low confidence or not a payment email)
+	if content == "null" || content == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(content, "[") {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(content), &items); err != nil {
+			return nil, fmt.Errorf("answer is not a JSON array of payments: %v", err)
+		}
+		return items, nil
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &envelope); err != nil {
+		return nil, fmt.Errorf("answer is not a JSON object: %v", err)
+	}
+	payments, ok := envelope[envelopeField]
+	if !ok || len(envelope) != 1 {
+		// A single payment object
+		return []json.RawMessage{json.RawMessage(content)}, nil
+	}
+	if strings.TrimSpace(string(payments)) == "null" {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(payments, &items); err != nil {
+		return nil, fmt.Errorf("%s is not an array: %v", envelopeField, err)
+	}
+	return items, nil
+}
+
+// DecodeRawResponse decodes an API response body for storing with the payment
+func DecodeRawResponse(body []byte) RawResponse {
+	var rawResponse RawResponse
+	_ = json.Unmarshal(body, &rawResponse)
+	return rawResponse
+}
+
+// cleanJSONResponse removes markdown code blocks and extra whitespace from LLM response
+func cleanJSONResponse(content string) string {
+	content = strings.TrimSpace(content)
+
+	// Check for null response first
+	if content == "null" {
+		return "null"
+	}
+
+	// Find the first { and last } to extract just the JSON object, or [ and ] when the array starts first
+	start, end := "{", "}"
+	if arrayIdx := strings.Index(content, "["); arrayIdx != -1 {
+		if objectIdx := strings.Index(content, "{"); objectIdx == -1 || arrayIdx < objectIdx {
+			start, end = "[", "]"
+		}
+	}
+	startIdx := strings.Index(content, start)
+	endIdx := strings.LastIndex(content, end)
+
+	if startIdx == -1 || endIdx == -1 || startIdx > endIdx {
+		// No valid JSON found, return as is and let JSON parser fail with proper error
+		return content
+	}
+
+	// Extract just the JSON value
+	jsonContent := content[startIdx : endIdx+1]
+
+	return strings.TrimSpace(jsonContent)
+}
+
+// BuildPrompt builds the extraction prompt for an email, now is the current time the model compares due dates with
+func BuildPrompt(email EmailData, now time.Time) string {
+	currentTime := now.Format(time.RFC3339)
+
+	return fmt.Sprintf(`You are an AI that extracts structured payment information from emails.
+
+Analyze the input and return a JSON array with one object per payment for the payment table. Only include a payment if you are ≥75%% confident in ALL its required fields. If no payment qualifies, return: []
+
+### OUTPUT FORMAT (example)
+[
+  {
+    "merchant": "Netflix",
+    "description": "Standard plan",
+    "amount": 649,
+    "currency": "INR",
+    "date": "2026-03-01T00:00:00+05:30",
+    "recurrence": "monthly",
+    "status": "paid",
+    "category": "subscription",
+    "metadata": {"plan_name": "Standard"}
+  }
+]
+
+### REQUIRED FIELDS (if any cannot be inferred with ≥75%% confidence → leave that payment out)
+
+| Field | Type | Rules |
+|-------|------|-------|
+| merchant | string | Business/entity name exactly as it appears in input |
+| amount | number | Total due amount. Numeric only, no symbols/commas. Exact, with at most the currency's decimals (none for JPY, three for KWD). Positive always (even for refunds). Breakups go to metadata |
+| currency | string | ISO 4217 code (INR, USD, EUR, GBP, JPY, etc.) |
+| date | string | ISO 8601 with timezone (YYYY-MM-DDTHH:MM:SS±HH:MM). Contextual to status - compare with current_time |
+| status | string | One of: draft, scheduled, upcoming, due, overdue, processing, partially_paid, paid, failed, refunded, cancelled, written_off |
+
+### OPTIONAL FIELDS (null if not inferable)
+
+| Field | Type | Rules |
+|-------|------|-------|
+| description | string | What the payment is for |
+| recurrence | string | daily, weekly, biweekly, monthly, bimonthly, quarterly, semiannual, annual. Infer from context if not explicit |
+| category | string | subscription, utility, emi, credit_card_bill, loan, insurance, rent, misc. credit_card_bill is ONLY for credit card dues/statements, not payments made via credit card |
+| metadata | object | Flat JSON with all additional inferred details: invoice_number, subscription_id, order_id, utr, reference_number, card_last_four, billing_period, plan_name, instalment_number, due_type, payment_method, etc. Use {} if none |
+
+### RULES
+- Return ONLY the raw JSON array. No explanations, no markdown.
+- If a response schema is given, answer {"payments": [...]} instead, with an empty array when there is no payment.
+- One email can contain several payments, one object each:
+  - Credit card statement: the total due and the minimum due (metadata.due_type: "total" / "minimum")
+  - EMI or loan schedule: each instalment listed with its own date (metadata.instalment_number)
+  - Order confirmation: each separate charge (not the line items of a single charge)
+- Never list the same payment twice.
+- All values must be inferred from input. Never fabricate.
+- Merchant name should be preserved exactly as found, no normalization.
+- Status logic using current_time: upcoming (>24hrs away), due (within 24hrs), overdue (past due date).
+- Promotional/marketing emails (e.g., "Pay now and get X") → return []
+- Attachments (e.g. PDF invoices) belong to the email. Use them when the body lacks amount, date or merchant
+- Confidence < 75%% on any required field of a payment → leave that payment out
+
+### INPUT
+current_time: %s
+from: %s
+subject: %s
+body: %s`, currentTime, email.From, email.Subject, email.Body) + formatAttachments(email.Attachments)
+}
+
+// formatAttachments renders attachment text for the prompt input, one block per attachment
+func formatAttachments(attachments []AttachmentData) string {
+	var b strings.Builder
+	for _, attachment := range attachments {
+		fmt.Fprintf(&b, "\nattachment (%s):\n%s", attachment.Filename, attachment.Text)
+	}
+	return b.String()
+}
@@ -0,0 +1,440 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+func TestCleanJSONResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "plain JSON",
+			input:    `{"merchant": "Netflix"}`,
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "JSON with markdown code blocks",
+			input:    "```json\n{\"merchant\": \"Netflix\"}\n```",
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "JSON with plain code blocks",
+			input:    "```\n{\"merchant\": \"Netflix\"}\n```",
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "JSON with explanatory text before",
+			input:    "Here is the payment information:\n{\"merchant\": \"Netflix\"}",
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "JSON with explanatory text after",
+			input:    "{\"merchant\": \"Netflix\"}\nThis is a subscription payment.",
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "JSON with text before and after",
+			input:    "No payment found. Output:\n{\"merchant\": null}\nEnd of response.",
+			expected: `{"merchant": null}`,
+		},
+		{
+			name:     "JSON with whitespace",
+			input:    "  \n  {\"merchant\": \"Netflix\"}  \n  ",
+			expected: `{"merchant": "Netflix"}`,
+		},
+		{
+			name:     "null response",
+			input:    "null",
+			expected: "null",
+		},
+		{
+			name:     "array with markdown code blocks",
+			input:    "```json\n[{\"merchant\": \"HDFC\"}, {\"merchant\": \"HDFC\"}]\n```",
+			expected: `[{"merchant": "HDFC"}, {"merchant": "HDFC"}]`,
+		},
+		{
+			name:     "object containing an array",
+			input:    "Output: {\"payments\": [{\"merchant\": \"HDFC\"}]}",
+			expected: `{"payments": [{"merchant": "HDFC"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanJSONResponse(tt.input)
+			if result != tt.expected {
+				t.Errorf("Expected:\n%s\n\nGot:\n%s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestValidatePayment(t *testing.T) {
+	tests := []struct {
+		name     string
+		payment  PaymentData
+		expected bool
+	}{
+		{
+			name: "valid payment",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: true,
+		},
+		{
+			name: "missing merchant",
+			payment: PaymentData{
+				Merchant: "",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "nil amount",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   nil,
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "zero amount",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("0"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "negative amount",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("-10"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "missing currency",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "missing date",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "missing status",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "",
+			},
+			expected: false,
+		},
+		{
+			name: "unknown status",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "settled",
+			},
+			expected: false,
+		},
+		{
+			name: "unknown recurrence",
+			payment: PaymentData{
+				Merchant:   "Netflix",
+				Amount:     amountPtr("19.99"),
+				Currency:   "USD",
+				Date:       "2025-01-01T00:00:00",
+				Status:     "upcoming",
+				Recurrence: strPtr("yearly"),
+			},
+			expected: false,
+		},
+		{
+			name: "lowercase currency",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "usd",
+				Date:     "2025-01-01T00:00:00",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+		{
+			name: "date without time",
+			payment: PaymentData{
+				Merchant: "Netflix",
+				Amount:   amountPtr("19.99"),
+				Currency: "USD",
+				Date:     "2025-01-01",
+				Status:   "upcoming",
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePayment(tt.payment)
+			if (err == nil) != tt.expected {
+				t.Errorf("Expected valid %v, got error %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func amountPtr(s string) *models.Amount {
+	amount := models.MustParseAmount(s)
+	return &amount
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestValidatePayment_FieldErrors(t *testing.T) {
+	err := ValidatePayment(PaymentData{Merchant: "Netflix", Amount: amountPtr("-5"), Currency: "USD", Date: "2025-01-01T00:00:00Z", Category: strPtr("streaming")})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("ValidatePayment() error = %v, want *ValidationError", err)
+	}
+	var fields []string
+	for _, field := range validationErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	if strings.Join(fields, ",") != "amount,status,category" {
+		t.Errorf("ValidatePayment() invalid fields = %v, want amount, status and category", fields)
+	}
+	if !strings.Contains(err.Error(), "status: is required") || !strings.Contains(err.Error(), `category: must be one of subscription`) {
+		t.Errorf("ValidatePayment() error = %q, want field-level messages", err)
+	}
+}
+
+func TestBuildPrompt_Attachments(t *testing.T) {
+	prompt := BuildPrompt(EmailData{
+		From:    "billing@power.example",
+		Subject: "Your bill is ready",
+		Body:    "Please find your bill attached.",
+		Attachments: []AttachmentData{
+			{Filename: "bill.pdf", Text: "Amount due: 1234.50 INR"},
+		},
+	}, time.Now())
+
+	if !strings.HasSuffix(prompt, "body: Please find your bill attached.\nattachment (bill.pdf):\nAmount due: 1234.50 INR") {
+		t.Errorf("expected attachment text after the body, got prompt ending %q", prompt[max(0, len(prompt)-200):])
+	}
+
+	prompt = BuildPrompt(EmailData{From: "a@example.com", Subject: "Hi", Body: "No attachments"}, time.Now())
+	if !strings.HasSuffix(prompt, "body: No attachments") {
+		t.Errorf("expected prompt to end with the body, got %q", prompt[max(0, len(prompt)-200):])
+	}
+}
+
+func TestBuildPrompt_ExampleMatchesSchema(t *testing.T) {
+	prompt := BuildPrompt(EmailData{From: "a@example.com", Subject: "Hi", Body: "No attachments"}, time.Now())
+
+	start := strings.Index(prompt, "### OUTPUT FORMAT")
+	end := strings.Index(prompt, "### REQUIRED FIELDS")
+	if start == -1 || end == -1 {
+		t.Fatal("expected the prompt to have an output format example")
+	}
+	example := prompt[strings.Index(prompt[start:], "\n")+start : end]
+
+	// The model copies the example, it has to be a valid answer
+	payments, err := ParsePayments(example)
+	if err != nil || len(payments) != 1 {
+		t.Errorf("ParsePayments(example) = %+v, %v; want one valid payment", payments, err)
+	}
+}
+
+func TestParsePayments(t *testing.T) {
+	payment := `{"merchant": "Netflix", "amount": 9.99, "currency": "EUR", "date": "2026-03-01T00:00:00Z", "status": "paid", "recurrence": "monthly", "category": "subscription", "metadata": {"plan_name": "Standard"}}`
+	minimumDue := `{"merchant": "HDFC Bank", "amount": 2500, "currency": "INR", "date": "2026-03-20T00:00:00+05:30", "status": "upcoming", "category": "credit_card_bill", "metadata": {"due_type": "minimum"}}`
+	totalDue := `{"merchant": "HDFC Bank", "amount": 48210.5, "currency": "INR", "date": "2026-03-20T00:00:00+05:30", "status": "upcoming", "category": "credit_card_bill", "metadata": {"due_type": "total"}}`
+
+	tests := []struct {
+		name      string
+		content   string
+		merchants []string // merchant of each expected payment
+		wantErr   bool
+		wantField string // first field of the validation error
+	}{
+		{"structured output", `{"payments": [` + payment + `]}`, []string{"Netflix"}, false, ""},
+		{"structured output with several payments", `{"payments": [` + totalDue + `, ` + minimumDue + `]}`, []string{"HDFC Bank", "HDFC Bank"}, false, ""},
+		{"structured output without payments", `{"payments": []}`, nil, false, ""},
+		{"bare array", `[` + payment + `]`, []string{"Netflix"}, false, ""},
+		{"empty array", `[]`, nil, false, ""},
+		{"single object", payment, []string{"Netflix"}, false, ""},
+		{"markdown fallback", "```json\n[" + totalDue + ", " + minimumDue + "]\n```", []string{"HDFC Bank", "HDFC Bank"}, false, ""},
+		{"text around the object", "Here is the payment: " + payment + " Let me know {if} you need more.", nil, true, ""},
+		{"null", "null", nil, false, ""},
+		{"missing required field", `{"merchant": "Netflix", "amount": 9.99}`, nil, true, "payments[0].currency"},
+		{"amount as a string", `{"payments": [{"merchant": "LIC", "amount": "1,23,456.78", "currency": "INR", "date": "2026-03-01T00:00:00Z", "status": "paid"}]}`, []string{"LIC"}, false, ""},
+		{"amount with decimals of another currency", `[{"merchant": "Rakuten", "amount": 1500.5, "currency": "JPY", "date": "2026-03-01T00:00:00Z", "status": "paid"}]`, nil, true, "payments[0].amount"},
+		{"amount not a number", `{"payments": [{"merchant": "Netflix", "amount": "9,99", "currency": "EUR", "date": "2026-03-01T00:00:00Z", "status": "paid"}]}`, nil, true, "payments[0].amount"},
+		{"wrong type", `{"payments": [{"merchant": "Netflix", "amount": true, "currency": "EUR", "date": "2026-03-01T00:00:00Z", "status": "paid"}]}`, nil, true, "payments[0].amount"},
+		{"unknown field", `[{"merchant": "Netflix", "amount": 9.99, "currency": "EUR", "date": "2026-03-01T00:00:00Z", "status": "paid", "confidence": 0.9}]`, nil, true, "payments[0].confidence"},
+		{"one invalid payment", `{"payments": [` + totalDue + `, {"merchant": "HDFC Bank", "amount": 2500, "currency": "INR", "date": "2026-03-20T00:00:00+05:30", "status": "settled"}]}`, nil, true, "payments[1].status"},
+		{"payments not an array", `{"payments": ` + payment + `}`, nil, true, ""},
+		{"invalid JSON", `{"merchant": }`, nil, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments, err := ParsePayments(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePayments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("ParsePayments() error = %v, want *ValidationError", err)
+				}
+				if tt.wantField != "" && validationErr.Fields[0].Field != tt.wantField {
+					t.Errorf("ParsePayments() invalid field = %q, want %q", validationErr.Fields[0].Field, tt.wantField)
+				}
+			}
+			var merchants []string
+			for _, payment := range payments {
+				merchants = append(merchants, payment.Merchant)
+			}
+			if fmt.Sprint(merchants) != fmt.Sprint(tt.merchants) {
+				t.Errorf("ParsePayments() merchants = %v, want %v", merchants, tt.merchants)
+			}
+		})
+	}
+}
+
+func TestPaymentSchema(t *testing.T) {
+	properties := PaymentSchema["properties"].(map[string]interface{})
+	if len(properties) != 9 || len(PaymentSchema["required"].([]string)) != 9 {
+		t.Fatalf("PaymentSchema has %d properties, want one per PaymentData field", len(properties))
+	}
+
+	tests := []struct {
+		field    string
+		wantType interface{}
+		wantEnum int // number of enum values, 0 for none
+	}{
+		{"merchant", "string", 0},
+		{"amount", "number", 0},
+		{"status", "string", len(Statuses)},
+		{"recurrence", []string{"string", "null"}, len(Recurrences) + 1},
+		{"category", []string{"string", "null"}, len(Categories) + 1},
+		{"metadata", []string{"object", "null"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			property := properties[tt.field].(map[string]interface{})
+			if fmt.Sprint(property["type"]) != fmt.Sprint(tt.wantType) {
+				t.Errorf("%s type = %v, want %v", tt.field, property["type"], tt.wantType)
+			}
+			enum, _ := property["enum"].([]interface{})
+			if len(enum) != tt.wantEnum {
+				t.Errorf("%s enum = %v, want %d values", tt.field, enum, tt.wantEnum)
+			}
+		})
+	}
+}
+
+func TestExtractEach(t *testing.T) {
+	emails := []EmailData{{Subject: "statement"}, {Subject: "newsletter"}, {Subject: "garbled"}}
+	results, err := ExtractEach(context.Background(), emails, func(ctx context.Context, email EmailData) ([]PaymentData, RawResponse, error) {
+		raw := RawResponse{"subject": email.Subject}
+		switch email.Subject {
+		case "newsletter":
+			return nil, raw, nil
+		case "garbled":
+			return nil, raw, &ValidationError{Fields: []FieldError{{Field: "status", Message: "is required"}}}
+		}
+		return []PaymentData{{Merchant: "HDFC Bank"}, {Merchant: "HDFC Bank"}}, raw, nil
+	})
+	if err != nil {
+		t.Fatalf("ExtractEach() error = %v", err)
+	}
+	if len(results) != 3 || len(results[0].Payments) != 2 || len(results[1].Payments) != 0 {
+		t.Errorf("ExtractEach() results = %+v, want two payments and none", results)
+	}
+	if results[1].Raw["subject"] != "newsletter" {
+		t.Errorf("ExtractEach() raw response = %v", results[1].Raw)
+	}
+	if results[2].Err == nil || results[2].Raw == nil {
+		t.Errorf("ExtractEach() result = %+v, want the validation error with the raw response", results[2])
+	}
+
+	// A failed request only fails its own email
+	boom := errors.New("boom")
+	results, err = ExtractEach(context.Background(), emails, func(ctx context.Context, email EmailData) ([]PaymentData, RawResponse, error) {
+		if email.Subject == "newsletter" {
+			return nil, nil, boom
+		}
+		return []PaymentData{{Merchant: "HDFC Bank"}}, RawResponse{}, nil
+	})
+	if err != nil {
+		t.Fatalf("ExtractEach() error = %v", err)
+	}
+	if len(results) != 3 || !errors.Is(results[1].Err, boom) || len(results[0].Payments) != 1 || len(results[2].Payments) != 1 {
+		t.Errorf("ExtractEach() results = %+v, want the backend error on the second email only", results)
+	}
+
+	// Emails left after cancellation are not sent
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	results, _ = ExtractEach(ctx, emails, func(ctx context.Context, email EmailData) ([]PaymentData, RawResponse, error) {
+		calls++
+		cancel()
+		return nil, nil, ctx.Err()
+	})
+	if calls != 1 || len(results) != 3 || !errors.Is(results[2].Err, context.Canceled) {
+		t.Errorf("ExtractEach() after cancel = %+v (%d calls), want one call and context.Canceled", results, calls)
+	}
+}
@@ -0,0 +1,112 @@
+// Package ollama is an LLM backend for a local or self-hosted Ollama server (POST /api/chat)
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+)
+
+const DefaultBaseURL = "http://localhost:11434"
+
+type Client struct {
+	url          string // Chat endpoint
+	model        string // Required, e.g. "llama3.1:8b"
+	httpClient   *http.Client
+	unstructured atomic.Bool // Set once the server rejected a format schema (Ollama before 0.5)
+}
+
+// NewClient creates a client for the Ollama server at baseURL (DefaultBaseURL when empty)
+func NewClient(baseURL, model string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		url:   strings.TrimSuffix(baseURL, "/") + "/api/chat",
+		model: model,
+		httpClient: &http.Client{
+			Timeout: 300 * time.Second, // 5 minutes timeout for LLM calls (local models on CPU are slow)
+		},
+	}
+}
+
+// ExtractPayments extracts payment information from each email, one chat request per email
+func (c *Client) ExtractPayments(ctx context.Context, emails []llm.EmailData) ([]llm.Result, error) {
+	return llm.ExtractEach(ctx, emails, c.ExtractPayment)
+}
+
+// ExtractPayment extracts the payments of a single email
+// The answer is constrained to llm.ResponseSchema with the format parameter unless the server rejects it
+func (c *Client) ExtractPayment(ctx context.Context, email llm.EmailData) ([]llm.PaymentData, llm.RawResponse, error) {
+	prompt := llm.BuildPrompt(email, time.Now())
+
+	structured := !c.unstructured.Load()
+	body, err := c.chat(ctx, prompt, structured)
+	if structured && llm.IsRejectedRequest(err) {
+		// Only a rejection naming format means the server does not support it, other rejected requests (e.g. a
+		// prompt too long) are retried once without it
+		if llm.IsRejectedParameter(err, "format") {
+			log.Printf("Ollama rejected the format schema, falling back to parsing plain answers: %v", err)
+			c.unstructured.Store(true)
+		} else {
+			log.Printf("Ollama rejected the request, retrying it without the format schema: %v", err)
+		}
+		structured = false
+		body, err = c.chat(ctx, prompt, structured)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var apiResp struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Done       bool   `json:"done"`
+		DoneReason string `json:"done_reason"`
+	}
+
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	if !apiResp.Done {
+		return nil, nil, fmt.Errorf("incomplete response from LLM")
+	}
+	if apiResp.DoneReason == "length" {
+		return nil, nil, fmt.Errorf("%w (done reason length)", llm.ErrTruncated)
+	}
+
+	// Store raw response for audit
+	rawResponse := llm.DecodeRawResponse(body)
+
+	payments, err := llm.ParsePayments(apiResp.Message.Content)
+	return payments, rawResponse, err
+}
+
+// chat sends the prompt as a chat request and returns the response body
+func (c *Client) chat(ctx context.Context, prompt string, structured bool) ([]byte, error) {
+	reqBody := map[string]interface{}{
+		"model": c.model,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+		"stream": false, // One JSON response instead of a stream of chunks
+	}
+
+	if structured {
+		reqBody["format"] = llm.ResponseSchema
+	}
+
+	return llm.PostJSON(ctx, c.httpClient, c.url, nil, reqBody)
+}
@@ -0,0 +1,104 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+)
+
+func TestClient_ExtractPayment(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"model":"llama3.1:8b","message":{"role":"assistant","content":"` +
+			"```json\\n{\\\"merchant\\\":\\\"Airtel\\\",\\\"amount\\\":499,\\\"currency\\\":\\\"INR\\\",\\\"date\\\":\\\"2026-03-05T00:00:00Z\\\",\\\"status\\\":\\\"due\\\"}\\n```" +
+			`"},"done":true}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "llama3.1:8b")
+	payments, raw, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Your bill"})
+	if err != nil {
+		t.Fatalf("ExtractPayment() error = %v", err)
+	}
+
+	if gotPath != "/api/chat" {
+		t.Errorf("request path = %q, want %q", gotPath, "/api/chat")
+	}
+	if gotBody["model"] != "llama3.1:8b" || gotBody["stream"] != false || gotBody["format"] == nil {
+		t.Errorf("unexpected request body: %v", gotBody)
+	}
+	if len(payments) != 1 || payments[0].Merchant != "Airtel" || payments[0].Status != "due" {
+		t.Errorf("ExtractPayment() = %+v", payments)
+	}
+	if raw["message"] == nil {
+		t.Errorf("expected raw response to be kept, got %v", raw)
+	}
+}
+
+func TestClient_ExtractPayment_FormatRejected(t *testing.T) {
+	tests := []struct {
+		name           string
+		rejection      string
+		wantStructured int // Requests with a format schema, of 3 (2 emails and the retry of the first)
+	}{
+		{"format unsupported", `{"error":"json: cannot unmarshal object into Go struct field ChatRequest.format of type string"}`, 1},
+		{"other rejection", `{"error":"model requires more system memory"}`, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, structuredRequests := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				var body map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&body)
+				if body["format"] != nil {
+					structuredRequests++
+				}
+				if requests == 1 {
+					http.Error(w, tt.rejection, http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(`{"message":{"role":"assistant","content":"[]"},"done":true}`))
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, "llama3.1:8b")
+			for range 2 {
+				if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Newsletter"}); err != nil {
+					t.Fatalf("ExtractPayment() error = %v", err)
+				}
+			}
+			if structuredRequests != tt.wantStructured {
+				t.Errorf("got %d requests with a format schema, want %d", structuredRequests, tt.wantStructured)
+			}
+		})
+	}
+}
+
+func TestClient_ExtractPayment_Truncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"llama3.1:8b","message":{"role":"assistant","content":"{\"payments\":[{\"merchant\":\"HDFC"},"done":true,"done_reason":"length"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "llama3.1:8b")
+	if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{}); !errors.Is(err, llm.ErrTruncated) {
+		t.Fatalf("expected ErrTruncated for a cut off answer, got %v", err)
+	}
+}
+
+func TestNewClient_DefaultBaseURL(t *testing.T) {
+	client := NewClient("", "llama3.1:8b")
+	if client.url != DefaultBaseURL+"/api/chat" {
+		t.Errorf("NewClient() url = %q, want %q", client.url, DefaultBaseURL+"/api/chat")
+	}
+}
@@ -0,0 +1,132 @@
+// Package openai is an LLM backend for OpenAI-compatible chat completion APIs (OpenAI, OpenRouter, vLLM,
+// llama.cpp server, LM Studio)
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+)
+
+type Client struct {
+	url          string // Chat completions endpoint
+	apiKey       string // Optional: local servers usually need none
+	model        string // Optional: some servers (OpenRouter, llama.cpp) fall back to their default model
+	httpClient   *http.Client
+	unstructured atomic.Bool // Set once the server rejected response_format, answers are then parsed heuristically
+}
+
+// NewClient creates a client for the API at baseURL (e.g. https://api.openai.com/v1, http://localhost:8080/v1)
+func NewClient(baseURL, apiKey, model string) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(baseURL, "/") + "/chat/completions",
+		apiKey: apiKey,
+		model:  model,
+		httpClient: &http.Client{
+			Timeout: 300 * time.Second, // 5 minutes timeout for LLM calls (free and local models are slow)
+		},
+	}
+}
+
+// SetModel sets the model to use
+func (c *Client) SetModel(model string) {
+	c.model = model
+}
+
+// ExtractPayments extracts payment information from each email, one chat completion per email
+func (c *Client) ExtractPayments(ctx context.Context, emails []llm.EmailData) ([]llm.Result, error) {
+	return llm.ExtractEach(ctx, emails, c.ExtractPayment)
+}
+
+// ExtractPayment extracts the payments of a single email
+// The answer is requested as structured output (response_format with llm.ResponseSchema) unless the server rejects it
+func (c *Client) ExtractPayment(ctx context.Context, email llm.EmailData) ([]llm.PaymentData, llm.RawResponse, error) {
+	prompt := llm.BuildPrompt(email, time.Now())
+
+	structured := !c.unstructured.Load()
+	body, err := c.complete(ctx, prompt, structured)
+	if structured && llm.IsRejectedRequest(err) {
+		// Only a rejection naming response_format means the server does not support it, other rejected requests (e.g. a
+		// prompt too long) are retried once without it
+		if llm.IsRejectedParameter(err, "response_format") {
+			log.Printf("LLM API rejected structured output, falling back to parsing plain answers: %v", err)
+			c.unstructured.Store(true)
+		} else {
+			log.Printf("LLM API rejected the request, retrying it without structured output: %v", err)
+		}
+		structured = false
+		body, err = c.complete(ctx, prompt, structured)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var apiResp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	if len(apiResp.Choices) == 0 {
+		return nil, nil, fmt.Errorf("no response from LLM")
+	}
+	if apiResp.Choices[0].FinishReason == "length" {
+		return nil, nil, fmt.Errorf("%w (finish reason length)", llm.ErrTruncated)
+	}
+
+	// Store raw response for audit
+	rawResponse := llm.DecodeRawResponse(body)
+
+	payments, err := llm.ParsePayments(apiResp.Choices[0].Message.Content)
+	return payments, rawResponse, err
+}
+
+// complete sends the prompt as a chat completion and returns the response body
+func (c *Client) complete(ctx context.Context, prompt string, structured bool) ([]byte, error) {
+	reqBody := map[string]interface{}{
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+
+	// Only include model if set, otherwise the server's default is used
+	if c.model != "" {
+		reqBody["model"] = c.model
+	}
+
+	if structured {
+		// Not strict: strict mode forbids free-form objects such as metadata
+		reqBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   llm.SchemaName,
+				"schema": llm.ResponseSchema,
+				"strict": false,
+			},
+		}
+	}
+
+	headers := map[string]string{}
+	if c.apiKey != "" {
+		headers["Authorization"] = "Bearer " + c.apiKey
+	}
+
+	return llm.PostJSON(ctx, c.httpClient, c.url, headers, reqBody)
+}
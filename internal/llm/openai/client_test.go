@@ -0,0 +1,149 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+func TestClient_ExtractPayment(t *testing.T) {
+	var gotAuth, gotPath string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		gotBody = nil
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"merchant\":\"Netflix\",\"amount\":15.49,\"currency\":\"USD\",\"date\":\"2026-03-01T00:00:00Z\",\"status\":\"paid\"}"}}]}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		apiKey    string
+		model     string
+		wantAuth  string
+		wantModel bool
+	}{
+		{"hosted API", "sk-test", "gpt-4o-mini", "Bearer sk-test", true},
+		{"local server without key or model", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(server.URL+"/v1/", tt.apiKey, tt.model)
+			payments, raw, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Your receipt"})
+			if err != nil {
+				t.Fatalf("ExtractPayment() error = %v", err)
+			}
+			if gotPath != "/v1/chat/completions" {
+				t.Errorf("request path = %q, want %q", gotPath, "/v1/chat/completions")
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", gotAuth, tt.wantAuth)
+			}
+			if _, ok := gotBody["model"]; ok != tt.wantModel {
+				t.Errorf("model sent = %v, want %v", ok, tt.wantModel)
+			}
+			if format, _ := gotBody["response_format"].(map[string]interface{}); format["type"] != "json_schema" {
+				t.Errorf("response_format = %v, want a json_schema", gotBody["response_format"])
+			}
+			if len(payments) != 1 || payments[0].Merchant != "Netflix" || *payments[0].Amount != models.MustParseAmount("15.49") {
+				t.Errorf("ExtractPayment() = %+v", payments)
+			}
+			if raw["choices"] == nil {
+				t.Errorf("expected raw response to be kept, got %v", raw)
+			}
+		})
+	}
+}
+
+func TestClient_ExtractPayment_StructuredOutputRejected(t *testing.T) {
+	var structuredRequests, plainRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["response_format"] != nil {
+			structuredRequests++
+			http.Error(w, `{"error":{"message":"response_format json_schema is not supported"}}`, http.StatusBadRequest)
+			return
+		}
+		plainRequests++
+		w.Write([]byte(`{"choices":[{"message":{"content":"` + "```json\\n{\\\"merchant\\\":\\\"Jio\\\",\\\"amount\\\":299,\\\"currency\\\":\\\"INR\\\",\\\"date\\\":\\\"2026-03-05T00:00:00+05:30\\\",\\\"status\\\":\\\"due\\\"}\\n```" + `"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "")
+	for range 2 {
+		payments, _, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Recharge due"})
+		if err != nil {
+			t.Fatalf("ExtractPayment() error = %v", err)
+		}
+		if len(payments) != 1 || payments[0].Merchant != "Jio" {
+			t.Errorf("ExtractPayment() = %+v, want the heuristically parsed payment", payments)
+		}
+	}
+	if structuredRequests != 1 || plainRequests != 2 {
+		t.Errorf("expected structured output to be dropped after the first rejection, got %d structured and %d plain requests", structuredRequests, plainRequests)
+	}
+}
+
+func TestClient_ExtractPayment_OtherRequestRejected(t *testing.T) {
+	var structuredRequests, plainRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["response_format"] != nil {
+			structuredRequests++
+			if structuredRequests == 1 {
+				http.Error(w, `{"error":{"message":"This model's maximum context length is 8192 tokens"}}`, http.StatusBadRequest)
+				return
+			}
+		} else {
+			plainRequests++
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"[]"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "")
+	for range 2 {
+		if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{Subject: "Newsletter"}); err != nil {
+			t.Fatalf("ExtractPayment() error = %v", err)
+		}
+	}
+	// The rejected request is retried without response_format, the next one still asks for structured output
+	if structuredRequests != 2 || plainRequests != 1 {
+		t.Errorf("expected structured output to be kept after an unrelated rejection, got %d structured and %d plain requests", structuredRequests, plainRequests)
+	}
+}
+
+func TestClient_ExtractPayment_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "missing")
+	if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{}); err == nil {
+		t.Fatal("expected error for non-200 response, got nil")
+	}
+}
+
+func TestClient_ExtractPayment_Truncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"payments\":[{\"merchant\":\"HDFC"},"finish_reason":"length"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "", "gpt-4o-mini")
+	if _, _, err := client.ExtractPayment(context.Background(), llm.EmailData{}); !errors.Is(err, llm.ErrTruncated) {
+		t.Fatalf("expected ErrTruncated for a cut off answer, got %v", err)
+	}
+}
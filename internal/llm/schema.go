@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// SchemaName names the structured output schema in API requests
+const SchemaName = "payment_extraction"
+
+// envelopeField wraps the payments in structured outputs, APIs require an object at the top level
+const envelopeField = "payments"
+
+// Allowed values of the enum fields of PaymentData
+var (
+	Statuses = []string{
+		models.PaymentStatusDraft, models.PaymentStatusScheduled, models.PaymentStatusUpcoming, models.PaymentStatusDue,
+		models.PaymentStatusOverdue, models.PaymentStatusProcessing, models.PaymentStatusPartiallyPaid, models.PaymentStatusPaid,
+		models.PaymentStatusFailed, models.PaymentStatusRefunded, models.PaymentStatusCancelled, models.PaymentStatusWrittenOff,
+	}
+	Recurrences = []string{
+		models.RecurrenceDaily, models.RecurrenceWeekly, models.RecurrenceBiweekly, models.RecurrenceMonthly,
+		models.RecurrenceBimonthly, models.RecurrenceQuarterly, models.RecurrenceSemiannual, models.RecurrenceAnnual,
+	}
+	Categories = []string{
+		models.CategorySubscription, models.CategoryUtility, models.CategoryEMI, models.CategoryCreditCardBill,
+		models.CategoryLoan, models.CategoryInsurance, models.CategoryRent, models.CategoryMisc,
+	}
+)
+
+// fieldEnums maps PaymentData's JSON fields to their allowed values
+var fieldEnums = map[string][]string{
+	"status":     Statuses,
+	"recurrence": Recurrences,
+	"category":   Categories,
+}
+
+// requiredFields are the PaymentData fields a payment must have, the others may be null
+var requiredFields = map[string]bool{
+	"merchant": true,
+	"amount":   true,
+	"currency": true,
+	"date":     true,
+	"status":   true,
+}
+
+// PaymentSchema is the JSON schema of PaymentData, derived from its JSON fields
+var PaymentSchema = paymentSchema()
+
+// ResponseSchema is the structured output schema sent to the model: {"payments": [PaymentSchema, ...]}
+var ResponseSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		envelopeField: map[string]interface{}{
+			"type":  "array",
+			"items": PaymentSchema,
+		},
+	},
+	"required":             []string{envelopeField},
+	"additionalProperties": false,
+}
+
+// paymentSchema builds PaymentSchema, every field is listed as required (structured output APIs expect that)
+// and optional fields are nullable instead
+func paymentSchema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	t := reflect.TypeOf(PaymentData{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		property := map[string]interface{}{"type": jsonType(fieldType)}
+		if !requiredFields[name] {
+			property["type"] = []string{jsonType(fieldType), "null"}
+		}
+
+		if values, ok := fieldEnums[name]; ok {
+			enum := make([]interface{}, 0, len(values)+1)
+			for _, value := range values {
+				enum = append(enum, value)
+			}
+			if !requiredFields[name] {
+				enum = append(enum, nil)
+			}
+			property["enum"] = enum
+		}
+
+		properties[name] = property
+		required = append(required, name)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// amountType is models.Amount, a number in the schema although numeric strings are accepted too
+var amountType = reflect.TypeOf(models.Amount{})
+
+// jsonType returns the JSON schema type of a Go type
+func jsonType(t reflect.Type) string {
+	if t == amountType {
+		return "number"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "object"
+	}
+}
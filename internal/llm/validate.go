@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// FieldError is one field of the model's answer that does not match PaymentSchema
+type FieldError struct {
+	Field   string // JSON field name, empty for the answer as a whole
+	Message string
+}
+
+// in returns the error for the field within the JSON value at path (e.g. "payments[1]")
+func (e FieldError) in(path string) FieldError {
+	if e.Field == "" {
+		return FieldError{Field: path, Message: e.Message}
+	}
+	return FieldError{Field: path + "." + e.Field, Message: e.Message}
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError is returned when the model's answer is not a valid payment
+// It is stored as the LLM sync job's last error, so it lists every invalid field
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field.String()
+	}
+	return "invalid payment: " + strings.Join(fields, "; ")
+}
+
+// currencyCode matches ISO 4217 codes
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// dateLayouts are the accepted payment date formats, timezone-less dates are UTC
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05"}
+
+// ParseDate parses a payment date as returned by the model
+func ParseDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// ValidatePayment checks a payment against PaymentSchema and the field rules of the prompt
+func ValidatePayment(payment PaymentData) error {
+	var fields []FieldError
+	invalid := func(field, message string) {
+		fields = append(fields, FieldError{Field: field, Message: message})
+	}
+
+	if strings.TrimSpace(payment.Merchant) == "" {
+		invalid("merchant", "is required")
+	}
+	if payment.Amount == nil {
+		invalid("amount", "is required")
+	} else if payment.Amount.Sign() <= 0 {
+		invalid("amount", fmt.Sprintf("must be positive, got %s", payment.Amount))
+	}
+	if payment.Currency == "" {
+		invalid("currency", "is required")
+	} else if !currencyCode.MatchString(payment.Currency) {
+		invalid("currency", fmt.Sprintf("must be an ISO 4217 code, got %q", payment.Currency))
+	} else if payment.Amount != nil && payment.Amount.Sign() > 0 {
+		// 1500.50 JPY is a misread amount, JPY has no decimals
+		money := models.Money{Amount: *payment.Amount, Currency: payment.Currency}
+		if err := money.Validate(); err != nil {
+			invalid("amount", fmt.Sprintf("must have at most %d decimals in %s, got %s",
+				models.CurrencyExponent(payment.Currency), payment.Currency, payment.Amount))
+		}
+	}
+	if payment.Date == "" {
+		invalid("date", "is required")
+	} else if _, err := ParseDate(payment.Date); err != nil {
+		invalid("date", fmt.Sprintf("must be ISO 8601 (YYYY-MM-DDTHH:MM:SS±HH:MM), got %q", payment.Date))
+	}
+	if payment.Status == "" {
+		invalid("status", "is required")
+	} else if !slices.Contains(Statuses, payment.Status) {
+		invalid("status", fmt.Sprintf("must be one of %s, got %q", strings.Join(Statuses, ", "), payment.Status))
+	}
+	if payment.Recurrence != nil && !slices.Contains(Recurrences, *payment.Recurrence) {
+		invalid("recurrence", fmt.Sprintf("must be one of %s or null, got %q", strings.Join(Recurrences, ", "), *payment.Recurrence))
+	}
+	if payment.Category != nil && !slices.Contains(Categories, *payment.Category) {
+		invalid("category", fmt.Sprintf("must be one of %s or null, got %q", strings.Join(Categories, ", "), *payment.Category))
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// decodeFieldError converts a JSON decoding error of a payment into the field it concerns
+func decodeFieldError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Type == amountType {
+		// Errors of unmarshalers may come without the field, amount is the only models.Amount of PaymentData
+		return FieldError{Field: "amount", Message: fmt.Sprintf("must be a number or a numeric string, got %s", typeErr.Value)}
+	}
+	if errors.As(err, &typeErr) {
+		return FieldError{Field: typeErr.Field, Message: fmt.Sprintf("must be of type %s, got %s", jsonType(typeErr.Type), typeErr.Value)}
+	}
+
+	// DisallowUnknownFields reports `json: unknown field "name"`
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return FieldError{Field: strings.Trim(name, `"`), Message: "is not a payment field"}
+	}
+
+	return FieldError{Message: fmt.Sprintf("answer is not a payment object: %v", err)}
+}
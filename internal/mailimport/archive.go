@@ -0,0 +1,257 @@
+// Package mailimport reads .eml files and mbox archives (e.g. Google Takeout) into a mail source,
+// so payment extraction can run on emails without a live mailbox; messages are streamed and held in batches
+package mailimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+	"github.com/vipul43/kiwis-worker/internal/mailparse"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// ErrNotSupported is returned for mailbox calls that make no sense for a fixed set of imported emails
+var ErrNotSupported = errors.New("not supported for imported emails")
+
+// ErrDuplicate is returned when a message with the same ID was already added to the archive
+var ErrDuplicate = errors.New("duplicate message")
+
+// Archive is an in-memory mail source holding a batch of imported messages, Reset empties it for the next batch
+// Message IDs are the Message-ID header (without angle brackets), or the SHA-256 of the raw message when it has
+// none, so an email imported again from another file or path keeps its ID
+type Archive struct {
+	ids      []string          // in import order
+	raw      map[string][]byte // keyed by message ID
+	messages map[string]*service.EmailMessage
+	seen     map[string]bool // IDs of every message added since NewArchive, kept by Reset
+}
+
+func NewArchive() *Archive {
+	return &Archive{
+		raw:      make(map[string][]byte),
+		messages: make(map[string]*service.EmailMessage),
+		seen:     make(map[string]bool),
+	}
+}
+
+// Read reads the .eml files and mbox archives at paths and calls fn with each raw message, one at a time
+// Directories import their .eml and .mbox files (not recursive) in name order; name is the file path, with "#n"
+// appended for the n-th message of an mbox archive
+func Read(paths []string, fn func(name string, raw []byte) error) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = readDir(path, fn)
+		} else {
+			err = readFile(path, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile reads an .eml file or an mbox archive (recognised by its leading "From " line)
+func readFile(path string, fn func(name string, raw []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	head, err := r.Peek(5)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if string(head) == "From " {
+		n := 0
+		return readMbox(r, func(raw []byte) error {
+			n++
+			return fn(path+"#"+strconv.Itoa(n), raw)
+		})
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return fn(path, raw)
+}
+
+// readDir reads every .eml and .mbox file in a directory (not recursive), in name order
+func readDir(dir string, fn func(name string, raw []byte) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".eml" && ext != ".mbox") {
+			continue
+		}
+		if err := readFile(filepath.Join(dir, entry.Name()), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses a raw RFC 5322 message, its ID is its Message-ID header or the SHA-256 of the raw message
+func Parse(raw []byte) (*service.EmailMessage, error) {
+	msg, err := mailparse.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = messageID(msg, raw)
+	msg.InternalDate = msg.Date
+	if labels, ok := msg.RawHeaders["X-Gmail-Labels"].(string); ok {
+		// Google Takeout keeps the Gmail labels of each message
+		for _, label := range strings.Split(labels, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				msg.Labels = append(msg.Labels, label)
+			}
+		}
+	}
+	return msg, nil
+}
+
+// messageID returns the message's Message-ID header without angle brackets, or the SHA-256 of the raw message
+func messageID(msg *service.EmailMessage, raw []byte) string {
+	for key, value := range msg.RawHeaders {
+		if !strings.EqualFold(key, "Message-ID") {
+			continue
+		}
+		if id, ok := value.(string); ok {
+			if id = strings.Trim(strings.TrimSpace(id), "<>"); id != "" {
+				return id
+			}
+		}
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether the message's subject or text matches the query's keywords, like the search of a sync
+func Matches(query service.SearchQuery, msg *service.EmailMessage) bool {
+	return query.Matches(msg.Subject, emailtext.Normalize(msg.BodyText, msg.BodyHTML))
+}
+
+// Add adds a parsed message with its raw content (attachments are read from it)
+// Returns ErrDuplicate when a message with the same ID was added before, also in an earlier batch
+func (a *Archive) Add(msg *service.EmailMessage, raw []byte) error {
+	if a.seen[msg.ID] {
+		return fmt.Errorf("%w: %s", ErrDuplicate, msg.ID)
+	}
+	a.seen[msg.ID] = true
+
+	a.ids = append(a.ids, msg.ID)
+	a.raw[msg.ID] = raw
+	a.messages[msg.ID] = msg
+	return nil
+}
+
+// Len returns the number of messages in the current batch
+func (a *Archive) Len() int {
+	return len(a.ids)
+}
+
+// Reset empties the archive for the next batch, messages added before are still reported as duplicates
+func (a *Archive) Reset() {
+	a.ids = nil
+	clear(a.raw)
+	clear(a.messages)
+}
+
+// Messages returns the messages of the current batch in import order
+func (a *Archive) Messages() []*service.EmailMessage {
+	messages := make([]*service.EmailMessage, len(a.ids))
+	for i, id := range a.ids {
+		messages[i] = a.messages[id]
+	}
+	return messages
+}
+
+// FetchMessageIDs returns the IDs of all imported messages in import order, the query is ignored
+// The page token is the index of the next message
+func (a *Archive) FetchMessageIDs(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
+	start := 0
+	if pageToken != "" {
+		var err error
+		if start, err = strconv.Atoi(pageToken); err != nil || start < 0 || start > len(a.ids) {
+			return nil, fmt.Errorf("invalid page token %q", pageToken)
+		}
+	}
+	end := min(start+maxResults, len(a.ids))
+
+	result := &service.MessageIDFetchResult{
+		MessageIDs:   append([]string(nil), a.ids[start:end]...),
+		TotalFetched: end - start,
+	}
+	if end < len(a.ids) {
+		result.NextPageToken = strconv.Itoa(end)
+	}
+	return result, nil
+}
+
+// FetchEmailsByIDs returns imported messages, unknown IDs are reported as service.ErrNotFound
+func (a *Archive) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*service.BatchFetchResult, error) {
+	result := &service.BatchFetchResult{
+		Messages: make(map[string]*service.EmailMessage, len(messageIDs)),
+		Errors:   make(map[string]error),
+	}
+	for _, id := range messageIDs {
+		if msg, ok := a.messages[id]; ok {
+			result.Messages[id] = msg
+		} else {
+			result.Errors[id] = fmt.Errorf("%w: message %s", service.ErrNotFound, id)
+		}
+	}
+	return result, nil
+}
+
+// FetchAttachment decodes an attachment of an imported message (the attachment ID is its MIME section number)
+func (a *Archive) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	raw, ok := a.raw[messageID]
+	if !ok {
+		return nil, fmt.Errorf("%w: message %s", service.ErrNotFound, messageID)
+	}
+	data, err := mailparse.ExtractPart(bytes.NewReader(raw), attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return data, nil
+}
+
+// FetchHistory is not supported, imports have no incremental sync
+func (a *Archive) FetchHistory(ctx context.Context, accountID string, startHistoryID string, query service.SearchQuery, pageToken string) (*service.HistoryFetchResult, error) {
+	return nil, ErrNotSupported
+}
+
+// GetProfile is not supported, imports have no mailbox
+func (a *Archive) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	return nil, ErrNotSupported
+}
+
+// Watch is not supported, imports have no push notifications
+func (a *Archive) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	return nil, service.ErrWatchUnsupported
+}
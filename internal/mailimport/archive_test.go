@@ -0,0 +1,219 @@
+package mailimport
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// takeoutMbox is a Google Takeout style mboxrd archive with LF line endings
+const takeoutMbox = `From 1790000000000000001@xxx Sun Mar 01 09:00:00 +0000 2026
+X-Gmail-Labels: Inbox,Category Updates,Bills
+Message-ID: <receipt-1@netflix.example>
+From: Netflix <info@netflix.example>
+Subject: Your receipt
+Date: Sun, 01 Mar 2026 09:00:00 +0000
+Content-Type: text/plain; charset=utf-8
+
+Amount charged: 9.99 EUR
+>From now on you are billed monthly.
+>>From the archive.
+
+From 1790000000000000002@xxx Mon Mar 02 10:00:00 +0000 2026
+From: Contoso <billing@contoso.example>
+Subject: Invoice
+Date: Mon, 02 Mar 2026 10:00:00 +0000
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+See attached invoice.
+--b
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="invoice.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQK
+--b--
+`
+
+// readArchive reads the messages at paths into a new archive, in one batch
+func readArchive(t *testing.T, paths ...string) *Archive {
+	t.Helper()
+
+	archive := NewArchive()
+	err := Read(paths, func(name string, raw []byte) error {
+		msg, err := Parse(raw)
+		if err != nil {
+			return err
+		}
+		return archive.Add(msg, raw)
+	})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return archive
+}
+
+func TestRead_Mbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "takeout.mbox")
+	if err := os.WriteFile(path, []byte(takeoutMbox), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	archive := readArchive(t, path)
+
+	messages := archive.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("Messages() = %d messages, want 2", len(messages))
+	}
+
+	receipt := messages[0]
+	if receipt.ID != "receipt-1@netflix.example" || receipt.Subject != "Your receipt" || receipt.From != "Netflix <info@netflix.example>" {
+		t.Errorf("first message = %q %q %q", receipt.ID, receipt.Subject, receipt.From)
+	}
+	wantBody := "Amount charged: 9.99 EUR\r\nFrom now on you are billed monthly.\r\n>From the archive.\r\n"
+	if receipt.BodyText != wantBody {
+		t.Errorf("BodyText = %q, want %q", receipt.BodyText, wantBody)
+	}
+	if strings.Join(receipt.Labels, "|") != "Inbox|Category Updates|Bills" {
+		t.Errorf("Labels = %v", receipt.Labels)
+	}
+	if receipt.InternalDate.IsZero() || !receipt.InternalDate.Equal(receipt.Date) {
+		t.Errorf("InternalDate = %v, want Date %v", receipt.InternalDate, receipt.Date)
+	}
+
+	// Without a Message-ID header the message is identified by its content
+	invoice := messages[1]
+	if len(invoice.ID) != 64 || len(invoice.Attachments) != 1 {
+		t.Fatalf("second message = %q with attachments %v, want a SHA-256 ID", invoice.ID, invoice.Attachments)
+	}
+	attachmentID := invoice.Attachments[0]["attachmentId"].(string)
+	data, err := archive.FetchAttachment(context.Background(), "acc-1", invoice.ID, attachmentID)
+	if err != nil {
+		t.Fatalf("FetchAttachment() error = %v", err)
+	}
+	if string(data) != "%PDF-1.4\n" {
+		t.Errorf("FetchAttachment() = %q", data)
+	}
+}
+
+func TestRead_Dir(t *testing.T) {
+	dir := t.TempDir()
+	eml := "From: a@example.com\r\nSubject: Paid\r\nMessage-ID: <paid-1@example.com>\r\n\r\nThanks, paid.\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "b.eml"), []byte(eml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.mbox"), []byte(takeoutMbox), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an email"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err := Read([]string{dir}, func(name string, raw []byte) error {
+		names = append(names, filepath.Base(name))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if strings.Join(names, ",") != "a.mbox#1,a.mbox#2,b.eml" {
+		t.Errorf("names = %v, want a.mbox#1,a.mbox#2,b.eml", names)
+	}
+}
+
+func TestArchive_StableIDs(t *testing.T) {
+	// The same emails unpacked to two places get the same IDs
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.WriteFile(filepath.Join(dir, "takeout.mbox"), []byte(takeoutMbox), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids [2][]string
+	for i, dir := range []string{first, second} {
+		for _, msg := range readArchive(t, dir).Messages() {
+			ids[i] = append(ids[i], msg.ID)
+		}
+	}
+	if strings.Join(ids[0], ",") != strings.Join(ids[1], ",") {
+		t.Errorf("IDs = %v and %v, want the same IDs", ids[0], ids[1])
+	}
+
+	// A message seen in an earlier batch is a duplicate
+	archive := readArchive(t, first)
+	archive.Reset()
+	msg, _ := Parse([]byte("Message-ID: <receipt-1@netflix.example>\r\n\r\nAgain\r\n"))
+	if err := archive.Add(msg, nil); !errors.Is(err, ErrDuplicate) || archive.Len() != 0 {
+		t.Errorf("Add() error = %v with %d messages, want ErrDuplicate", err, archive.Len())
+	}
+}
+
+func TestMatches(t *testing.T) {
+	query := service.SearchQuery{Keywords: service.PaymentKeywords}
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{"Subject: Your invoice\r\n\r\nSee attached.\r\n", true},
+		{"Subject: Hello\r\n\r\nAmount charged: 9.99 EUR\r\n", true},
+		{"Subject: Hello\r\nContent-Type: text/html\r\n\r\n<p>Your <b>receipt</b></p>\r\n", true},
+		{"Subject: Lunch tomorrow?\r\n\r\nSee you at noon\r\n", false},
+	}
+
+	for _, tt := range tests {
+		msg, err := Parse([]byte(tt.raw))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if got := Matches(query, msg); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", msg.Subject, got, tt.want)
+		}
+	}
+}
+
+func TestArchive_MailSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.mbox")
+	if err := os.WriteFile(path, []byte(takeoutMbox), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	archive := readArchive(t, path)
+	messages := archive.Messages()
+	ctx := context.Background()
+
+	page, err := archive.FetchMessageIDs(ctx, "acc-1", service.SearchQuery{}, 1, "")
+	if err != nil || strings.Join(page.MessageIDs, ",") != messages[0].ID || page.NextPageToken != "1" {
+		t.Fatalf("FetchMessageIDs() = %+v, %v", page, err)
+	}
+	page, err = archive.FetchMessageIDs(ctx, "acc-1", service.SearchQuery{}, 1, page.NextPageToken)
+	if err != nil || strings.Join(page.MessageIDs, ",") != messages[1].ID || page.NextPageToken != "" {
+		t.Fatalf("FetchMessageIDs() page 2 = %+v, %v", page, err)
+	}
+
+	fetched, err := archive.FetchEmailsByIDs(ctx, "acc-1", []string{messages[0].ID, "missing@example.com"})
+	if err != nil {
+		t.Fatalf("FetchEmailsByIDs() error = %v", err)
+	}
+	if fetched.Messages[messages[0].ID] == nil || !errors.Is(fetched.Errors["missing@example.com"], service.ErrNotFound) {
+		t.Errorf("FetchEmailsByIDs() = %+v", fetched)
+	}
+
+	if _, err := archive.GetProfile(ctx, "acc-1"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("GetProfile() error = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestReadMbox_NotMbox(t *testing.T) {
+	err := readMbox(strings.NewReader("Subject: hi\n\nbody\n"), func(raw []byte) error { return nil })
+	if err == nil {
+		t.Error("readMbox() error = nil, want error for a file without \"From \" separators")
+	}
+}
@@ -0,0 +1,59 @@
+package mailimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// maxLineLength bounds a single mbox line (long base64 lines without breaks exist in the wild)
+const maxLineLength = 16 << 20
+
+// readMbox splits an mbox archive into raw messages and calls fn for each
+// Messages start at a "From " line; lines quoted as ">From " (any number of '>') lose one '>' (mboxrd,
+// which Google Takeout writes). Line endings are converted to CRLF like messages fetched over IMAP
+func readMbox(r io.Reader, fn func(raw []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	var current *bytes.Buffer
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		// The blank line separating messages belongs to the archive, not the message
+		raw := bytes.TrimSuffix(current.Bytes(), []byte("\r\n"))
+		current = nil
+		return fn(raw)
+	}
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if err := flush(); err != nil {
+				return err
+			}
+			current = new(bytes.Buffer)
+			continue
+		}
+		if current == nil {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			return fmt.Errorf("not an mbox archive: line %d is not a \"From \" separator", lineNo)
+		}
+
+		if unquoted := bytes.TrimLeft(line, ">"); len(unquoted) < len(line) && bytes.HasPrefix(unquoted, []byte("From ")) {
+			line = line[1:]
+		}
+		current.Write(line)
+		current.WriteString("\r\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read mbox: %w", err)
+	}
+
+	return flush()
+}
@@ -0,0 +1,295 @@
+package mailparse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// snippetChars is the length of the snippet built from the body (Gmail snippets are about as long)
+const snippetChars = 200
+
+// messageBodies holds the first plain-text and HTML body found in a message
+type messageBodies struct {
+	text string
+	html string
+}
+
+// parser collects the attachments of one message
+type parser struct {
+	attachments []map[string]interface{}
+}
+
+// Parse reads a raw RFC 5322 message (IMAP BODY[], .eml file, mbox entry) into an EmailMessage
+// Bodies and headers are decoded to UTF-8; the caller sets ID, InternalDate and Labels
+// Attachment IDs are IMAP section numbers ("2", "1.2"), so an attachment can be fetched on its own
+func Parse(r io.Reader) (*service.EmailMessage, error) {
+	entity, err := message.Read(r)
+	if err != nil && !isDecodingError(err) {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	msg := &service.EmailMessage{
+		RawHeaders:  make(map[string]interface{}),
+		Attachments: []map[string]interface{}{},
+	}
+
+	fields := entity.Header.Fields()
+	for fields.Next() {
+		key, value := fields.Key(), fields.Value()
+		msg.RawHeaders[key] = value
+
+		// Address and subject headers may use RFC 2047 encoded words
+		switch strings.ToLower(key) {
+		case "subject":
+			msg.Subject = emailtext.DecodeHeader(value)
+		case "from":
+			msg.From = emailtext.DecodeHeader(value)
+		case "to":
+			msg.To = emailtext.DecodeHeader(value)
+		case "cc":
+			msg.CC = emailtext.DecodeHeader(value)
+		case "bcc":
+			msg.BCC = emailtext.DecodeHeader(value)
+		case "date":
+			date, err := mail.ParseDate(value)
+			if err != nil {
+				log.Printf("Warning: failed to parse date '%s': %v", value, err)
+			} else {
+				msg.Date = date
+			}
+		}
+	}
+	msg.ThreadID = threadID(entity.Header)
+
+	var p parser
+	var bodies messageBodies
+	if err := p.walk(entity, nil, &bodies); err != nil {
+		return nil, err
+	}
+
+	msg.BodyText = bodies.text
+	msg.BodyHTML = bodies.html
+	msg.Snippet = emailtext.Truncate(emailtext.Normalize(bodies.text, bodies.html), snippetChars)
+	if len(p.attachments) > 0 {
+		msg.HasAttachments = true
+		msg.Attachments = p.attachments
+	}
+
+	mediaType, _, _ := entity.Header.ContentType()
+	msg.RawPayload = map[string]interface{}{
+		"mimeType": mediaType,
+	}
+
+	return msg, nil
+}
+
+// DecodePart decodes the body of a single MIME part given its MIME header (e.g. IMAP BODY[2.MIME] and BODY[2])
+// Undoes the Content-Transfer-Encoding, the bytes are returned as is otherwise
+func DecodePart(header io.Reader, body io.Reader) ([]byte, error) {
+	h, err := textproto.ReadHeader(bufio.NewReader(header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read part header: %w", err)
+	}
+
+	// Only the transfer encoding is undone, so the charset of text attachments is left to the extractor
+	h.Del("Content-Type")
+	entity, err := message.New(message.Header{Header: h}, body)
+	if err != nil && !isDecodingError(err) {
+		return nil, fmt.Errorf("failed to decode part: %w", err)
+	}
+
+	data, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode part: %w", err)
+	}
+	return data, nil
+}
+
+// ExtractPart decodes the part at an IMAP section number (an attachment ID from Parse) of a raw message
+// Like DecodePart only the transfer encoding is undone
+func ExtractPart(r io.Reader, section string) ([]byte, error) {
+	var path []int
+	for _, part := range strings.Split(section, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid section %q", section)
+		}
+		path = append(path, n)
+	}
+
+	entity, err := message.Read(r)
+	if err != nil && !isDecodingError(err) {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	// Section 1 of a single-part message is its body
+	if entity.MultipartReader() == nil && section == "1" {
+		path = nil
+	}
+
+	for depth, n := range path {
+		mr := entity.MultipartReader()
+		if mr == nil {
+			return nil, fmt.Errorf("section %s not found", section)
+		}
+		for i := 1; i <= n; i++ {
+			entity, err = mr.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("section %s not found", sectionNumber(path[:depth+1]))
+			}
+			if err != nil && !isDecodingError(err) {
+				return nil, fmt.Errorf("failed to read section %s: %w", section, err)
+			}
+		}
+	}
+
+	data, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode section %s: %w", section, err)
+	}
+	return data, nil
+}
+
+// walk reads a MIME entity, collecting bodies and attachments
+// path holds the entity's position in the MIME tree (nil for the top-level entity)
+//   - multipart/alternative: the last (richest) representation of each type wins
+//   - multipart/related: only the root part is a body, the rest are inline resources (images)
+//   - other multipart types (mixed, signed, ...): parts in order
+func (p *parser) walk(entity *message.Entity, path []int, bodies *messageBodies) error {
+	mediaType, _, _ := entity.Header.ContentType()
+	mediaType = strings.ToLower(mediaType)
+
+	if mr := entity.MultipartReader(); mr != nil {
+		var children []messageBodies
+		for i := 1; ; i++ {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && !isDecodingError(err) {
+				return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+			}
+
+			var child messageBodies
+			if err := p.walk(part, append(path[:len(path):len(path)], i), &child); err != nil {
+				return err
+			}
+			children = append(children, child)
+		}
+
+		switch mediaType {
+		case "multipart/alternative":
+			for i := len(children) - 1; i >= 0; i-- {
+				bodies.fill(children[i])
+			}
+		case "multipart/related":
+			if len(children) > 0 {
+				bodies.fill(children[0])
+			}
+		default:
+			for _, child := range children {
+				bodies.fill(child)
+			}
+		}
+		return nil
+	}
+
+	if filename := partFilename(entity.Header); filename != "" || isAttachment(entity.Header) {
+		size, err := io.Copy(io.Discard, entity.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment: %w", err)
+		}
+		if filename != "" {
+			p.attachments = append(p.attachments, map[string]interface{}{
+				"filename":     filename,
+				"mimeType":     mediaType,
+				"size":         size,
+				"attachmentId": sectionNumber(path),
+			})
+		}
+		return nil
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return nil
+	}
+
+	data, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+	}
+	// go-message leaves non-UTF-8 bodies undecoded (message.CharsetReader is not set), DecodeCharset converts them
+	text := emailtext.DecodeCharset(data, entity.Header.Get("Content-Type"))
+	if mediaType == "text/plain" {
+		bodies.fill(messageBodies{text: text})
+	} else {
+		bodies.fill(messageBodies{html: text})
+	}
+	return nil
+}
+
+// fill sets the bodies that are still empty
+func (b *messageBodies) fill(other messageBodies) {
+	if b.text == "" {
+		b.text = other.text
+	}
+	if b.html == "" {
+		b.html = other.html
+	}
+}
+
+// partFilename returns the decoded filename of a part (Content-Disposition filename, else Content-Type name)
+func partFilename(header message.Header) string {
+	_, params, _ := header.ContentDisposition()
+	filename := params["filename"]
+	if filename == "" {
+		_, params, _ := header.ContentType()
+		filename = params["name"]
+	}
+	return emailtext.DecodeHeader(filename)
+}
+
+// isAttachment reports parts with Content-Disposition: attachment
+func isAttachment(header message.Header) bool {
+	disposition, _, err := header.ContentDisposition()
+	return err == nil && strings.EqualFold(disposition, "attachment")
+}
+
+// sectionNumber returns the IMAP section number of a part ("1" for the body of a single-part message)
+func sectionNumber(path []int) string {
+	if len(path) == 0 {
+		return "1"
+	}
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// threadID identifies the conversation by its first message: the first References ID, else In-Reply-To,
+// else the message's own Message-ID
+func threadID(header message.Header) string {
+	for _, key := range []string{"References", "In-Reply-To", "Message-Id"} {
+		if ids := strings.Fields(header.Get(key)); len(ids) > 0 {
+			return strings.Trim(ids[0], "<>")
+		}
+	}
+	return ""
+}
+
+// isDecodingError reports unknown charsets and transfer encodings, the entity is still readable (undecoded)
+func isDecodingError(err error) bool {
+	return message.IsUnknownCharset(err) || message.IsUnknownEncoding(err)
+}
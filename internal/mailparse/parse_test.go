@@ -0,0 +1,206 @@
+package mailparse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// crlf converts a readable test message to wire format
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+const mixedMessage = `From: =?ISO-8859-1?Q?Caf=E9_de_Paris?= <billing@cafe.example>
+To: anna@example.com
+Subject: =?UTF-8?B?Vm90cmUgZmFjdHVyZSBkZSBkw6ljZW1icmU=?=
+Date: Wed, 31 Dec 2025 18:30:00 +0100
+Message-ID: <invoice-42@cafe.example>
+References: <order-7@cafe.example> <invoice-41@cafe.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="mixed"
+
+--mixed
+Content-Type: multipart/related; boundary="related"
+
+--related
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+Montant d=FB : 49,99 EUR
+--alt
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
+
+PHA+TW9udGFudCBkw7sgOiA8Yj40OSw5OSDigqw8L2I+PC9wPg==
+--alt--
+
+--related
+Content-Type: image/png; name="logo.png"
+Content-Transfer-Encoding: base64
+Content-ID: <logo>
+
+iVBORw0KGgo=
+--related--
+
+--mixed
+Content-Type: text/plain; charset=us-ascii
+Content-Disposition: attachment; filename="terms.txt"
+
+These terms are not the message body.
+--mixed
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="=?UTF-8?Q?Rechnung_M=C3=A4rz.pdf?="
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQK
+--mixed--
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		subject     string
+		from        string
+		threadID    string
+		bodyText    string
+		bodyHTML    string
+		attachments []string // filename=attachmentId
+	}{
+		{
+			name:        "multipart with attachments",
+			raw:         mixedMessage,
+			subject:     "Votre facture de décembre",
+			from:        "Café de Paris <billing@cafe.example>",
+			threadID:    "order-7@cafe.example",
+			bodyText:    "Montant dû : 49,99 EUR",
+			bodyHTML:    "<p>Montant dû : <b>49,99 €</b></p>",
+			attachments: []string{"logo.png=1.2", "terms.txt=2", "Rechnung März.pdf=3"},
+		},
+		{
+			name: "single part windows-1252",
+			raw: "From: receipts@shop.example\nSubject: Receipt\nMessage-ID: <r1@shop.example>\n" +
+				"Content-Type: text/plain; charset=windows-1252\n\nTotal \x80 12\n",
+			subject:  "Receipt",
+			from:     "receipts@shop.example",
+			threadID: "r1@shop.example",
+			bodyText: "Total € 12\r\n",
+		},
+		{
+			name:     "no content type",
+			raw:      "From: a@example.com\nSubject: Paid\nIn-Reply-To: <q@example.com>\n\nThanks, paid.\n",
+			subject:  "Paid",
+			from:     "a@example.com",
+			threadID: "q@example.com",
+			bodyText: "Thanks, paid.\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Parse(strings.NewReader(crlf(tt.raw)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			if msg.From != tt.from {
+				t.Errorf("From = %q, want %q", msg.From, tt.from)
+			}
+			if msg.ThreadID != tt.threadID {
+				t.Errorf("ThreadID = %q, want %q", msg.ThreadID, tt.threadID)
+			}
+			if msg.BodyText != tt.bodyText {
+				t.Errorf("BodyText = %q, want %q", msg.BodyText, tt.bodyText)
+			}
+			if msg.BodyHTML != tt.bodyHTML {
+				t.Errorf("BodyHTML = %q, want %q", msg.BodyHTML, tt.bodyHTML)
+			}
+
+			var attachments []string
+			for _, attachment := range msg.Attachments {
+				attachments = append(attachments, attachment["filename"].(string)+"="+attachment["attachmentId"].(string))
+			}
+			if strings.Join(attachments, ",") != strings.Join(tt.attachments, ",") {
+				t.Errorf("attachments = %v, want %v", attachments, tt.attachments)
+			}
+			if msg.HasAttachments != (len(tt.attachments) > 0) {
+				t.Errorf("HasAttachments = %v", msg.HasAttachments)
+			}
+		})
+	}
+}
+
+func TestParse_Date(t *testing.T) {
+	msg, err := Parse(strings.NewReader(crlf(mixedMessage)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := time.Date(2025, 12, 31, 17, 30, 0, 0, time.UTC)
+	if !msg.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", msg.Date, want)
+	}
+	if msg.Snippet != "Montant dû : 49,99 EUR" {
+		t.Errorf("Snippet = %q", msg.Snippet)
+	}
+}
+
+func TestDecodePart(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   string
+	}{
+		{"base64", "Content-Type: application/pdf\r\nContent-Transfer-Encoding: base64\r\n\r\n", "JVBERi0xLjQK", "%PDF-1.4\n"},
+		{"quoted-printable keeps charset bytes", "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", "Caf=E9", "Caf\xe9"},
+		{"no encoding", "Content-Type: text/csv\r\n\r\n", "a,b\r\n", "a,b\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePart(strings.NewReader(tt.header), strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("DecodePart() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("DecodePart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPart(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		section string
+		want    string
+		wantErr bool
+	}{
+		{"nested inline image", mixedMessage, "1.2", "\x89PNG\r\n\x1a\n", false},
+		{"text attachment", mixedMessage, "2", "These terms are not the message body.", false},
+		{"base64 attachment", mixedMessage, "3", "%PDF-1.4\n", false},
+		{"single part body", "Subject: Hi\nContent-Type: text/plain\n\nHello\n", "1", "Hello\r\n", false},
+		{"missing section", mixedMessage, "4", "", true},
+		{"invalid section", mixedMessage, "1.x", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPart(strings.NewReader(crlf(tt.raw)), tt.section)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractPart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ExtractPart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package mailsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+var ErrUnsupportedProvider = errors.New("unsupported mail provider")
+
+// AccountStore loads accounts to look up their provider
+type AccountStore interface {
+	GetByID(ctx context.Context, accountID string) (*models.Account, error)
+}
+
+// Router is a service.MailSource that sends each call to the source of the account's provider (Account.ProviderID)
+type Router struct {
+	accounts AccountStore
+	sources  map[string]service.MailSource // keyed by provider ID
+
+	mu        sync.Mutex
+	providers map[string]string // account ID -> provider ID, an account never changes provider
+}
+
+func NewRouter(accounts AccountStore, sources map[string]service.MailSource) *Router {
+	return &Router{
+		accounts:  accounts,
+		sources:   sources,
+		providers: make(map[string]string),
+	}
+}
+
+func (r *Router) FetchMessageIDs(ctx context.Context, accountID string, query service.SearchQuery, maxResults int, pageToken string) (*service.MessageIDFetchResult, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.FetchMessageIDs(ctx, accountID, query, maxResults, pageToken)
+}
+
+func (r *Router) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*service.BatchFetchResult, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.FetchEmailsByIDs(ctx, accountID, messageIDs)
+}
+
+func (r *Router) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.FetchAttachment(ctx, accountID, messageID, attachmentID)
+}
+
+func (r *Router) FetchHistory(ctx context.Context, accountID string, startHistoryID string, query service.SearchQuery, pageToken string) (*service.HistoryFetchResult, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.FetchHistory(ctx, accountID, startHistoryID, query, pageToken)
+}
+
+func (r *Router) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.GetProfile(ctx, accountID)
+}
+
+func (r *Router) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	source, err := r.source(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return source.Watch(ctx, accountID, topicName)
+}
+
+// source returns the mail source of the account's provider, looking the provider up once per account
+func (r *Router) source(ctx context.Context, accountID string) (service.MailSource, error) {
+	r.mu.Lock()
+	providerID, ok := r.providers[accountID]
+	r.mu.Unlock()
+
+	if !ok {
+		account, err := r.accounts.GetByID(ctx, accountID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get account: %w", err)
+		}
+		providerID = account.ProviderID
+
+		r.mu.Lock()
+		r.providers[accountID] = providerID
+		r.mu.Unlock()
+	}
+
+	source, ok := r.sources[providerID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedProvider, providerID)
+	}
+	return source, nil
+}
@@ -0,0 +1,88 @@
+package mailsource
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+type mockAccountStore struct {
+	accounts map[string]*models.Account
+	lookups  int
+}
+
+func (m *mockAccountStore) GetByID(ctx context.Context, accountID string) (*models.Account, error) {
+	m.lookups++
+	account, ok := m.accounts[accountID]
+	if !ok {
+		return nil, errors.New("account not found")
+	}
+	return account, nil
+}
+
+// namedSource reports its name as the profile email address
+type namedSource struct {
+	service.MailSource
+	name string
+}
+
+func (s *namedSource) GetProfile(ctx context.Context, accountID string) (*service.MailboxProfile, error) {
+	return &service.MailboxProfile{EmailAddress: s.name + ":" + accountID}, nil
+}
+
+func TestRouter(t *testing.T) {
+	accounts := &mockAccountStore{accounts: map[string]*models.Account{
+		"gmail-account":      {ID: "gmail-account", ProviderID: models.ProviderGoogle},
+		"imap-account":       {ID: "imap-account", ProviderID: models.ProviderIMAP},
+		"credential-account": {ID: "credential-account", ProviderID: "credential"},
+	}}
+	router := NewRouter(accounts, map[string]service.MailSource{
+		models.ProviderGoogle: &namedSource{name: "gmail"},
+		models.ProviderIMAP:   &namedSource{name: "imap"},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		accountID string
+		want      string
+		wantErr   error
+	}{
+		{"gmail-account", "gmail:gmail-account", nil},
+		{"imap-account", "imap:imap-account", nil},
+		{"credential-account", "", ErrUnsupportedProvider},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accountID, func(t *testing.T) {
+			profile, err := router.GetProfile(ctx, tt.accountID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GetProfile() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetProfile() error = %v", err)
+			}
+			if profile.EmailAddress != tt.want {
+				t.Errorf("GetProfile() routed to %q, want %q", profile.EmailAddress, tt.want)
+			}
+		})
+	}
+
+	// Providers are looked up once per account
+	lookups := accounts.lookups
+	if _, err := router.GetProfile(ctx, "imap-account"); err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if accounts.lookups != lookups {
+		t.Errorf("account looked up again, lookups = %d, want %d", accounts.lookups, lookups)
+	}
+
+	if _, err := router.GetProfile(ctx, "missing"); err == nil {
+		t.Error("GetProfile() for a missing account returned no error")
+	}
+}
@@ -0,0 +1,276 @@
+// Package merchant normalises the merchant names extracted from emails to canonical merchants: "NETFLIX.COM",
+// "Netflix" and "Netflix India" are written differently but are the same merchant
+package merchant
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+
+	"github.com/vipul43/kiwis-worker/internal/dedup"
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+const (
+	// FuzzyThreshold is the similarity (0 to 1) from which a name is matched to the closest alias
+	FuzzyThreshold = 0.85
+	// prefixSimilarity is the similarity of a name starting with the words of an alias, "netflix india" and "netflix"
+	// It is below FuzzyThreshold: "hdfc life" starts with "hdfc" but is another merchant, only the sender domain
+	// links a longer name to its merchant
+	prefixSimilarity = 0.8
+	// minPrefixLength is the length of the shorter name from which word prefixes count, "the" is no merchant
+	minPrefixLength = 4
+	// minWordLength is the length from which a word shared with a sender domain's merchant counts
+	minWordLength = 3
+)
+
+// Match methods, in the order they are tried
+const (
+	MatchAlias  = "alias"  // The name is a known alias
+	MatchDomain = "domain" // The email was sent from the merchant's domain and the name shares a word with it
+	MatchFuzzy  = "fuzzy"  // The name is close to a known alias
+)
+
+// sharedDomains send emails on behalf of many merchants (mailbox providers, payment processors, app stores),
+// they never identify a merchant
+var sharedDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true, "live.com": true,
+	"yahoo.com": true, "icloud.com": true, "me.com": true, "proton.me": true, "protonmail.com": true,
+	"paypal.com": true, "stripe.com": true, "razorpay.com": true, "paddle.com": true, "square.com": true,
+	"apple.com": true, "google.com": true, "amazonaws.com": true,
+}
+
+// domainSuffixes are dropped from names written as a domain, "NETFLIX.COM" is "netflix"
+var domainSuffixes = map[string]bool{
+	"com": true, "net": true, "org": true, "io": true, "in": true, "co": true, "uk": true, "app": true, "tv": true,
+}
+
+// Match is the canonical merchant a name was matched to
+type Match struct {
+	MerchantID string
+	Method     string  // One of the Match* constants
+	Similarity float64 // 1 for alias and domain matches
+}
+
+// Registry resolves merchant names against the known canonical merchants, their aliases and sender domains
+type Registry struct {
+	merchants map[string]models.Merchant
+	aliases   map[string]string // Alias -> merchant ID
+	domains   map[string]string // Domain -> merchant ID
+	keys      []string          // Aliases in order, so fuzzy matching is deterministic
+}
+
+// NewRegistry returns a registry of merchants with their aliases and domains loaded
+func NewRegistry(merchants []models.Merchant) *Registry {
+	r := &Registry{
+		merchants: make(map[string]models.Merchant, len(merchants)),
+		aliases:   make(map[string]string),
+		domains:   make(map[string]string),
+	}
+	for _, merchant := range merchants {
+		r.Add(merchant)
+	}
+	return r
+}
+
+// Add registers a merchant with its aliases and domains
+func (r *Registry) Add(merchant models.Merchant) {
+	r.merchants[merchant.ID] = merchant
+	for _, alias := range merchant.Aliases {
+		r.AddAlias(merchant.ID, alias.Alias)
+	}
+	for _, domain := range merchant.Domains {
+		r.domains[strings.ToLower(domain.Domain)] = merchant.ID
+	}
+}
+
+// AddAlias registers another alias of a merchant, an alias already referring to a merchant is kept
+func (r *Registry) AddAlias(merchantID, alias string) {
+	if alias == "" {
+		return
+	}
+	if _, ok := r.aliases[alias]; ok {
+		return
+	}
+	r.aliases[alias] = merchantID
+	i := sort.SearchStrings(r.keys, alias)
+	r.keys = append(r.keys, "")
+	copy(r.keys[i+1:], r.keys[i:])
+	r.keys[i] = alias
+}
+
+// Merchant returns a registered merchant by ID
+func (r *Registry) Merchant(merchantID string) (models.Merchant, bool) {
+	merchant, ok := r.merchants[merchantID]
+	return merchant, ok
+}
+
+// Resolve maps a merchant name and the domain of the email it was found in (empty when unknown) to a canonical
+// merchant: an exact alias, then the sender domain, then the most similar alias from FuzzyThreshold
+func (r *Registry) Resolve(name, domain string) (Match, bool) {
+	key := Key(name)
+	if key == "" {
+		return Match{}, false
+	}
+
+	if merchantID, ok := r.aliases[key]; ok {
+		return Match{MerchantID: merchantID, Method: MatchAlias, Similarity: 1}, true
+	}
+
+	// A bank's alert about a purchase is sent from the bank's domain, so the name must share a word with the
+	// domain's merchant
+	if merchantID, ok := r.lookupDomain(domain); ok && r.sharesWord(merchantID, key) {
+		return Match{MerchantID: merchantID, Method: MatchDomain, Similarity: 1}, true
+	}
+
+	best := Match{}
+	for _, alias := range r.keys {
+		if similarity := Similarity(key, alias); similarity > best.Similarity {
+			best = Match{MerchantID: r.aliases[alias], Method: MatchFuzzy, Similarity: similarity}
+		}
+	}
+	if best.Similarity >= FuzzyThreshold {
+		return best, true
+	}
+	return Match{}, false
+}
+
+// lookupDomain returns the merchant of a sender domain or its parent domains (mailer.netflix.com is netflix.com)
+func (r *Registry) lookupDomain(domain string) (string, bool) {
+	domain = strings.ToLower(domain)
+	for domain != "" && !IsSharedDomain(domain) {
+		if merchantID, ok := r.domains[domain]; ok {
+			return merchantID, true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found || !strings.Contains(parent, ".") {
+			break
+		}
+		domain = parent
+	}
+	return "", false
+}
+
+// sharesWord reports whether key shares a word of at least minWordLength letters with an alias of a merchant
+func (r *Registry) sharesWord(merchantID, key string) bool {
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(key) {
+		if len(word) >= minWordLength {
+			words[word] = true
+		}
+	}
+	for _, alias := range r.keys {
+		if r.aliases[alias] != merchantID {
+			continue
+		}
+		for _, word := range strings.Fields(alias) {
+			if words[word] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Key normalises a merchant name into an alias: dedup.MerchantKey, with names written as a domain reduced to
+// their name ("NETFLIX.COM" and "www.netflix.com" are "netflix")
+func Key(name string) string {
+	name = strings.TrimSpace(name)
+	if !strings.ContainsAny(name, " \t") && strings.Contains(name, ".") {
+		labels := strings.Split(strings.TrimPrefix(strings.ToLower(name), "www."), ".")
+		for len(labels) > 1 && domainSuffixes[labels[len(labels)-1]] {
+			labels = labels[:len(labels)-1]
+		}
+		name = strings.Join(labels, " ")
+	}
+	return dedup.MerchantKey(name)
+}
+
+// SenderDomain returns the lower-case domain of a From header, empty when there is none
+func SenderDomain(from string) string {
+	address := from
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		address = parsed.Address
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(address[at+1:], " >"))
+}
+
+// IsSharedDomain reports whether a domain sends emails for many merchants and never identifies one
+func IsSharedDomain(domain string) bool {
+	domain = strings.ToLower(domain)
+	for {
+		if sharedDomains[domain] {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found || !strings.Contains(parent, ".") {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// OwnDomain returns the part of a sender domain that is a merchant's own, from the label equal to its alias without
+// spaces ("netflix.com" from mailer.netflix.com for "netflix", "hdfcbank.com" for "hdfc bank"), empty when there is
+// none; a bank's domain is never learned from its alert about a purchase
+func OwnDomain(alias, domain string) string {
+	if domain == "" || IsSharedDomain(domain) {
+		return ""
+	}
+	compact := strings.ReplaceAll(alias, " ", "")
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for i, label := range labels[:len(labels)-1] {
+		if label == compact {
+			return strings.Join(labels[i:], ".")
+		}
+	}
+	return ""
+}
+
+// Similarity compares two aliases, 0 to 1: prefixSimilarity when one starts with the words of the other,
+// otherwise 1 minus their edit distance relative to the longer one (spaces ignored)
+func Similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	shorter, longer := a, b
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	if len(shorter) >= minPrefixLength && strings.HasPrefix(longer, shorter+" ") {
+		return prefixSimilarity
+	}
+
+	ra := []rune(strings.ReplaceAll(a, " ", ""))
+	rb := []rune(strings.ReplaceAll(b, " ", ""))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,134 @@
+package merchant
+
+import (
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+func testRegistry() *Registry {
+	return NewRegistry([]models.Merchant{
+		{
+			ID: "netflix", Name: "Netflix",
+			Aliases: []models.MerchantAlias{{Alias: "netflix"}},
+			Domains: []models.MerchantDomain{{Domain: "netflix.com"}},
+		},
+		{
+			ID: "hdfc", Name: "HDFC Bank",
+			Aliases: []models.MerchantAlias{{Alias: "hdfc bank"}},
+			Domains: []models.MerchantDomain{{Domain: "hdfcbank.com"}},
+		},
+		{ID: "spotify", Name: "Spotify", Aliases: []models.MerchantAlias{{Alias: "spotify"}}},
+	})
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Netflix", "netflix"},
+		{"NETFLIX.COM", "netflix"},
+		{"www.amazon.co.in", "amazon"},
+		{"Netflix, Inc.", "netflix"},
+		{"Netflix India", "netflix india"},
+		{"St. John Ambulance", "st john ambulance"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Key(tt.name); got != tt.want {
+				t.Errorf("Key(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	registry := testRegistry()
+
+	tests := []struct {
+		name       string
+		merchant   string
+		domain     string
+		wantID     string // empty for no match
+		wantMethod string
+	}{
+		{"exact alias", "NETFLIX.COM", "", "netflix", MatchAlias},
+		{"alias wins over domain", "Spotify", "netflix.com", "spotify", MatchAlias},
+		{"sender domain", "HDFC Credit Card", "alerts.hdfcbank.com", "hdfc", MatchDomain},
+		{"bank alert about a purchase", "Zomato", "alerts.hdfcbank.com", "", ""},
+		{"prefix is no match", "HDFC Bank Life", "", "", ""},
+		{"prefix with sender domain", "Netflix India", "mailer.netflix.com", "netflix", MatchDomain},
+		{"fuzzy typo", "Spotfy", "", "spotify", MatchFuzzy},
+		{"shared domain", "HDFC Credit Card", "gmail.com", "", ""},
+		{"unknown", "Electricity Board", "", "", ""},
+		{"empty", "", "netflix.com", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := registry.Resolve(tt.merchant, tt.domain)
+			if tt.wantID == "" {
+				if ok {
+					t.Errorf("Resolve() = %+v, want no match", match)
+				}
+				return
+			}
+			if !ok || match.MerchantID != tt.wantID || match.Method != tt.wantMethod {
+				t.Errorf("Resolve() = %+v, %v, want %s by %s", match, ok, tt.wantID, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestRegistry_AddAlias(t *testing.T) {
+	registry := testRegistry()
+	registry.AddAlias("netflix", "nflx")
+	registry.AddAlias("spotify", "netflix") // Already refers to Netflix
+
+	if match, ok := registry.Resolve("NFLX", ""); !ok || match.MerchantID != "netflix" || match.Method != MatchAlias {
+		t.Errorf("Resolve() = %+v, want the added alias", match)
+	}
+	if match, _ := registry.Resolve("Netflix", ""); match.MerchantID != "netflix" {
+		t.Errorf("Resolve() = %+v, want the alias to keep its merchant", match)
+	}
+}
+
+func TestSenderDomain(t *testing.T) {
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"Netflix <info@Mailer.Netflix.com>", "mailer.netflix.com"},
+		{"billing@power.example", "power.example"},
+		{"\"Acme, Inc.\" <billing@acme.io>", "acme.io"},
+		{"Undisclosed", ""},
+	}
+
+	for _, tt := range tests {
+		if got := SenderDomain(tt.from); got != tt.want {
+			t.Errorf("SenderDomain(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestOwnDomain(t *testing.T) {
+	tests := []struct {
+		alias  string
+		domain string
+		want   string
+	}{
+		{"netflix", "mailer.netflix.com", "netflix.com"},
+		{"hdfc bank", "hdfcbank.net", "hdfcbank.net"},
+		{"zomato", "alerts.hdfcbank.net", ""},
+		{"paypal", "paypal.com", ""},
+		{"com", "netflix.com", ""},
+	}
+
+	for _, tt := range tests {
+		if got := OwnDomain(tt.alias, tt.domain); got != tt.want {
+			t.Errorf("OwnDomain(%q, %q) = %q, want %q", tt.alias, tt.domain, got, tt.want)
+		}
+	}
+}
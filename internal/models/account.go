@@ -2,6 +2,13 @@ package models
 
 import "time"
 
+// Provider IDs select the mail source an account is synced from
+const (
+	ProviderGoogle    = "google"    // Gmail API
+	ProviderIMAP      = "imap"      // IMAP server from imap_settings
+	ProviderMicrosoft = "microsoft" // Microsoft Graph (Outlook.com, Microsoft 365)
+)
+
 // Account represents a user's OAuth account
 // Note: Column names use camelCase to match Prisma/frontend schema
 type Account struct {
@@ -9,17 +9,22 @@ const (
 	StatusProcessing AccountSyncStatus = "processing"
 	StatusCompleted  AccountSyncStatus = "completed"
 	StatusFailed     AccountSyncStatus = "failed"
+	StatusDead       AccountSyncStatus = "dead"
 )
 
 type AccountSyncJob struct {
-	ID          string            `gorm:"column:id;primaryKey"`
-	AccountID   string            `gorm:"column:account_id;uniqueIndex"`
-	Status      AccountSyncStatus `gorm:"column:status"`
-	Attempts    int               `gorm:"column:attempts"`
-	LastError   *string           `gorm:"column:last_error"`
-	CreatedAt   time.Time         `gorm:"column:created_at"`
-	UpdatedAt   time.Time         `gorm:"column:updated_at"`
-	ProcessedAt *time.Time        `gorm:"column:processed_at"`
+	ID             string            `gorm:"column:id;primaryKey"`
+	AccountID      string            `gorm:"column:account_id;uniqueIndex"`
+	Status         AccountSyncStatus `gorm:"column:status"`
+	Attempts       int               `gorm:"column:attempts"`
+	LastError      *string           `gorm:"column:last_error"`
+	ClaimedBy      *string           `gorm:"column:claimed_by"`
+	ClaimedAt      *time.Time        `gorm:"column:claimed_at"`
+	LeaseExpiresAt *time.Time        `gorm:"column:lease_expires_at"`
+	NextAttemptAt  *time.Time        `gorm:"column:next_attempt_at"`
+	CreatedAt      time.Time         `gorm:"column:created_at"`
+	UpdatedAt      time.Time         `gorm:"column:updated_at"`
+	ProcessedAt    *time.Time        `gorm:"column:processed_at"`
 }
 
 // TableName specifies the table name for GORM
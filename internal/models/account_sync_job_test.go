@@ -14,6 +14,7 @@ func TestAccountSyncStatus_Constants(t *testing.T) {
 		{StatusProcessing, "processing"},
 		{StatusCompleted, "completed"},
 		{StatusFailed, "failed"},
+		{StatusDead, "dead"},
 	}
 
 	for _, tt := range tests {
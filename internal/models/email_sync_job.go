@@ -7,32 +7,41 @@ type EmailSyncStatus string
 const (
 	EmailStatusPending    EmailSyncStatus = "pending"    // Ready to fetch next batch
 	EmailStatusProcessing EmailSyncStatus = "processing" // Currently fetching
-	EmailStatusSynced     EmailSyncStatus = "synced"     // All historical emails fetched, waiting for webhook
-	EmailStatusCompleted  EmailSyncStatus = "completed"  // Webhook setup complete, job finished
-	EmailStatusFailed     EmailSyncStatus = "failed"     // Failed after max retries
+	EmailStatusSynced     EmailSyncStatus = "synced"     // Caught up, re-claimed for incremental sync after IncrementalSyncInterval
+	EmailStatusCompleted  EmailSyncStatus = "completed"  // Caught up with webhook (push notifications) set up, re-claimed on push or watch renewal
+	EmailStatusFailed     EmailSyncStatus = "failed"     // Failed, retried after next_attempt_at
+	EmailStatusDead       EmailSyncStatus = "dead"       // Failed after max retries, not retried
 )
 
 type EmailSyncType string
 
 const (
 	SyncTypeInitial     EmailSyncType = "initial"     // Initial historical sync
-	SyncTypeIncremental EmailSyncType = "incremental" // Incremental sync (manual re-sync)
-	SyncTypeWebhook     EmailSyncType = "webhook"     // Real-time sync (webhook-triggered)
+	SyncTypeIncremental EmailSyncType = "incremental" // Incremental sync via Gmail History API (after initial sync)
+	SyncTypeWebhook     EmailSyncType = "webhook"     // Real-time sync via Gmail History API (triggered by Pub/Sub push)
 )
 
 type EmailSyncJob struct {
-	ID            string          `gorm:"column:id;primaryKey"`
-	AccountID     string          `gorm:"column:account_id;index"`
-	Status        EmailSyncStatus `gorm:"column:status;index"`
-	SyncType      EmailSyncType   `gorm:"column:sync_type"`
-	EmailsFetched int             `gorm:"column:emails_fetched"`
-	PageToken     *string         `gorm:"column:page_token"`
-	LastSyncedAt  *time.Time      `gorm:"column:last_synced_at"`
-	Attempts      int             `gorm:"column:attempts"`
-	LastError     *string         `gorm:"column:last_error"`
-	CreatedAt     time.Time       `gorm:"column:created_at"`
-	UpdatedAt     time.Time       `gorm:"column:updated_at"`
-	ProcessedAt   *time.Time      `gorm:"column:processed_at"`
+	ID              string          `gorm:"column:id;primaryKey"`
+	AccountID       string          `gorm:"column:account_id;index"`
+	Status          EmailSyncStatus `gorm:"column:status;index"`
+	SyncType        EmailSyncType   `gorm:"column:sync_type"`
+	EmailsFetched   int             `gorm:"column:emails_fetched"`
+	PageToken       *string         `gorm:"column:page_token"`
+	HistoryID       *string         `gorm:"column:history_id"`          // Mail source history ID incremental sync resumes from
+	EmailAddress    *string         `gorm:"column:email_address;index"` // Mailbox address, matches push notifications to the job
+	WatchExpiration *time.Time      `gorm:"column:watch_expiration"`
+	SyncRequestedAt *time.Time      `gorm:"column:sync_requested_at"` // Set by push notifications
+	LastSyncedAt    *time.Time      `gorm:"column:last_synced_at"`
+	Attempts        int             `gorm:"column:attempts"`
+	LastError       *string         `gorm:"column:last_error"`
+	ClaimedBy       *string         `gorm:"column:claimed_by"`
+	ClaimedAt       *time.Time      `gorm:"column:claimed_at"`
+	LeaseExpiresAt  *time.Time      `gorm:"column:lease_expires_at"`
+	NextAttemptAt   *time.Time      `gorm:"column:next_attempt_at"`
+	CreatedAt       time.Time       `gorm:"column:created_at"`
+	UpdatedAt       time.Time       `gorm:"column:updated_at"`
+	ProcessedAt     *time.Time      `gorm:"column:processed_at"`
 }
 
 // TableName specifies the table name for GORM
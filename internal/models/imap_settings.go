@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type IMAPAuthMethod string
+
+const (
+	IMAPAuthPassword IMAPAuthMethod = "password" // LOGIN with the account's app password
+	IMAPAuthXOAuth2  IMAPAuthMethod = "xoauth2"  // SASL XOAUTH2 with the account's access token, refreshed via OAuthProvider
+)
+
+// IMAPSettings holds the IMAP server of an account with providerId 'imap'
+type IMAPSettings struct {
+	AccountID     string         `gorm:"column:account_id;primaryKey"`
+	Host          string         `gorm:"column:host"`
+	Port          int            `gorm:"column:port"`
+	TLS           bool           `gorm:"column:tls"` // Implicit TLS, otherwise STARTTLS when offered
+	Username      string         `gorm:"column:username"`
+	AuthMethod    IMAPAuthMethod `gorm:"column:auth_method"`
+	OAuthProvider *string        `gorm:"column:oauth_provider"` // Provider ID whose OAuth app refreshes XOAUTH2 tokens (google, microsoft)
+	Mailbox       string         `gorm:"column:mailbox"`
+	CreatedAt     time.Time      `gorm:"column:created_at"`
+	UpdatedAt     time.Time      `gorm:"column:updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (IMAPSettings) TableName() string {
+	return "imap_settings"
+}
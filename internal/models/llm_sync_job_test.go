@@ -15,6 +15,7 @@ func TestLLMSyncJobStatus_Constants(t *testing.T) {
 		{"processing", LLMStatusProcessing, "processing"},
 		{"completed", LLMStatusCompleted, "completed"},
 		{"failed", LLMStatusFailed, "failed"},
+		{"dead", LLMStatusDead, "dead"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Merchant is a canonical merchant, the payments of "NETFLIX.COM", "Netflix" and "Netflix India" all refer to one
+type Merchant struct {
+	ID              string           `gorm:"column:id;primaryKey"`
+	Name            string           `gorm:"column:name"`
+	DefaultCategory *string          `gorm:"column:default_category"` // Category of its payments when the LLM gives none
+	Aliases         []MerchantAlias  `gorm:"foreignKey:MerchantID"`
+	Domains         []MerchantDomain `gorm:"foreignKey:MerchantID"`
+	CreatedAt       time.Time        `gorm:"column:created_at"`
+	UpdatedAt       time.Time        `gorm:"column:updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (Merchant) TableName() string {
+	return "merchant"
+}
+
+// MerchantAlias is a normalised merchant name (see merchant.Key) that refers to a canonical merchant
+type MerchantAlias struct {
+	Alias      string    `gorm:"column:alias;primaryKey"`
+	MerchantID string    `gorm:"column:merchant_id;index"`
+	CreatedAt  time.Time `gorm:"column:created_at"`
+}
+
+// TableName specifies the table name for GORM
+func (MerchantAlias) TableName() string {
+	return "merchant_alias"
+}
+
+// MerchantDomain is a sender domain of a canonical merchant's emails, e.g. netflix.com
+type MerchantDomain struct {
+	Domain     string    `gorm:"column:domain;primaryKey"`
+	MerchantID string    `gorm:"column:merchant_id;index"`
+	CreatedAt  time.Time `gorm:"column:created_at"`
+}
+
+// TableName specifies the table name for GORM
+func (MerchantDomain) TableName() string {
+	return "merchant_domain"
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AmountDecimals is the number of decimals amounts are stored with, enough for every currency in currencyExponents
+const AmountDecimals = 3
+
+const (
+	// amountScale is the number of Amount units in 1
+	amountScale = 1000
+	// maxAmountUnits is the largest amount a NUMERIC(15, 3) column holds, 999999999999.999
+	maxAmountUnits = 999_999_999_999_999
+)
+
+// amountText matches the amounts Amount parses: plain, grouped by thousands ("1,234,567.89") or the Indian way
+// ("12,34,567.89"), and JSON numbers with an exponent. "12,34" is rejected, the comma may be a decimal separator
+var amountText = regexp.MustCompile(`^[+-]?(\d+|\d{1,3}(,\d{3})+|\d{1,2}(,\d{2})+,\d{3})(\.\d+)?([eE][+-]?\d+)?$`)
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit is not a hundredth (CLF and UYW, units of
+// account with 4 decimals, are left out)
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0,
+	"UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// CurrencyExponent returns the number of decimals of a currency's minor unit: 0 for JPY, 3 for KWD and 2 for all
+// other currencies
+func CurrencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Amount is an exact decimal amount of money with up to AmountDecimals decimals, stored in NUMERIC(15, 3) columns
+// Amounts never go through floating point, so 0.1 + 0.2 is 0.3; the zero value is 0
+type Amount struct {
+	units int64 // Thousandths
+}
+
+// ParseAmount parses an amount written as a number, with or without thousands separators ("1,23,456.78")
+func ParseAmount(text string) (Amount, error) {
+	text = strings.TrimSpace(text)
+	if !amountText.MatchString(text) {
+		return Amount{}, fmt.Errorf("invalid amount %q", text)
+	}
+
+	value, ok := new(big.Rat).SetString(strings.ReplaceAll(text, ",", ""))
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount %q", text)
+	}
+	value.Mul(value, big.NewRat(amountScale, 1))
+	if !value.IsInt() {
+		return Amount{}, fmt.Errorf("amount %q has more than %d decimals", text, AmountDecimals)
+	}
+	units := value.Num()
+	if units.CmpAbs(big.NewInt(maxAmountUnits)) > 0 {
+		return Amount{}, fmt.Errorf("amount %q is too large", text)
+	}
+	return Amount{units: units.Int64()}, nil
+}
+
+// MustParseAmount is ParseAmount for amounts known to be valid, it panics otherwise
+func MustParseAmount(text string) Amount {
+	amount, err := ParseAmount(text)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// AmountFromFloat converts a float to the nearest amount, for numbers decoded from JSON metadata
+func AmountFromFloat(value float64) Amount {
+	return Amount{units: int64(math.Round(value * amountScale))}
+}
+
+// Float64 returns the amount as a float, for ratios and tolerances only
+func (a Amount) Float64() float64 {
+	return float64(a.units) / amountScale
+}
+
+// Sign returns -1, 0 or 1 as the amount is negative, zero or positive
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// Cmp returns -1, 0 or 1 as a is less than, equal to or greater than b
+func (a Amount) Cmp(b Amount) int {
+	return a.Sub(b).Sign()
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: a.units + b.units}
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: a.units - b.units}
+}
+
+// Div returns a / n rounded half away from zero to AmountDecimals decimals
+func (a Amount) Div(n int64) Amount {
+	return Amount{units: roundDiv(a.units, n)}
+}
+
+// Round returns the amount rounded half away from zero to decimals (at most AmountDecimals)
+func (a Amount) Round(decimals int) Amount {
+	step := pow10(AmountDecimals - decimals)
+	return Amount{units: roundDiv(a.units, step) * step}
+}
+
+// Decimals returns the number of decimals needed to write the amount exactly, 0 to AmountDecimals
+func (a Amount) Decimals() int {
+	decimals := AmountDecimals
+	for units := a.units; decimals > 0 && units%10 == 0; units /= 10 {
+		decimals--
+	}
+	return decimals
+}
+
+// String writes the amount with two decimals, or three when it has them ("15.49", "1500.00", "1.234")
+func (a Amount) String() string {
+	return a.format(max(a.Decimals(), 2))
+}
+
+// format writes the amount with a number of decimals, rounding it when it has more
+func (a Amount) format(decimals int) string {
+	units := a.Round(decimals).units
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	whole := strconv.FormatInt(units/amountScale, 10)
+	if decimals == 0 {
+		return sign + whole
+	}
+	fraction := fmt.Sprintf("%0*d", AmountDecimals, units%amountScale)
+	return sign + whole + "." + fraction[:decimals]
+}
+
+// Value implements driver.Valuer for Amount, NUMERIC columns take the exact decimal text
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan implements sql.Scanner for Amount
+func (a *Amount) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		*a = Amount{}
+	case []byte:
+		return a.Scan(string(value))
+	case string:
+		amount, err := ParseAmount(value)
+		if err != nil {
+			return err
+		}
+		*a = amount
+	case float64:
+		*a = AmountFromFloat(value)
+	case int64:
+		*a = Amount{units: value * amountScale}
+	default:
+		return fmt.Errorf("cannot scan %T into an amount", value)
+	}
+	return nil
+}
+
+// MarshalJSON writes the amount as a JSON number
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads an amount from a JSON number or a string such as "1,23,456.78"
+// Invalid amounts are reported as a *json.UnmarshalTypeError of type Amount
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+
+	kind := "number"
+	switch text[0] {
+	case '"':
+		kind = "string"
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	case '{':
+		kind = "object"
+	case '[':
+		kind = "array"
+	case 't', 'f':
+		kind = "bool"
+	}
+
+	amount, err := ParseAmount(text)
+	if err != nil || kind == "object" || kind == "array" || kind == "bool" {
+		return &json.UnmarshalTypeError{Value: kind + " " + string(data), Type: reflect.TypeOf(Amount{})}
+	}
+	*a = amount
+	return nil
+}
+
+// Money is an amount in a currency
+type Money struct {
+	Amount   Amount
+	Currency string // ISO 4217 code
+}
+
+// Validate checks that the amount fits the currency's minor unit: 1500.5 JPY or 9.999 USD are no amounts
+func (m Money) Validate() error {
+	if exponent := CurrencyExponent(m.Currency); m.Amount.Decimals() > exponent {
+		return fmt.Errorf("%s %s has more than the %d decimals of the currency", m.Amount, m.Currency, exponent)
+	}
+	return nil
+}
+
+// MinorUnits returns the amount in the currency's minor unit (cents, fils, yen), rounding extra decimals
+func (m Money) MinorUnits() int64 {
+	return m.Amount.Round(CurrencyExponent(m.Currency)).units / pow10(AmountDecimals-CurrencyExponent(m.Currency))
+}
+
+// String writes the amount with the currency's decimals, e.g. "15.49 USD", "1500 JPY" and "1.250 KWD"
+func (m Money) String() string {
+	return m.Amount.format(CurrencyExponent(m.Currency)) + " " + m.Currency
+}
+
+// roundDiv returns a / b rounded half away from zero
+func roundDiv(a, b int64) int64 {
+	quotient, remainder := a/b, a%b
+	if remainder < 0 {
+		remainder = -remainder
+	}
+	if 2*remainder >= b {
+		if a < 0 {
+			return quotient - 1
+		}
+		return quotient + 1
+	}
+	return quotient
+}
+
+// pow10 returns 10^n for n >= 0
+func pow10(n int) int64 {
+	result := int64(1)
+	for ; n > 0; n-- {
+		result *= 10
+	}
+	return result
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string // String() of the amount, empty for an error
+		wantErr bool
+	}{
+		{"499", "499.00", false},
+		{"15.49", "15.49", false},
+		{"1.234", "1.234", false},
+		{"1,234,567.89", "1234567.89", false},
+		{"1,23,456.78", "123456.78", false},
+		{" 2500 ", "2500.00", false},
+		{"1.5e3", "1500.00", false},
+		{"-10", "-10.00", false},
+		{"0.1", "0.10", false},
+		{"12,34", "", true},
+		{"1,2345", "", true},
+		{"9.9999", "", true},
+		{"₹499", "", true},
+		{"", "", true},
+		{"1000000000000", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			amount, err := ParseAmount(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAmount(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err == nil && amount.String() != tt.want {
+				t.Errorf("ParseAmount(%q) = %s, want %s", tt.input, amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmount_Arithmetic(t *testing.T) {
+	if sum := MustParseAmount("0.1").Add(MustParseAmount("0.2")); sum != MustParseAmount("0.3") {
+		t.Errorf("0.1 + 0.2 = %s, want 0.30", sum)
+	}
+	if half := MustParseAmount("22.55").Div(2); half != MustParseAmount("11.275") {
+		t.Errorf("22.55 / 2 = %s, want 11.275", half)
+	}
+	if rounded := MustParseAmount("11.275").Round(2); rounded != MustParseAmount("11.28") {
+		t.Errorf("11.275 rounded to cents = %s, want 11.28", rounded)
+	}
+	if rounded := MustParseAmount("-0.5").Round(0); rounded != MustParseAmount("-1") {
+		t.Errorf("-0.5 rounded = %s, want -1", rounded)
+	}
+	if MustParseAmount("9.99").Cmp(MustParseAmount("10")) >= 0 {
+		t.Error("expected 9.99 to be less than 10")
+	}
+}
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		amount, currency string
+		wantErr          bool
+		wantString       string
+		wantMinor        int64
+	}{
+		{"15.49", "USD", false, "15.49 USD", 1549},
+		{"1500", "JPY", false, "1500 JPY", 1500},
+		{"1.250", "KWD", false, "1.250 KWD", 1250},
+		{"1,23,456.78", "INR", false, "123456.78 INR", 12345678},
+		{"1500.5", "JPY", true, "1501 JPY", 1501},
+		{"9.999", "USD", true, "10.00 USD", 1000},
+		{"9.999", "BHD", false, "9.999 BHD", 9999},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			money := Money{Amount: MustParseAmount(tt.amount), Currency: tt.currency}
+			if err := money.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := money.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+			if got := money.MinorUnits(); got != tt.wantMinor {
+				t.Errorf("MinorUnits() = %d, want %d", got, tt.wantMinor)
+			}
+		})
+	}
+}
+
+func TestAmount_JSON(t *testing.T) {
+	var payment struct {
+		Amount *Amount `json:"amount"`
+	}
+	for _, input := range []string{`{"amount": 123456.78}`, `{"amount": "1,23,456.78"}`} {
+		if err := json.Unmarshal([]byte(input), &payment); err != nil || payment.Amount.String() != "123456.78" {
+			t.Errorf("Unmarshal(%s) = %v, %v", input, payment.Amount, err)
+		}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	for _, input := range []string{`{"amount": "9,99"}`, `{"amount": true}`, `{"amount": 1.0001}`} {
+		if err := json.Unmarshal([]byte(input), &payment); !errors.As(err, &typeErr) {
+			t.Errorf("Unmarshal(%s) error = %v, want *json.UnmarshalTypeError", input, err)
+		}
+	}
+
+	data, err := json.Marshal(MustParseAmount("1.5"))
+	if err != nil || string(data) != "1.50" {
+		t.Errorf("Marshal() = %s, %v, want 1.50", data, err)
+	}
+}
+
+func TestAmount_Scan(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{[]byte("1234.567"), "1234.567"},
+		{"15.490", "15.49"},
+		{15.49, "15.49"},
+		{int64(42), "42.00"},
+		{nil, "0.00"},
+	}
+
+	for _, tt := range tests {
+		var amount Amount
+		if err := amount.Scan(tt.value); err != nil || amount.String() != tt.want {
+			t.Errorf("Scan(%v) = %s, %v, want %s", tt.value, amount, err, tt.want)
+		}
+		if value, err := amount.Value(); err != nil || value != tt.want {
+			t.Errorf("Value() = %v, %v, want %s", value, err, tt.want)
+		}
+	}
+}
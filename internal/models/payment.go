@@ -35,6 +35,18 @@ const (
 	RecurrenceAnnual     = "annual"
 )
 
+// Payment category constants
+const (
+	CategorySubscription   = "subscription"
+	CategoryUtility        = "utility"
+	CategoryEMI            = "emi"
+	CategoryCreditCardBill = "credit_card_bill"
+	CategoryLoan           = "loan"
+	CategoryInsurance      = "insurance"
+	CategoryRent           = "rent"
+	CategoryMisc           = "misc"
+)
+
 // JSONB type for GORM to handle PostgreSQL JSONB columns
 type JSONB map[string]interface{}
 
@@ -61,24 +73,39 @@ func (j *JSONB) Scan(value interface{}) error {
 
 // Payment represents a payment extracted from an email
 type Payment struct {
-	ID                string    `gorm:"column:id;primaryKey"`
-	AccountID         string    `gorm:"column:account_id;index"`
-	Merchant          string    `gorm:"column:merchant;index"`
-	Description       *string   `gorm:"column:description"`
-	Amount            float64   `gorm:"column:amount"`
-	Currency          string    `gorm:"column:currency"`
-	Date              time.Time `gorm:"column:date;index"`
-	Recurrence        *string   `gorm:"column:recurrence"`
-	Status            string    `gorm:"column:status;index"`
-	Category          *string   `gorm:"column:category"`
-	ExternalReference *string   `gorm:"column:external_reference"`
-	Metadata          JSONB     `gorm:"column:metadata;type:jsonb"`
-	RawLlmResponse    JSONB     `gorm:"column:raw_llm_response;type:jsonb"`
-	CreatedAt         time.Time `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt         time.Time `gorm:"column:updated_at;autoUpdateTime"`
+	ID                 string     `gorm:"column:id;primaryKey"`
+	AccountID          string     `gorm:"column:account_id;index"`
+	Merchant           string     `gorm:"column:merchant;index"`
+	MerchantKey        string     `gorm:"column:merchant_key"`      // Normalised merchant for deduplication
+	MerchantID         *string    `gorm:"column:merchant_id;index"` // Canonical merchant, see Merchant
+	Description        *string    `gorm:"column:description"`
+	Amount             Amount     `gorm:"column:amount"`
+	Currency           string     `gorm:"column:currency"`
+	Date               time.Time  `gorm:"column:date;index"`
+	Recurrence         *string    `gorm:"column:recurrence"`
+	Status             string     `gorm:"column:status;index"`
+	Category           *string    `gorm:"column:category"`
+	ExternalReference  *string    `gorm:"column:external_reference"`
+	SourceMessageID    *string    `gorm:"column:source_message_id;index"` // Message the payment was extracted from, shared by the payments of one email
+	SourceThreadID     *string    `gorm:"column:source_thread_id;index"`
+	EmailReceivedAt    *time.Time `gorm:"column:email_received_at"`
+	SenderDomain       *string    `gorm:"column:sender_domain"`              // Domain of the email's From address
+	LLMSyncJobID       *string    `gorm:"column:llm_sync_job_id;index"`      // Nil for imported emails
+	Fingerprint        *string    `gorm:"column:fingerprint;uniqueIndex"`    // See dedup.Fingerprint, nil for payments stored before deduplication
+	Projected          bool       `gorm:"column:projected"`                  // Next occurrence of a recurring payment, not from an email yet
+	RecurringPaymentID *string    `gorm:"column:recurring_payment_id;index"` // Series the payment belongs to, see RecurringPayment
+	Metadata           JSONB      `gorm:"column:metadata;type:jsonb"`
+	RawLlmResponse     JSONB      `gorm:"column:raw_llm_response;type:jsonb"`
+	CreatedAt          time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt          time.Time  `gorm:"column:updated_at;autoUpdateTime"`
 }
 
 // TableName specifies the table name for GORM
 func (Payment) TableName() string {
 	return "payment"
 }
+
+// Money returns the amount of a payment in its currency
+func (p Payment) Money() Money {
+	return Money{Amount: p.Amount, Currency: p.Currency}
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Payment link status constants
+const (
+	PaymentLinkSuggested = "suggested" // Found by reconciliation with low confidence, waits for manual review
+	PaymentLinkLinked    = "linked"    // The settlement pays the obligation, whose status was updated
+	PaymentLinkRejected  = "rejected"  // Rejected by hand, the pair is never linked automatically again
+)
+
+// Payment link source constants
+const (
+	PaymentLinkSourceAuto   = "auto"
+	PaymentLinkSourceManual = "manual"
+)
+
+// PaymentLink links an obligation (a bill or due payment) to the paid payment that settles it
+type PaymentLink struct {
+	ID             string    `gorm:"column:id;primaryKey"`
+	AccountID      string    `gorm:"column:account_id;index"`
+	ObligationID   string    `gorm:"column:obligation_id"`
+	SettlementID   string    `gorm:"column:settlement_id;index"`
+	Settles        string    `gorm:"column:settles"`    // Status the link moves the obligation to: paid or partially_paid
+	Confidence     float64   `gorm:"column:confidence"` // 0 to 1, 1 for manual links
+	Reasons        *string   `gorm:"column:reasons"`    // What matched, e.g. "merchant, total amount, card_last_four"
+	Status         string    `gorm:"column:status"`
+	Source         string    `gorm:"column:source"`
+	PreviousStatus *string   `gorm:"column:previous_status"` // Obligation status before the link, restored when it is rejected
+	CreatedAt      time.Time `gorm:"column:created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (PaymentLink) TableName() string {
+	return "payment_link"
+}
@@ -0,0 +1,108 @@
+package models
+
+import "time"
+
+// PaymentDueWindow is how long before its date an unpaid payment is due rather than upcoming
+// Same 24-hour rule the extraction prompt gives the LLM
+const PaymentDueWindow = 24 * time.Hour
+
+// Payment status change causes recorded in payment_status_history
+const (
+	PaymentStatusCauseExtracted  = "extracted"  // First extracted from an email
+	PaymentStatusCauseMerged     = "merged"     // A later email about the same payment (e.g. the receipt of a reminder)
+	PaymentStatusCauseAged       = "aged"       // Its date came within PaymentDueWindow or passed
+	PaymentStatusCauseReconciled = "reconciled" // Linked to the payment that settles it
+	PaymentStatusCauseManual     = "manual"     // Changed by hand (a confirmed, rejected or manual payment link)
+	PaymentStatusCauseProjected  = "projected"  // Projected as the next occurrence of a recurring payment
+)
+
+// PaymentStatusTransitions lists the statuses each payment status may move to
+// Refunded, cancelled and written off are terminal
+var PaymentStatusTransitions = map[string][]string{
+	PaymentStatusDraft: {
+		PaymentStatusScheduled, PaymentStatusUpcoming, PaymentStatusDue, PaymentStatusOverdue,
+		PaymentStatusProcessing, PaymentStatusPaid, PaymentStatusCancelled,
+	},
+	PaymentStatusScheduled: {
+		PaymentStatusUpcoming, PaymentStatusDue, PaymentStatusOverdue, PaymentStatusProcessing,
+		PaymentStatusPaid, PaymentStatusFailed, PaymentStatusCancelled,
+	},
+	PaymentStatusUpcoming: {
+		PaymentStatusScheduled, PaymentStatusDue, PaymentStatusOverdue, PaymentStatusProcessing,
+		PaymentStatusPartiallyPaid, PaymentStatusPaid, PaymentStatusFailed, PaymentStatusCancelled,
+	},
+	PaymentStatusDue: {
+		PaymentStatusScheduled, PaymentStatusOverdue, PaymentStatusProcessing, PaymentStatusPartiallyPaid,
+		PaymentStatusPaid, PaymentStatusFailed, PaymentStatusCancelled, PaymentStatusWrittenOff,
+	},
+	PaymentStatusOverdue: {
+		PaymentStatusScheduled, PaymentStatusProcessing, PaymentStatusPartiallyPaid, PaymentStatusPaid,
+		PaymentStatusFailed, PaymentStatusCancelled, PaymentStatusWrittenOff,
+	},
+	PaymentStatusProcessing: {
+		PaymentStatusPartiallyPaid, PaymentStatusPaid, PaymentStatusFailed, PaymentStatusCancelled,
+	},
+	PaymentStatusFailed: {
+		PaymentStatusScheduled, PaymentStatusDue, PaymentStatusOverdue, PaymentStatusProcessing,
+		PaymentStatusPartiallyPaid, PaymentStatusPaid, PaymentStatusCancelled, PaymentStatusWrittenOff,
+	},
+	PaymentStatusPartiallyPaid: {
+		PaymentStatusOverdue, PaymentStatusProcessing, PaymentStatusPaid, PaymentStatusFailed,
+		PaymentStatusRefunded, PaymentStatusWrittenOff,
+	},
+	PaymentStatusPaid: {
+		PaymentStatusRefunded,
+	},
+	PaymentStatusRefunded:   {},
+	PaymentStatusCancelled:  {},
+	PaymentStatusWrittenOff: {},
+}
+
+// CanTransitionPaymentStatus reports whether a payment may move from status from to status to
+func CanTransitionPaymentStatus(from, to string) bool {
+	for _, status := range PaymentStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentStatusForDate returns the status of an unpaid payment dated date at now:
+// upcoming more than PaymentDueWindow ahead, due within it, overdue once the date has passed
+func PaymentStatusForDate(date, now time.Time) string {
+	switch {
+	case date.Before(now):
+		return PaymentStatusOverdue
+	case date.Sub(now) <= PaymentDueWindow:
+		return PaymentStatusDue
+	default:
+		return PaymentStatusUpcoming
+	}
+}
+
+// AgedPaymentStatus returns the status a scheduled, upcoming or due payment moves to at now, and whether it moves
+// Aging only moves a payment towards due and overdue, a scheduled payment stays scheduled until its date is near
+func AgedPaymentStatus(status string, date, now time.Time) (string, bool) {
+	next := PaymentStatusForDate(date, now)
+	if next == PaymentStatusUpcoming || next == status || !CanTransitionPaymentStatus(status, next) {
+		return status, false
+	}
+	return next, true
+}
+
+// PaymentStatusHistory records one status change of a payment and its cause
+type PaymentStatusHistory struct {
+	ID              string    `gorm:"column:id;primaryKey"`
+	PaymentID       string    `gorm:"column:payment_id;index"`
+	FromStatus      *string   `gorm:"column:from_status"` // Nil when the payment was created
+	ToStatus        string    `gorm:"column:to_status"`
+	Cause           string    `gorm:"column:cause"`
+	SourceMessageID *string   `gorm:"column:source_message_id"` // Email that caused an extracted or merged change
+	CreatedAt       time.Time `gorm:"column:created_at"`
+}
+
+// TableName specifies the table name for GORM
+func (PaymentStatusHistory) TableName() string {
+	return "payment_status_history"
+}
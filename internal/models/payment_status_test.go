@@ -0,0 +1,88 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanTransitionPaymentStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{PaymentStatusUpcoming, PaymentStatusDue, true},
+		{PaymentStatusDue, PaymentStatusOverdue, true},
+		{PaymentStatusDue, PaymentStatusPaid, true},
+		{PaymentStatusOverdue, PaymentStatusPaid, true},
+		{PaymentStatusFailed, PaymentStatusProcessing, true},
+		{PaymentStatusPaid, PaymentStatusRefunded, true},
+		{PaymentStatusDue, PaymentStatusUpcoming, false},
+		{PaymentStatusOverdue, PaymentStatusDue, false},
+		{PaymentStatusPaid, PaymentStatusDue, false},
+		{PaymentStatusPaid, PaymentStatusFailed, false},
+		{PaymentStatusCancelled, PaymentStatusPaid, false},
+		{PaymentStatusDue, PaymentStatusDue, false},
+		{"unknown", PaymentStatusDue, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"→"+tt.to, func(t *testing.T) {
+			if got := CanTransitionPaymentStatus(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransitionPaymentStatus(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentStatusTransitions_CoverEveryStatus(t *testing.T) {
+	statuses := []string{
+		PaymentStatusDraft, PaymentStatusScheduled, PaymentStatusUpcoming, PaymentStatusDue, PaymentStatusOverdue,
+		PaymentStatusProcessing, PaymentStatusPartiallyPaid, PaymentStatusPaid, PaymentStatusFailed,
+		PaymentStatusRefunded, PaymentStatusCancelled, PaymentStatusWrittenOff,
+	}
+	known := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		known[status] = true
+		if _, ok := PaymentStatusTransitions[status]; !ok {
+			t.Errorf("PaymentStatusTransitions has no entry for %s", status)
+		}
+	}
+	for from, targets := range PaymentStatusTransitions {
+		for _, to := range targets {
+			if !known[to] || to == from {
+				t.Errorf("PaymentStatusTransitions[%s] contains %q", from, to)
+			}
+		}
+	}
+}
+
+func TestAgedPaymentStatus(t *testing.T) {
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		status   string
+		date     time.Time
+		want     string
+		wantMove bool
+	}{
+		{"upcoming in a week", PaymentStatusUpcoming, now.AddDate(0, 0, 7), PaymentStatusUpcoming, false},
+		{"upcoming tomorrow", PaymentStatusUpcoming, now.Add(20 * time.Hour), PaymentStatusDue, true},
+		{"upcoming exactly 24 hours away", PaymentStatusUpcoming, now.Add(PaymentDueWindow), PaymentStatusDue, true},
+		{"upcoming already passed", PaymentStatusUpcoming, now.Add(-time.Hour), PaymentStatusOverdue, true},
+		{"due within the window", PaymentStatusDue, now.Add(time.Hour), PaymentStatusDue, false},
+		{"due passed", PaymentStatusDue, now.Add(-time.Minute), PaymentStatusOverdue, true},
+		{"scheduled in a week", PaymentStatusScheduled, now.AddDate(0, 0, 7), PaymentStatusScheduled, false},
+		{"scheduled today", PaymentStatusScheduled, now.Add(time.Hour), PaymentStatusDue, true},
+		{"paid long ago", PaymentStatusPaid, now.AddDate(0, -1, 0), PaymentStatusPaid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, moved := AgedPaymentStatus(tt.status, tt.date, now)
+			if got != tt.want || moved != tt.wantMove {
+				t.Errorf("AgedPaymentStatus(%s) = %s, %v, want %s, %v", tt.status, got, moved, tt.want, tt.wantMove)
+			}
+		})
+	}
+}
@@ -71,7 +71,7 @@ func TestPayment_Structure(t *testing.T) {
 		AccountID:         "account-456",
 		Merchant:          "Netflix",
 		Description:       &description,
-		Amount:            19.99,
+		Amount:            MustParseAmount("19.99"),
 		Currency:          "USD",
 		Date:              now,
 		Recurrence:        &recurrence,
@@ -90,8 +90,8 @@ func TestPayment_Structure(t *testing.T) {
 	if payment.Merchant != "Netflix" {
 		t.Errorf("Expected Merchant 'Netflix', got %s", payment.Merchant)
 	}
-	if payment.Amount != 19.99 {
-		t.Errorf("Expected Amount 19.99, got %f", payment.Amount)
+	if payment.Amount != MustParseAmount("19.99") {
+		t.Errorf("Expected Amount 19.99, got %s", payment.Amount)
 	}
 	if payment.Status != PaymentStatusUpcoming {
 		t.Errorf("Expected Status 'upcoming', got %s", payment.Status)
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// RecurringPayment is a series of payments to one merchant detected from an account's payment history
+// One per account, canonical merchant (or merchant key for payments without one) and currency
+type RecurringPayment struct {
+	ID             string     `gorm:"column:id;primaryKey"`
+	AccountID      string     `gorm:"column:account_id;index"`
+	MerchantKey    string     `gorm:"column:merchant_key"`
+	MerchantID     *string    `gorm:"column:merchant_id"` // Canonical merchant the series is detected for, nil to group by merchant key
+	Merchant       string     `gorm:"column:merchant"`    // As written on the latest payment
+	Currency       string     `gorm:"column:currency"`
+	Recurrence     string     `gorm:"column:recurrence"` // One of the Recurrence* constants
+	TypicalAmount  Amount     `gorm:"column:typical_amount"`
+	LastAmount     Amount     `gorm:"column:last_amount"`
+	FixedAmount    bool       `gorm:"column:fixed_amount"`    // Same amount every time (since the last price change)
+	PreviousAmount *Amount    `gorm:"column:previous_amount"` // Amount before the last price change
+	PriceChangedAt *time.Time `gorm:"column:price_changed_at"`
+	DayOfMonth     *int       `gorm:"column:day_of_month"` // Nil for daily, weekly and biweekly series
+	Occurrences    int        `gorm:"column:occurrences"`
+	Confidence     float64    `gorm:"column:confidence"` // Share of regular intervals, 0 to 1
+	LastDate       time.Time  `gorm:"column:last_date"`
+	NextDate       *time.Time `gorm:"column:next_date"`
+	Active         bool       `gorm:"column:active"` // False once occurrences stopped (e.g. a cancelled subscription)
+	CreatedAt      time.Time  `gorm:"column:created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at"`
+}
+
+// TableName specifies the table name for GORM
+func (RecurringPayment) TableName() string {
+	return "recurring_payment"
+}
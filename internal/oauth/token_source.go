@@ -0,0 +1,104 @@
+// Package oauth keeps the OAuth2 tokens of mail accounts fresh, persisting refreshed tokens to the account
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// persistTimeout bounds saving a refreshed token (Token has no context of its own)
+const persistTimeout = 10 * time.Second
+
+// TokenStore loads and persists the OAuth tokens of an account
+type TokenStore interface {
+	GetByID(ctx context.Context, accountID string) (*models.Account, error)
+	UpdateTokens(ctx context.Context, accountID string, accessToken string, refreshToken string, accessTokenExpiresAt time.Time) error
+}
+
+// AccountToken returns the account's stored tokens
+// Returns service.ErrTokenRevoked when the account has no access or refresh token
+func AccountToken(account *models.Account) (*oauth2.Token, error) {
+	if account.AccessToken == nil || account.RefreshToken == nil {
+		return nil, fmt.Errorf("%w: account missing tokens", service.ErrTokenRevoked)
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  *account.AccessToken,
+		RefreshToken: *account.RefreshToken,
+		TokenType:    "Bearer",
+		Expiry:       time.Now(), // Assume expired if no expiry time
+	}
+	if account.AccessTokenExpiresAt != nil {
+		token.Expiry = *account.AccessTokenExpiresAt
+	}
+	return token, nil
+}
+
+// PersistingTokenSource refreshes the account's access token when it expires and saves new tokens to the database
+// Token calls are serialized, so concurrent jobs for the same account share one refresh
+type PersistingTokenSource struct {
+	accountID      string
+	store          TokenStore
+	base           oauth2.TokenSource // refreshes via the OAuth2 token endpoint when expired
+	onRefreshError func()             // called when refreshing fails (e.g. revoked refresh token)
+
+	mu      sync.Mutex
+	current *oauth2.Token
+}
+
+// NewPersistingTokenSource returns a token source for the account starting from its stored token
+// onRefreshError is optional, callers use it to drop clients built on the token source
+func NewPersistingTokenSource(accountID string, token *oauth2.Token, config *oauth2.Config, store TokenStore, onRefreshError func()) *PersistingTokenSource {
+	return &PersistingTokenSource{
+		accountID:      accountID,
+		store:          store,
+		base:           config.TokenSource(context.Background(), token),
+		onRefreshError: onRefreshError,
+		current:        token,
+	}
+}
+
+// Token returns a valid access token, refreshing and persisting it if needed
+func (s *PersistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.base.Token()
+	if err != nil {
+		if s.onRefreshError != nil {
+			s.onRefreshError()
+		}
+		return nil, err
+	}
+
+	if token.AccessToken == s.current.AccessToken {
+		return token, nil
+	}
+
+	// Keep the same refresh token unless it was rotated
+	refreshToken := token.RefreshToken
+	if refreshToken == "" {
+		refreshToken = s.current.RefreshToken
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	if err := s.store.UpdateTokens(ctx, s.accountID, token.AccessToken, refreshToken, token.Expiry); err != nil {
+		// The new token still works in memory, it is persisted with the next refresh
+		log.Printf("Warning: failed to persist refreshed token for account %s: %v", s.accountID, err)
+	} else {
+		log.Printf("Token refreshed for account %s, expires at %s", s.accountID, token.Expiry)
+	}
+
+	s.current = token
+	return token, nil
+}
@@ -0,0 +1,233 @@
+// Package reconcile links obligations (bills, dues) to the later payments that settle them: a due credit card bill
+// and its "payment received" email, which are extracted as two payments
+package reconcile
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/dedup"
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+const (
+	// DateWindow is how far apart an obligation and its settlement can be dated, about a billing cycle either way
+	// (a bill paid early or late)
+	DateWindow = 45 * 24 * time.Hour
+	// AmountTolerance is the relative difference between amounts still considered equal (rounding, small fees)
+	AmountTolerance = 0.01
+
+	// AutoLinkConfidence is the confidence from which a match is linked and the obligation settled
+	AutoLinkConfidence = 0.65
+	// SuggestConfidence is the confidence from which a match is kept as a suggestion for manual review
+	SuggestConfidence = 0.5
+)
+
+// Confidence weights, a total amount match dated the same day scores 0.7, a shared reference adds 0.3
+const (
+	weightTotalAmount   = 0.5
+	weightMinimumAmount = 0.4
+	weightDate          = 0.2
+	weightReference     = 0.3
+)
+
+// OpenStatuses are the statuses of obligations still waiting for a payment
+var OpenStatuses = []string{
+	models.PaymentStatusScheduled, models.PaymentStatusUpcoming, models.PaymentStatusDue,
+	models.PaymentStatusOverdue, models.PaymentStatusPartiallyPaid, models.PaymentStatusFailed,
+}
+
+// referenceKeys are the metadata fields both an obligation and its settlement may carry
+// Different values mean different bills (another card, another invoice)
+var referenceKeys = []string{"card_last_four", "invoice_number", "bill_number", "order_id", "subscription_id"}
+
+// Match is a scored pairing of an obligation and a payment settling it
+type Match struct {
+	Obligation models.Payment
+	Settlement models.Payment
+	Settles    string   // Status the obligation moves to: paid, or partially_paid for the minimum due
+	Confidence float64  // 0 to 1
+	Reasons    []string // What matched, e.g. "merchant", "total amount", "card_last_four"
+}
+
+// IsOpen reports whether a payment is an obligation still waiting for a payment
+// Projected occurrences of recurring payments are not bills yet
+func IsOpen(payment models.Payment) bool {
+	if payment.Projected {
+		return false
+	}
+	for _, status := range OpenStatuses {
+		if payment.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Score matches a paid payment against an open obligation of the same account, merchant and currency
+// dated within DateWindow. The amount must equal the obligation's amount (settling it) or its minimum_due
+// metadata (partially settling it); conflicting references rule the match out
+func Score(obligation, payment models.Payment) (Match, bool) {
+	if obligation.ID == payment.ID || payment.Status != models.PaymentStatusPaid || !IsOpen(obligation) ||
+		obligation.AccountID != payment.AccountID ||
+		!strings.EqualFold(obligation.Currency, payment.Currency) ||
+		!sameMerchant(obligation, payment) {
+		return Match{}, false
+	}
+
+	distance := math.Abs(float64(obligation.Date.Sub(payment.Date)))
+	if distance > float64(DateWindow) {
+		return Match{}, false
+	}
+
+	match := Match{Obligation: obligation, Settlement: payment, Reasons: []string{"merchant"}}
+
+	switch minimumDue, ok := amountField(obligation.Metadata, "minimum_due"); {
+	case sameAmount(obligation.Amount, payment.Amount):
+		match.Settles = models.PaymentStatusPaid
+		match.Confidence = weightTotalAmount
+		match.Reasons = append(match.Reasons, "total amount")
+	case ok && sameAmount(minimumDue, payment.Amount):
+		match.Settles = models.PaymentStatusPartiallyPaid
+		match.Confidence = weightMinimumAmount
+		match.Reasons = append(match.Reasons, "minimum due")
+	default:
+		return Match{}, false
+	}
+
+	match.Confidence += weightDate * (1 - distance/float64(DateWindow))
+
+	shared := false
+	for _, key := range referenceKeys {
+		a, b := reference(obligation.Metadata, key), reference(payment.Metadata, key)
+		if a == "" || b == "" {
+			continue
+		}
+		if !strings.EqualFold(a, b) {
+			return Match{}, false
+		}
+		shared = true
+		match.Reasons = append(match.Reasons, key)
+	}
+	if shared {
+		match.Confidence += weightReference
+	}
+
+	match.Confidence = math.Min(1, math.Round(match.Confidence*100)/100)
+	return match, true
+}
+
+// Best returns the highest scoring match of payment against the candidate obligations
+func Best(payment models.Payment, obligations []models.Payment) (Match, bool) {
+	var best Match
+	found := false
+	for _, obligation := range obligations {
+		if match, ok := Score(obligation, payment); ok && (!found || better(match, best)) {
+			best, found = match, true
+		}
+	}
+	return best, found
+}
+
+// BestSettlement returns the highest scoring match of an obligation against candidate paid payments
+// Used when the payment was extracted before its bill (emails are not processed in date order)
+func BestSettlement(obligation models.Payment, payments []models.Payment) (Match, bool) {
+	var best Match
+	found := false
+	for _, payment := range payments {
+		if match, ok := Score(obligation, payment); ok && (!found || better(match, best)) {
+			best, found = match, true
+		}
+	}
+	return best, found
+}
+
+// Settles returns the status an obligation moves to when linked to payment by hand:
+// paid unless the payment is short of the amount due
+func Settles(obligation, payment models.Payment) string {
+	if payment.Amount.Cmp(obligation.Amount) < 0 && !sameAmount(obligation.Amount, payment.Amount) {
+		return models.PaymentStatusPartiallyPaid
+	}
+	return models.PaymentStatusPaid
+}
+
+// StatementDues returns the other open dues of the credit card statement an obligation is a due of
+// A statement is extracted as one payment per due (metadata.due_type "total" and "minimum") from the same email;
+// settling one due settles the others as far as the payment covers them (see Settles): paying the total pays the
+// minimum, paying the minimum partially pays the total
+func StatementDues(obligation models.Payment, payments []models.Payment) []models.Payment {
+	if obligation.SourceMessageID == nil || dueType(obligation) == "" {
+		return nil
+	}
+	var dues []models.Payment
+	for _, payment := range payments {
+		if payment.ID == obligation.ID || payment.AccountID != obligation.AccountID ||
+			payment.SourceMessageID == nil || *payment.SourceMessageID != *obligation.SourceMessageID ||
+			!strings.EqualFold(payment.Currency, obligation.Currency) ||
+			dueType(payment) == "" || dueType(payment) == dueType(obligation) || !IsOpen(payment) {
+			continue
+		}
+		dues = append(dues, payment)
+	}
+	return dues
+}
+
+// sameMerchant reports whether two payments are to the same merchant: the same canonical merchant when both have
+// one, otherwise the same normalised name
+func sameMerchant(a, b models.Payment) bool {
+	if a.MerchantID != nil && b.MerchantID != nil {
+		return *a.MerchantID == *b.MerchantID
+	}
+	return dedup.MerchantKey(a.Merchant) == dedup.MerchantKey(b.Merchant)
+}
+
+// sameAmount reports whether two amounts are equal within AmountTolerance
+func sameAmount(a, b models.Amount) bool {
+	return math.Abs(a.Float64()-b.Float64()) <= AmountTolerance*math.Max(a.Float64(), b.Float64())
+}
+
+// better reports whether match scores higher than best, or equally with dates closer together
+func better(match, best Match) bool {
+	if match.Confidence != best.Confidence {
+		return match.Confidence > best.Confidence
+	}
+	return gap(match) < gap(best)
+}
+
+// gap is the time between an obligation and its settlement
+func gap(match Match) time.Duration {
+	gap := match.Obligation.Date.Sub(match.Settlement.Date)
+	if gap < 0 {
+		return -gap
+	}
+	return gap
+}
+
+// amountField reads an amount from metadata, amounts may be extracted as strings ("1,250.00")
+func amountField(metadata models.JSONB, key string) (models.Amount, bool) {
+	switch value := metadata[key].(type) {
+	case float64:
+		amount := models.AmountFromFloat(value)
+		return amount, amount.Sign() > 0
+	case string:
+		amount, err := models.ParseAmount(value)
+		return amount, err == nil && amount.Sign() > 0
+	}
+	return models.Amount{}, false
+}
+
+// dueType reads which due of a statement a payment is (metadata.due_type), empty when it is no statement due
+func dueType(payment models.Payment) string {
+	return strings.ToLower(reference(payment.Metadata, "due_type"))
+}
+
+// reference reads a reference from metadata as a trimmed string, empty when missing
+func reference(metadata models.JSONB, key string) string {
+	value, ok := metadata[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
@@ -0,0 +1,158 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+var dueDate = time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+
+func bill() models.Payment {
+	return models.Payment{
+		ID: "bill-1", AccountID: "acc-1", Merchant: "HDFC Bank", Amount: models.MustParseAmount("24500"), Currency: "INR", Date: dueDate,
+		Status: models.PaymentStatusDue, Metadata: models.JSONB{"card_last_four": "4321", "minimum_due": "1,225.00"},
+	}
+}
+
+func receipt() models.Payment {
+	return models.Payment{
+		ID: "pay-1", AccountID: "acc-1", Merchant: "HDFC Bank Ltd.", Amount: models.MustParseAmount("24500"), Currency: "INR", Date: dueDate.AddDate(0, 0, -5),
+		Status: models.PaymentStatusPaid, Metadata: models.JSONB{"card_last_four": "4321", "utr": "UTR123"},
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name           string
+		change         func(obligation, payment *models.Payment)
+		wantOK         bool
+		wantSettles    string
+		wantConfidence float64
+	}{
+		{"total with card", func(o, p *models.Payment) {}, true, models.PaymentStatusPaid, 0.98},
+		{"total with card on the due date", func(o, p *models.Payment) { p.Date = dueDate }, true, models.PaymentStatusPaid, 1},
+		{"total without reference", func(o, p *models.Payment) { delete(p.Metadata, "card_last_four") }, true, models.PaymentStatusPaid, 0.68},
+		{"total on the due date", func(o, p *models.Payment) { delete(p.Metadata, "card_last_four"); p.Date = dueDate }, true, models.PaymentStatusPaid, 0.7},
+		{"total within tolerance", func(o, p *models.Payment) {
+			delete(p.Metadata, "card_last_four")
+			p.Amount = models.MustParseAmount("24550")
+		}, true, models.PaymentStatusPaid, 0.68},
+		{"minimum due with card", func(o, p *models.Payment) { p.Amount = models.MustParseAmount("1225") }, true, models.PaymentStatusPartiallyPaid, 0.88},
+		{"minimum due without reference", func(o, p *models.Payment) {
+			delete(p.Metadata, "card_last_four")
+			p.Amount = models.MustParseAmount("1225")
+		}, true, models.PaymentStatusPartiallyPaid, 0.58},
+		{"other amount", func(o, p *models.Payment) { p.Amount = models.MustParseAmount("5000") }, false, "", 0},
+		{"other card", func(o, p *models.Payment) { p.Metadata["card_last_four"] = "9999" }, false, "", 0},
+		{"other merchant", func(o, p *models.Payment) { p.Merchant = "ICICI Bank" }, false, "", 0},
+		{"same canonical merchant", func(o, p *models.Payment) {
+			id := "hdfc"
+			p.Merchant, o.MerchantID, p.MerchantID = "HDFC Credit Card", &id, &id
+		}, true, models.PaymentStatusPaid, 0.98},
+		{"other currency", func(o, p *models.Payment) { p.Currency = "USD" }, false, "", 0},
+		{"other account", func(o, p *models.Payment) { p.AccountID = "acc-2" }, false, "", 0},
+		{"paid two months later", func(o, p *models.Payment) { p.Date = dueDate.AddDate(0, 2, 0) }, false, "", 0},
+		{"obligation already paid", func(o, p *models.Payment) { o.Status = models.PaymentStatusPaid }, false, "", 0},
+		{"payment not paid", func(o, p *models.Payment) { p.Status = models.PaymentStatusDue }, false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obligation, payment := bill(), receipt()
+			tt.change(&obligation, &payment)
+
+			match, ok := Score(obligation, payment)
+			if ok != tt.wantOK {
+				t.Fatalf("Score() ok = %v, want %v (match %+v)", ok, tt.wantOK, match)
+			}
+			if !ok {
+				return
+			}
+			if match.Settles != tt.wantSettles || match.Confidence != tt.wantConfidence {
+				t.Errorf("Score() = %s with confidence %v, want %s with %v (reasons %v)",
+					match.Settles, match.Confidence, tt.wantSettles, tt.wantConfidence, match.Reasons)
+			}
+		})
+	}
+}
+
+func TestBest(t *testing.T) {
+	payment := receipt()
+	delete(payment.Metadata, "card_last_four")
+
+	lastMonth := bill()
+	lastMonth.ID = "bill-0"
+	lastMonth.Date = dueDate.AddDate(0, -1, 0)
+	otherCard := bill()
+	otherCard.ID = "bill-2"
+	otherCard.Amount = models.MustParseAmount("999")
+
+	match, ok := Best(payment, []models.Payment{lastMonth, otherCard, bill()})
+	if !ok || match.Obligation.ID != "bill-1" {
+		t.Errorf("Best() = %s, %v, want bill-1", match.Obligation.ID, ok)
+	}
+
+	if _, ok := Best(payment, []models.Payment{otherCard}); ok {
+		t.Error("Best() found a match for an obligation of another amount")
+	}
+}
+
+func TestBestSettlement(t *testing.T) {
+	early := receipt()
+	late := receipt()
+	late.ID = "pay-2"
+	late.Date = dueDate.AddDate(0, 0, 1)
+
+	match, ok := BestSettlement(bill(), []models.Payment{early, late})
+	if !ok || match.Settlement.ID != "pay-2" {
+		t.Errorf("BestSettlement() = %s, %v, want the payment closest to the due date", match.Settlement.ID, ok)
+	}
+}
+
+func TestSettles(t *testing.T) {
+	obligation := bill()
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"24500", models.PaymentStatusPaid},
+		{"24400", models.PaymentStatusPaid},
+		{"30000", models.PaymentStatusPaid},
+		{"10000", models.PaymentStatusPartiallyPaid},
+	}
+
+	for _, tt := range tests {
+		payment := receipt()
+		payment.Amount = models.MustParseAmount(tt.amount)
+		if got := Settles(obligation, payment); got != tt.want {
+			t.Errorf("Settles() with %s = %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestStatementDues(t *testing.T) {
+	message := "msg-1"
+	total, minimum := bill(), bill()
+	total.SourceMessageID, minimum.SourceMessageID = &message, &message
+	total.Metadata = models.JSONB{"due_type": "total"}
+	minimum.ID = "bill-2"
+	minimum.Amount = models.MustParseAmount("1225")
+	minimum.Metadata = models.JSONB{"due_type": "Minimum"}
+
+	paid := minimum
+	paid.ID = "bill-3"
+	paid.Status = models.PaymentStatusPaid
+	other := bill()
+	other.ID = "bill-4"
+	other.SourceMessageID = &message
+
+	dues := StatementDues(total, []models.Payment{total, minimum, paid, other})
+	if len(dues) != 1 || dues[0].ID != "bill-2" {
+		t.Errorf("StatementDues() = %v, want the open minimum due only", dues)
+	}
+	if dues := StatementDues(bill(), []models.Payment{total, minimum}); len(dues) != 0 {
+		t.Errorf("StatementDues() of a bill without due_type = %v, want none", dues)
+	}
+}
@@ -0,0 +1,296 @@
+// Package recurrence detects recurring payments (subscriptions, utility bills, EMIs) in an account's payment
+// history to one merchant, and projects their next occurrences
+package recurrence
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+const (
+	MinOccurrences    = 3                   // Payments needed before a series is recognised
+	MinConfidence     = 0.75                // Share of intervals that must match the period
+	AmountTolerance   = 0.01                // Relative difference between amounts still considered equal
+	ProjectionHorizon = 90 * 24 * time.Hour // Occurrences are projected this far ahead (at least the next one)
+	MaxProjections    = 12
+)
+
+// period is a recurrence with its length, the deviation still considered on time (a renewal charged a day late,
+// months of different length) and its length in calendar months for month-based recurrences
+type period struct {
+	recurrence string
+	days       float64
+	tolerance  float64 // days
+	months     int     // 0 for day-based recurrences
+}
+
+var periods = []period{
+	{models.RecurrenceDaily, 1, 0.5, 0},
+	{models.RecurrenceWeekly, 7, 1, 0},
+	{models.RecurrenceBiweekly, 14, 2, 0},
+	{models.RecurrenceMonthly, 30.44, 4, 1},
+	{models.RecurrenceBimonthly, 60.88, 6, 2},
+	{models.RecurrenceQuarterly, 91.31, 10, 3},
+	{models.RecurrenceSemiannual, 182.62, 15, 6},
+	{models.RecurrenceAnnual, 365.25, 20, 12},
+}
+
+// maxMissed is how many occurrences in a row may be missing from the history (an email not received)
+// while the intervals still count as regular
+const maxMissed = 2
+
+// Occurrence is one payment of a series
+type Occurrence struct {
+	Date   time.Time
+	Amount models.Amount
+}
+
+// Pattern is a detected recurring payment
+type Pattern struct {
+	Recurrence     string
+	TypicalAmount  models.Amount // Median amount, rounded to the currency's minor unit
+	LastAmount     models.Amount
+	FixedAmount    bool           // Same amount every time since the last price change, otherwise a variable bill
+	PreviousAmount *models.Amount // Set when the price changed: the amount before
+	PriceChangedAt *time.Time     // Set when the price changed: the first occurrence at the new price
+	DayOfMonth     *int           // Month-based recurrences only
+	Occurrences    int
+	Confidence     float64 // Share of intervals matching the period, 0 to 1
+	LastDate       time.Time
+	period         period
+}
+
+// Counts reports whether a payment is an occurrence of its series: extracted from an email (not projected),
+// and not cancelled, refunded, written off, failed or a draft
+func Counts(payment models.Payment) bool {
+	if payment.Projected {
+		return false
+	}
+	switch payment.Status {
+	case models.PaymentStatusDraft, models.PaymentStatusCancelled, models.PaymentStatusRefunded,
+		models.PaymentStatusWrittenOff, models.PaymentStatusFailed:
+		return false
+	}
+	return true
+}
+
+// Occurrences returns the occurrences of a merchant's payments in date order
+// Payments dated the same day are one occurrence with the largest amount (e.g. a statement's total and minimum due)
+func Occurrences(payments []models.Payment) []Occurrence {
+	sorted := make([]models.Payment, 0, len(payments))
+	for _, payment := range payments {
+		if Counts(payment) {
+			sorted = append(sorted, payment)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	occurrences := make([]Occurrence, 0, len(sorted))
+	for _, payment := range sorted {
+		if n := len(occurrences); n > 0 && sameDay(occurrences[n-1].Date, payment.Date) {
+			if payment.Amount.Cmp(occurrences[n-1].Amount) > 0 {
+				occurrences[n-1].Amount = payment.Amount
+			}
+			continue
+		}
+		occurrences = append(occurrences, Occurrence{Date: payment.Date, Amount: payment.Amount})
+	}
+	return occurrences
+}
+
+// Detect infers a recurring pattern from the payments of one account to one merchant in one currency
+// The period is the recurrence closest to the median interval between occurrences, accepted when at least
+// MinConfidence of the intervals match it (allowing up to maxMissed missing occurrences in between)
+func Detect(payments []models.Payment) (Pattern, bool) {
+	occurrences := Occurrences(payments)
+	if len(occurrences) < MinOccurrences {
+		return Pattern{}, false
+	}
+
+	intervals := make([]float64, 0, len(occurrences)-1)
+	for i := 1; i < len(occurrences); i++ {
+		intervals = append(intervals, occurrences[i].Date.Sub(occurrences[i-1].Date).Hours()/24)
+	}
+
+	p, ok := matchPeriod(median(intervals))
+	if !ok {
+		return Pattern{}, false
+	}
+
+	regular := 0
+	for _, interval := range intervals {
+		for k := 1.0; k <= maxMissed+1; k++ {
+			if math.Abs(interval-k*p.days) <= k*p.tolerance {
+				regular++
+				break
+			}
+		}
+	}
+	confidence := float64(regular) / float64(len(intervals))
+	if confidence < MinConfidence {
+		return Pattern{}, false
+	}
+
+	amounts := make([]models.Amount, 0, len(occurrences))
+	for _, occurrence := range occurrences {
+		amounts = append(amounts, occurrence.Amount)
+	}
+	last := occurrences[len(occurrences)-1]
+
+	pattern := Pattern{
+		Recurrence:    p.recurrence,
+		TypicalAmount: medianAmount(amounts).Round(models.CurrencyExponent(payments[0].Currency)),
+		LastAmount:    last.Amount,
+		FixedAmount:   true,
+		Occurrences:   len(occurrences),
+		Confidence:    math.Round(confidence*100) / 100,
+		LastDate:      last.Date,
+		period:        p,
+	}
+
+	// A price change is a switch between two stable amounts; bills that vary every time are variable instead
+	if change := lastChange(amounts); change > 0 {
+		if change >= 2 && allSame(amounts[:change]) {
+			previous := amounts[change-1]
+			changedAt := occurrences[change].Date
+			pattern.PreviousAmount = &previous
+			pattern.PriceChangedAt = &changedAt
+		} else {
+			pattern.FixedAmount = false
+		}
+	}
+
+	if p.months > 0 {
+		days := make([]float64, 0, len(occurrences))
+		for _, occurrence := range occurrences {
+			days = append(days, float64(occurrence.Date.Day()))
+		}
+		day := int(math.Round(median(days)))
+		pattern.DayOfMonth = &day
+	}
+
+	return pattern, true
+}
+
+// Next returns the occurrence following one dated date
+// Month-based recurrences fall on DayOfMonth, or the last day of shorter months
+func (p Pattern) Next(date time.Time) time.Time {
+	if p.period.months == 0 {
+		return date.AddDate(0, 0, int(math.Round(p.period.days)))
+	}
+
+	first := time.Date(date.Year(), date.Month()+time.Month(p.period.months), 1,
+		date.Hour(), date.Minute(), date.Second(), 0, date.Location())
+	day := date.Day()
+	if p.DayOfMonth != nil {
+		day = *p.DayOfMonth
+	}
+	return first.AddDate(0, 0, min(day, daysIn(first))-1)
+}
+
+// Active reports whether the series is still running at now: at most one occurrence is overdue
+// A subscription that missed two renewals was most likely cancelled
+func (p Pattern) Active(now time.Time) bool {
+	deadline := p.Next(p.Next(p.LastDate)).Add(time.Duration(p.period.tolerance * float64(24*time.Hour)))
+	return now.Before(deadline)
+}
+
+// Project returns the dates of the next occurrences after now within ProjectionHorizon, at least the next one,
+// none for an inactive series
+func (p Pattern) Project(now time.Time) []time.Time {
+	if !p.Active(now) {
+		return nil
+	}
+
+	next := p.Next(p.LastDate)
+	for next.Before(now) {
+		next = p.Next(next)
+	}
+
+	var dates []time.Time
+	for len(dates) < MaxProjections && (len(dates) == 0 || next.Sub(now) <= ProjectionHorizon) {
+		dates = append(dates, next)
+		next = p.Next(next)
+	}
+	return dates
+}
+
+// ProjectedAmount is the amount expected next: the current price, or the typical amount of a variable bill
+func (p Pattern) ProjectedAmount() models.Amount {
+	if p.FixedAmount {
+		return p.LastAmount
+	}
+	return p.TypicalAmount
+}
+
+// matchPeriod returns the recurrence whose length is within tolerance of interval days
+func matchPeriod(interval float64) (period, bool) {
+	for _, p := range periods {
+		if math.Abs(interval-p.days) <= p.tolerance {
+			return p, true
+		}
+	}
+	return period{}, false
+}
+
+// lastChange returns the index of the last amount differing from the one before it, 0 when all are the same
+func lastChange(amounts []models.Amount) int {
+	for i := len(amounts) - 1; i > 0; i-- {
+		if !sameAmount(amounts[i], amounts[i-1]) {
+			return i
+		}
+	}
+	return 0
+}
+
+// allSame reports whether all amounts are equal within AmountTolerance
+func allSame(amounts []models.Amount) bool {
+	for _, amount := range amounts[1:] {
+		if !sameAmount(amount, amounts[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameAmount reports whether two amounts are equal within AmountTolerance
+func sameAmount(a, b models.Amount) bool {
+	return math.Abs(a.Float64()-b.Float64()) <= AmountTolerance*math.Max(a.Float64(), b.Float64())
+}
+
+// median returns the median of values (not empty)
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// medianAmount is median for amounts, the mean of the middle two rounded to models.AmountDecimals
+func medianAmount(amounts []models.Amount) models.Amount {
+	sorted := append([]models.Amount(nil), amounts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return sorted[mid-1].Add(sorted[mid]).Div(2)
+	}
+	return sorted[mid]
+}
+
+// sameDay reports whether two times fall on the same calendar day
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// daysIn returns the number of days in the month of t
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
@@ -0,0 +1,173 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// series builds paid payments of amounts dated at dates
+func series(dates []time.Time, amounts ...string) []models.Payment {
+	payments := make([]models.Payment, 0, len(dates))
+	for i, date := range dates {
+		payments = append(payments, models.Payment{
+			Merchant: "Netflix", Amount: models.MustParseAmount(amounts[min(i, len(amounts)-1)]), Currency: "USD", Date: date,
+			Status: models.PaymentStatusPaid,
+		})
+	}
+	return payments
+}
+
+// monthly returns n dates a month apart from the 15th of January 2026
+func monthly(n int) []time.Time {
+	dates := make([]time.Time, 0, n)
+	for i := range n {
+		dates = append(dates, time.Date(2026, time.January+time.Month(i), 15, 0, 0, 0, 0, time.UTC))
+	}
+	return dates
+}
+
+func TestDetect_Recurrences(t *testing.T) {
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		dates []time.Time
+		want  string // empty for not recurring
+	}{
+		{"monthly", monthly(4), models.RecurrenceMonthly},
+		{"monthly charged a day late", []time.Time{start, start.AddDate(0, 1, 1), start.AddDate(0, 2, 0), start.AddDate(0, 3, 2)}, models.RecurrenceMonthly},
+		{"monthly with a missing email", []time.Time{start, start.AddDate(0, 1, 0), start.AddDate(0, 3, 0), start.AddDate(0, 4, 0)}, models.RecurrenceMonthly},
+		{"weekly", []time.Time{start, start.AddDate(0, 0, 7), start.AddDate(0, 0, 14), start.AddDate(0, 0, 21)}, models.RecurrenceWeekly},
+		{"quarterly", []time.Time{start, start.AddDate(0, 3, 0), start.AddDate(0, 6, 0)}, models.RecurrenceQuarterly},
+		{"annual", []time.Time{start, start.AddDate(1, 0, 0), start.AddDate(2, 0, 0)}, models.RecurrenceAnnual},
+		{"two payments", monthly(2), ""},
+		{"irregular", []time.Time{start, start.AddDate(0, 0, 9), start.AddDate(0, 2, 0), start.AddDate(0, 2, 20)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, ok := Detect(series(tt.dates, "15.49"))
+			got := ""
+			if ok {
+				got = pattern.Recurrence
+			}
+			if got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect_Amounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		amounts      []string
+		wantFixed    bool
+		wantPrevious string // Empty for no price change
+		wantNext     string
+	}{
+		{"fixed price", []string{"15.49", "15.49", "15.49", "15.49"}, true, "", "15.49"},
+		{"price change", []string{"15.49", "15.49", "15.49", "17.99"}, true, "15.49", "17.99"},
+		{"price change two months ago", []string{"15.49", "15.49", "17.99", "17.99"}, true, "15.49", "17.99"},
+		{"variable bill", []string{"1200", "1350", "980", "1100"}, false, "", "1150.00"},
+		{"variable bill rounded to cents", []string{"10.01", "12.51", "10.04", "14.00"}, false, "", "11.28"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, ok := Detect(series(monthly(len(tt.amounts)), tt.amounts...))
+			if !ok {
+				t.Fatal("Detect() did not detect a monthly series")
+			}
+			previous := ""
+			if pattern.PreviousAmount != nil {
+				previous = pattern.PreviousAmount.String()
+			}
+			if pattern.FixedAmount != tt.wantFixed || previous != tt.wantPrevious || pattern.ProjectedAmount().String() != tt.wantNext {
+				t.Errorf("Detect() fixed = %v, previous = %v, next = %v, want %v, %v, %v",
+					pattern.FixedAmount, previous, pattern.ProjectedAmount(), tt.wantFixed, tt.wantPrevious, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestDetect_SkipsProjectedAndCancelled(t *testing.T) {
+	payments := series(monthly(2), "15.49")
+	projected := series(monthly(3)[2:], "15.49")
+	projected[0].Projected = true
+	cancelled := series(monthly(4)[3:], "15.49")
+	cancelled[0].Status = models.PaymentStatusCancelled
+
+	if _, ok := Detect(append(append(payments, projected...), cancelled...)); ok {
+		t.Error("Detect() counted projected and cancelled payments as occurrences")
+	}
+}
+
+func TestOccurrences_SameDay(t *testing.T) {
+	dates := monthly(1)
+	total := series(dates, "24500")
+	minimum := series(dates, "1225")
+
+	occurrences := Occurrences(append(minimum, total...))
+	if len(occurrences) != 1 || occurrences[0].Amount != models.MustParseAmount("24500") {
+		t.Errorf("Occurrences() = %+v, want one occurrence of the total", occurrences)
+	}
+}
+
+func TestPattern_Next(t *testing.T) {
+	dates := []time.Time{
+		time.Date(2025, 10, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 11, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	pattern, ok := Detect(series(dates, "9.99"))
+	if !ok || pattern.DayOfMonth == nil || *pattern.DayOfMonth != 31 {
+		t.Fatalf("Detect() = %+v, want a monthly series on the 31st", pattern)
+	}
+
+	next := pattern.Next(dates[2])
+	if want := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next() = %v, want %v", next, want)
+	}
+	if next = pattern.Next(next); !next.Equal(time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Next() = %v, want the last day of February", next)
+	}
+}
+
+func TestPattern_Project(t *testing.T) {
+	pattern, _ := Detect(series(monthly(4), "15.49")) // Last on April 15
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		want  int
+		first time.Time
+	}{
+		{"before the next renewal", time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC), 3, time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"one renewal missing", time.Date(2026, 5, 20, 0, 0, 0, 0, time.UTC), 3, time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"two renewals missing", time.Date(2026, 6, 25, 0, 0, 0, 0, time.UTC), 0, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dates := pattern.Project(tt.now)
+			if len(dates) != tt.want {
+				t.Fatalf("Project() = %v, want %d dates", dates, tt.want)
+			}
+			if len(dates) > 0 && !dates[0].Equal(tt.first) {
+				t.Errorf("Project() first = %v, want %v", dates[0], tt.first)
+			}
+		})
+	}
+
+	annual, _ := Detect(series([]time.Time{
+		time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	}, "99"))
+	if dates := annual.Project(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)); len(dates) != 1 {
+		t.Errorf("Project() = %v, want only the next renewal beyond the horizon", dates)
+	}
+}
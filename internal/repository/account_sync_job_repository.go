@@ -7,6 +7,7 @@ import (
 
 	"github.com/vipul43/kiwis-worker/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AccountSyncJobRepository struct {
@@ -17,50 +18,80 @@ func NewAccountSyncJobRepository(db *gorm.DB) *AccountSyncJobRepository {
 	return &AccountSyncJobRepository{db: db}
 }
 
-// GetPendingJobs retrieves all pending account sync jobs
-func (r *AccountSyncJobRepository) GetPendingJobs(ctx context.Context, limit int) ([]models.AccountSyncJob, error) {
+// ClaimJobs atomically claims up to limit account sync jobs for the given worker
+// Uses SELECT ... FOR UPDATE SKIP LOCKED so concurrent workers never claim the same job
+// Claimed jobs are marked processing, leased for the given duration, and their attempt counter is incremented
+func (r *AccountSyncJobRepository) ClaimJobs(ctx context.Context, limit int, workerID string, lease time.Duration) ([]models.AccountSyncJob, error) {
 	var jobs []models.AccountSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.StatusPending).
-		Order("created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query pending jobs: %w", result.Error)
-	}
-	return jobs, nil
-}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
 
-// GetFailedJobs retrieves all failed account sync jobs for retry
-func (r *AccountSyncJobRepository) GetFailedJobs(ctx context.Context, limit int) ([]models.AccountSyncJob, error) {
-	var jobs []models.AccountSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.StatusFailed).
-		Order("created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query failed jobs: %w", result.Error)
+		// Pending jobs are always claimable
+		// Failed jobs are claimable once their backoff has elapsed (next_attempt_at)
+		// Processing jobs are only reclaimed once their lease has expired (worker crashed or hung)
+		result := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ?", models.StatusPending).
+			Or("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", models.StatusFailed, now).
+			Or("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.StatusProcessing, now).
+			Order("created_at ASC").
+			Limit(limit).
+			Find(&jobs)
+		if result.Error != nil {
+			return fmt.Errorf("failed to query claimable jobs: %w", result.Error)
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+
+		leaseExpiresAt := now.Add(lease)
+		result = tx.Model(&models.AccountSyncJob{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":           models.StatusProcessing,
+				"claimed_by":       workerID,
+				"claimed_at":       now,
+				"lease_expires_at": leaseExpiresAt,
+				"attempts":         gorm.Expr("attempts + 1"),
+				"updated_at":       now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark jobs as claimed: %w", result.Error)
+		}
+
+		// Reflect the claim on the returned jobs
+		for i := range jobs {
+			jobs[i].Status = models.StatusProcessing
+			jobs[i].ClaimedBy = &workerID
+			jobs[i].ClaimedAt = &now
+			jobs[i].LeaseExpiresAt = &leaseExpiresAt
+			jobs[i].Attempts++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return jobs, nil
 }
 
-// GetProcessingJobs retrieves account sync jobs stuck in processing state
-func (r *AccountSyncJobRepository) GetProcessingJobs(ctx context.Context, limit int) ([]models.AccountSyncJob, error) {
-	var jobs []models.AccountSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.StatusProcessing).
-		Order("created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query processing jobs: %w", result.Error)
+// ExtendLease extends the lease on jobs still being processed by the given worker (heartbeat)
+// Returns ErrLeaseLost once the jobs were reclaimed by another worker
+func (r *AccountSyncJobRepository) ExtendLease(ctx context.Context, jobIDs []string, workerID string, lease time.Duration) error {
+	if err := extendLease(r.db.WithContext(ctx), &models.AccountSyncJob{}, jobIDs, workerID, models.StatusProcessing, lease); err != nil {
+		return fmt.Errorf("failed to extend lease: %w", err)
 	}
-	return jobs, nil
+	return nil
 }
 
 // UpdateStatus updates the job status
-func (r *AccountSyncJobRepository) UpdateStatus(ctx context.Context, jobID string, status models.AccountSyncStatus, lastError *string) error {
+// Releases the lease when the job leaves the processing state
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *AccountSyncJobRepository) UpdateStatus(ctx context.Context, jobID string, workerID string, status models.AccountSyncStatus, lastError *string) error {
 	updates := map[string]interface{}{
 		"status":     status,
 		"last_error": lastError,
@@ -72,25 +103,58 @@ func (r *AccountSyncJobRepository) UpdateStatus(ctx context.Context, jobID strin
 		updates["processed_at"] = &now
 	}
 
-	result := r.db.WithContext(ctx).Model(&models.AccountSyncJob{}).
-		Where("id = ?", jobID).
-		Updates(updates)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update job status: %w", result.Error)
+	if status != models.StatusProcessing {
+		updates["lease_expires_at"] = nil
+	}
+
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.AccountSyncJob{}, jobID, workerID, updates); err != nil {
+		return fmt.Errorf("failed to update job status: %w", err)
 	}
 	return nil
 }
 
-// IncrementAttempts increments the retry attempt counter
-func (r *AccountSyncJobRepository) IncrementAttempts(ctx context.Context, jobID string) error {
-	result := r.db.WithContext(ctx).Model(&models.AccountSyncJob{}).
-		Where("id = ?", jobID).
-		Updates(map[string]interface{}{
-			"attempts":   gorm.Expr("attempts + 1"),
-			"updated_at": time.Now(),
-		})
-	if result.Error != nil {
-		return fmt.Errorf("failed to increment attempts: %w", result.Error)
+// MarkFailed marks the job as failed and schedules its next attempt (backoff)
+func (r *AccountSyncJobRepository) MarkFailed(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	now := time.Now()
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.AccountSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           models.StatusFailed,
+		"last_error":       lastError,
+		"next_attempt_at":  nextAttemptAt,
+		"lease_expires_at": nil,
+		"processed_at":     now,
+		"updated_at":       now,
+	}); err != nil {
+		return fmt.Errorf("failed to mark job as failed: %w", err)
+	}
+	return nil
+}
+
+// MarkDead marks the job as dead (max retries exceeded), keeping last_error for inspection
+func (r *AccountSyncJobRepository) MarkDead(ctx context.Context, jobID string, workerID string, lastError string) error {
+	now := time.Now()
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.AccountSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           models.StatusDead,
+		"last_error":       lastError,
+		"next_attempt_at":  nil,
+		"lease_expires_at": nil,
+		"processed_at":     now,
+		"updated_at":       now,
+	}); err != nil {
+		return fmt.Errorf("failed to mark job as dead: %w", err)
 	}
 	return nil
 }
+
+// GetDeadJobs retrieves dead account sync jobs, most recently failed first
+func (r *AccountSyncJobRepository) GetDeadJobs(ctx context.Context, limit int) ([]models.AccountSyncJob, error) {
+	var jobs []models.AccountSyncJob
+	result := r.db.WithContext(ctx).
+		Where("status = ?", models.StatusDead).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&jobs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query dead jobs: %w", result.Error)
+	}
+	return jobs, nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/vipul43/kiwis-worker/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type EmailSyncJobRepository struct {
@@ -18,80 +20,221 @@ func NewEmailSyncJobRepository(db *gorm.DB) *EmailSyncJobRepository {
 	return &EmailSyncJobRepository{db: db}
 }
 
-// GetPendingJobs retrieves pending email sync jobs in round-robin order
+// ClaimJobs atomically claims up to limit email sync jobs for the given worker in round-robin order
 // New jobs (last_synced_at = NULL) get picked first, then oldest synced jobs
-func (r *EmailSyncJobRepository) GetPendingJobs(ctx context.Context, limit int) ([]models.EmailSyncJob, error) {
+// Uses SELECT ... FOR UPDATE SKIP LOCKED so concurrent workers never claim the same job
+// Synced jobs are claimable for incremental sync once last_synced_at is older than incrementalInterval
+// Synced and completed jobs are claimable when a push notification requested a sync
+// Completed jobs are claimable once their watch expires within watchRenewBefore
+// Claimed jobs are marked processing and leased for the given duration
+func (r *EmailSyncJobRepository) ClaimJobs(ctx context.Context, limit int, workerID string, lease time.Duration, incrementalInterval time.Duration, watchRenewBefore time.Duration) ([]models.EmailSyncJob, error) {
 	var jobs []models.EmailSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.EmailStatusPending).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query pending jobs: %w", result.Error)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Pending jobs are always claimable
+		// Failed jobs are claimable once their backoff has elapsed (next_attempt_at)
+		// Processing jobs are claimable once their lease is released (partial progress) or expired (crashed)
+		// Synced jobs are claimable once they are due for an incremental sync
+		// Synced and completed jobs are claimable on push notification (sync_requested_at) or watch renewal
+		result := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ?", models.EmailStatusPending).
+			Or("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", models.EmailStatusFailed, now).
+			Or("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.EmailStatusProcessing, now).
+			Or("status = ? AND last_synced_at < ?", models.EmailStatusSynced, now.Add(-incrementalInterval)).
+			Or("status IN ? AND sync_requested_at IS NOT NULL", []models.EmailSyncStatus{models.EmailStatusSynced, models.EmailStatusCompleted}).
+			Or("status = ? AND watch_expiration < ?", models.EmailStatusCompleted, now.Add(watchRenewBefore)).
+			Order("last_synced_at ASC NULLS FIRST, created_at ASC").
+			Limit(limit).
+			Find(&jobs)
+		if result.Error != nil {
+			return fmt.Errorf("failed to query claimable jobs: %w", result.Error)
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+
+		leaseExpiresAt := now.Add(lease)
+		result = tx.Model(&models.EmailSyncJob{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":            models.EmailStatusProcessing,
+				"claimed_by":        workerID,
+				"claimed_at":        now,
+				"lease_expires_at":  leaseExpiresAt,
+				"attempts":          gorm.Expr("attempts + 1"),
+				"sync_requested_at": nil, // A push arriving from now on requests another sync
+				"processed_at":      nil,
+				"updated_at":        now,
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark jobs as claimed: %w", result.Error)
+		}
+
+		// Reflect the claim on the returned jobs
+		for i := range jobs {
+			jobs[i].Status = models.EmailStatusProcessing
+			jobs[i].ClaimedBy = &workerID
+			jobs[i].ClaimedAt = &now
+			jobs[i].LeaseExpiresAt = &leaseExpiresAt
+			jobs[i].Attempts++
+			jobs[i].SyncRequestedAt = nil
+			jobs[i].ProcessedAt = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return jobs, nil
 }
 
-// GetFailedJobs retrieves failed email sync jobs for retry in round-robin order
-func (r *EmailSyncJobRepository) GetFailedJobs(ctx context.Context, limit int) ([]models.EmailSyncJob, error) {
-	var jobs []models.EmailSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.EmailStatusFailed).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query failed jobs: %w", result.Error)
+// ExtendLease extends the lease on jobs still being processed by the given worker (heartbeat)
+// Returns ErrLeaseLost once the jobs were reclaimed by another worker
+func (r *EmailSyncJobRepository) ExtendLease(ctx context.Context, jobIDs []string, workerID string, lease time.Duration) error {
+	if err := extendLease(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobIDs, workerID, models.EmailStatusProcessing, lease); err != nil {
+		return fmt.Errorf("failed to extend lease: %w", err)
 	}
-	return jobs, nil
+	return nil
 }
 
-// GetProcessingJobs retrieves email sync jobs stuck in processing state
-func (r *EmailSyncJobRepository) GetProcessingJobs(ctx context.Context, limit int) ([]models.EmailSyncJob, error) {
-	var jobs []models.EmailSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.EmailStatusProcessing).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to query processing jobs: %w", result.Error)
+// ReleaseLease releases the lease on a job that stays in processing (partial progress)
+// The job becomes claimable again and waits its turn in the round-robin queue
+// Resets attempts: ClaimJobs counts every page of a backfill, so each saved page starts a fresh retry budget
+func (r *EmailSyncJobRepository) ReleaseLease(ctx context.Context, jobID string, workerID string) error {
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"lease_expires_at": nil,
+		"attempts":         0,
+		"updated_at":       time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
 	}
-	return jobs, nil
+	return nil
 }
 
-// Create creates a new email sync job
+// Create creates a new email sync job and notifies listeners on the email sync job channel
 func (r *EmailSyncJobRepository) Create(ctx context.Context, job models.EmailSyncJob) error {
-	result := r.db.WithContext(ctx).Create(&job)
-	if result.Error != nil {
-		return fmt.Errorf("failed to create email sync job: %w", result.Error)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		return notify(tx, ChannelEmailSyncJob, job.AccountID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create email sync job: %w", err)
 	}
 	return nil
 }
 
 // UpdateProgress updates job progress (emails fetched, page token, last synced time)
 // Updates last_synced_at to push job to back of round-robin queue
-func (r *EmailSyncJobRepository) UpdateProgress(ctx context.Context, jobID string, emailsFetched int, pageToken *string) error {
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *EmailSyncJobRepository) UpdateProgress(ctx context.Context, jobID string, workerID string, emailsFetched int, pageToken *string) error {
 	now := time.Now()
-	result := r.db.WithContext(ctx).Model(&models.EmailSyncJob{}).
-		Where("id = ?", jobID).
-		Updates(map[string]interface{}{
-			"emails_fetched": emailsFetched,
-			"page_token":     pageToken,
-			"last_synced_at": now,
-			"updated_at":     now,
-		})
-	if result.Error != nil {
-		return fmt.Errorf("failed to update job progress: %w", result.Error)
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"emails_fetched": emailsFetched,
+		"page_token":     pageToken,
+		"last_synced_at": now,
+		"updated_at":     now,
+	}); err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
+	}
+	return nil
+}
+
+// UpdateHistoryID records the history ID the next incremental sync starts from
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *EmailSyncJobRepository) UpdateHistoryID(ctx context.Context, jobID string, workerID string, historyID string) error {
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"history_id": historyID,
+		"updated_at": time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to update history id: %w", err)
 	}
 	return nil
 }
 
+// MarkSynced marks the job as synced and switches it to incremental (polling) sync
+func (r *EmailSyncJobRepository) MarkSynced(ctx context.Context, jobID string, workerID string) error {
+	return r.markCaughtUp(ctx, jobID, workerID, models.EmailStatusSynced, models.SyncTypeIncremental)
+}
+
+// MarkCompleted marks the job as completed and switches it to webhook sync (push notifications are set up)
+func (r *EmailSyncJobRepository) MarkCompleted(ctx context.Context, jobID string, workerID string) error {
+	return r.markCaughtUp(ctx, jobID, workerID, models.EmailStatusCompleted, models.SyncTypeWebhook)
+}
+
+// markCaughtUp moves a job that has fetched everything to the given status and sync type
+// Resets attempts and backoff so every later sync starts with a fresh retry budget
+func (r *EmailSyncJobRepository) markCaughtUp(ctx context.Context, jobID string, workerID string, status models.EmailSyncStatus, syncType models.EmailSyncType) error {
+	now := time.Now()
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           status,
+		"sync_type":        syncType,
+		"page_token":       nil,
+		"attempts":         0,
+		"next_attempt_at":  nil,
+		"last_error":       nil,
+		"lease_expires_at": nil,
+		"processed_at":     &now,
+		"updated_at":       now,
+	}); err != nil {
+		return fmt.Errorf("failed to mark job as %s: %w", status, err)
+	}
+	return nil
+}
+
+// UpdateWatch records the mailbox address and expiry of the job's Gmail watch
+// Addresses are stored lowercase so push notifications match regardless of case
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *EmailSyncJobRepository) UpdateWatch(ctx context.Context, jobID string, workerID string, emailAddress string, expiration time.Time) error {
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"email_address":    strings.ToLower(emailAddress),
+		"watch_expiration": expiration,
+		"updated_at":       time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to update watch: %w", err)
+	}
+	return nil
+}
+
+// RequestSync flags the jobs for the given mailbox for a history sync (Gmail push notification)
+// Notifies listeners on the email sync job channel and returns the number of jobs flagged
+func (r *EmailSyncJobRepository) RequestSync(ctx context.Context, emailAddress string) (int64, error) {
+	var flagged int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.EmailSyncJob{}).
+			Where("email_address = ?", strings.ToLower(emailAddress)).
+			Updates(map[string]interface{}{
+				"sync_requested_at": now,
+				"updated_at":        now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		flagged = result.RowsAffected
+		if flagged == 0 {
+			return nil
+		}
+		return notify(tx, ChannelEmailSyncJob, emailAddress)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to request sync: %w", err)
+	}
+	return flagged, nil
+}
+
 // UpdateStatus updates the job status
 // For synced/completed/failed status, sets processed_at
-// For processing status, clears processed_at
-func (r *EmailSyncJobRepository) UpdateStatus(ctx context.Context, jobID string, status models.EmailSyncStatus, lastError *string) error {
+// Releases the lease when the job leaves the processing state
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *EmailSyncJobRepository) UpdateStatus(ctx context.Context, jobID string, workerID string, status models.EmailSyncStatus, lastError *string) error {
 	now := time.Now()
 	updates := map[string]interface{}{
 		"status":     status,
@@ -100,34 +243,82 @@ func (r *EmailSyncJobRepository) UpdateStatus(ctx context.Context, jobID string,
 	}
 
 	// Set processed_at for terminal states (synced, completed, failed)
-	// Clear it for processing state (job is being worked on)
 	if status == models.EmailStatusSynced || status == models.EmailStatusCompleted || status == models.EmailStatusFailed {
 		updates["processed_at"] = &now
 	}
 
-	result := r.db.WithContext(ctx).Model(&models.EmailSyncJob{}).
-		Where("id = ?", jobID).
-		Updates(updates)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update job status: %w", result.Error)
+	if status != models.EmailStatusProcessing {
+		updates["lease_expires_at"] = nil
+	}
+
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, updates); err != nil {
+		return fmt.Errorf("failed to update job status: %w", err)
 	}
 	return nil
 }
 
-// IncrementAttempts increments the retry attempt counter
-func (r *EmailSyncJobRepository) IncrementAttempts(ctx context.Context, jobID string) error {
-	result := r.db.WithContext(ctx).Model(&models.EmailSyncJob{}).
-		Where("id = ?", jobID).
-		Updates(map[string]interface{}{
-			"attempts":   gorm.Expr("attempts + 1"),
-			"updated_at": time.Now(),
-		})
-	if result.Error != nil {
-		return fmt.Errorf("failed to increment attempts: %w", result.Error)
+// MarkFailed marks the job as failed and schedules its next attempt (backoff)
+func (r *EmailSyncJobRepository) MarkFailed(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	now := time.Now()
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           models.EmailStatusFailed,
+		"last_error":       lastError,
+		"next_attempt_at":  nextAttemptAt,
+		"lease_expires_at": nil,
+		"processed_at":     now,
+		"updated_at":       now,
+	}); err != nil {
+		return fmt.Errorf("failed to mark job as failed: %w", err)
 	}
 	return nil
 }
 
+// Reschedule puts the job back to wait until nextAttemptAt without counting the attempt (e.g. Gmail rate limit)
+// Keeps the page token so the sync resumes where it stopped
+func (r *EmailSyncJobRepository) Reschedule(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           models.EmailStatusFailed,
+		"last_error":       lastError,
+		"next_attempt_at":  nextAttemptAt,
+		"attempts":         gorm.Expr("GREATEST(attempts - 1, 0)"),
+		"lease_expires_at": nil,
+		"updated_at":       time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to reschedule job: %w", err)
+	}
+	return nil
+}
+
+// MarkDead marks the job as dead (max retries exceeded), keeping last_error for inspection
+func (r *EmailSyncJobRepository) MarkDead(ctx context.Context, jobID string, workerID string, lastError string) error {
+	now := time.Now()
+	if err := claimedUpdate(r.db.WithContext(ctx), &models.EmailSyncJob{}, jobID, workerID, map[string]interface{}{
+		"status":           models.EmailStatusDead,
+		"last_error":       lastError,
+		"next_attempt_at":  nil,
+		"lease_expires_at": nil,
+		"processed_at":     now,
+		"updated_at":       now,
+	}); err != nil {
+		return fmt.Errorf("failed to mark job as dead: %w", err)
+	}
+	return nil
+}
+
+// GetDeadJobs retrieves dead email sync jobs, most recently failed first
+func (r *EmailSyncJobRepository) GetDeadJobs(ctx context.Context, limit int) ([]models.EmailSyncJob, error) {
+	var jobs []models.EmailSyncJob
+	result := r.db.WithContext(ctx).
+		Where("status = ?", models.EmailStatusDead).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&jobs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to query dead jobs: %w", result.Error)
+	}
+	return jobs, nil
+}
+
 // GetByID retrieves an email sync job by ID
 func (r *EmailSyncJobRepository) GetByID(ctx context.Context, jobID string) (*models.EmailSyncJob, error) {
 	var job models.EmailSyncJob
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrIMAPSettingsNotFound = errors.New("imap settings not found")
+
+type IMAPSettingsRepository struct {
+	db *gorm.DB
+}
+
+func NewIMAPSettingsRepository(db *gorm.DB) *IMAPSettingsRepository {
+	return &IMAPSettingsRepository{db: db}
+}
+
+// GetByAccountID retrieves the IMAP server settings of an account
+func (r *IMAPSettingsRepository) GetByAccountID(ctx context.Context, accountID string) (*models.IMAPSettings, error) {
+	var settings models.IMAPSettings
+	result := r.db.WithContext(ctx).First(&settings, "account_id = ?", accountID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrIMAPSettingsNotFound
+		}
+		return nil, fmt.Errorf("failed to get imap settings: %w", result.Error)
+	}
+	return &settings, nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrLeaseLost is returned when a job is no longer claimed by the worker updating it
+// Its lease expired and another worker reclaimed it, the update is dropped
+var ErrLeaseLost = errors.New("job lease lost")
+
+// extendLease extends the lease on the given jobs that are still claimed by the worker (heartbeat)
+// Jobs this worker already finished match without being leased again, so a heartbeat racing the end of a job
+// does not report a lost lease
+// Returns ErrLeaseLost when none of the jobs is claimed by the worker any more
+func extendLease(db *gorm.DB, model interface{}, jobIDs []string, workerID string, processing interface{}, lease time.Duration) error {
+	now := time.Now()
+	result := db.Model(model).
+		Where("id IN ? AND claimed_by = ?", jobIDs, workerID).
+		Updates(map[string]interface{}{
+			"lease_expires_at": gorm.Expr("CASE WHEN status = ? THEN ? ELSE lease_expires_at END", processing, now.Add(lease)),
+			"updated_at":       now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// claimedUpdate applies updates to a job only while it is claimed by the worker
+// Returns ErrLeaseLost when the job was reclaimed by another worker, whose result is kept
+func claimedUpdate(db *gorm.DB, model interface{}, jobID string, workerID string, updates map[string]interface{}) error {
+	result := db.Model(model).
+		Where("id = ? AND claimed_by = ?", jobID, workerID).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
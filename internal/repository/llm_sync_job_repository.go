@@ -24,71 +24,152 @@ func (r *LLMSyncJobRepository) Create(ctx context.Context, job models.LLMSyncJob
 
 // BulkCreate creates multiple LLM sync jobs in a single transaction
 // Uses ON CONFLICT DO NOTHING to skip duplicates by message_id
+// Notifies listeners on the LLM sync job channel when any new job was inserted
 func (r *LLMSyncJobRepository) BulkCreate(ctx context.Context, jobs []models.LLMSyncJob) error {
 	if len(jobs) == 0 {
 		return nil
 	}
 
-	return r.db.WithContext(ctx).
-		Clauses(clause.OnConflict{
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "message_id"}},
 			DoNothing: true,
 		}).
-		Create(&jobs).Error
+			Create(&jobs)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return notify(tx, ChannelLLMSyncJob, jobs[0].AccountID)
+	})
 }
 
-// GetPendingJobs retrieves pending LLM sync jobs (round-robin by last_synced_at)
-func (r *LLMSyncJobRepository) GetPendingJobs(ctx context.Context, limit int) ([]models.LLMSyncJob, error) {
+// ClaimJobs atomically claims up to limit LLM sync jobs for the given worker (round-robin by last_synced_at)
+// Uses SELECT ... FOR UPDATE SKIP LOCKED so concurrent workers never claim the same job
+// Claimed jobs are marked processing and leased for the given duration
+func (r *LLMSyncJobRepository) ClaimJobs(ctx context.Context, limit int, workerID string, lease time.Duration) ([]models.LLMSyncJob, error) {
 	var jobs []models.LLMSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.LLMStatusPending).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	return jobs, result.Error
-}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
 
-// GetFailedJobs retrieves failed LLM sync jobs for retry
-func (r *LLMSyncJobRepository) GetFailedJobs(ctx context.Context, limit int) ([]models.LLMSyncJob, error) {
-	var jobs []models.LLMSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.LLMStatusFailed).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	return jobs, result.Error
+		// Failed jobs wait for their backoff (next_attempt_at)
+		// Processing jobs (crash recovery) are only reclaimed once their lease has expired
+		result := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("status = ?", models.LLMStatusPending).
+			Or("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", models.LLMStatusFailed, now).
+			Or("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.LLMStatusProcessing, now).
+			Order("last_synced_at ASC NULLS FIRST, created_at ASC").
+			Limit(limit).
+			Find(&jobs)
+		if result.Error != nil || len(jobs) == 0 {
+			return result.Error
+		}
+
+		ids := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+
+		leaseExpiresAt := now.Add(lease)
+		err := tx.Model(&models.LLMSyncJob{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":           models.LLMStatusProcessing,
+				"claimed_by":       workerID,
+				"claimed_at":       now,
+				"lease_expires_at": leaseExpiresAt,
+				"attempts":         gorm.Expr("attempts + 1"),
+				"updated_at":       now,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		// Reflect the claim on the returned jobs
+		for i := range jobs {
+			jobs[i].Status = models.LLMStatusProcessing
+			jobs[i].ClaimedBy = &workerID
+			jobs[i].ClaimedAt = &now
+			jobs[i].LeaseExpiresAt = &leaseExpiresAt
+			jobs[i].Attempts++
+		}
+		return nil
+	})
+	return jobs, err
 }
 
-// GetProcessingJobs retrieves stuck processing jobs (crash recovery)
-func (r *LLMSyncJobRepository) GetProcessingJobs(ctx context.Context, limit int) ([]models.LLMSyncJob, error) {
-	var jobs []models.LLMSyncJob
-	result := r.db.WithContext(ctx).
-		Where("status = ?", models.LLMStatusProcessing).
-		Order("last_synced_at ASC NULLS FIRST, created_at ASC").
-		Limit(limit).
-		Find(&jobs)
-	return jobs, result.Error
+// ExtendLease extends the lease on jobs still being processed by the given worker (heartbeat)
+// Returns ErrLeaseLost once the jobs were reclaimed by another worker
+func (r *LLMSyncJobRepository) ExtendLease(ctx context.Context, ids []string, workerID string, lease time.Duration) error {
+	return extendLease(r.db.WithContext(ctx), &models.LLMSyncJob{}, ids, workerID, models.LLMStatusProcessing, lease)
 }
 
 // UpdateStatus updates the status of an LLM sync job
-func (r *LLMSyncJobRepository) UpdateStatus(ctx context.Context, id string, status string, lastError *string) error {
+// Releases the lease when the job leaves the processing state
+// Only applies while the job is claimed by workerID (ErrLeaseLost otherwise)
+func (r *LLMSyncJobRepository) UpdateStatus(ctx context.Context, id string, workerID string, status string, lastError *string) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":         status,
+		"last_error":     lastError,
+		"updated_at":     now,
+		"last_synced_at": now,
+	}
+	if status != models.LLMStatusProcessing {
+		updates["lease_expires_at"] = nil
+	}
+	return claimedUpdate(r.db.WithContext(ctx), &models.LLMSyncJob{}, id, workerID, updates)
+}
+
+// MarkFailed marks the job as failed and schedules its next attempt (backoff)
+func (r *LLMSyncJobRepository) MarkFailed(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	now := time.Now()
+	return claimedUpdate(r.db.WithContext(ctx), &models.LLMSyncJob{}, id, workerID, map[string]interface{}{
+		"status":           models.LLMStatusFailed,
+		"last_error":       lastError,
+		"next_attempt_at":  nextAttemptAt,
+		"lease_expires_at": nil,
+		"updated_at":       now,
+		"last_synced_at":   now,
+	})
+}
+
+// Reschedule puts the job back to wait until nextAttemptAt without counting the attempt (e.g. Gmail rate limit)
+func (r *LLMSyncJobRepository) Reschedule(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error {
 	now := time.Now()
-	return r.db.WithContext(ctx).Model(&models.LLMSyncJob{}).
-		Where("id = ?", id).
-		Updates(map[string]interface{}{
-			"status":         status,
-			"last_error":     lastError,
-			"updated_at":     now,
-			"last_synced_at": now,
-		}).Error
+	return claimedUpdate(r.db.WithContext(ctx), &models.LLMSyncJob{}, id, workerID, map[string]interface{}{
+		"status":           models.LLMStatusFailed,
+		"last_error":       lastError,
+		"next_attempt_at":  nextAttemptAt,
+		"attempts":         gorm.Expr("GREATEST(attempts - 1, 0)"),
+		"lease_expires_at": nil,
+		"updated_at":       now,
+		"last_synced_at":   now,
+	})
 }
 
-// IncrementAttempts increments the attempts counter
-func (r *LLMSyncJobRepository) IncrementAttempts(ctx context.Context, id string) error {
-	return r.db.WithContext(ctx).Model(&models.LLMSyncJob{}).
-		Where("id = ?", id).
-		Updates(map[string]interface{}{
-			"attempts":   gorm.Expr("attempts + 1"),
-			"updated_at": time.Now(),
-		}).Error
+// MarkDead marks the job as dead (max retries exceeded), keeping last_error for inspection
+func (r *LLMSyncJobRepository) MarkDead(ctx context.Context, id string, workerID string, lastError string) error {
+	now := time.Now()
+	return claimedUpdate(r.db.WithContext(ctx), &models.LLMSyncJob{}, id, workerID, map[string]interface{}{
+		"status":           models.LLMStatusDead,
+		"last_error":       lastError,
+		"next_attempt_at":  nil,
+		"lease_expires_at": nil,
+		"updated_at":       now,
+		"last_synced_at":   now,
+	})
+}
+
+// GetDeadJobs retrieves dead LLM sync jobs, most recently failed first
+func (r *LLMSyncJobRepository) GetDeadJobs(ctx context.Context, limit int) ([]models.LLMSyncJob, error) {
+	var jobs []models.LLMSyncJob
+	result := r.db.WithContext(ctx).
+		Where("status = ?", models.LLMStatusDead).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&jobs)
+	return jobs, result.Error
 }
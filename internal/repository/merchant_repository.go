@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errAliasTaken rolls back the registration of a merchant whose alias a concurrent worker registered first
+var errAliasTaken = errors.New("merchant alias taken")
+
+type MerchantRepository struct {
+	db *gorm.DB
+}
+
+func NewMerchantRepository(db *gorm.DB) *MerchantRepository {
+	return &MerchantRepository{db: db}
+}
+
+// List retrieves all canonical merchants with their aliases and domains, ordered by name
+func (r *MerchantRepository) List(ctx context.Context) ([]models.Merchant, error) {
+	var merchants []models.Merchant
+	result := r.db.WithContext(ctx).
+		Preload("Aliases").
+		Preload("Domains").
+		Order("name ASC").
+		Find(&merchants)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list merchants: %w", result.Error)
+	}
+	return merchants, nil
+}
+
+// Register creates a canonical merchant with its aliases and domains and returns its ID
+// When its first alias already refers to a merchant (registered concurrently), that merchant's ID is returned
+// instead; other aliases and domains already taken are left out
+func (r *MerchantRepository) Register(ctx context.Context, merchant models.Merchant) (string, error) {
+	if len(merchant.Aliases) == 0 {
+		return "", fmt.Errorf("merchant %s has no alias", merchant.Name)
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		merchant.CreatedAt, merchant.UpdatedAt = now, now
+		if err := tx.Omit(clause.Associations).Create(&merchant).Error; err != nil {
+			return fmt.Errorf("failed to create merchant: %w", err)
+		}
+
+		for i, alias := range merchant.Aliases {
+			created, err := addAlias(tx, merchant.ID, alias.Alias)
+			if err != nil {
+				return err
+			}
+			if i == 0 && !created {
+				return errAliasTaken
+			}
+		}
+		for _, domain := range merchant.Domains {
+			if err := addDomain(tx, merchant.ID, domain.Domain); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errAliasTaken) {
+		var alias models.MerchantAlias
+		if err := r.db.WithContext(ctx).First(&alias, "alias = ?", merchant.Aliases[0].Alias).Error; err != nil {
+			return "", fmt.Errorf("failed to get merchant alias: %w", err)
+		}
+		return alias.MerchantID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return merchant.ID, nil
+}
+
+// AddAlias adds an alias of a merchant, unless it already refers to a merchant
+func (r *MerchantRepository) AddAlias(ctx context.Context, merchantID, alias string) error {
+	_, err := addAlias(r.db.WithContext(ctx), merchantID, alias)
+	return err
+}
+
+// ListPayments retrieves up to limit payments ordered by ID after afterID, only those without a canonical merchant
+// unless all is set
+func (r *MerchantRepository) ListPayments(ctx context.Context, afterID string, limit int, all bool) ([]models.Payment, error) {
+	query := r.db.WithContext(ctx).Where("id > ?", afterID)
+	if !all {
+		query = query.Where("merchant_id IS NULL")
+	}
+
+	var payments []models.Payment
+	result := query.Order("id ASC").Limit(limit).Find(&payments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", result.Error)
+	}
+	return payments, nil
+}
+
+// LinkPayments sets the canonical merchant of payments, and their category where they have none
+func (r *MerchantRepository) LinkPayments(ctx context.Context, payments []models.Payment) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for _, payment := range payments {
+			err := tx.Model(&models.Payment{}).
+				Where("id = ?", payment.ID).
+				Updates(map[string]interface{}{
+					"merchant_id": payment.MerchantID,
+					"category":    gorm.Expr("COALESCE(category, ?)", payment.Category),
+					"updated_at":  now,
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to link payment %s to its merchant: %w", payment.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// addAlias inserts an alias of a merchant and reports whether it was inserted, false when it is taken
+func addAlias(tx *gorm.DB, merchantID, alias string) (bool, error) {
+	created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MerchantAlias{
+		Alias:      alias,
+		MerchantID: merchantID,
+		CreatedAt:  time.Now(),
+	})
+	if created.Error != nil {
+		return false, fmt.Errorf("failed to add merchant alias %q: %w", alias, created.Error)
+	}
+	return created.RowsAffected == 1, nil
+}
+
+// addDomain inserts a sender domain of a merchant, unless it already belongs to a merchant
+func addDomain(tx *gorm.DB, merchantID, domain string) error {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MerchantDomain{
+		Domain:     domain,
+		MerchantID: merchantID,
+		CreatedAt:  time.Now(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to add merchant domain %s: %w", domain, err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package repository
+
+import "gorm.io/gorm"
+
+// Postgres NOTIFY channels, one per job type
+// The account_sync_job channel is notified by the account trigger (see migrations)
+const (
+	ChannelAccountSyncJob = "account_sync_job"
+	ChannelEmailSyncJob   = "email_sync_job"
+	ChannelLLMSyncJob     = "llm_sync_job"
+)
+
+// notify sends a pg_notify on the given channel
+// Inside a transaction, Postgres only delivers the notification once the transaction commits
+func notify(tx *gorm.DB, channel string, payload string) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/reconcile"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPaymentLinkNotFound = errors.New("payment link not found")
+
+type PaymentLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewPaymentLinkRepository(db *gorm.DB) *PaymentLinkRepository {
+	return &PaymentLinkRepository{db: db}
+}
+
+// FindObligations retrieves the open payments of the same account, merchant and currency dated within
+// reconcile.DateWindow of a paid payment, leaving out obligations already linked to it or rejected for it
+func (r *PaymentLinkRepository) FindObligations(ctx context.Context, payment models.Payment) ([]models.Payment, error) {
+	var obligations []models.Payment
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND currency = ? AND id <> ?", payment.AccountID, payment.Currency, payment.ID).
+		Where(sameMerchant(r.db, payment)).
+		Where("status IN ? AND NOT projected", reconcile.OpenStatuses).
+		Where("date BETWEEN ? AND ?", payment.Date.Add(-reconcile.DateWindow), payment.Date.Add(reconcile.DateWindow)).
+		Where("NOT EXISTS (SELECT 1 FROM payment_link WHERE payment_link.obligation_id = payment.id AND payment_link.settlement_id = ?)", payment.ID).
+		Find(&obligations)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find obligations: %w", result.Error)
+	}
+	return obligations, nil
+}
+
+// FindSettlements retrieves the paid payments of the same account, merchant and currency dated within
+// reconcile.DateWindow of an obligation, leaving out payments already linked or suggested for any obligation
+func (r *PaymentLinkRepository) FindSettlements(ctx context.Context, obligation models.Payment) ([]models.Payment, error) {
+	var payments []models.Payment
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND currency = ? AND id <> ?", obligation.AccountID, obligation.Currency, obligation.ID).
+		Where(sameMerchant(r.db, obligation)).
+		Where("status = ?", models.PaymentStatusPaid).
+		Where("date BETWEEN ? AND ?", obligation.Date.Add(-reconcile.DateWindow), obligation.Date.Add(reconcile.DateWindow)).
+		Where(`NOT EXISTS (SELECT 1 FROM payment_link WHERE payment_link.settlement_id = payment.id
+			AND (payment_link.status <> ? OR payment_link.obligation_id = ?))`, models.PaymentLinkRejected, obligation.ID).
+		Find(&payments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find settlements: %w", result.Error)
+	}
+	return payments, nil
+}
+
+// FindStatementDues retrieves the open payments extracted from the same email as an obligation, among them the
+// other dues of its statement (see reconcile.StatementDues)
+func (r *PaymentLinkRepository) FindStatementDues(ctx context.Context, obligation models.Payment) ([]models.Payment, error) {
+	if obligation.SourceMessageID == nil {
+		return nil, nil
+	}
+	var payments []models.Payment
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND source_message_id = ? AND id <> ?", obligation.AccountID, *obligation.SourceMessageID, obligation.ID).
+		Where("status IN ? AND NOT projected", reconcile.OpenStatuses).
+		Find(&payments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to find statement dues: %w", result.Error)
+	}
+	return payments, nil
+}
+
+// Suggest records a suggested link for manual review, unless the pair is already linked, suggested or rejected
+func (r *PaymentLinkRepository) Suggest(ctx context.Context, link models.PaymentLink) error {
+	link.Status = models.PaymentLinkSuggested
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "obligation_id"}, {Name: "settlement_id"}},
+		DoNothing: true,
+	}).Create(&link)
+	if result.Error != nil {
+		return fmt.Errorf("failed to suggest payment link: %w", result.Error)
+	}
+	return nil
+}
+
+// Apply links the pair and moves the obligation to link.Settles when the status transition table allows it,
+// recording the change with cause reconciled (auto links) or manual
+// Replaces a suggested or rejected link of the same pair
+func (r *PaymentLinkRepository) Apply(ctx context.Context, link models.PaymentLink) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var obligation models.Payment
+		err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			First(&obligation, "id = ?", link.ObligationID).Error
+		if err != nil {
+			return fmt.Errorf("failed to get obligation %s: %w", link.ObligationID, err)
+		}
+
+		now := time.Now()
+		link.Status = models.PaymentLinkLinked
+		link.PreviousStatus = nil
+		link.UpdatedAt = now
+		if models.CanTransitionPaymentStatus(obligation.Status, link.Settles) {
+			cause := models.PaymentStatusCauseReconciled
+			if link.Source == models.PaymentLinkSourceManual {
+				cause = models.PaymentStatusCauseManual
+			}
+			err := tx.Model(&models.Payment{}).
+				Where("id = ?", obligation.ID).
+				Updates(map[string]interface{}{"status": link.Settles, "updated_at": now}).Error
+			if err != nil {
+				return fmt.Errorf("failed to settle payment %s: %w", obligation.ID, err)
+			}
+			if err := recordStatusChange(tx, obligation.ID, &obligation.Status, link.Settles, cause, nil); err != nil {
+				return err
+			}
+			link.PreviousStatus = &obligation.Status
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "obligation_id"}, {Name: "settlement_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"settles", "confidence", "reasons", "status", "source", "previous_status", "updated_at",
+			}),
+		}).Create(&link).Error
+		if err != nil {
+			return fmt.Errorf("failed to link payment %s to %s: %w", link.SettlementID, link.ObligationID, err)
+		}
+		return nil
+	})
+}
+
+// Reject marks a link rejected, so the pair is never linked automatically again
+// A linked obligation still in the status the link moved it to gets its previous status back (cause manual)
+func (r *PaymentLinkRepository) Reject(ctx context.Context, linkID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var link models.PaymentLink
+		err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&link, "id = ?", linkID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPaymentLinkNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get payment link: %w", err)
+		}
+
+		now := time.Now()
+		if link.Status == models.PaymentLinkLinked && link.PreviousStatus != nil {
+			// Manual override: the previous status is restored even where the transition table has no way back
+			result := tx.Model(&models.Payment{}).
+				Where("id = ? AND status = ?", link.ObligationID, link.Settles).
+				Updates(map[string]interface{}{"status": *link.PreviousStatus, "updated_at": now})
+			if result.Error != nil {
+				return fmt.Errorf("failed to restore payment %s: %w", link.ObligationID, result.Error)
+			}
+			if result.RowsAffected == 1 {
+				err := recordStatusChange(tx, link.ObligationID, &link.Settles, *link.PreviousStatus, models.PaymentStatusCauseManual, nil)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = tx.Model(&models.PaymentLink{}).
+			Where("id = ?", link.ID).
+			Updates(map[string]interface{}{"status": models.PaymentLinkRejected, "updated_at": now}).Error
+		if err != nil {
+			return fmt.Errorf("failed to reject payment link: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetByID retrieves a payment link by ID
+func (r *PaymentLinkRepository) GetByID(ctx context.Context, linkID string) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+	result := r.db.WithContext(ctx).First(&link, "id = ?", linkID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentLinkNotFound
+		}
+		return nil, fmt.Errorf("failed to get payment link: %w", result.Error)
+	}
+	return &link, nil
+}
+
+// ListByAccount retrieves the payment links of an account with the given status (all when empty), newest first
+func (r *PaymentLinkRepository) ListByAccount(ctx context.Context, accountID, status string) ([]models.PaymentLink, error) {
+	query := r.db.WithContext(ctx).Where("account_id = ?", accountID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var links []models.PaymentLink
+	result := query.Order("created_at DESC").Find(&links)
+	return links, result.Error
+}
+
+// sameMerchant is the condition for payments to the same merchant as payment: the same normalised name, or the same
+// canonical merchant when it has one
+func sameMerchant(db *gorm.DB, payment models.Payment) *gorm.DB {
+	condition := db.Where("merchant_key = ?", payment.MerchantKey)
+	if payment.MerchantID != nil {
+		condition = condition.Or("merchant_id = ?", *payment.MerchantID)
+	}
+	return condition
+}
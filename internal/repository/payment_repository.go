@@ -2,11 +2,19 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/vipul43/kiwis-worker/internal/dedup"
 	"github.com/vipul43/kiwis-worker/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrPaymentNotFound = errors.New("payment not found")
+
 type PaymentRepository struct {
 	db *gorm.DB
 }
@@ -17,15 +25,228 @@ func NewPaymentRepository(db *gorm.DB) *PaymentRepository {
 
 // Create creates a new payment
 func (r *PaymentRepository) Create(ctx context.Context, payment models.Payment) error {
-	return r.db.WithContext(ctx).Create(&payment).Error
+	return r.BulkCreate(ctx, []models.Payment{payment})
 }
 
-// BulkCreate creates multiple payments in a single transaction
+// BulkCreate creates multiple payments in a single transaction, recording their initial status
 func (r *PaymentRepository) BulkCreate(ctx context.Context, payments []models.Payment) error {
 	if len(payments) == 0 {
 		return nil
 	}
-	return r.db.WithContext(ctx).Create(&payments).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payments).Error; err != nil {
+			return err
+		}
+		for _, payment := range payments {
+			if err := recordStatusChange(tx, payment.ID, nil, payment.Status, models.PaymentStatusCauseExtracted, payment.SourceMessageID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertResult counts the payments an Upsert created, and merged into stored payments
+type UpsertResult struct {
+	Created         int
+	Merged          int
+	CreatedPayments []models.Payment // Payments stored as new rows
+	MergedPayments  []models.Payment // Stored payments after the merge, once each (e.g. moved from due to paid)
+}
+
+// Payments returns the created and merged payments, the payments whose links may have changed
+func (r UpsertResult) Payments() []models.Payment {
+	payments := make([]models.Payment, 0, len(r.CreatedPayments)+len(r.MergedPayments))
+	payments = append(payments, r.CreatedPayments...)
+	return append(payments, r.MergedPayments...)
+}
+
+// Upsert stores payments prepared with dedup.Prepare, merging each into a stored payment of the same bill
+// (same fingerprint, or dedup.Matches) instead of creating a duplicate, see dedup.Merge
+// Runs in a single transaction, matched payments are locked so concurrent workers merge one after the other
+func (r *PaymentRepository) Upsert(ctx context.Context, payments []models.Payment) (UpsertResult, error) {
+	var result UpsertResult
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, payment := range payments {
+			if payment.Fingerprint == nil {
+				return fmt.Errorf("payment %s has no fingerprint", payment.ID)
+			}
+
+			existing, err := findDuplicate(tx, payment)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				created := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "fingerprint"}},
+					DoNothing: true,
+				}).Create(&payment)
+				if created.Error != nil {
+					return fmt.Errorf("failed to create payment: %w", created.Error)
+				}
+				if created.RowsAffected == 1 {
+					if err := recordStatusChange(tx, payment.ID, nil, payment.Status, models.PaymentStatusCauseExtracted, payment.SourceMessageID); err != nil {
+						return err
+					}
+					result.Created++
+					result.CreatedPayments = append(result.CreatedPayments, payment)
+					continue
+				}
+
+				// A concurrent worker stored the same payment first
+				if existing, err = findDuplicate(tx, payment); err != nil {
+					return err
+				}
+				if existing == nil {
+					return fmt.Errorf("payment with fingerprint %s not found after conflict", *payment.Fingerprint)
+				}
+			}
+
+			merged := dedup.Merge(*existing, payment, time.Now())
+			if err := tx.Save(&merged).Error; err != nil {
+				return fmt.Errorf("failed to merge payment %s: %w", existing.ID, err)
+			}
+			if merged.Status != existing.Status {
+				if err := recordStatusChange(tx, existing.ID, &existing.Status, merged.Status, models.PaymentStatusCauseMerged, payment.SourceMessageID); err != nil {
+					return err
+				}
+			}
+			result.Merged++
+			result.addMerged(merged)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// addMerged records a merged payment, replacing an earlier merge into the same payment
+func (r *UpsertResult) addMerged(payment models.Payment) {
+	for i := range r.MergedPayments {
+		if r.MergedPayments[i].ID == payment.ID {
+			r.MergedPayments[i] = payment
+			return
+		}
+	}
+	r.MergedPayments = append(r.MergedPayments, payment)
+}
+
+// AgeStatuses moves scheduled, upcoming and due payments whose date is within models.PaymentDueWindow or has
+// passed to due or overdue (models.AgedPaymentStatus), recording each change with cause aged
+// Moves at most limit payments (oldest date first) in a single transaction and returns how many were moved
+// Locked payments are skipped, a concurrent Upsert or worker is updating them; projected payments are left alone
+func (r *PaymentRepository) AgeStatuses(ctx context.Context, now time.Time, limit int) (int, error) {
+	moved := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var payments []models.Payment
+		err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("(status IN ? AND date <= ?) OR (status = ? AND date < ?)",
+				[]string{models.PaymentStatusScheduled, models.PaymentStatusUpcoming}, now.Add(models.PaymentDueWindow),
+				models.PaymentStatusDue, now).
+			Where("NOT projected").
+			Order("date ASC").
+			Limit(limit).
+			Find(&payments).Error
+		if err != nil {
+			return fmt.Errorf("failed to find payments to age: %w", err)
+		}
+
+		for _, payment := range payments {
+			status, ok := models.AgedPaymentStatus(payment.Status, payment.Date, now)
+			if !ok {
+				continue
+			}
+			err := tx.Model(&models.Payment{}).
+				Where("id = ?", payment.ID).
+				Updates(map[string]interface{}{"status": status, "updated_at": now}).Error
+			if err != nil {
+				return fmt.Errorf("failed to age payment %s: %w", payment.ID, err)
+			}
+			if err := recordStatusChange(tx, payment.ID, &payment.Status, status, models.PaymentStatusCauseAged, nil); err != nil {
+				return err
+			}
+			moved++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// GetStatusHistory retrieves the status changes of a payment, oldest first
+func (r *PaymentRepository) GetStatusHistory(ctx context.Context, paymentID string) ([]models.PaymentStatusHistory, error) {
+	var history []models.PaymentStatusHistory
+	result := r.db.WithContext(ctx).
+		Where("payment_id = ?", paymentID).
+		Order("created_at ASC").
+		Find(&history)
+	return history, result.Error
+}
+
+// recordStatusChange adds a status change of a payment to payment_status_history (from is nil for a new payment)
+func recordStatusChange(tx *gorm.DB, paymentID string, from *string, to, cause string, sourceMessageID *string) error {
+	entry := models.PaymentStatusHistory{
+		ID:              uuid.New().String(),
+		PaymentID:       paymentID,
+		FromStatus:      from,
+		ToStatus:        to,
+		Cause:           cause,
+		SourceMessageID: sourceMessageID,
+		CreatedAt:       time.Now(),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record status change of payment %s: %w", paymentID, err)
+	}
+	return nil
+}
+
+// findDuplicate locks and returns the stored payment the given one duplicates, nil when there is none
+// An exact fingerprint match wins, then the closest date within dedup.DateWindow
+func findDuplicate(tx *gorm.DB, payment models.Payment) (*models.Payment, error) {
+	similar := tx.Where("merchant_key = ? AND currency = ? AND amount = ? AND date BETWEEN ? AND ?",
+		payment.MerchantKey, payment.Currency, payment.Amount,
+		payment.Date.Add(-dedup.DateWindow), payment.Date.Add(dedup.DateWindow))
+	if payment.SourceMessageID != nil {
+		// Identical charges of one email are different payments, told apart by their fingerprints
+		similar = similar.Where("source_message_id IS NULL OR source_message_id <> ? OR fingerprint IS NULL", *payment.SourceMessageID)
+	}
+	query := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("account_id = ?", payment.AccountID).
+		Where(tx.Where("fingerprint = ?", *payment.Fingerprint).Or(similar))
+	if payment.ExternalReference != nil {
+		// Payments with different bill references are different payments
+		query = query.Where("external_reference IS NULL OR LOWER(external_reference) = LOWER(?)", *payment.ExternalReference)
+	}
+
+	var existing models.Payment
+	err := query.
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "fingerprint = ? DESC, ABS(EXTRACT(EPOCH FROM (date - ?)))",
+			Vars:               []interface{}{*payment.Fingerprint, payment.Date},
+			WithoutParentheses: true,
+		}}).
+		Take(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate payment: %w", err)
+	}
+	return &existing, nil
+}
+
+// GetByID retrieves a payment by ID
+func (r *PaymentRepository) GetByID(ctx context.Context, paymentID string) (*models.Payment, error) {
+	var payment models.Payment
+	result := r.db.WithContext(ctx).First(&payment, "id = ?", paymentID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", result.Error)
+	}
+	return &payment, nil
 }
 
 // GetByAccountID retrieves all payments for an account
@@ -37,3 +258,21 @@ func (r *PaymentRepository) GetByAccountID(ctx context.Context, accountID string
 		Find(&payments)
 	return payments, result.Error
 }
+
+// GetBySourceMessage retrieves the payments extracted from an email
+func (r *PaymentRepository) GetBySourceMessage(ctx context.Context, accountID, messageID string) ([]models.Payment, error) {
+	var payments []models.Payment
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND source_message_id = ?", accountID, messageID).
+		Order("date DESC").
+		Find(&payments)
+	return payments, result.Error
+}
+
+// DeleteBySourceMessage deletes the payments extracted from an email (e.g. before reprocessing it)
+func (r *PaymentRepository) DeleteBySourceMessage(ctx context.Context, accountID, messageID string) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND source_message_id = ?", accountID, messageID).
+		Delete(&models.Payment{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RecurringPaymentRepository struct {
+	db *gorm.DB
+}
+
+func NewRecurringPaymentRepository(db *gorm.DB) *RecurringPaymentRepository {
+	return &RecurringPaymentRepository{db: db}
+}
+
+// ListAccountIDs retrieves the IDs of accounts with payments extracted from emails
+func (r *RecurringPaymentRepository) ListAccountIDs(ctx context.Context) ([]string, error) {
+	var accountIDs []string
+	result := r.db.WithContext(ctx).
+		Model(&models.Payment{}).
+		Where("NOT projected").
+		Distinct().
+		Pluck("account_id", &accountIDs)
+	return accountIDs, result.Error
+}
+
+// GetHistory retrieves the payments of an account extracted from emails, oldest first
+func (r *RecurringPaymentRepository) GetHistory(ctx context.Context, accountID string) ([]models.Payment, error) {
+	var payments []models.Payment
+	result := r.db.WithContext(ctx).
+		Where("account_id = ? AND NOT projected", accountID).
+		Order("date ASC").
+		Find(&payments)
+	return payments, result.Error
+}
+
+// Save stores a detected series, one per account, canonical merchant (merchant key without one) and currency,
+// and returns its ID
+// Member payments are assigned to the series (and its recurrence where they have none); the series' projected
+// payments are replaced by projections, unchanged ones are kept as they have the same fingerprint
+func (r *RecurringPaymentRepository) Save(ctx context.Context, series models.RecurringPayment, memberIDs []string, projections []models.Payment) (string, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		series.UpdatedAt = time.Now()
+
+		// Conflict target and lookup of the stored series, see the partial unique indexes of migration 000025
+		merchant := clause.Column{Name: "merchant_key"}
+		target := "merchant_id IS NULL"
+		stored := tx.Where("account_id = ? AND merchant_key = ? AND currency = ? AND merchant_id IS NULL",
+			series.AccountID, series.MerchantKey, series.Currency)
+		if series.MerchantID != nil {
+			merchant = clause.Column{Name: "merchant_id"}
+			target = "merchant_id IS NOT NULL"
+			stored = tx.Where("account_id = ? AND merchant_id = ? AND currency = ?",
+				series.AccountID, *series.MerchantID, series.Currency)
+		}
+
+		err := tx.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "account_id"}, merchant, {Name: "currency"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: target}}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"merchant", "merchant_key", "recurrence", "typical_amount", "last_amount", "fixed_amount",
+				"previous_amount", "price_changed_at", "day_of_month", "occurrences", "confidence", "last_date",
+				"next_date", "active", "updated_at",
+			}),
+		}).Create(&series).Error
+		if err != nil {
+			return fmt.Errorf("failed to save recurring payment: %w", err)
+		}
+
+		// The ID of the stored series when it already existed
+		var existing models.RecurringPayment
+		if err := tx.Select("id").Where(stored).Take(&existing).Error; err != nil {
+			return fmt.Errorf("failed to get recurring payment: %w", err)
+		}
+		series.ID = existing.ID
+
+		if len(memberIDs) > 0 {
+			err := tx.Model(&models.Payment{}).
+				Where("id IN ?", memberIDs).
+				Update("recurring_payment_id", series.ID).Error
+			if err != nil {
+				return fmt.Errorf("failed to assign payments to recurring payment %s: %w", series.ID, err)
+			}
+			err = tx.Model(&models.Payment{}).
+				Where("id IN ? AND recurrence IS NULL", memberIDs).
+				Update("recurrence", series.Recurrence).Error
+			if err != nil {
+				return fmt.Errorf("failed to set recurrence of payments: %w", err)
+			}
+		}
+
+		return replaceProjections(tx, series.ID, projections)
+	})
+	if err != nil {
+		return "", err
+	}
+	return series.ID, nil
+}
+
+// DeactivateExcept marks the series of an account not in keepIDs inactive (no longer detected) and deletes
+// their projected payments
+func (r *RecurringPaymentRepository) DeactivateExcept(ctx context.Context, accountID string, keepIDs []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.RecurringPayment{}).Where("account_id = ? AND active", accountID)
+		if len(keepIDs) > 0 {
+			query = query.Where("id NOT IN ?", keepIDs)
+		}
+		var seriesIDs []string
+		if err := query.Pluck("id", &seriesIDs).Error; err != nil {
+			return fmt.Errorf("failed to find recurring payments: %w", err)
+		}
+		if len(seriesIDs) == 0 {
+			return nil
+		}
+
+		err := tx.Model(&models.RecurringPayment{}).
+			Where("id IN ?", seriesIDs).
+			Updates(map[string]interface{}{"active": false, "next_date": nil, "updated_at": time.Now()}).Error
+		if err != nil {
+			return fmt.Errorf("failed to deactivate recurring payments: %w", err)
+		}
+		err = tx.Where("recurring_payment_id IN ? AND projected AND status = ?", seriesIDs, models.PaymentStatusScheduled).
+			Delete(&models.Payment{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to delete projected payments: %w", err)
+		}
+		return nil
+	})
+}
+
+// replaceProjections deletes the projected payments of a series not among projections and creates the new ones,
+// recording their initial status with cause projected
+// Projections are matched by fingerprint; one colliding with a payment extracted from an email is left out
+func replaceProjections(tx *gorm.DB, seriesID string, projections []models.Payment) error {
+	fingerprints := make([]string, 0, len(projections))
+	for _, projection := range projections {
+		fingerprints = append(fingerprints, *projection.Fingerprint)
+	}
+
+	stale := tx.Where("recurring_payment_id = ? AND projected AND status = ?", seriesID, models.PaymentStatusScheduled)
+	if len(fingerprints) > 0 {
+		stale = stale.Where("fingerprint NOT IN ?", fingerprints)
+	}
+	if err := stale.Delete(&models.Payment{}).Error; err != nil {
+		return fmt.Errorf("failed to delete projected payments: %w", err)
+	}
+
+	for _, projection := range projections {
+		projection.RecurringPaymentID = &seriesID
+		created := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "fingerprint"}},
+			DoNothing: true,
+		}).Create(&projection)
+		if created.Error != nil {
+			return fmt.Errorf("failed to create projected payment: %w", created.Error)
+		}
+		if created.RowsAffected == 1 {
+			err := recordStatusChange(tx, projection.ID, nil, projection.Status, models.PaymentStatusCauseProjected, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -36,8 +36,12 @@ func (p *AccountProcessor) ProcessAccount(ctx context.Context, accountID string)
 		return fmt.Errorf("failed to get account: %w", err)
 	}
 
-	// Validate tokens exist
-	if account.AccessToken == nil {
+	// Validate credentials exist (IMAP accounts use an app password or an access token)
+	if account.ProviderID == models.ProviderIMAP {
+		if account.Password == nil && account.AccessToken == nil {
+			return fmt.Errorf("account missing IMAP credentials")
+		}
+	} else if account.AccessToken == nil {
 		return fmt.Errorf("account missing access token")
 	}
 
@@ -68,6 +68,38 @@ func TestAccountProcessor_ProcessAccount_MissingToken(t *testing.T) {
 	}
 }
 
+func TestAccountProcessor_ProcessAccount_IMAPCredentials(t *testing.T) {
+	appPassword := "app-password"
+	tests := []struct {
+		name     string
+		password *string
+		wantErr  bool
+	}{
+		{"app password", &appPassword, false},
+		{"no credentials", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &mockAccountRepository{
+				getByIDFunc: func(ctx context.Context, accountID string) (*models.Account, error) {
+					return &models.Account{
+						ID:         accountID,
+						ProviderID: models.ProviderIMAP,
+						UserID:     "user-123",
+						Password:   tt.password,
+					}, nil
+				},
+			}
+
+			err := NewAccountProcessor(mockRepo).ProcessAccount(context.Background(), "acc-123")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ProcessAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestAccountProcessor_ProcessAccount_AccountNotFound(t *testing.T) {
 	mockRepo := &mockAccountRepository{
 		getByIDFunc: func(ctx context.Context, accountID string) (*models.Account, error) {
@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,21 +17,72 @@ const (
 	MaxEmailsPerAccount = 10000 // Fetch max 10,000 emails per account
 	EmailsPerPage       = 50    // Fetch 50 emails per batch
 	InitialSyncDays     = 365   // Fetch last 1 year of emails for initial sync
+	HistoryFallbackDays = 30    // Query window when the mailbox history is too old to resume from
+	HistoryFallbackMax  = 500   // Max emails fetched by the history fallback query sync
+)
+
+// WatchRenewBefore is how long before expiry a Gmail watch is renewed (watches last 7 days)
+const WatchRenewBefore = 24 * time.Hour
+
+var (
+	// ErrHistoryTooOld is returned by MailSource.FetchHistory when the start historyId is no longer available
+	// (Gmail HTTP 404, IMAP UIDVALIDITY changed)
+	ErrHistoryTooOld = errors.New("mailbox history too old")
+
+	// ErrWatchUnsupported is returned by MailSource.Watch for sources without push notifications (IMAP)
+	ErrWatchUnsupported = errors.New("push notifications not supported")
 )
 
 type EmailProcessor struct {
-	accountRepo      *repository.AccountRepository
 	emailSyncJobRepo *repository.EmailSyncJobRepository
 	llmSyncJobRepo   *repository.LLMSyncJobRepository
-	gmailClient      GmailClient // Interface for Gmail API
+	mailSource       MailSource // Mailbox provider (Gmail, IMAP)
+	pubSubTopic      string     // Optional: push notifications are only set up when set
+}
+
+// MailSource is a mailbox provider the pipeline syncs from (Gmail API, IMAP)
+// Calls are made as the given account; the source loads (and for OAuth, refreshes and persists) its credentials
+type MailSource interface {
+	FetchMessageIDs(ctx context.Context, accountID string, query SearchQuery, maxResults int, pageToken string) (*MessageIDFetchResult, error)
+	FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*BatchFetchResult, error)
+	FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error)
+	FetchHistory(ctx context.Context, accountID string, startHistoryID string, query SearchQuery, pageToken string) (*HistoryFetchResult, error)
+	GetProfile(ctx context.Context, accountID string) (*MailboxProfile, error)
+	Watch(ctx context.Context, accountID string, topicName string) (*WatchResult, error)
+}
+
+// SearchQuery selects the emails fetched by a query sync, and the messages kept from a history sync (Keywords only)
+// Each source translates it to its own search syntax (Gmail query string, IMAP SEARCH)
+type SearchQuery struct {
+	After    time.Time // Only emails received after this day
+	Keywords []string  // Emails matching any keyword, no filter when empty
+}
+
+// Matches reports whether any of the texts (e.g. subject and snippet) contains one of the keywords, ignoring case
+// Used by sources that cannot search the messages of a history sync and filter them by their summary instead
+func (q SearchQuery) Matches(texts ...string) bool {
+	if len(q.Keywords) == 0 {
+		return true
+	}
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, keyword := range q.Keywords {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// GmailClient interface for Gmail API operations
-type GmailClient interface {
-	FetchMessageIDs(ctx context.Context, accessToken string, query string, maxResults int, pageToken string) (*MessageIDFetchResult, error)
-	FetchEmailByID(ctx context.Context, accessToken string, messageID string) (*EmailMessage, error)
-	FetchEmails(ctx context.Context, accessToken string, query string, maxResults int, pageToken string) (*EmailFetchResult, error)
-	RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenRefreshResult, error)
+// PaymentKeywords is the keyword filter for query syncs
+// Comprehensive list to reduce LLM costs while maintaining high coverage of payment emails
+var PaymentKeywords = []string{
+	"invoice", "bill", "payment", "paid", "pay", "due", "overdue", "outstanding", "balance", "amount", "total",
+	"charge", "charged", "subscription", "renewal", "renew", "recurring", "membership", "plan", "premium",
+	"upgrade", "downgrade", "receipt", "statement", "confirmation", "order", "purchase", "transaction", "refund",
+	"reminder", "notice", "alert", "expiring", "expires", "expiry", "deadline", "emi", "installment", "instalment",
+	"booking", "reservation", "renewing", "billing", "billed", "autopay", "auto-pay",
 }
 
 type MessageIDFetchResult struct {
@@ -38,6 +91,26 @@ type MessageIDFetchResult struct {
 	TotalFetched  int
 }
 
+// HistoryFetchResult holds message IDs added since the start history ID
+// HistoryID is the mailbox's current history ID (the start point for the next sync)
+// History IDs are opaque to the pipeline: a Gmail historyId, an IMAP UIDVALIDITY and UID, a Graph delta link
+type HistoryFetchResult struct {
+	MessageIDs    []string
+	NextPageToken string
+	HistoryID     string
+}
+
+type MailboxProfile struct {
+	EmailAddress string
+	HistoryID    string
+}
+
+// WatchResult holds the outcome of a users.watch call
+type WatchResult struct {
+	HistoryID  string
+	Expiration time.Time
+}
+
 type EmailFetchResult struct {
 	Messages      []EmailMessage
 	NextPageToken string
@@ -61,26 +134,27 @@ type EmailMessage struct {
 	RawHeaders     map[string]any
 	RawPayload     map[string]any
 	HasAttachments bool
-	Attachments    []map[string]any
+	Attachments    []map[string]any // filename, mimeType, size and attachmentId (see FetchAttachment) or inline data ([]byte)
 }
 
-type TokenRefreshResult struct {
-	AccessToken  string
-	ExpiresAt    time.Time
-	RefreshToken string // May be same or new
+// BatchFetchResult holds emails fetched by message ID
+// Messages that could not be fetched are in Errors instead of Messages
+type BatchFetchResult struct {
+	Messages map[string]*EmailMessage
+	Errors   map[string]error
 }
 
 func NewEmailProcessor(
-	accountRepo *repository.AccountRepository,
 	emailSyncJobRepo *repository.EmailSyncJobRepository,
 	llmSyncJobRepo *repository.LLMSyncJobRepository,
-	gmailClient GmailClient,
+	mailSource MailSource,
+	pubSubTopic string,
 ) *EmailProcessor {
 	return &EmailProcessor{
-		accountRepo:      accountRepo,
 		emailSyncJobRepo: emailSyncJobRepo,
 		llmSyncJobRepo:   llmSyncJobRepo,
-		gmailClient:      gmailClient,
+		mailSource:       mailSource,
+		pubSubTopic:      pubSubTopic,
 	}
 }
 
@@ -90,36 +164,77 @@ func (p *EmailProcessor) ProcessEmailSyncJob(ctx context.Context, job *models.Em
 	log.Printf("Processing email sync job %s for account %s (type: %s, fetched: %d)",
 		job.ID, job.AccountID, job.SyncType, job.EmailsFetched)
 
-	// Fetch account details
-	account, err := p.accountRepo.GetByID(ctx, job.AccountID)
+	// Initial sync backfills with a search query, later syncs only fetch what changed (History API)
+	var err error
+	if job.SyncType == models.SyncTypeInitial {
+		err = p.syncByQuery(ctx, job)
+	} else {
+		err = p.syncByHistory(ctx, job)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get account: %w", err)
+		return err
 	}
 
-	// Validate tokens exist
-	if account.AccessToken == nil || account.RefreshToken == nil {
-		return fmt.Errorf("account missing tokens")
+	// Start or renew push notifications once the job has caught up
+	if job.PageToken == nil {
+		return p.ensureWatch(ctx, job)
 	}
+	return nil
+}
 
-	// Check if access token is expired and refresh if needed
-	accessToken := *account.AccessToken
-	if p.isTokenExpired(account.AccessTokenExpiresAt) {
-		log.Printf("Access token expired for account %s, refreshing...", job.AccountID)
-		newToken, err := p.refreshToken(ctx, account)
-		if err != nil {
-			return fmt.Errorf("failed to refresh token: %w", err)
+// ensureWatch starts Gmail push notifications for the job's mailbox, or renews them within WatchRenewBefore of expiry
+// Does nothing when no Pub/Sub topic is configured or the mailbox's source has no push notifications (IMAP, Microsoft
+// Graph), the job is then left without a watch and keeps syncing periodically
+func (p *EmailProcessor) ensureWatch(ctx context.Context, job *models.EmailSyncJob) error {
+	if p.pubSubTopic == "" {
+		return nil
+	}
+	if job.WatchExpiration != nil && time.Until(*job.WatchExpiration) > WatchRenewBefore {
+		return nil
+	}
+
+	watch, err := p.mailSource.Watch(ctx, job.AccountID, p.pubSubTopic)
+	if errors.Is(err, ErrWatchUnsupported) {
+		return nil // Incremental sync keeps the mailbox up to date instead
+	}
+	if err != nil {
+		return fmt.Errorf("failed to watch mailbox: %w", err)
+	}
+
+	// Push notifications only carry the email address, so store it to find the job later
+	profile, err := p.mailSource.GetProfile(ctx, job.AccountID)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	if err := p.emailSyncJobRepo.UpdateWatch(ctx, job.ID, emailClaimant(job), profile.EmailAddress, watch.Expiration); err != nil {
+		return err
+	}
+	job.EmailAddress = &profile.EmailAddress
+	job.WatchExpiration = &watch.Expiration
+
+	log.Printf("Watching mailbox for email sync job %s until %s", job.ID, watch.Expiration.Format(time.RFC3339))
+	return nil
+}
+
+// syncByQuery fetches the next page of the initial (historical) sync
+func (p *EmailProcessor) syncByQuery(ctx context.Context, job *models.EmailSyncJob) error {
+	// Record the historyId before the first page so mail arriving during the backfill is picked up by incremental sync
+	if job.HistoryID == nil && job.PageToken == nil {
+		if err := p.recordHistoryID(ctx, job); err != nil {
+			return err
 		}
-		accessToken = newToken
 	}
 
-	// Build Gmail query based on sync type
-	query := p.buildGmailQuery(*job)
+	// Search the initial sync window
+	query := p.buildSearchQuery(job.ID, time.Now().AddDate(0, 0, -InitialSyncDays))
 
 	// Determine how many emails to fetch in this batch
 	remainingEmails := MaxEmailsPerAccount - job.EmailsFetched
 	if remainingEmails <= 0 {
 		log.Printf("Account %s has reached max emails limit (%d)", job.AccountID, MaxEmailsPerAccount)
-		return nil // Job is complete
+		job.PageToken = nil // Job is complete
+		return nil
 	}
 
 	batchSize := EmailsPerPage
@@ -127,7 +242,7 @@ func (p *EmailProcessor) ProcessEmailSyncJob(ctx context.Context, job *models.Em
 		batchSize = remainingEmails
 	}
 
-	// Fetch emails from Gmail
+	// Fetch message IDs from the mail source
 	pageToken := ""
 	if job.PageToken != nil {
 		pageToken = *job.PageToken
@@ -135,7 +250,7 @@ func (p *EmailProcessor) ProcessEmailSyncJob(ctx context.Context, job *models.Em
 
 	log.Printf("Fetching %d message IDs for account %s (page_token: %s)", batchSize, job.AccountID, pageToken)
 
-	result, err := p.gmailClient.FetchMessageIDs(ctx, accessToken, query, batchSize, pageToken)
+	result, err := p.mailSource.FetchMessageIDs(ctx, job.AccountID, query, batchSize, pageToken)
 	if err != nil {
 		return fmt.Errorf("failed to fetch message IDs: %w", err)
 	}
@@ -143,107 +258,186 @@ func (p *EmailProcessor) ProcessEmailSyncJob(ctx context.Context, job *models.Em
 	log.Printf("Fetched %d message IDs for account %s", len(result.MessageIDs), job.AccountID)
 
 	// Create LLM sync jobs for each message ID
-	if len(result.MessageIDs) > 0 {
-		llmJobs := make([]models.LLMSyncJob, 0, len(result.MessageIDs))
-		now := time.Now()
-
-		for _, messageID := range result.MessageIDs {
-			llmJob := models.LLMSyncJob{
-				ID:           uuid.New().String(),
-				AccountID:    job.AccountID,
-				MessageID:    messageID,
-				Status:       models.LLMStatusPending,
-				LastSyncedAt: nil, // NULL = new job, gets priority in round-robin
-				Attempts:     0,
-				CreatedAt:    now,
-				UpdatedAt:    now,
-			}
-			llmJobs = append(llmJobs, llmJob)
-		}
-
-		// Bulk create LLM sync jobs
-		if err := p.llmSyncJobRepo.BulkCreate(ctx, llmJobs); err != nil {
-			return fmt.Errorf("failed to create LLM sync jobs: %w", err)
-		}
-		log.Printf("Created %d LLM sync jobs for account %s", len(llmJobs), job.AccountID)
+	if err := p.createLLMSyncJobs(ctx, job.AccountID, result.MessageIDs); err != nil {
+		return err
 	}
 
 	// Update job progress
-	newEmailsFetched := job.EmailsFetched + len(result.MessageIDs)
 	var nextPageToken *string
 	if result.NextPageToken != "" {
 		nextPageToken = &result.NextPageToken
 	}
+	return p.updateProgress(ctx, job, len(result.MessageIDs), nextPageToken)
+}
+
+// syncByHistory fetches messages added since the job's historyId (users.history.list)
+// Only messages matching the payment keywords get an LLM sync job, like in query syncs
+// Falls back to a bounded query sync when there is no usable historyId
+func (p *EmailProcessor) syncByHistory(ctx context.Context, job *models.EmailSyncJob) error {
+	if job.HistoryID == nil {
+		// Synced before history tracking existed
+		log.Printf("Email sync job %s has no history ID, falling back to query sync", job.ID)
+		return p.syncByFallbackQuery(ctx, job)
+	}
 
-	err = p.emailSyncJobRepo.UpdateProgress(ctx, job.ID, newEmailsFetched, nextPageToken)
+	pageToken := ""
+	if job.PageToken != nil {
+		pageToken = *job.PageToken
+	}
+
+	log.Printf("Fetching history for account %s (start_history_id: %s, page_token: %s)", job.AccountID, *job.HistoryID, pageToken)
+
+	query := SearchQuery{Keywords: PaymentKeywords}
+	result, err := p.mailSource.FetchHistory(ctx, job.AccountID, *job.HistoryID, query, pageToken)
+	if errors.Is(err, ErrHistoryTooOld) {
+		log.Printf("History ID %s too old for account %s, falling back to query sync", *job.HistoryID, job.AccountID)
+		return p.syncByFallbackQuery(ctx, job)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update job progress: %w", err)
+		return fmt.Errorf("failed to fetch history: %w", err)
 	}
 
-	// Update the job object in-place with new values (since DB update succeeded)
-	job.EmailsFetched = newEmailsFetched
-	job.PageToken = nextPageToken
+	log.Printf("Fetched %d new message IDs from history for account %s", len(result.MessageIDs), job.AccountID)
 
-	log.Printf("Updated job %s: emails_fetched=%d, has_more=%v", job.ID, newEmailsFetched, nextPageToken != nil)
+	if err := p.createLLMSyncJobs(ctx, job.AccountID, result.MessageIDs); err != nil {
+		return err
+	}
+
+	var nextPageToken *string
+	if result.NextPageToken != "" {
+		nextPageToken = &result.NextPageToken
+	}
+	if err := p.updateProgress(ctx, job, len(result.MessageIDs), nextPageToken); err != nil {
+		return err
+	}
+
+	// Advance the start point only once every page has been fetched
+	if nextPageToken == nil && result.HistoryID != "" {
+		if err := p.emailSyncJobRepo.UpdateHistoryID(ctx, job.ID, emailClaimant(job), result.HistoryID); err != nil {
+			return err
+		}
+		job.HistoryID = &result.HistoryID
+	}
 
 	return nil
 }
 
-// isTokenExpired checks if access token is expired or will expire within 5 minutes
-func (p *EmailProcessor) isTokenExpired(expiresAt *time.Time) bool {
-	if expiresAt == nil {
-		return true // Assume expired if no expiry time
+// syncByFallbackQuery catches up with a query sync over the last HistoryFallbackDays (max HistoryFallbackMax emails)
+// Messages that already have LLM sync jobs are skipped by BulkCreate, so overlap is harmless
+func (p *EmailProcessor) syncByFallbackQuery(ctx context.Context, job *models.EmailSyncJob) error {
+	// Take the new start point first so nothing arriving during the fallback is missed
+	profile, err := p.mailSource.GetProfile(ctx, job.AccountID)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
 	}
-	return time.Now().Add(5 * time.Minute).After(*expiresAt)
-}
+	historyID := profile.HistoryID
+
+	query := p.buildSearchQuery(job.ID, time.Now().AddDate(0, 0, -HistoryFallbackDays))
+
+	fetched := 0
+	pageToken := ""
+	for fetched < HistoryFallbackMax {
+		batchSize := min(EmailsPerPage, HistoryFallbackMax-fetched)
+		result, err := p.mailSource.FetchMessageIDs(ctx, job.AccountID, query, batchSize, pageToken)
+		if err != nil {
+			return fmt.Errorf("failed to fetch message IDs: %w", err)
+		}
 
-// refreshToken refreshes the access token and updates the account
-func (p *EmailProcessor) refreshToken(ctx context.Context, account *models.Account) (string, error) {
-	if account.RefreshToken == nil {
-		return "", fmt.Errorf("no refresh token available")
+		if err := p.createLLMSyncJobs(ctx, job.AccountID, result.MessageIDs); err != nil {
+			return err
+		}
+		fetched += len(result.MessageIDs)
+
+		if result.NextPageToken == "" {
+			break
+		}
+		pageToken = result.NextPageToken
 	}
 
-	result, err := p.gmailClient.RefreshAccessToken(ctx, *account.RefreshToken)
-	if err != nil {
-		return "", fmt.Errorf("failed to refresh token: %w", err)
+	log.Printf("Fallback query sync fetched %d message IDs for account %s", fetched, job.AccountID)
+
+	if err := p.updateProgress(ctx, job, fetched, nil); err != nil {
+		return err
+	}
+	if err := p.emailSyncJobRepo.UpdateHistoryID(ctx, job.ID, emailClaimant(job), historyID); err != nil {
+		return err
 	}
+	job.HistoryID = &historyID
 
-	// Update account with new tokens
-	err = p.accountRepo.UpdateTokens(ctx, account.ID, result.AccessToken, result.RefreshToken, result.ExpiresAt)
+	return nil
+}
+
+// recordHistoryID stores the mailbox's current historyId on the job
+func (p *EmailProcessor) recordHistoryID(ctx context.Context, job *models.EmailSyncJob) error {
+	profile, err := p.mailSource.GetProfile(ctx, job.AccountID)
 	if err != nil {
-		return "", fmt.Errorf("failed to update tokens in database: %w", err)
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+	historyID := profile.HistoryID
+	if err := p.emailSyncJobRepo.UpdateHistoryID(ctx, job.ID, emailClaimant(job), historyID); err != nil {
+		return err
+	}
+	job.HistoryID = &historyID
+	log.Printf("Recorded history ID %s for email sync job %s", historyID, job.ID)
+	return nil
+}
+
+// createLLMSyncJobs creates a pending LLM sync job for each message ID (duplicates are skipped)
+func (p *EmailProcessor) createLLMSyncJobs(ctx context.Context, accountID string, messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
 	}
 
-	log.Printf("Token refreshed for account %s, expires at %s", account.ID, result.ExpiresAt)
+	llmJobs := make([]models.LLMSyncJob, 0, len(messageIDs))
+	now := time.Now()
+
+	for _, messageID := range messageIDs {
+		llmJob := models.LLMSyncJob{
+			ID:           uuid.New().String(),
+			AccountID:    accountID,
+			MessageID:    messageID,
+			Status:       models.LLMStatusPending,
+			LastSyncedAt: nil, // NULL = new job, gets priority in round-robin
+			Attempts:     0,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		llmJobs = append(llmJobs, llmJob)
+	}
 
-	return result.AccessToken, nil
+	// Bulk create LLM sync jobs
+	if err := p.llmSyncJobRepo.BulkCreate(ctx, llmJobs); err != nil {
+		return fmt.Errorf("failed to create LLM sync jobs: %w", err)
+	}
+	log.Printf("Created %d LLM sync jobs for account %s", len(llmJobs), accountID)
+	return nil
 }
 
-// buildGmailQuery builds the Gmail API query string based on sync type
-// Fetches emails in REVERSE chronological order (newest first) for recent payment dues
-// Only fetches emails where user is in "To" field (excludes CC'ed emails)
-// Excludes social category (pure noise with no payment emails)
-// Uses comprehensive keyword filter to reduce LLM costs while maintaining high coverage
-func (p *EmailProcessor) buildGmailQuery(job models.EmailSyncJob) string {
-	// Base query: only emails directly to user (not CC), exclude spam, sent, and social
-	// deliveredto:me ensures only emails where user is primary recipient
-	// -category:social excludes social media notifications (Facebook, LinkedIn, Twitter, etc.)
-	// Keyword filter: comprehensive list to catch all payment-related emails
-	baseQuery := "in:inbox -in:spam -category:social deliveredto:me {invoice OR bill OR payment OR paid OR pay OR due OR overdue OR outstanding OR balance OR amount OR total OR charge OR charged OR subscription OR renewal OR renew OR recurring OR membership OR plan OR premium OR upgrade OR downgrade OR receipt OR statement OR confirmation OR order OR purchase OR transaction OR refund OR reminder OR notice OR alert OR expiring OR expires OR expiry OR deadline OR emi OR installment OR instalment OR booking OR reservation OR renewing OR billing OR billed OR autopay OR auto-pay}"
-
-	// Add time filter based on sync type
-	if job.SyncType == models.SyncTypeInitial {
-		// Initial sync: fetch last 1 year (Gmail returns newest first by default)
-		afterDate := time.Now().AddDate(-1, 0, 0).Format("2006/01/02")
-		baseQuery += fmt.Sprintf(" after:%s", afterDate)
-	} else if job.LastSyncedAt != nil {
-		// Incremental sync: fetch emails after last sync
-		afterDate := job.LastSyncedAt.Format("2006/01/02")
-		baseQuery += fmt.Sprintf(" after:%s", afterDate)
+// updateProgress saves job progress and updates the job object in-place (since DB update succeeded)
+func (p *EmailProcessor) updateProgress(ctx context.Context, job *models.EmailSyncJob, fetched int, nextPageToken *string) error {
+	newEmailsFetched := job.EmailsFetched + fetched
+
+	err := p.emailSyncJobRepo.UpdateProgress(ctx, job.ID, emailClaimant(job), newEmailsFetched, nextPageToken)
+	if err != nil {
+		return fmt.Errorf("failed to update job progress: %w", err)
 	}
 
-	log.Printf("Gmail query for job %s: %s (newest first, To: only, no social, keyword filtered)", job.ID, baseQuery)
-	return baseQuery
+	job.EmailsFetched = newEmailsFetched
+	job.PageToken = nextPageToken
+
+	log.Printf("Updated job %s: emails_fetched=%d, has_more=%v", job.ID, newEmailsFetched, nextPageToken != nil)
+
+	return nil
+}
+
+// buildSearchQuery builds the search for payment emails received after the given time
+// Sources return the newest emails first so recent payment dues are processed first
+func (p *EmailProcessor) buildSearchQuery(jobID string, after time.Time) SearchQuery {
+	log.Printf("Search for job %s: after %s, %d payment keywords", jobID, after.Format("2006-01-02"), len(PaymentKeywords))
+	return SearchQuery{
+		After:    after,
+		Keywords: PaymentKeywords,
+	}
 }
 
 // CreateInitialEmailSyncJob creates an initial email sync job for a new account
@@ -269,3 +463,11 @@ func (p *EmailProcessor) CreateInitialEmailSyncJob(ctx context.Context, accountI
 	log.Printf("Created initial email sync job %s for account %s (will be picked first)", job.ID, accountID)
 	return nil
 }
+
+// emailClaimant returns the worker that claimed the job, progress updates only apply while it holds the job's lease
+func emailClaimant(job *models.EmailSyncJob) string {
+	if job.ClaimedBy == nil {
+		return ""
+	}
+	return *job.ClaimedBy
+}
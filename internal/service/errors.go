@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRateLimitDelay is the reschedule delay when the provider rate limits without a Retry-After
+const DefaultRateLimitDelay = 1 * time.Minute
+
+var (
+	// ErrRateLimited is returned by MailSource when the account's quota is exhausted (HTTP 429 or 403 rateLimitExceeded)
+	// Errors matching it are *RateLimitError and carry the delay the provider asked for
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrTokenRevoked is returned by MailSource when the account's credentials are revoked or expired (invalid_grant)
+	// The job can only succeed once the user re-authenticates, so it is marked dead and revived by the account update
+	ErrTokenRevoked = errors.New("token revoked")
+
+	// ErrNotFound is returned by MailSource when the requested resource does not exist (HTTP 404), e.g. a deleted message
+	ErrNotFound = errors.New("resource not found")
+)
+
+// RateLimitError is a rate limit response, RetryAfter is how long to wait before calling the provider again for the account
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v (retry after %s): %v", ErrRateLimited, e.RetryAfter, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrRateLimited) match any RateLimitError
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RescheduleDelay returns how long a job that failed with err should wait before running again
+// Returns false for errors that should count as a failed attempt (backoff and dead-lettering)
+// Rate limits are not the job's fault, so they reschedule without using up retries
+func RescheduleDelay(err error) (time.Duration, bool) {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		if rateLimitErr.RetryAfter > 0 {
+			return rateLimitErr.RetryAfter, true
+		}
+		return DefaultRateLimitDelay, true
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return DefaultRateLimitDelay, true
+	}
+	return 0, false
+}
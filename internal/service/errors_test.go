@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimitError_Is(t *testing.T) {
+	err := fmt.Errorf("failed to list messages: %w", &RateLimitError{RetryAfter: time.Minute, Err: errors.New("429")})
+
+	if !errors.Is(err, ErrRateLimited) {
+		t.Error("expected wrapped RateLimitError to match ErrRateLimited")
+	}
+	if errors.Is(err, ErrTokenRevoked) {
+		t.Error("expected RateLimitError not to match ErrTokenRevoked")
+	}
+}
+
+func TestRescheduleDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		{"retry after", &RateLimitError{RetryAfter: 90 * time.Second}, 90 * time.Second, true},
+		{"wrapped retry after", fmt.Errorf("failed to get message: %w", &RateLimitError{RetryAfter: 5 * time.Second}), 5 * time.Second, true},
+		{"rate limited without retry after", &RateLimitError{}, DefaultRateLimitDelay, true},
+		{"rate limited sentinel", fmt.Errorf("%w: quota", ErrRateLimited), DefaultRateLimitDelay, true},
+		{"token revoked", fmt.Errorf("%w: invalid_grant", ErrTokenRevoked), 0, false},
+		{"not found", fmt.Errorf("%w: deleted", ErrNotFound), 0, false},
+		{"other error", errors.New("connection reset"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := RescheduleDelay(tt.err)
+			if delay != tt.wantDelay || ok != tt.wantOK {
+				t.Errorf("RescheduleDelay() = %s, %v; want %s, %v", delay, ok, tt.wantDelay, tt.wantOK)
+			}
+		})
+	}
+}
@@ -2,42 +2,75 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/vipul43/kiwis-worker/internal/attachment"
+	"github.com/vipul43/kiwis-worker/internal/dedup"
+	"github.com/vipul43/kiwis-worker/internal/emailtext"
+	"github.com/vipul43/kiwis-worker/internal/llm"
+	"github.com/vipul43/kiwis-worker/internal/merchant"
 	"github.com/vipul43/kiwis-worker/internal/models"
-	"github.com/vipul43/kiwis-worker/internal/openrouter"
 	"github.com/vipul43/kiwis-worker/internal/repository"
 )
 
 const (
-	LLMBatchSize      = 3    // Process 3 LLM jobs at a time (free models are very slow, ~30-60s per email, 3 emails = ~3-5 minutes)
-	MaxEmailBodyChars = 5000 // Limit email body to 5000 characters for DDoS protection and efficient LLM processing
+	LLMBatchSize           = 3    // Process 3 LLM jobs at a time (free models are very slow, ~30-60s per email, 3 emails = ~3-5 minutes)
+	MaxEmailBodyChars      = 5000 // Limit email body to 5000 characters for DDoS protection and efficient LLM processing
+	MaxAttachmentsPerEmail = 3    // Only the first 3 supported attachments (PDF, text, CSV) are downloaded
+	MaxAttachmentChars     = 5000 // Limit attachment text to 5000 characters per email (all attachments together)
 )
 
+// Extractor is an LLM backend that extracts payment information from emails
+// It returns one result per email, in the order of emails
+type Extractor interface {
+	ExtractPayments(ctx context.Context, emails []llm.EmailData) ([]llm.Result, error)
+}
+
+// LLMJobRepository interface for dependency injection
+type LLMJobRepository interface {
+	UpdateStatus(ctx context.Context, id string, workerID string, status string, lastError *string) error
+	MarkFailed(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error
+	Reschedule(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error
+	MarkDead(ctx context.Context, id string, workerID string, lastError string) error
+}
+
+// PaymentUpserter interface for dependency injection
+type PaymentUpserter interface {
+	Upsert(ctx context.Context, payments []models.Payment) (repository.UpsertResult, error)
+}
+
 type LLMProcessor struct {
-	accountRepo      *repository.AccountRepository
-	llmSyncJobRepo   *repository.LLMSyncJobRepository
-	paymentRepo      *repository.PaymentRepository
-	gmailClient      GmailClient
-	openRouterClient *openrouter.Client
+	llmSyncJobRepo LLMJobRepository
+	paymentRepo    PaymentUpserter
+	mailSource     MailSource
+	extractor      Extractor
+	normaliser     *MerchantNormaliser // Optional: nil leaves payments without a canonical merchant
+	reconciler     *Reconciler         // Optional: nil skips reconciliation
+	retryPolicy    RetryPolicy
 }
 
 func NewLLMProcessor(
-	accountRepo *repository.AccountRepository,
 	llmSyncJobRepo *repository.LLMSyncJobRepository,
 	paymentRepo *repository.PaymentRepository,
-	gmailClient GmailClient,
-	openRouterClient *openrouter.Client,
+	mailSource MailSource,
+	extractor Extractor,
+	normaliser *MerchantNormaliser,
+	reconciler *Reconciler,
+	retryPolicy RetryPolicy,
 ) *LLMProcessor {
 	return &LLMProcessor{
-		accountRepo:      accountRepo,
-		llmSyncJobRepo:   llmSyncJobRepo,
-		paymentRepo:      paymentRepo,
-		gmailClient:      gmailClient,
-		openRouterClient: openRouterClient,
+		llmSyncJobRepo: llmSyncJobRepo,
+		paymentRepo:    paymentRepo,
+		mailSource:     mailSource,
+		extractor:      extractor,
+		normaliser:     normaliser,
+		reconciler:     reconciler,
+		retryPolicy:    retryPolicy,
 	}
 }
 
@@ -49,7 +82,7 @@ func (p *LLMProcessor) ProcessLLMSyncJobs(ctx context.Context, jobs []models.LLM
 
 	log.Printf("Processing batch of %d LLM sync jobs", len(jobs))
 
-	// Group jobs by account to get access token once per account
+	// Group jobs by account to fetch each account's emails in one batch
 	jobsByAccount := make(map[string][]models.LLMSyncJob)
 	for _, job := range jobs {
 		jobsByAccount[job.AccountID] = append(jobsByAccount[job.AccountID], job)
@@ -68,55 +101,41 @@ func (p *LLMProcessor) ProcessLLMSyncJobs(ctx context.Context, jobs []models.LLM
 
 // processAccountJobs processes all jobs for a single account
 func (p *LLMProcessor) processAccountJobs(ctx context.Context, accountID string, jobs []models.LLMSyncJob) error {
-	// Fetch account details
-	account, err := p.accountRepo.GetByID(ctx, accountID)
-	if err != nil {
-		// Mark all jobs as failed
-		for _, job := range jobs {
-			errMsg := fmt.Sprintf("failed to get account: %v", err)
-			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
-		}
-		return fmt.Errorf("failed to get account: %w", err)
+	// Fetch full emails for all message IDs in one batch request
+	log.Printf("Fetching %d emails for account %s", len(jobs), accountID)
+	messageIDs := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		messageIDs = append(messageIDs, job.MessageID)
 	}
 
-	// Validate tokens exist
-	if account.AccessToken == nil || account.RefreshToken == nil {
-		errMsg := "account missing tokens"
+	fetched, err := p.mailSource.FetchEmailsByIDs(ctx, accountID, messageIDs)
+	if err != nil {
+		// Mark all jobs as failed (or reschedule them when rate limited)
+		err = fmt.Errorf("failed to fetch emails: %w", err)
 		for _, job := range jobs {
-			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
+			p.failJob(ctx, job, err)
 		}
-		return fmt.Errorf("account missing tokens")
+		return err
 	}
 
-	// Check if access token is expired and refresh if needed
-	accessToken := *account.AccessToken
-	if p.isTokenExpired(account.AccessTokenExpiresAt) {
-		log.Printf("Access token expired for account %s, refreshing...", accountID)
-		newToken, err := p.refreshToken(ctx, account)
-		if err != nil {
-			errMsg := fmt.Sprintf("failed to refresh token: %v", err)
-			for _, job := range jobs {
-				_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
-			}
-			return fmt.Errorf("failed to refresh token: %w", err)
-		}
-		accessToken = newToken
-	}
-
-	// Fetch full emails for all message IDs
-	log.Printf("Fetching %d emails for account %s", len(jobs), accountID)
-	emails := make([]openrouter.EmailData, 0, len(jobs))
+	emails := make([]llm.EmailData, 0, len(jobs))
 	jobIndexMap := make(map[int]models.LLMSyncJob) // Map email index to job
+	messages := make([]*EmailMessage, 0, len(jobs))
 
 	for _, job := range jobs {
-		email, err := p.fetchEmail(ctx, accessToken, job.MessageID)
+		msg, ok := fetched.Messages[job.MessageID]
+		if !ok {
+			log.Printf("Failed to fetch email %s: %v", job.MessageID, fetched.Errors[job.MessageID])
+			p.failJob(ctx, job, fmt.Errorf("failed to fetch email: %w", fetched.Errors[job.MessageID]))
+			continue
+		}
+		emailData, err := p.fetchEmail(ctx, accountID, msg)
 		if err != nil {
-			log.Printf("Failed to fetch email %s: %v", job.MessageID, err)
-			errMsg := fmt.Sprintf("failed to fetch email: %v", err)
-			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
+			p.failJob(ctx, job, fmt.Errorf("failed to fetch attachments: %w", err))
 			continue
 		}
-		emails = append(emails, *email)
+		emails = append(emails, emailData)
+		messages = append(messages, msg)
 		jobIndexMap[len(emails)-1] = job
 	}
 
@@ -125,131 +144,366 @@ func (p *LLMProcessor) processAccountJobs(ctx context.Context, accountID string,
 		return nil
 	}
 
-	// Send batch to OpenRouter LLM
+	// Send batch to the LLM backend
 	log.Printf("Sending %d emails to LLM for payment extraction", len(emails))
-	payments, rawResponses, err := p.openRouterClient.BatchExtractPayments(ctx, emails)
+	results, err := p.extractor.ExtractPayments(ctx, emails)
 	if err != nil {
-		// Mark all jobs as failed
-		errMsg := fmt.Sprintf("LLM extraction failed: %v", err)
-		for _, job := range jobs {
-			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
+		// Mark the jobs sent to the LLM as failed, the others were already failed above
+		err = fmt.Errorf("LLM extraction failed: %w", err)
+		for _, job := range jobIndexMap {
+			p.failJob(ctx, job, err)
 		}
-		return fmt.Errorf("LLM extraction failed: %w", err)
+		return err
 	}
 
 	// Process results
 	paymentsToCreate := make([]models.Payment, 0)
+	paymentJobs := make([]models.LLMSyncJob, 0, len(results)) // Completed once their payments are stored
 	now := time.Now()
 
-	for i, paymentData := range payments {
+	for i, result := range results {
 		job := jobIndexMap[i]
-		rawResp := rawResponses[i]
 
-		// Check if it's a valid payment
-		if paymentData.Merchant == "" || paymentData.Amount == nil {
+		if result.Err != nil {
+			// The request failed or the model's answer is not a valid payment (field errors are kept as the job's last error)
+			log.Printf("Failed to extract payments from email %s: %v", job.MessageID, result.Err)
+			p.failJob(ctx, job, result.Err)
+			continue
+		}
+
+		payments, err := buildPayments(accountID, messages[i], &job.ID, result, now)
+		if err != nil {
+			log.Printf("Failed to build payments from email %s: %v", job.MessageID, err)
+			p.failJob(ctx, job, err)
+			continue
+		}
+		if len(payments) == 0 {
 			// Not a payment email, mark job as completed
 			log.Printf("Email %s is not a payment email, marking as completed", job.MessageID)
-			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusCompleted, nil)
+			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, claimant(job), models.LLMStatusCompleted, nil)
 			continue
 		}
 
-		// Parse date
-		paymentDate, err := time.Parse(time.RFC3339, paymentData.Date)
+		paymentsToCreate = append(paymentsToCreate, payments...)
+		paymentJobs = append(paymentJobs, job)
+		for _, payment := range payments {
+			log.Printf("Extracted payment from email %s: %s - %s", job.MessageID, payment.Merchant, payment.Money())
+		}
+	}
+
+	// Store payments, duplicates of stored payments are merged into them
+	if len(paymentsToCreate) > 0 {
+		p.normaliseMerchants(ctx, paymentsToCreate)
+		upserted, err := p.paymentRepo.Upsert(ctx, paymentsToCreate)
 		if err != nil {
-			// Try alternative format without timezone
-			paymentDate, err = time.Parse("2006-01-02T15:04:05", paymentData.Date)
+			// Nothing was stored (single transaction), the jobs are retried
+			err = fmt.Errorf("failed to store payments: %w", err)
+			for _, job := range paymentJobs {
+				p.failJob(ctx, job, err)
+			}
+			return err
+		}
+		log.Printf("Created %d and merged %d payments for account %s", upserted.Created, upserted.Merged, accountID)
+
+		// Mark jobs as completed
+		for _, job := range paymentJobs {
+			_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, claimant(job), models.LLMStatusCompleted, nil)
+		}
+		p.reconcilePayments(ctx, upserted.Payments())
+	}
+
+	return nil
+}
+
+// ProcessEmailsResult summarises a ProcessEmails run
+type ProcessEmailsResult struct {
+	Processed int              // Emails sent to the LLM
+	Payments  []models.Payment // Extracted payments
+	Stored    int              // Payments created or merged into stored ones (none on a dry run)
+	Failed    map[string]error // Emails that could not be processed, keyed by message ID
+}
+
+// ProcessEmails extracts payments from already parsed emails (offline .eml / mbox import) for the account
+// Attachments are read from the processor's mail source; no LLM sync jobs are created or updated
+// Payments are recorded after each batch unless dryRun is set, so a failure keeps the payments of earlier batches
+func (p *LLMProcessor) ProcessEmails(ctx context.Context, accountID string, messages []*EmailMessage, dryRun bool) (*ProcessEmailsResult, error) {
+	result := &ProcessEmailsResult{Failed: make(map[string]error)}
+
+	for start := 0; start < len(messages); start += LLMBatchSize {
+		batch := messages[start:min(start+LLMBatchSize, len(messages))]
+
+		emails := make([]llm.EmailData, 0, len(batch))
+		emailMessages := make([]*EmailMessage, 0, len(batch))
+		for _, msg := range batch {
+			emailData, err := p.fetchEmail(ctx, accountID, msg)
+			if err != nil {
+				result.Failed[msg.ID] = fmt.Errorf("failed to fetch attachments: %w", err)
+				continue
+			}
+			emails = append(emails, emailData)
+			emailMessages = append(emailMessages, msg)
+		}
+		if len(emails) == 0 {
+			continue
+		}
+
+		extracted, err := p.extractor.ExtractPayments(ctx, emails)
+		if err != nil {
+			return result, fmt.Errorf("LLM extraction failed: %w", err)
+		}
+		result.Processed += len(emails)
+
+		now := time.Now()
+		var batchPayments []models.Payment
+		for i, extraction := range extracted {
+			msg := emailMessages[i]
+			if extraction.Err != nil {
+				result.Failed[msg.ID] = extraction.Err
+				continue
+			}
+			payments, err := buildPayments(accountID, msg, nil, extraction, now)
 			if err != nil {
-				log.Printf("Failed to parse date %s: %v", paymentData.Date, err)
-				errMsg := fmt.Sprintf("failed to parse date: %v", err)
-				_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusFailed, &errMsg)
+				result.Failed[msg.ID] = err
+				continue
+			}
+			if len(payments) == 0 {
+				log.Printf("Email %s is not a payment email", msg.ID)
 				continue
 			}
+			batchPayments = append(batchPayments, payments...)
+			for _, payment := range payments {
+				log.Printf("Extracted payment from email %s: %s - %s", msg.ID, payment.Merchant, payment.Money())
+			}
 		}
+		result.Payments = append(result.Payments, batchPayments...)
 
-		// Create payment
-		payment := models.Payment{
-			ID:             uuid.New().String(),
-			AccountID:      accountID,
-			Merchant:       paymentData.Merchant,
-			Description:    paymentData.Description,
-			Amount:         *paymentData.Amount,
-			Currency:       paymentData.Currency,
-			Date:           paymentDate,
-			Recurrence:     paymentData.Recurrence,
-			Status:         paymentData.Status,
-			Category:       paymentData.Category,
-			Metadata:       models.JSONB(paymentData.Metadata),
-			RawLlmResponse: models.JSONB(rawResp),
-			CreatedAt:      now,
-			UpdatedAt:      now,
+		if dryRun || len(batchPayments) == 0 {
+			continue
+		}
+		p.normaliseMerchants(ctx, batchPayments)
+		upserted, err := p.paymentRepo.Upsert(ctx, batchPayments)
+		if err != nil {
+			return result, fmt.Errorf("failed to store payments: %w", err)
 		}
+		result.Stored += upserted.Created + upserted.Merged
+		log.Printf("Created %d and merged %d payments for account %s", upserted.Created, upserted.Merged, accountID)
+		p.reconcilePayments(ctx, upserted.Payments())
+	}
 
-		paymentsToCreate = append(paymentsToCreate, payment)
+	return result, nil
+}
 
-		// Mark job as completed
-		_ = p.llmSyncJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusCompleted, nil)
-		log.Printf("Extracted payment from email %s: %s - %.2f %s", job.MessageID, payment.Merchant, payment.Amount, payment.Currency)
+// normaliseMerchants links payments to their canonical merchants before they are stored
+// Failures are only logged, the payments are stored without one and linked by kiwis-worker merchants backfill
+func (p *LLMProcessor) normaliseMerchants(ctx context.Context, payments []models.Payment) {
+	if p.normaliser == nil {
+		return
 	}
+	if err := p.normaliser.Normalise(ctx, payments); err != nil {
+		log.Printf("Failed to normalise merchants: %v", err)
+	}
+}
 
-	// Bulk create payments
-	if len(paymentsToCreate) > 0 {
-		if err := p.paymentRepo.BulkCreate(ctx, paymentsToCreate); err != nil {
-			return fmt.Errorf("failed to create payments: %w", err)
-		}
-		log.Printf("Created %d payments for account %s", len(paymentsToCreate), accountID)
+// reconcilePayments links created and merged payments to the obligations they settle, or the payments settling them
+// Merged payments are reconciled again, a merge may have moved an obligation to paid
+// Failures are only logged, the payments are stored and can still be linked by hand
+func (p *LLMProcessor) reconcilePayments(ctx context.Context, payments []models.Payment) {
+	if p.reconciler == nil || len(payments) == 0 {
+		return
+	}
+	if _, err := p.reconciler.ReconcilePayments(ctx, payments); err != nil {
+		log.Printf("Failed to reconcile payments: %v", err)
+	}
+}
+
+// buildPayments converts the LLM output for one email into payments for the account, linked to the email and
+// the LLM sync job it was extracted by (nil for imports)
+// Fails when any payment cannot be built, so an email's payments are recorded together or not at all
+func buildPayments(accountID string, msg *EmailMessage, jobID *string, result llm.Result, now time.Time) ([]models.Payment, error) {
+	var threadID *string
+	if msg.ThreadID != "" {
+		threadID = &msg.ThreadID
+	}
+	var senderDomain *string
+	if domain := merchant.SenderDomain(msg.From); domain != "" {
+		senderDomain = &domain
+	}
+	var receivedAt *time.Time
+	if !msg.InternalDate.IsZero() {
+		receivedAt = &msg.InternalDate
+	} else if !msg.Date.IsZero() {
+		receivedAt = &msg.Date
 	}
 
-	return nil
+	payments := make([]models.Payment, 0, len(result.Payments))
+	for i := range result.Payments {
+		payment, err := buildPayment(accountID, &result.Payments[i], result.Raw, now)
+		if err != nil {
+			return nil, fmt.Errorf("payment %d: %w", i, err)
+		}
+		if payment == nil {
+			continue
+		}
+		payment.SourceMessageID = &msg.ID
+		payment.SourceThreadID = threadID
+		payment.EmailReceivedAt = receivedAt
+		payment.SenderDomain = senderDomain
+		payment.LLMSyncJobID = jobID
+		payments = append(payments, *payment)
+	}
+	dedup.PrepareEmail(payments)
+	return payments, nil
 }
 
-// fetchEmail fetches a single email by message ID
-func (p *LLMProcessor) fetchEmail(ctx context.Context, accessToken string, messageID string) (*openrouter.EmailData, error) {
-	// Fetch email directly by ID
-	msg, err := p.gmailClient.FetchEmailByID(ctx, accessToken, messageID)
-	if err != nil {
-		return nil, err
+// buildPayment converts one payment of the LLM output into a payment for the account
+// Returns nil without an error when the payment lacks a merchant or amount
+func buildPayment(accountID string, paymentData *llm.PaymentData, rawResp llm.RawResponse, now time.Time) (*models.Payment, error) {
+	if paymentData == nil || paymentData.Merchant == "" || paymentData.Amount == nil {
+		return nil, nil
 	}
 
-	// Truncate body to prevent DDoS and reduce token usage
-	// Payment info is typically in the first part of the email
-	body := msg.BodyText
-	if len(body) > MaxEmailBodyChars {
-		body = body[:MaxEmailBodyChars]
+	// Parse date (RFC 3339, or without timezone)
+	paymentDate, err := llm.ParseDate(paymentData.Date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse date: %w", err)
 	}
 
-	return &openrouter.EmailData{
-		From:    msg.From,
-		Subject: msg.Subject,
-		Body:    body,
+	return &models.Payment{
+		ID:             uuid.New().String(),
+		AccountID:      accountID,
+		Merchant:       paymentData.Merchant,
+		Description:    paymentData.Description,
+		Amount:         *paymentData.Amount,
+		Currency:       paymentData.Currency,
+		Date:           paymentDate,
+		Recurrence:     paymentData.Recurrence,
+		Status:         paymentData.Status,
+		Category:       paymentData.Category,
+		Metadata:       models.JSONB(paymentData.Metadata),
+		RawLlmResponse: models.JSONB(rawResp),
+		CreatedAt:      now,
+		UpdatedAt:      now,
 	}, nil
 }
 
-// isTokenExpired checks if access token is expired or will expire within 5 minutes
-func (p *LLMProcessor) isTokenExpired(expiresAt *time.Time) bool {
-	if expiresAt == nil {
-		return true // Assume expired if no expiry time
+// failJob schedules a retry for a failed job with backoff, or marks it dead once retries are exhausted
+// Rate limits reschedule the job without using up a retry, deleted messages and revoked tokens are dead right away
+// Jobs that died on a revoked token are revived by the account trigger once the user re-authenticates
+func (p *LLMProcessor) failJob(ctx context.Context, job models.LLMSyncJob, err error) {
+	errMsg := err.Error()
+
+	if delay, ok := RescheduleDelay(err); ok {
+		_ = p.llmSyncJobRepo.Reschedule(ctx, job.ID, claimant(job), errMsg, time.Now().Add(delay))
+		return
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("LLM job %s is dead, message %s no longer exists: %s", job.ID, job.MessageID, errMsg)
+		_ = p.llmSyncJobRepo.MarkDead(ctx, job.ID, claimant(job), errMsg)
+		return
+	}
+
+	if errors.Is(err, ErrTokenRevoked) {
+		log.Printf("LLM job %s is dead until the account is re-authenticated: %s", job.ID, errMsg)
+		_ = p.llmSyncJobRepo.MarkDead(ctx, job.ID, claimant(job), errMsg)
+		return
+	}
+
+	nextAttemptAt, retry := p.retryPolicy.NextAttemptAt(job.Attempts, time.Now())
+	if !retry {
+		log.Printf("LLM job %s is dead after %d attempts: %s", job.ID, job.Attempts, errMsg)
+		_ = p.llmSyncJobRepo.MarkDead(ctx, job.ID, claimant(job), errMsg)
+		return
 	}
-	return time.Now().Add(5 * time.Minute).After(*expiresAt)
+	_ = p.llmSyncJobRepo.MarkFailed(ctx, job.ID, claimant(job), errMsg, nextAttemptAt)
 }
 
-// refreshToken refreshes the access token and updates the account
-func (p *LLMProcessor) refreshToken(ctx context.Context, account *models.Account) (string, error) {
-	if account.RefreshToken == nil {
-		return "", fmt.Errorf("no refresh token available")
+// claimant returns the worker that claimed the job, status updates only apply while it holds the job's lease
+func claimant(job models.LLMSyncJob) string {
+	if job.ClaimedBy == nil {
+		return ""
 	}
+	return *job.ClaimedBy
+}
 
-	result, err := p.gmailClient.RefreshAccessToken(ctx, *account.RefreshToken)
-	if err != nil {
-		return "", fmt.Errorf("failed to refresh token: %w", err)
+// fetchEmail converts a fetched email into LLM input, downloading and extracting the text of its attachments
+// Attachments that cannot be downloaded or read are skipped, except for rate limits and revoked tokens (fail the job)
+func (p *LLMProcessor) fetchEmail(ctx context.Context, accountID string, msg *EmailMessage) (llm.EmailData, error) {
+	// Use readable text (HTML-only emails are converted), truncated to prevent DDoS and reduce token usage
+	// Payment info is typically in the first part of the email
+	body := emailtext.Truncate(emailtext.Normalize(msg.BodyText, msg.BodyHTML), MaxEmailBodyChars)
+
+	emailData := llm.EmailData{
+		From:    msg.From,
+		Subject: msg.Subject,
+		Body:    body,
 	}
 
-	// Update account with new tokens
-	err = p.accountRepo.UpdateTokens(ctx, account.ID, result.AccessToken, result.RefreshToken, result.ExpiresAt)
-	if err != nil {
-		return "", fmt.Errorf("failed to update tokens in database: %w", err)
+	remaining := MaxAttachmentChars
+	for _, meta := range msg.Attachments {
+		if len(emailData.Attachments) == MaxAttachmentsPerEmail || remaining <= 0 {
+			break
+		}
+
+		filename, _ := meta["filename"].(string)
+		mimeType, _ := meta["mimeType"].(string)
+		attachmentID, _ := meta["attachmentId"].(string)
+		inline, _ := meta["data"].([]byte)
+		if attachmentID == "" && inline == nil {
+			continue
+		}
+		if _, ok := attachment.Detect(filename, mimeType); !ok {
+			continue
+		}
+		if size := attachmentSize(meta["size"]); size > attachment.MaxSize {
+			log.Printf("Skipping attachment %s of email %s: %d bytes exceeds limit", filename, msg.ID, size)
+			continue
+		}
+
+		// Small attachments come with the message, the others are downloaded
+		data := inline
+		if data == nil {
+			var err error
+			data, err = p.mailSource.FetchAttachment(ctx, accountID, msg.ID, attachmentID)
+			if err != nil {
+				if _, reschedule := RescheduleDelay(err); reschedule || errors.Is(err, ErrTokenRevoked) {
+					return llm.EmailData{}, err
+				}
+				log.Printf("Warning: failed to fetch attachment %s of email %s: %v", filename, msg.ID, err)
+				continue
+			}
+		}
+
+		text, err := attachment.ExtractText(filename, mimeType, data)
+		if err != nil {
+			log.Printf("Warning: failed to extract text from attachment %s of email %s: %v", filename, msg.ID, err)
+			continue
+		}
+		if text == "" {
+			continue // e.g. scanned PDF without a text layer
+		}
+
+		text = emailtext.Truncate(text, remaining)
+		remaining -= utf8.RuneCountInString(text)
+
+		emailData.Attachments = append(emailData.Attachments, llm.AttachmentData{
+			Filename: filename,
+			Text:     text,
+		})
 	}
 
-	log.Printf("Token refreshed for account %s, expires at %s", account.ID, result.ExpiresAt)
+	return emailData, nil
+}
 
-	return result.AccessToken, nil
+// attachmentSize reads the size from attachment metadata (int64 from Gmail, float64 once stored as JSON)
+func attachmentSize(value interface{}) int64 {
+	switch size := value.(type) {
+	case int64:
+		return size
+	case int:
+		return int64(size)
+	case float64:
+		return int64(size)
+	}
+	return 0
 }
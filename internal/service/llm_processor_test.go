@@ -0,0 +1,525 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/llm"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+)
+
+// mockMailSource serves attachments from memory, other MailSource calls are not used by these tests
+type mockMailSource struct {
+	MailSource
+	attachments map[string][]byte        // keyed by attachment ID
+	messages    map[string]*EmailMessage // keyed by message ID, others are not found
+	err         error
+	fetched     []string
+}
+
+func (m *mockMailSource) FetchEmailsByIDs(ctx context.Context, accountID string, messageIDs []string) (*BatchFetchResult, error) {
+	result := &BatchFetchResult{Messages: make(map[string]*EmailMessage), Errors: make(map[string]error)}
+	for _, id := range messageIDs {
+		if msg, ok := m.messages[id]; ok {
+			result.Messages[id] = msg
+		} else {
+			result.Errors[id] = ErrNotFound
+		}
+	}
+	return result, nil
+}
+
+func (m *mockMailSource) FetchAttachment(ctx context.Context, accountID string, messageID string, attachmentID string) ([]byte, error) {
+	m.fetched = append(m.fetched, attachmentID)
+	if m.err != nil {
+		return nil, m.err
+	}
+	data, ok := m.attachments[attachmentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func TestLLMProcessor_FetchEmail_Attachments(t *testing.T) {
+	mailSource := &mockMailSource{
+		attachments: map[string][]byte{
+			"att-txt": []byte("Amount due: 499.00 INR"),
+			"att-csv": []byte("date,amount\n2025-11-01,120.00"),
+		},
+	}
+	processor := &LLMProcessor{mailSource: mailSource}
+
+	msg := &EmailMessage{
+		ID:       "msg-1",
+		From:     "billing@telco.example",
+		Subject:  "Your bill",
+		BodyText: "See attached",
+		Attachments: []map[string]any{
+			{"filename": "logo.png", "mimeType": "image/png", "size": int64(100), "attachmentId": "att-png"},
+			{"filename": "bill.txt", "mimeType": "text/plain", "size": int64(22), "attachmentId": "att-txt"},
+			{"filename": "huge.pdf", "mimeType": "application/pdf", "size": int64(50 * 1024 * 1024), "attachmentId": "att-huge"},
+			{"filename": "deleted.txt", "mimeType": "text/plain", "size": float64(10), "attachmentId": "att-gone"},
+			{"filename": "usage.csv", "mimeType": "text/csv", "size": float64(30), "attachmentId": "att-csv"},
+		},
+	}
+
+	emailData, err := processor.fetchEmail(context.Background(), "acc-1", msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if emailData.Body != "See attached" || emailData.Subject != "Your bill" {
+		t.Errorf("unexpected email data: %+v", emailData)
+	}
+	if len(emailData.Attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got %+v", emailData.Attachments)
+	}
+	if emailData.Attachments[0].Filename != "bill.txt" || emailData.Attachments[0].Text != "Amount due: 499.00 INR" {
+		t.Errorf("unexpected first attachment: %+v", emailData.Attachments[0])
+	}
+	if emailData.Attachments[1].Filename != "usage.csv" {
+		t.Errorf("unexpected second attachment: %+v", emailData.Attachments[1])
+	}
+
+	// Unsupported and oversized attachments are never downloaded
+	for _, id := range mailSource.fetched {
+		if id == "att-png" || id == "att-huge" {
+			t.Errorf("expected %s not to be downloaded", id)
+		}
+	}
+}
+
+func TestLLMProcessor_FetchEmail_InlineAttachment(t *testing.T) {
+	mailSource := &mockMailSource{}
+	processor := &LLMProcessor{mailSource: mailSource}
+
+	msg := &EmailMessage{
+		ID: "msg-1",
+		Attachments: []map[string]any{
+			{"filename": "bill.txt", "mimeType": "text/plain", "size": int64(22), "data": []byte("Amount due: 499.00 INR")},
+		},
+	}
+
+	emailData, err := processor.fetchEmail(context.Background(), "acc-1", msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(emailData.Attachments) != 1 || emailData.Attachments[0].Text != "Amount due: 499.00 INR" {
+		t.Errorf("expected the inline attachment's text, got %+v", emailData.Attachments)
+	}
+	if len(mailSource.fetched) != 0 {
+		t.Errorf("expected inline attachments not to be downloaded, got %v", mailSource.fetched)
+	}
+}
+
+func TestLLMProcessor_FetchEmail_AttachmentTextLimit(t *testing.T) {
+	mailSource := &mockMailSource{
+		attachments: map[string][]byte{
+			"att-1": []byte(strings.Repeat("a", 4000)),
+			"att-2": []byte(strings.Repeat("b", 4000)),
+			"att-3": []byte(strings.Repeat("c", 4000)),
+		},
+	}
+	processor := &LLMProcessor{mailSource: mailSource}
+
+	msg := &EmailMessage{ID: "msg-1"}
+	for _, id := range []string{"att-1", "att-2", "att-3"} {
+		msg.Attachments = append(msg.Attachments, map[string]any{"filename": id + ".txt", "mimeType": "text/plain", "attachmentId": id})
+	}
+
+	emailData, err := processor.fetchEmail(context.Background(), "acc-1", msg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	total := 0
+	for _, attachment := range emailData.Attachments {
+		total += len(attachment.Text)
+	}
+	if total != MaxAttachmentChars {
+		t.Errorf("expected %d attachment characters in total, got %d", MaxAttachmentChars, total)
+	}
+	if len(mailSource.fetched) != 2 {
+		t.Errorf("expected downloads to stop once the limit is reached, got %v", mailSource.fetched)
+	}
+}
+
+func TestLLMProcessor_FetchEmail_RateLimited(t *testing.T) {
+	mailSource := &mockMailSource{err: &RateLimitError{RetryAfter: 30}}
+	processor := &LLMProcessor{mailSource: mailSource}
+
+	msg := &EmailMessage{
+		ID:          "msg-1",
+		Attachments: []map[string]any{{"filename": "bill.pdf", "mimeType": "application/pdf", "attachmentId": "att-1"}},
+	}
+
+	if _, err := processor.fetchEmail(context.Background(), "acc-1", msg); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited so the job is rescheduled, got %v", err)
+	}
+}
+
+func TestBuildPayments(t *testing.T) {
+	amount := models.MustParseAmount("49.99")
+	valid := llm.PaymentData{Merchant: "Netflix", Amount: &amount, Currency: "EUR", Date: "2026-03-01T00:00:00Z", Status: "paid"}
+	raw := llm.RawResponse{"id": "resp-1"}
+
+	received := time.Date(2026, 2, 28, 9, 30, 0, 0, time.UTC)
+	msg := &EmailMessage{
+		ID: "msg-1", ThreadID: "thread-1", From: "Netflix <info@mailer.netflix.com>",
+		Date: received.Add(-time.Minute), InternalDate: received,
+	}
+	jobID := "job-1"
+
+	payments, err := buildPayments("acc-1", msg, &jobID, llm.Result{Payments: []llm.PaymentData{valid, valid}, Raw: raw}, time.Now())
+	if err != nil {
+		t.Fatalf("buildPayments() error = %v", err)
+	}
+	if len(payments) != 2 || payments[0].ID == payments[1].ID {
+		t.Fatalf("buildPayments() = %+v, want two distinct payments", payments)
+	}
+	for _, payment := range payments {
+		if payment.SourceMessageID == nil || *payment.SourceMessageID != "msg-1" || payment.RawLlmResponse["id"] != "resp-1" {
+			t.Errorf("buildPayments() payment = %+v, want it linked to msg-1 with the raw response", payment)
+		}
+		if payment.SourceThreadID == nil || *payment.SourceThreadID != "thread-1" || payment.LLMSyncJobID == nil || *payment.LLMSyncJobID != "job-1" {
+			t.Errorf("buildPayments() payment = %+v, want thread-1 and job-1", payment)
+		}
+		if payment.EmailReceivedAt == nil || !payment.EmailReceivedAt.Equal(received) {
+			t.Errorf("buildPayments() email_received_at = %v, want the internal date %v", payment.EmailReceivedAt, received)
+		}
+		if payment.Fingerprint == nil || payment.MerchantKey != "netflix" {
+			t.Errorf("buildPayments() payment = %+v, want it prepared for deduplication", payment)
+		}
+		if payment.SenderDomain == nil || *payment.SenderDomain != "mailer.netflix.com" {
+			t.Errorf("buildPayments() sender_domain = %v, want mailer.netflix.com", payment.SenderDomain)
+		}
+	}
+
+	// Imported emails have no job, and no thread unless the headers link one
+	payments, err = buildPayments("acc-1", &EmailMessage{ID: "takeout.mbox#1", Date: received}, nil, llm.Result{Payments: []llm.PaymentData{valid}}, time.Now())
+	if err != nil {
+		t.Fatalf("buildPayments() error = %v", err)
+	}
+	if payments[0].LLMSyncJobID != nil || payments[0].SourceThreadID != nil || !payments[0].EmailReceivedAt.Equal(received) {
+		t.Errorf("buildPayments() imported payment = %+v, want no job or thread and the Date header as received time", payments[0])
+	}
+
+	invalid := valid
+	invalid.Date = "March 1st"
+	if _, err := buildPayments("acc-1", msg, &jobID, llm.Result{Payments: []llm.PaymentData{valid, invalid}}, time.Now()); err == nil {
+		t.Error("buildPayments() error = nil, want the invalid date of the second payment")
+	}
+}
+
+func TestBuildPayment(t *testing.T) {
+	amount := models.MustParseAmount("49.99")
+	now := time.Now()
+	tests := []struct {
+		name     string
+		data     *llm.PaymentData
+		wantNil  bool
+		wantErr  bool
+		wantDate time.Time
+	}{
+		{"not a payment", nil, true, false, time.Time{}},
+		{"missing amount", &llm.PaymentData{Merchant: "Netflix"}, true, false, time.Time{}},
+		{
+			"RFC 3339 date",
+			&llm.PaymentData{Merchant: "Netflix", Amount: &amount, Currency: "EUR", Date: "2026-03-01T00:00:00+01:00", Status: "paid"},
+			false, false, time.Date(2026, 2, 28, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			"date without timezone",
+			&llm.PaymentData{Merchant: "Netflix", Amount: &amount, Currency: "EUR", Date: "2026-03-01T00:00:00", Status: "paid"},
+			false, false, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{"invalid date", &llm.PaymentData{Merchant: "Netflix", Amount: &amount, Date: "March 1st"}, false, true, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment, err := buildPayment("acc-1", tt.data, nil, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildPayment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (payment == nil) != tt.wantNil {
+				t.Fatalf("buildPayment() = %+v, wantNil %v", payment, tt.wantNil)
+			}
+			if payment == nil {
+				return
+			}
+			if payment.AccountID != "acc-1" || payment.Amount != amount || !payment.Date.Equal(tt.wantDate) || payment.ID == "" {
+				t.Errorf("buildPayment() = %+v", payment)
+			}
+		})
+	}
+}
+
+// mockExtractor returns two payments for emails whose subject contains "statement", one for "bill", an invalid
+// answer for "garbled", and records the emails it saw; when err is set it fails every call after the first okCalls
+type mockExtractor struct {
+	seen    []llm.EmailData
+	calls   int
+	okCalls int
+	err     error
+}
+
+func (m *mockExtractor) ExtractPayments(ctx context.Context, emails []llm.EmailData) ([]llm.Result, error) {
+	m.seen = append(m.seen, emails...)
+	m.calls++
+	if m.err != nil && m.calls > m.okCalls {
+		return nil, m.err
+	}
+	results := make([]llm.Result, len(emails))
+	for i, email := range emails {
+		results[i].Raw = llm.RawResponse{"subject": email.Subject}
+		switch {
+		case strings.Contains(email.Subject, "statement"):
+			total, minimum := models.MustParseAmount("480"), models.MustParseAmount("25")
+			results[i].Payments = []llm.PaymentData{
+				{Merchant: email.From, Amount: &total, Currency: "USD", Date: "2026-03-20T00:00:00Z", Status: "upcoming"},
+				{Merchant: email.From, Amount: &minimum, Currency: "USD", Date: "2026-03-20T00:00:00Z", Status: "upcoming"},
+			}
+		case strings.Contains(email.Subject, "bill"):
+			amount := models.MustParseAmount("10")
+			results[i].Payments = []llm.PaymentData{{Merchant: email.From, Amount: &amount, Currency: "USD", Date: "2026-03-01T00:00:00Z", Status: "due"}}
+		case strings.Contains(email.Subject, "garbled"):
+			results[i].Err = &llm.ValidationError{Fields: []llm.FieldError{{Field: "status", Message: "is required"}}}
+		}
+	}
+	return results, nil
+}
+
+func TestLLMProcessor_ProcessEmails_DryRun(t *testing.T) {
+	extractor := &mockExtractor{}
+	processor := &LLMProcessor{mailSource: &mockMailSource{}, extractor: extractor}
+
+	var messages []*EmailMessage
+	for i, subject := range []string{"Your bill", "Newsletter", "Card statement", "Hello", "Final bill", "garbled"} {
+		messages = append(messages, &EmailMessage{ID: string(rune('a' + i)), From: "billing@example.com", Subject: subject})
+	}
+
+	// paymentRepo is nil, so a dry run must not try to record payments
+	result, err := processor.ProcessEmails(context.Background(), "acc-1", messages, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(extractor.seen) != len(messages) {
+		t.Errorf("expected %d emails sent to the extractor, got %d", len(messages), len(extractor.seen))
+	}
+	if result.Processed != len(messages) {
+		t.Errorf("expected %d emails processed, got %d", len(messages), result.Processed)
+	}
+	var validationErr *llm.ValidationError
+	if len(result.Failed) != 1 || !errors.As(result.Failed["f"], &validationErr) {
+		t.Errorf("expected only the garbled email to fail validation, got %v", result.Failed)
+	}
+	if len(result.Payments) != 4 {
+		t.Fatalf("expected 4 payments, got %d", len(result.Payments))
+	}
+	var sources []string
+	for _, payment := range result.Payments {
+		if payment.AccountID != "acc-1" || payment.Merchant != "billing@example.com" || payment.SourceMessageID == nil {
+			t.Fatalf("unexpected payment: %+v", payment)
+		}
+		sources = append(sources, *payment.SourceMessageID)
+	}
+	// Both payments of the statement link back to its message
+	if strings.Join(sources, ",") != "a,c,c,e" {
+		t.Errorf("expected payments from messages a, c, c and e, got %v", sources)
+	}
+}
+
+// mockLLMJobRepository records the last status each job was set to
+type mockLLMJobRepository struct {
+	statuses map[string]string
+}
+
+func (m *mockLLMJobRepository) UpdateStatus(ctx context.Context, id string, workerID string, status string, lastError *string) error {
+	m.statuses[id] = status
+	return nil
+}
+
+func (m *mockLLMJobRepository) MarkFailed(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	m.statuses[id] = models.LLMStatusFailed
+	return nil
+}
+
+func (m *mockLLMJobRepository) Reschedule(ctx context.Context, id string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	m.statuses[id] = models.LLMStatusFailed
+	return nil
+}
+
+func (m *mockLLMJobRepository) MarkDead(ctx context.Context, id string, workerID string, lastError string) error {
+	m.statuses[id] = models.LLMStatusDead
+	return nil
+}
+
+func TestLLMProcessor_ProcessLLMSyncJobs_ExtractionFailed(t *testing.T) {
+	jobRepo := &mockLLMJobRepository{statuses: make(map[string]string)}
+	processor := &LLMProcessor{
+		llmSyncJobRepo: jobRepo,
+		mailSource:     &mockMailSource{messages: map[string]*EmailMessage{"msg-1": {ID: "msg-1", Subject: "Your bill"}}},
+		extractor:      &mockExtractor{err: errors.New("llm: 500 internal error")},
+		retryPolicy:    NewRetryPolicy(3),
+	}
+
+	jobs := []models.LLMSyncJob{
+		{ID: "job-1", AccountID: "acc-1", MessageID: "msg-1", Attempts: 1},
+		{ID: "job-2", AccountID: "acc-1", MessageID: "msg-deleted", Attempts: 1},
+	}
+	if err := processor.ProcessLLMSyncJobs(context.Background(), jobs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if jobRepo.statuses["job-1"] != models.LLMStatusFailed {
+		t.Errorf("expected the job sent to the LLM to be failed, got %q", jobRepo.statuses["job-1"])
+	}
+	// The deleted message's job stays dead instead of being retried
+	if jobRepo.statuses["job-2"] != models.LLMStatusDead {
+		t.Errorf("expected the job of the deleted message to stay dead, got %q", jobRepo.statuses["job-2"])
+	}
+}
+
+func TestLLMProcessor_ProcessLLMSyncJobs_TokenRevoked(t *testing.T) {
+	jobRepo := &mockLLMJobRepository{statuses: make(map[string]string)}
+	msg := &EmailMessage{
+		ID:          "msg-1",
+		Subject:     "Your bill",
+		Attachments: []map[string]any{{"filename": "bill.pdf", "mimeType": "application/pdf", "attachmentId": "att-1"}},
+	}
+	processor := &LLMProcessor{
+		llmSyncJobRepo: jobRepo,
+		mailSource:     &mockMailSource{messages: map[string]*EmailMessage{"msg-1": msg}, err: fmt.Errorf("%w: invalid_grant", ErrTokenRevoked)},
+		extractor:      &mockExtractor{},
+		retryPolicy:    NewRetryPolicy(3),
+	}
+
+	jobs := []models.LLMSyncJob{{ID: "job-1", AccountID: "acc-1", MessageID: "msg-1", Attempts: 1}}
+	if err := processor.ProcessLLMSyncJobs(context.Background(), jobs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Dead until the account update revives it, instead of being rescheduled forever
+	if jobRepo.statuses["job-1"] != models.LLMStatusDead {
+		t.Errorf("expected the job to be dead on a revoked token, got %q", jobRepo.statuses["job-1"])
+	}
+}
+
+// mockPaymentUpserter stores payments in memory, or fails every call when err is set
+// When merged is set, every call merges into those stored payments instead
+type mockPaymentUpserter struct {
+	payments []models.Payment
+	merged   []models.Payment
+	err      error
+}
+
+func (m *mockPaymentUpserter) Upsert(ctx context.Context, payments []models.Payment) (repository.UpsertResult, error) {
+	if m.err != nil {
+		return repository.UpsertResult{}, m.err
+	}
+	if m.merged != nil {
+		return repository.UpsertResult{Merged: len(payments), MergedPayments: m.merged}, nil
+	}
+	m.payments = append(m.payments, payments...)
+	return repository.UpsertResult{Created: len(payments), CreatedPayments: payments}, nil
+}
+
+func TestLLMProcessor_ProcessEmails_ReconcilesMergedPayments(t *testing.T) {
+	bill, receipt := reconcilerPayments()
+	linkRepo := &mockPaymentLinkRepository{obligations: []models.Payment{bill}}
+	processor := &LLMProcessor{
+		// The email's payment merged into the stored receipt, moving it from due to paid
+		paymentRepo: &mockPaymentUpserter{merged: []models.Payment{receipt}},
+		mailSource:  &mockMailSource{},
+		extractor:   &mockExtractor{},
+		reconciler:  NewReconciler(&mockPaymentGetter{}, linkRepo),
+	}
+
+	messages := []*EmailMessage{{ID: "msg-1", From: "alerts@hdfcbank.net", Subject: "Your bill"}}
+	if _, err := processor.ProcessEmails(context.Background(), "acc-1", messages, false); err != nil {
+		t.Fatalf("ProcessEmails() error = %v", err)
+	}
+
+	if len(linkRepo.applied) != 1 || linkRepo.applied[0].SettlementID != receipt.ID || linkRepo.applied[0].ObligationID != bill.ID {
+		t.Errorf("expected the merged receipt linked to the bill, applied %+v", linkRepo.applied)
+	}
+}
+
+func TestLLMProcessor_ProcessEmails_StoredPerBatch(t *testing.T) {
+	paymentRepo := &mockPaymentUpserter{}
+	processor := &LLMProcessor{
+		paymentRepo: paymentRepo,
+		mailSource:  &mockMailSource{},
+		extractor:   &mockExtractor{okCalls: 1, err: errors.New("connection reset by peer")},
+	}
+
+	var messages []*EmailMessage
+	for i, subject := range []string{"Your bill", "Newsletter", "Card statement", "Final bill"} {
+		messages = append(messages, &EmailMessage{ID: string(rune('a' + i)), From: "billing@example.com", Subject: subject})
+	}
+
+	// The second batch fails, the payments of the first are already stored
+	result, err := processor.ProcessEmails(context.Background(), "acc-1", messages, false)
+	if err == nil {
+		t.Fatal("expected the extraction error, got nil")
+	}
+	if len(paymentRepo.payments) != 3 || result.Stored != 3 {
+		t.Errorf("expected the 3 payments of the first batch stored, got %d (result %d)", len(paymentRepo.payments), result.Stored)
+	}
+	if result.Processed != LLMBatchSize {
+		t.Errorf("expected %d emails processed, got %d", LLMBatchSize, result.Processed)
+	}
+}
+
+func TestLLMProcessor_ProcessLLMSyncJobs_StoreFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		upsertErr  error
+		wantStatus string // Status of the bill's job, the newsletter's is always completed
+	}{
+		{"stored", nil, models.LLMStatusCompleted},
+		{"store failed", errors.New("connection reset by peer"), models.LLMStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobRepo := &mockLLMJobRepository{statuses: make(map[string]string)}
+			processor := &LLMProcessor{
+				llmSyncJobRepo: jobRepo,
+				paymentRepo:    &mockPaymentUpserter{err: tt.upsertErr},
+				mailSource: &mockMailSource{messages: map[string]*EmailMessage{
+					"msg-1": {ID: "msg-1", From: "billing@example.com", Subject: "Your bill"},
+					"msg-2": {ID: "msg-2", From: "news@example.com", Subject: "Newsletter"},
+				}},
+				extractor:   &mockExtractor{},
+				retryPolicy: NewRetryPolicy(3),
+			}
+
+			jobs := []models.LLMSyncJob{
+				{ID: "job-1", AccountID: "acc-1", MessageID: "msg-1", Attempts: 1},
+				{ID: "job-2", AccountID: "acc-1", MessageID: "msg-2", Attempts: 1},
+			}
+			if err := processor.ProcessLLMSyncJobs(context.Background(), jobs); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if jobRepo.statuses["job-1"] != tt.wantStatus {
+				t.Errorf("expected the bill's job to be %s, got %q", tt.wantStatus, jobRepo.statuses["job-1"])
+			}
+			if jobRepo.statuses["job-2"] != models.LLMStatusCompleted {
+				t.Errorf("expected the newsletter's job to be completed, got %q", jobRepo.statuses["job-2"])
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vipul43/kiwis-worker/internal/merchant"
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// MerchantBackfillBatchSize is how many payments a backfill links per transaction
+const MerchantBackfillBatchSize = 500
+
+// merchantRegistryTTL is how long Normalise reuses the loaded merchants before reloading them
+// Merchants registered by other workers are seen after at most this long, registering one again resolves to it
+const merchantRegistryTTL = 10 * time.Minute
+
+// MerchantRepository interface for dependency injection
+type MerchantRepository interface {
+	List(ctx context.Context) ([]models.Merchant, error)
+	Register(ctx context.Context, merchant models.Merchant) (string, error)
+	AddAlias(ctx context.Context, merchantID, alias string) error
+	ListPayments(ctx context.Context, afterID string, limit int, all bool) ([]models.Payment, error)
+	LinkPayments(ctx context.Context, payments []models.Payment) error
+}
+
+// MerchantNormaliser links payments to canonical merchants, see internal/merchant
+// Names matched by sender domain become aliases of their merchant; fuzzy matches only link their payment, a
+// close name may be another merchant. Names matching no merchant register a new one, named as written, with the
+// sender domain when it is the merchant's own
+type MerchantNormaliser struct {
+	merchantRepo MerchantRepository
+
+	mu       sync.Mutex // Guards the registry, Normalise runs concurrently for the LLM workers
+	registry *merchant.Registry
+	loadedAt time.Time
+}
+
+func NewMerchantNormaliser(merchantRepo MerchantRepository) *MerchantNormaliser {
+	return &MerchantNormaliser{merchantRepo: merchantRepo}
+}
+
+// Normalise sets the canonical merchant of payments, and the merchant's default category where they have none
+func (n *MerchantNormaliser) Normalise(ctx context.Context, payments []models.Payment) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.registry == nil || time.Since(n.loadedAt) >= merchantRegistryTTL {
+		merchants, err := n.merchantRepo.List(ctx)
+		if err != nil {
+			return err
+		}
+		n.registry = merchant.NewRegistry(merchants)
+		n.loadedAt = time.Now()
+	}
+
+	for i := range payments {
+		if err := n.normalise(ctx, n.registry, &payments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backfill links stored payments to canonical merchants in batches, those without one unless all is set
+// (e.g. after aliases were added by hand). Returns the number of payments linked
+func (n *MerchantNormaliser) Backfill(ctx context.Context, all bool) (int, error) {
+	merchants, err := n.merchantRepo.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	registry := merchant.NewRegistry(merchants)
+
+	linked := 0
+	afterID := ""
+	for {
+		payments, err := n.merchantRepo.ListPayments(ctx, afterID, MerchantBackfillBatchSize, all)
+		if err != nil {
+			return linked, err
+		}
+		if len(payments) == 0 {
+			return linked, nil
+		}
+
+		for i := range payments {
+			if err := n.normalise(ctx, registry, &payments[i]); err != nil {
+				return linked, err
+			}
+		}
+		if err := n.merchantRepo.LinkPayments(ctx, payments); err != nil {
+			return linked, err
+		}
+		linked += len(payments)
+		afterID = payments[len(payments)-1].ID
+		log.Printf("Linked %d payments to their merchants so far", linked)
+	}
+}
+
+// normalise resolves the canonical merchant of a payment, registering it when it is not known yet
+func (n *MerchantNormaliser) normalise(ctx context.Context, registry *merchant.Registry, payment *models.Payment) error {
+	key := merchant.Key(payment.Merchant)
+	if key == "" {
+		return nil
+	}
+	domain := ""
+	if payment.SenderDomain != nil {
+		domain = *payment.SenderDomain
+	}
+
+	match, ok := registry.Resolve(payment.Merchant, domain)
+	switch {
+	case !ok:
+		registered, err := n.register(ctx, registry, payment, key, domain)
+		if err != nil {
+			return err
+		}
+		match.MerchantID = registered
+	case match.Method == merchant.MatchDomain:
+		// Sent from the merchant's own domain, later payments written the same way match exactly
+		if err := n.merchantRepo.AddAlias(ctx, match.MerchantID, key); err != nil {
+			return err
+		}
+		registry.AddAlias(match.MerchantID, key)
+	case match.Method == merchant.MatchFuzzy:
+		// Not learned as an alias, it would link every later payment of a similar name
+		log.Printf("Linked merchant %q to merchant %s by fuzzy match (similarity %.2f)", payment.Merchant, match.MerchantID, match.Similarity)
+	}
+
+	payment.MerchantID = &match.MerchantID
+	if canonical, ok := registry.Merchant(match.MerchantID); ok && payment.Category == nil {
+		payment.Category = canonical.DefaultCategory
+	}
+	return nil
+}
+
+// register creates the canonical merchant of a payment matching none, and adds it to the registry
+func (n *MerchantNormaliser) register(ctx context.Context, registry *merchant.Registry, payment *models.Payment, key, domain string) (string, error) {
+	canonical := models.Merchant{
+		ID:              uuid.New().String(),
+		Name:            strings.TrimSpace(payment.Merchant),
+		DefaultCategory: payment.Category,
+		Aliases:         []models.MerchantAlias{{Alias: key}},
+	}
+	if own := merchant.OwnDomain(key, domain); own != "" {
+		canonical.Domains = []models.MerchantDomain{{Domain: own}}
+	}
+
+	merchantID, err := n.merchantRepo.Register(ctx, canonical)
+	if err != nil {
+		return "", fmt.Errorf("failed to register merchant %s: %w", canonical.Name, err)
+	}
+	if merchantID == canonical.ID {
+		registry.Add(canonical)
+		log.Printf("Registered merchant %s", canonical.Name)
+	} else {
+		// Registered by a concurrent worker
+		registry.AddAlias(merchantID, key)
+	}
+	return merchantID, nil
+}
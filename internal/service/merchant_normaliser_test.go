@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+type mockMerchantRepository struct {
+	merchants  []models.Merchant
+	lists      int // Calls of List
+	registered []models.Merchant
+	aliases    map[string]string // Alias -> merchant ID added
+	payments   []models.Payment
+	linked     []models.Payment
+}
+
+func (m *mockMerchantRepository) List(ctx context.Context) ([]models.Merchant, error) {
+	m.lists++
+	return m.merchants, nil
+}
+
+func (m *mockMerchantRepository) Register(ctx context.Context, merchant models.Merchant) (string, error) {
+	m.registered = append(m.registered, merchant)
+	return merchant.ID, nil
+}
+
+func (m *mockMerchantRepository) AddAlias(ctx context.Context, merchantID, alias string) error {
+	if m.aliases == nil {
+		m.aliases = make(map[string]string)
+	}
+	m.aliases[alias] = merchantID
+	return nil
+}
+
+func (m *mockMerchantRepository) ListPayments(ctx context.Context, afterID string, limit int, all bool) ([]models.Payment, error) {
+	var page []models.Payment
+	for _, payment := range m.payments {
+		if payment.ID > afterID && (all || payment.MerchantID == nil) && len(page) < limit {
+			page = append(page, payment)
+		}
+	}
+	return page, nil
+}
+
+func (m *mockMerchantRepository) LinkPayments(ctx context.Context, payments []models.Payment) error {
+	m.linked = append(m.linked, payments...)
+	return nil
+}
+
+func netflixMerchant() models.Merchant {
+	category := models.CategorySubscription
+	return models.Merchant{
+		ID: "merchant-netflix", Name: "Netflix", DefaultCategory: &category,
+		Aliases: []models.MerchantAlias{{Alias: "netflix"}},
+		Domains: []models.MerchantDomain{{Domain: "netflix.com"}},
+	}
+}
+
+func TestMerchantNormaliser_Normalise(t *testing.T) {
+	repo := &mockMerchantRepository{merchants: []models.Merchant{netflixMerchant()}}
+	normaliser := NewMerchantNormaliser(repo)
+
+	domain := "mailer.netflix.com"
+	payments := []models.Payment{
+		{ID: "pay-1", Merchant: "NETFLIX.COM"},
+		{ID: "pay-2", Merchant: "Netflx"},
+		{ID: "pay-3", Merchant: "Netflix Streaming", SenderDomain: &domain},
+	}
+	if err := normaliser.Normalise(context.Background(), payments); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, payment := range payments {
+		if payment.MerchantID == nil || *payment.MerchantID != "merchant-netflix" {
+			t.Errorf("Normalise() %s merchant = %v, want merchant-netflix", payment.Merchant, payment.MerchantID)
+		}
+		if payment.Category == nil || *payment.Category != models.CategorySubscription {
+			t.Errorf("Normalise() %s category = %v, want the merchant's default", payment.Merchant, payment.Category)
+		}
+	}
+	if len(repo.aliases) != 1 || repo.aliases["netflix streaming"] != "merchant-netflix" {
+		t.Errorf("Normalise() added aliases %v, want only the domain match", repo.aliases)
+	}
+	if len(repo.registered) != 0 {
+		t.Errorf("Normalise() registered %+v, want no new merchant", repo.registered)
+	}
+}
+
+func TestMerchantNormaliser_Normalise_CachesMerchants(t *testing.T) {
+	repo := &mockMerchantRepository{merchants: []models.Merchant{netflixMerchant()}}
+	normaliser := NewMerchantNormaliser(repo)
+
+	for _, name := range []string{"Netflix", "Zomato", "Zomato"} {
+		payments := []models.Payment{{ID: "pay-1", Merchant: name}}
+		if err := normaliser.Normalise(context.Background(), payments); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if repo.lists != 1 {
+		t.Errorf("Normalise() listed merchants %d times, want once", repo.lists)
+	}
+	if len(repo.registered) != 1 {
+		t.Errorf("Normalise() registered %+v, want Zomato once", repo.registered)
+	}
+}
+
+func TestMerchantNormaliser_Normalise_RegistersUnknownMerchant(t *testing.T) {
+	repo := &mockMerchantRepository{merchants: []models.Merchant{netflixMerchant()}}
+	normaliser := NewMerchantNormaliser(repo)
+
+	category := models.CategoryCreditCardBill
+	bankDomain := "alerts.hdfcbank.com"
+	payments := []models.Payment{
+		{ID: "pay-1", Merchant: "HDFC Bank", Category: &category, SenderDomain: &bankDomain},
+		{ID: "pay-2", Merchant: "HDFC Bank Ltd."},
+		{ID: "pay-3", Merchant: "Zomato", SenderDomain: &bankDomain}, // The bank's alert about a purchase
+	}
+	if err := normaliser.Normalise(context.Background(), payments); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(repo.registered) != 2 {
+		t.Fatalf("Normalise() registered %+v, want HDFC Bank and Zomato", repo.registered)
+	}
+	hdfc, zomato := repo.registered[0], repo.registered[1]
+	if hdfc.Name != "HDFC Bank" || len(hdfc.Domains) != 1 || hdfc.Domains[0].Domain != "hdfcbank.com" ||
+		hdfc.DefaultCategory == nil || *hdfc.DefaultCategory != category {
+		t.Errorf("Normalise() registered %+v, want HDFC Bank with its domain and category", hdfc)
+	}
+	if zomato.Name != "Zomato" || len(zomato.Domains) != 0 {
+		t.Errorf("Normalise() registered %+v, want Zomato without the bank's domain", zomato)
+	}
+	if *payments[1].MerchantID != hdfc.ID || *payments[2].MerchantID != zomato.ID {
+		t.Errorf("Normalise() linked %v and %v, want %s and %s", *payments[1].MerchantID, *payments[2].MerchantID, hdfc.ID, zomato.ID)
+	}
+}
+
+func TestMerchantNormaliser_Backfill(t *testing.T) {
+	linkedID := "merchant-netflix"
+	repo := &mockMerchantRepository{merchants: []models.Merchant{netflixMerchant()}}
+	for i := range MerchantBackfillBatchSize + 10 {
+		repo.payments = append(repo.payments, models.Payment{ID: fmt.Sprintf("pay-%04d", i), Merchant: "Netflix"})
+	}
+	repo.payments[0].MerchantID = &linkedID
+
+	linked, err := NewMerchantNormaliser(repo).Backfill(context.Background(), false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if linked != MerchantBackfillBatchSize+9 || len(repo.linked) != linked {
+		t.Errorf("Backfill() = %d, linked %d, want %d payments without a merchant", linked, len(repo.linked), MerchantBackfillBatchSize+9)
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const PaymentAgingBatchSize = 500 // Payments moved per transaction
+
+// PaymentStatusRepository interface for dependency injection
+type PaymentStatusRepository interface {
+	AgeStatuses(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// PaymentStatusProcessor moves scheduled, upcoming and due payments to due and overdue as their date comes near
+// and passes, using the same 24-hour rule as the extraction prompt (models.AgedPaymentStatus)
+type PaymentStatusProcessor struct {
+	paymentRepo PaymentStatusRepository
+	now         func() time.Time
+}
+
+func NewPaymentStatusProcessor(paymentRepo PaymentStatusRepository) *PaymentStatusProcessor {
+	return &PaymentStatusProcessor{
+		paymentRepo: paymentRepo,
+		now:         time.Now,
+	}
+}
+
+// AgePayments re-evaluates payments against their date in batches of PaymentAgingBatchSize until none is left to
+// move, returns the number of payments moved
+func (p *PaymentStatusProcessor) AgePayments(ctx context.Context) (int, error) {
+	now := p.now()
+	total := 0
+	for {
+		moved, err := p.paymentRepo.AgeStatuses(ctx, now, PaymentAgingBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to age payment statuses: %w", err)
+		}
+		total += moved
+		if moved < PaymentAgingBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("Moved %d payment(s) to due or overdue", total)
+	}
+	return total, nil
+}
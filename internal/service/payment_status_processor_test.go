@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type mockPaymentStatusRepository struct {
+	ageStatusesFunc func(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+func (m *mockPaymentStatusRepository) AgeStatuses(ctx context.Context, now time.Time, limit int) (int, error) {
+	return m.ageStatusesFunc(ctx, now, limit)
+}
+
+func TestPaymentStatusProcessor_AgePayments_Batches(t *testing.T) {
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	remaining := PaymentAgingBatchSize + 20
+	calls := 0
+	mockRepo := &mockPaymentStatusRepository{
+		ageStatusesFunc: func(ctx context.Context, at time.Time, limit int) (int, error) {
+			calls++
+			if !at.Equal(now) {
+				t.Errorf("AgeStatuses() now = %v, want %v for every batch", at, now)
+			}
+			moved := min(remaining, limit)
+			remaining -= moved
+			return moved, nil
+		},
+	}
+
+	processor := NewPaymentStatusProcessor(mockRepo)
+	processor.now = func() time.Time { return now }
+
+	moved, err := processor.AgePayments(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if moved != PaymentAgingBatchSize+20 || calls != 2 {
+		t.Errorf("AgePayments() = %d in %d batches, want %d in 2", moved, calls, PaymentAgingBatchSize+20)
+	}
+}
+
+func TestPaymentStatusProcessor_AgePayments_Error(t *testing.T) {
+	mockRepo := &mockPaymentStatusRepository{
+		ageStatusesFunc: func(ctx context.Context, now time.Time, limit int) (int, error) {
+			return 0, errors.New("connection refused")
+		},
+	}
+
+	processor := NewPaymentStatusProcessor(mockRepo)
+
+	if _, err := processor.AgePayments(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/reconcile"
+)
+
+// PaymentGetter interface for dependency injection
+type PaymentGetter interface {
+	GetByID(ctx context.Context, paymentID string) (*models.Payment, error)
+}
+
+// PaymentLinkRepository interface for dependency injection
+type PaymentLinkRepository interface {
+	FindObligations(ctx context.Context, payment models.Payment) ([]models.Payment, error)
+	FindSettlements(ctx context.Context, obligation models.Payment) ([]models.Payment, error)
+	FindStatementDues(ctx context.Context, obligation models.Payment) ([]models.Payment, error)
+	Suggest(ctx context.Context, link models.PaymentLink) error
+	Apply(ctx context.Context, link models.PaymentLink) error
+	Reject(ctx context.Context, linkID string) error
+	GetByID(ctx context.Context, linkID string) (*models.PaymentLink, error)
+}
+
+// Reconciler links obligations (bills, due payments) to the paid payments that settle them, see internal/reconcile
+// Matches from reconcile.AutoLinkConfidence are applied, weaker ones from reconcile.SuggestConfidence are
+// suggested for manual review; Link, Confirm and Reject are the manual override
+type Reconciler struct {
+	paymentRepo PaymentGetter
+	linkRepo    PaymentLinkRepository
+}
+
+func NewReconciler(paymentRepo PaymentGetter, linkRepo PaymentLinkRepository) *Reconciler {
+	return &Reconciler{
+		paymentRepo: paymentRepo,
+		linkRepo:    linkRepo,
+	}
+}
+
+// ReconcilePayments reconciles stored payments (created, or changed by a merge): a paid payment against the open obligations it may settle,
+// an open obligation against paid payments extracted before it. Returns the number of links applied
+func (r *Reconciler) ReconcilePayments(ctx context.Context, payments []models.Payment) (int, error) {
+	linked := 0
+	for _, payment := range payments {
+		var match reconcile.Match
+		var found bool
+		switch {
+		case payment.Status == models.PaymentStatusPaid:
+			obligations, err := r.linkRepo.FindObligations(ctx, payment)
+			if err != nil {
+				return linked, err
+			}
+			match, found = reconcile.Best(payment, obligations)
+		case reconcile.IsOpen(payment):
+			settlements, err := r.linkRepo.FindSettlements(ctx, payment)
+			if err != nil {
+				return linked, err
+			}
+			match, found = reconcile.BestSettlement(payment, settlements)
+		}
+		if !found || match.Confidence < reconcile.SuggestConfidence {
+			continue
+		}
+
+		link := newPaymentLink(match.Obligation, match.Settlement, match.Settles, match.Confidence, match.Reasons,
+			models.PaymentLinkSourceAuto)
+		if match.Confidence < reconcile.AutoLinkConfidence {
+			if err := r.linkRepo.Suggest(ctx, link); err != nil {
+				return linked, err
+			}
+			log.Printf("Suggested payment %s as settling %s (confidence %.2f: %s)",
+				link.SettlementID, link.ObligationID, link.Confidence, *link.Reasons)
+			continue
+		}
+
+		if err := r.linkRepo.Apply(ctx, link); err != nil {
+			return linked, err
+		}
+		linked++
+		log.Printf("Linked payment %s as settling %s, marked %s (confidence %.2f: %s)",
+			link.SettlementID, link.ObligationID, link.Settles, link.Confidence, *link.Reasons)
+
+		settled, err := r.settleStatementDues(ctx, match.Obligation, match.Settlement, link)
+		linked += settled
+		if err != nil {
+			return linked, err
+		}
+	}
+	return linked, nil
+}
+
+// Link links an obligation to the payment settling it by hand, whatever their score
+func (r *Reconciler) Link(ctx context.Context, obligationID, settlementID string) (*models.PaymentLink, error) {
+	obligation, err := r.paymentRepo.GetByID(ctx, obligationID)
+	if err != nil {
+		return nil, err
+	}
+	settlement, err := r.paymentRepo.GetByID(ctx, settlementID)
+	if err != nil {
+		return nil, err
+	}
+	if obligation.AccountID != settlement.AccountID {
+		return nil, fmt.Errorf("payments %s and %s belong to different accounts", obligationID, settlementID)
+	}
+
+	link := newPaymentLink(*obligation, *settlement, reconcile.Settles(*obligation, *settlement), 1,
+		[]string{"manual"}, models.PaymentLinkSourceManual)
+	if err := r.linkRepo.Apply(ctx, link); err != nil {
+		return nil, err
+	}
+	if _, err := r.settleStatementDues(ctx, *obligation, *settlement, link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Confirm applies a suggested link
+func (r *Reconciler) Confirm(ctx context.Context, linkID string) error {
+	link, err := r.linkRepo.GetByID(ctx, linkID)
+	if err != nil {
+		return err
+	}
+	if link.Status != models.PaymentLinkSuggested {
+		return fmt.Errorf("payment link %s is %s, only suggested links can be confirmed", linkID, link.Status)
+	}
+
+	link.Source = models.PaymentLinkSourceManual
+	if err := r.linkRepo.Apply(ctx, *link); err != nil {
+		return err
+	}
+
+	obligation, err := r.paymentRepo.GetByID(ctx, link.ObligationID)
+	if err != nil {
+		return err
+	}
+	settlement, err := r.paymentRepo.GetByID(ctx, link.SettlementID)
+	if err != nil {
+		return err
+	}
+	_, err = r.settleStatementDues(ctx, *obligation, *settlement, *link)
+	return err
+}
+
+// Reject rejects a suggested or applied link, see PaymentLinkRepository.Reject
+func (r *Reconciler) Reject(ctx context.Context, linkID string) error {
+	return r.linkRepo.Reject(ctx, linkID)
+}
+
+// settleStatementDues links the settlement of one due of a credit card statement to the statement's other dues,
+// with the source and confidence of that link (see reconcile.StatementDues). Returns the number of links applied
+func (r *Reconciler) settleStatementDues(ctx context.Context, obligation, settlement models.Payment, settled models.PaymentLink) (int, error) {
+	payments, err := r.linkRepo.FindStatementDues(ctx, obligation)
+	if err != nil {
+		return 0, err
+	}
+
+	linked := 0
+	for _, due := range reconcile.StatementDues(obligation, payments) {
+		link := newPaymentLink(due, settlement, reconcile.Settles(due, settlement), settled.Confidence,
+			[]string{"same statement"}, settled.Source)
+		if err := r.linkRepo.Apply(ctx, link); err != nil {
+			return linked, err
+		}
+		linked++
+		log.Printf("Linked payment %s as settling %s of the same statement as %s, marked %s",
+			link.SettlementID, link.ObligationID, obligation.ID, link.Settles)
+	}
+	return linked, nil
+}
+
+// newPaymentLink builds a link of settlement to obligation
+func newPaymentLink(obligation, settlement models.Payment, settles string, confidence float64, reasons []string, source string) models.PaymentLink {
+	joined := strings.Join(reasons, ", ")
+	return models.PaymentLink{
+		ID:           uuid.New().String(),
+		AccountID:    obligation.AccountID,
+		ObligationID: obligation.ID,
+		SettlementID: settlement.ID,
+		Settles:      settles,
+		Confidence:   confidence,
+		Reasons:      &joined,
+		Source:       source,
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+// mockPaymentGetter serves payments from memory
+type mockPaymentGetter struct {
+	payments map[string]models.Payment
+}
+
+func (m *mockPaymentGetter) GetByID(ctx context.Context, paymentID string) (*models.Payment, error) {
+	payment := m.payments[paymentID]
+	return &payment, nil
+}
+
+type mockPaymentLinkRepository struct {
+	obligations []models.Payment
+	settlements []models.Payment
+	dues        []models.Payment // Payments of the obligation's email
+	links       map[string]*models.PaymentLink
+	applied     []models.PaymentLink
+	suggested   []models.PaymentLink
+}
+
+func (m *mockPaymentLinkRepository) FindObligations(ctx context.Context, payment models.Payment) ([]models.Payment, error) {
+	return m.obligations, nil
+}
+
+func (m *mockPaymentLinkRepository) FindSettlements(ctx context.Context, obligation models.Payment) ([]models.Payment, error) {
+	return m.settlements, nil
+}
+
+func (m *mockPaymentLinkRepository) FindStatementDues(ctx context.Context, obligation models.Payment) ([]models.Payment, error) {
+	return m.dues, nil
+}
+
+func (m *mockPaymentLinkRepository) Suggest(ctx context.Context, link models.PaymentLink) error {
+	m.suggested = append(m.suggested, link)
+	return nil
+}
+
+func (m *mockPaymentLinkRepository) Apply(ctx context.Context, link models.PaymentLink) error {
+	m.applied = append(m.applied, link)
+	return nil
+}
+
+func (m *mockPaymentLinkRepository) Reject(ctx context.Context, linkID string) error {
+	return nil
+}
+
+func (m *mockPaymentLinkRepository) GetByID(ctx context.Context, linkID string) (*models.PaymentLink, error) {
+	return m.links[linkID], nil
+}
+
+func reconcilerPayments() (bill, receipt models.Payment) {
+	due := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	bill = models.Payment{
+		ID: "bill-1", AccountID: "acc-1", Merchant: "HDFC Bank", Amount: models.MustParseAmount("24500"), Currency: "INR", Date: due,
+		Status: models.PaymentStatusDue, Metadata: models.JSONB{"card_last_four": "4321", "minimum_due": 1225.0},
+	}
+	receipt = models.Payment{
+		ID: "pay-1", AccountID: "acc-1", Merchant: "HDFC Bank", Amount: models.MustParseAmount("24500"), Currency: "INR", Date: due.AddDate(0, 0, -2),
+		Status: models.PaymentStatusPaid, Metadata: models.JSONB{"card_last_four": "4321"},
+	}
+	return bill, receipt
+}
+
+func TestReconciler_ReconcilePayments_LinksPaidPayment(t *testing.T) {
+	bill, receipt := reconcilerPayments()
+	linkRepo := &mockPaymentLinkRepository{obligations: []models.Payment{bill}}
+	reconciler := NewReconciler(nil, linkRepo)
+
+	linked, err := reconciler.ReconcilePayments(context.Background(), []models.Payment{receipt})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if linked != 1 || len(linkRepo.applied) != 1 {
+		t.Fatalf("ReconcilePayments() linked %d, applied %d, want 1", linked, len(linkRepo.applied))
+	}
+	link := linkRepo.applied[0]
+	if link.ObligationID != "bill-1" || link.SettlementID != "pay-1" || link.Settles != models.PaymentStatusPaid ||
+		link.Source != models.PaymentLinkSourceAuto {
+		t.Errorf("ReconcilePayments() applied %+v", link)
+	}
+}
+
+func TestReconciler_ReconcilePayments_LinksObligationToEarlierPayment(t *testing.T) {
+	bill, receipt := reconcilerPayments()
+	receipt.Amount = models.MustParseAmount("1225")
+	linkRepo := &mockPaymentLinkRepository{settlements: []models.Payment{receipt}}
+	reconciler := NewReconciler(nil, linkRepo)
+
+	if _, err := reconciler.ReconcilePayments(context.Background(), []models.Payment{bill}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(linkRepo.applied) != 1 || linkRepo.applied[0].Settles != models.PaymentStatusPartiallyPaid {
+		t.Errorf("ReconcilePayments() applied %+v, want the minimum due payment as partially paying the bill", linkRepo.applied)
+	}
+}
+
+func TestReconciler_ReconcilePayments_SuggestsWeakMatch(t *testing.T) {
+	bill, receipt := reconcilerPayments()
+	delete(receipt.Metadata, "card_last_four")
+	receipt.Date = bill.Date.AddDate(0, 0, 30) // Paid a month late, no card number
+	linkRepo := &mockPaymentLinkRepository{obligations: []models.Payment{bill}}
+	reconciler := NewReconciler(nil, linkRepo)
+
+	linked, err := reconciler.ReconcilePayments(context.Background(), []models.Payment{receipt})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if linked != 0 || len(linkRepo.suggested) != 1 {
+		t.Errorf("ReconcilePayments() linked %d, suggested %d, want a suggestion only", linked, len(linkRepo.suggested))
+	}
+}
+
+func TestReconciler_Confirm(t *testing.T) {
+	linkRepo := &mockPaymentLinkRepository{links: map[string]*models.PaymentLink{
+		"link-1": {ID: "link-1", Status: models.PaymentLinkSuggested, Source: models.PaymentLinkSourceAuto},
+		"link-2": {ID: "link-2", Status: models.PaymentLinkRejected, Source: models.PaymentLinkSourceAuto},
+	}}
+	reconciler := NewReconciler(&mockPaymentGetter{}, linkRepo)
+
+	if err := reconciler.Confirm(context.Background(), "link-1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(linkRepo.applied) != 1 || linkRepo.applied[0].Source != models.PaymentLinkSourceManual {
+		t.Errorf("Confirm() applied %+v, want the link applied as manual", linkRepo.applied)
+	}
+
+	if err := reconciler.Confirm(context.Background(), "link-2"); err == nil {
+		t.Error("expected an error confirming a rejected link")
+	}
+}
+
+// statementDues returns a credit card statement extracted as two payments of one email, its total and minimum due
+func statementDues() (total, minimum models.Payment) {
+	bill, _ := reconcilerPayments()
+	delete(bill.Metadata, "minimum_due")
+	message := "msg-1"
+	total, minimum = bill, bill
+	total.SourceMessageID, minimum.SourceMessageID = &message, &message
+	total.Metadata = models.JSONB{"card_last_four": "4321", "due_type": "total"}
+	minimum.ID = "bill-2"
+	minimum.Amount = models.MustParseAmount("1225")
+	minimum.Metadata = models.JSONB{"card_last_four": "4321", "due_type": "minimum"}
+	return total, minimum
+}
+
+func TestReconciler_ReconcilePayments_SettlesStatementDues(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      string
+		wantTotal   string // Status the total due is settled as
+		wantMinimum string // Status the minimum due is settled as
+	}{
+		{"total paid", "24500", models.PaymentStatusPaid, models.PaymentStatusPaid},
+		{"minimum paid", "1225", models.PaymentStatusPartiallyPaid, models.PaymentStatusPaid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, minimum := statementDues()
+			_, receipt := reconcilerPayments()
+			receipt.Amount = models.MustParseAmount(tt.amount)
+			linkRepo := &mockPaymentLinkRepository{
+				obligations: []models.Payment{total, minimum},
+				dues:        []models.Payment{total, minimum},
+			}
+			reconciler := NewReconciler(nil, linkRepo)
+
+			linked, err := reconciler.ReconcilePayments(context.Background(), []models.Payment{receipt})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if linked != 2 || len(linkRepo.applied) != 2 {
+				t.Fatalf("ReconcilePayments() linked %d, applied %+v, want both dues linked", linked, linkRepo.applied)
+			}
+			settles := make(map[string]string)
+			for _, link := range linkRepo.applied {
+				if link.SettlementID != "pay-1" || link.Source != models.PaymentLinkSourceAuto {
+					t.Errorf("ReconcilePayments() applied %+v", link)
+				}
+				settles[link.ObligationID] = link.Settles
+			}
+			if settles["bill-1"] != tt.wantTotal || settles["bill-2"] != tt.wantMinimum {
+				t.Errorf("ReconcilePayments() settled the total as %q and the minimum as %q, want %q and %q",
+					settles["bill-1"], settles["bill-2"], tt.wantTotal, tt.wantMinimum)
+			}
+		})
+	}
+}
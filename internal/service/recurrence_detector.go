@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vipul43/kiwis-worker/internal/dedup"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/recurrence"
+)
+
+// RecurringPaymentRepository interface for dependency injection
+type RecurringPaymentRepository interface {
+	ListAccountIDs(ctx context.Context) ([]string, error)
+	GetHistory(ctx context.Context, accountID string) ([]models.Payment, error)
+	Save(ctx context.Context, series models.RecurringPayment, memberIDs []string, projections []models.Payment) (string, error)
+	DeactivateExcept(ctx context.Context, accountID string, keepIDs []string) error
+}
+
+// RecurrenceDetector detects recurring payments per merchant in each account's payment history and keeps
+// projected scheduled payments for their next occurrences, see internal/recurrence
+type RecurrenceDetector struct {
+	recurringRepo RecurringPaymentRepository
+	now           func() time.Time
+}
+
+func NewRecurrenceDetector(recurringRepo RecurringPaymentRepository) *RecurrenceDetector {
+	return &RecurrenceDetector{
+		recurringRepo: recurringRepo,
+		now:           time.Now,
+	}
+}
+
+// DetectAll runs detection for every account with payments, returns the number of active series
+// An account that fails is logged and skipped
+func (d *RecurrenceDetector) DetectAll(ctx context.Context) (int, error) {
+	accountIDs, err := d.recurringRepo.ListAccountIDs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	total := 0
+	for _, accountID := range accountIDs {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		active, err := d.DetectAccount(ctx, accountID)
+		if err != nil {
+			log.Printf("Failed to detect recurring payments for account %s: %v", accountID, err)
+			continue
+		}
+		total += active
+	}
+	return total, nil
+}
+
+// DetectAccount detects the recurring payments of an account, returns the number of active series
+// Series no longer detected are deactivated and their projections deleted
+func (d *RecurrenceDetector) DetectAccount(ctx context.Context, accountID string) (int, error) {
+	payments, err := d.recurringRepo.GetHistory(ctx, accountID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get payments: %w", err)
+	}
+
+	now := d.now()
+	active := 0
+	keepIDs := make([]string, 0)
+	for _, group := range groupByMerchant(payments) {
+		pattern, ok := recurrence.Detect(group)
+		if !ok {
+			continue
+		}
+
+		series, memberIDs, projections := buildSeries(accountID, group, pattern, now)
+		seriesID, err := d.recurringRepo.Save(ctx, series, memberIDs, projections)
+		if err != nil {
+			return active, err
+		}
+		keepIDs = append(keepIDs, seriesID)
+		if series.Active {
+			active++
+		}
+		if pattern.PriceChangedAt != nil && pattern.PreviousAmount != nil {
+			log.Printf("Price of %s changed from %s to %s on %s", series.Merchant,
+				models.Money{Amount: *pattern.PreviousAmount, Currency: series.Currency},
+				models.Money{Amount: pattern.LastAmount, Currency: series.Currency}, pattern.PriceChangedAt.Format("2006-01-02"))
+		}
+	}
+
+	if err := d.recurringRepo.DeactivateExcept(ctx, accountID, keepIDs); err != nil {
+		return active, err
+	}
+	return active, nil
+}
+
+// groupByMerchant groups payments by canonical merchant and currency, keeping their order
+// Payments without a canonical merchant join the merchant of other payments with their normalised name, or are
+// grouped by normalised name
+func groupByMerchant(payments []models.Payment) [][]models.Payment {
+	merchantIDs := make(map[string]string) // Canonical merchant by normalised name
+	for _, payment := range payments {
+		if payment.MerchantID != nil {
+			merchantIDs[dedup.MerchantKey(payment.Merchant)] = *payment.MerchantID
+		}
+	}
+
+	index := make(map[string]int)
+	var groups [][]models.Payment
+	for _, payment := range payments {
+		key := "key:" + dedup.MerchantKey(payment.Merchant)
+		if payment.MerchantID != nil {
+			key = "id:" + *payment.MerchantID
+		} else if merchantID, ok := merchantIDs[dedup.MerchantKey(payment.Merchant)]; ok {
+			key = "id:" + merchantID
+		}
+		key += "|" + strings.ToUpper(payment.Currency)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], payment)
+	}
+	return groups
+}
+
+// buildSeries converts a detected pattern into a recurring payment, the IDs of its member payments and projected
+// payments for its next occurrences (described like the latest payment)
+func buildSeries(accountID string, group []models.Payment, pattern recurrence.Pattern, now time.Time) (models.RecurringPayment, []string, []models.Payment) {
+	var latest models.Payment
+	var merchantID *string
+	memberIDs := make([]string, 0, len(group))
+	for _, payment := range group {
+		if payment.MerchantID != nil {
+			merchantID = payment.MerchantID
+		}
+		if !recurrence.Counts(payment) {
+			continue
+		}
+		memberIDs = append(memberIDs, payment.ID)
+		if payment.Date.After(latest.Date) || latest.ID == "" {
+			latest = payment
+		}
+	}
+
+	dates := pattern.Project(now)
+	series := models.RecurringPayment{
+		ID:             uuid.New().String(),
+		AccountID:      accountID,
+		MerchantKey:    dedup.MerchantKey(latest.Merchant),
+		MerchantID:     merchantID,
+		Merchant:       latest.Merchant,
+		Currency:       strings.ToUpper(latest.Currency),
+		Recurrence:     pattern.Recurrence,
+		TypicalAmount:  pattern.TypicalAmount,
+		LastAmount:     pattern.LastAmount,
+		FixedAmount:    pattern.FixedAmount,
+		PreviousAmount: pattern.PreviousAmount,
+		PriceChangedAt: pattern.PriceChangedAt,
+		DayOfMonth:     pattern.DayOfMonth,
+		Occurrences:    pattern.Occurrences,
+		Confidence:     pattern.Confidence,
+		LastDate:       pattern.LastDate,
+		Active:         len(dates) > 0,
+	}
+	if len(dates) > 0 {
+		series.NextDate = &dates[0]
+	}
+
+	projections := make([]models.Payment, 0, len(dates))
+	for _, date := range dates {
+		projection := models.Payment{
+			ID:          uuid.New().String(),
+			AccountID:   accountID,
+			Merchant:    latest.Merchant,
+			MerchantID:  merchantID,
+			Description: latest.Description,
+			Amount:      pattern.ProjectedAmount(),
+			Currency:    series.Currency,
+			Date:        date,
+			Recurrence:  &series.Recurrence,
+			Status:      models.PaymentStatusScheduled,
+			Category:    latest.Category,
+			Projected:   true,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if !pattern.FixedAmount {
+			projection.Metadata = models.JSONB{"estimated_amount": true} // Typical amount of a variable bill
+		}
+		dedup.Prepare(&projection)
+		projections = append(projections, projection)
+	}
+
+	return series, memberIDs, projections
+}
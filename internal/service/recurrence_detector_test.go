@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/models"
+)
+
+type savedSeries struct {
+	series      models.RecurringPayment
+	memberIDs   []string
+	projections []models.Payment
+}
+
+type mockRecurringPaymentRepository struct {
+	history map[string][]models.Payment
+	saved   []savedSeries
+	keepIDs []string
+}
+
+func (m *mockRecurringPaymentRepository) ListAccountIDs(ctx context.Context) ([]string, error) {
+	accountIDs := make([]string, 0, len(m.history))
+	for accountID := range m.history {
+		accountIDs = append(accountIDs, accountID)
+	}
+	return accountIDs, nil
+}
+
+func (m *mockRecurringPaymentRepository) GetHistory(ctx context.Context, accountID string) ([]models.Payment, error) {
+	return m.history[accountID], nil
+}
+
+func (m *mockRecurringPaymentRepository) Save(ctx context.Context, series models.RecurringPayment, memberIDs []string, projections []models.Payment) (string, error) {
+	m.saved = append(m.saved, savedSeries{series, memberIDs, projections})
+	return series.ID, nil
+}
+
+func (m *mockRecurringPaymentRepository) DeactivateExcept(ctx context.Context, accountID string, keepIDs []string) error {
+	m.keepIDs = keepIDs
+	return nil
+}
+
+// subscriptionHistory returns four monthly Netflix receipts from January 2026 and an unrelated one-off payment
+func subscriptionHistory(amounts ...string) []models.Payment {
+	payments := []models.Payment{{
+		ID: "pay-amazon", AccountID: "acc-1", Merchant: "Amazon", Amount: models.MustParseAmount("42"), Currency: "USD",
+		Date: time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC), Status: models.PaymentStatusPaid,
+	}}
+	for i, amount := range amounts {
+		payments = append(payments, models.Payment{
+			ID: fmt.Sprintf("pay-netflix-%d", i+1), AccountID: "acc-1", Merchant: "NETFLIX.COM", Amount: models.MustParseAmount(amount),
+			Currency: "usd", Date: time.Date(2026, time.January+time.Month(i), 15, 0, 0, 0, 0, time.UTC),
+			Status: models.PaymentStatusPaid,
+		})
+	}
+	return payments
+}
+
+func TestRecurrenceDetector_DetectAccount_ProjectsSubscription(t *testing.T) {
+	repo := &mockRecurringPaymentRepository{
+		history: map[string][]models.Payment{"acc-1": subscriptionHistory("15.49", "15.49", "15.49", "17.99")},
+	}
+	detector := NewRecurrenceDetector(repo)
+	detector.now = func() time.Time { return time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC) }
+
+	active, err := detector.DetectAccount(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if active != 1 || len(repo.saved) != 1 {
+		t.Fatalf("DetectAccount() = %d active, saved %d series, want 1", active, len(repo.saved))
+	}
+
+	saved := repo.saved[0]
+	series := saved.series
+	if series.Recurrence != models.RecurrenceMonthly || series.Currency != "USD" || series.PreviousAmount == nil ||
+		*series.PreviousAmount != models.MustParseAmount("15.49") || series.NextDate == nil {
+		t.Errorf("DetectAccount() saved series %+v", series)
+	}
+	if len(saved.memberIDs) != 4 {
+		t.Errorf("DetectAccount() assigned %v, want the four Netflix payments", saved.memberIDs)
+	}
+	if len(saved.projections) == 0 {
+		t.Fatal("DetectAccount() projected no payments")
+	}
+	for _, projection := range saved.projections {
+		if !projection.Projected || projection.Status != models.PaymentStatusScheduled || projection.Amount != models.MustParseAmount("17.99") ||
+			projection.Fingerprint == nil {
+			t.Errorf("DetectAccount() projected %+v", projection)
+		}
+	}
+	if first := saved.projections[0].Date; !first.Equal(time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("DetectAccount() first projection on %v, want May 15", first)
+	}
+	if len(repo.keepIDs) != 1 || repo.keepIDs[0] != series.ID {
+		t.Errorf("DetectAccount() kept %v, want only %s", repo.keepIDs, series.ID)
+	}
+}
+
+func TestRecurrenceDetector_DetectAccount_EstimatesVariableBill(t *testing.T) {
+	repo := &mockRecurringPaymentRepository{
+		history: map[string][]models.Payment{"acc-1": subscriptionHistory("1200", "1350", "980", "1100")},
+	}
+	detector := NewRecurrenceDetector(repo)
+	detector.now = func() time.Time { return time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC) }
+
+	if _, err := detector.DetectAccount(context.Background(), "acc-1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(repo.saved) != 1 || len(repo.saved[0].projections) == 0 {
+		t.Fatalf("DetectAccount() saved %+v, want a series with projections", repo.saved)
+	}
+	projection := repo.saved[0].projections[0]
+	if projection.Metadata["estimated_amount"] != true {
+		t.Errorf("DetectAccount() projected %+v, want an estimated amount", projection)
+	}
+}
+
+func TestRecurrenceDetector_DetectAccount_DeactivatesLapsedSeries(t *testing.T) {
+	repo := &mockRecurringPaymentRepository{
+		history: map[string][]models.Payment{"acc-1": subscriptionHistory("15.49", "15.49", "15.49")},
+	}
+	detector := NewRecurrenceDetector(repo)
+	detector.now = func() time.Time { return time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC) } // Three renewals missing
+
+	active, err := detector.DetectAccount(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if active != 0 || len(repo.saved) != 1 || repo.saved[0].series.Active || len(repo.saved[0].projections) != 0 {
+		t.Errorf("DetectAccount() = %d active, saved %+v, want an inactive series without projections", active, repo.saved)
+	}
+}
+
+func TestRecurrenceDetector_DetectAccount_GroupsByCanonicalMerchant(t *testing.T) {
+	// Netflix receipts written two ways, normalised to one merchant, and one not normalised yet
+	merchantID := "merchant-netflix"
+	payments := subscriptionHistory("15.49", "15.49", "15.49", "15.49")
+	for i := range payments[1:] {
+		payments[i+1].MerchantID = &merchantID
+	}
+	payments[2].Merchant = "Netflix Inc."
+	payments[4].MerchantID = nil
+	repo := &mockRecurringPaymentRepository{history: map[string][]models.Payment{"acc-1": payments}}
+	detector := NewRecurrenceDetector(repo)
+	detector.now = func() time.Time { return time.Date(2026, 4, 20, 0, 0, 0, 0, time.UTC) }
+
+	if _, err := detector.DetectAccount(context.Background(), "acc-1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(repo.saved) != 1 {
+		t.Fatalf("DetectAccount() saved %d series, want one for the merchant", len(repo.saved))
+	}
+	saved := repo.saved[0]
+	if saved.series.MerchantID == nil || *saved.series.MerchantID != merchantID || saved.series.Occurrences != 4 {
+		t.Errorf("DetectAccount() saved %+v, want 4 occurrences of merchant %s", saved.series, merchantID)
+	}
+	if len(saved.memberIDs) != 4 {
+		t.Errorf("DetectAccount() members = %v, want all 4 receipts", saved.memberIDs)
+	}
+}
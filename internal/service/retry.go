@@ -0,0 +1,58 @@
+package service
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	RetryBaseDelay = 5 * time.Minute // Delay before the first retry, doubled on every further attempt
+	RetryMaxDelay  = 6 * time.Hour   // Upper bound for the backoff delay
+)
+
+// RetryPolicy decides when a failed job is retried and when it is dead-lettered
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func NewRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  RetryBaseDelay,
+		MaxDelay:   RetryMaxDelay,
+	}
+}
+
+// NextAttemptAt returns when a job that failed on its given attempt should be retried
+// Returns false once attempts exceed MaxRetries, meaning the job is dead
+func (p RetryPolicy) NextAttemptAt(attempts int, now time.Time) (time.Time, bool) {
+	if attempts > p.MaxRetries {
+		return time.Time{}, false
+	}
+	return now.Add(p.Backoff(attempts)), true
+}
+
+// Backoff returns the exponential backoff delay for the given attempt with jitter
+// Uses "equal jitter": half the delay is fixed, the other half is random
+// Jitter spreads out retries of jobs that failed together (e.g. during an outage)
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_NextAttemptAt(t *testing.T) {
+	policy := NewRetryPolicy(3)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		attempts  int
+		wantRetry bool
+	}{
+		{"first attempt", 1, true},
+		{"second attempt", 2, true},
+		{"last retry", 3, true},
+		{"exceeds max retries", 4, false},
+		{"far beyond max retries", 10, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, retry := policy.NextAttemptAt(tt.attempts, now)
+			if retry != tt.wantRetry {
+				t.Fatalf("expected retry %v, got %v", tt.wantRetry, retry)
+			}
+			if retry && !next.After(now) {
+				t.Errorf("expected next attempt after now, got %s", next)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		attempts int
+		maxDelay time.Duration
+	}{
+		{"first attempt", 1, time.Minute},
+		{"second attempt", 2, 2 * time.Minute},
+		{"third attempt", 3, 4 * time.Minute},
+		{"capped at max delay", 8, 10 * time.Minute},
+		{"zero attempts treated as first", 0, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := policy.Backoff(tt.attempts)
+				if delay < tt.maxDelay/2 || delay > tt.maxDelay {
+					t.Fatalf("expected delay between %s and %s, got %s", tt.maxDelay/2, tt.maxDelay, delay)
+				}
+			}
+		})
+	}
+}
@@ -3,23 +3,15 @@ package watcher
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/vipul43/kiwis-worker/internal/models"
 )
 
 // processAccountJob processes a single account sync job
 func (w *Watcher) processAccountJob(ctx context.Context, job models.AccountSyncJob) error {
-	log.Printf("Processing account job %s for account %s", job.ID, job.AccountID)
-
-	// Mark as processing
-	if err := w.accountJobRepo.UpdateStatus(ctx, job.ID, models.StatusProcessing, nil); err != nil {
-		return err
-	}
-
-	// Increment attempt counter
-	if err := w.accountJobRepo.IncrementAttempts(ctx, job.ID); err != nil {
-		log.Printf("Warning: failed to increment attempts: %v", err)
-	}
+	// Job is already marked as processing (and attempts incremented) by ClaimJobs
+	log.Printf("Processing account job %s for account %s (attempt %d)", job.ID, job.AccountID, job.Attempts)
 
 	// Process the account
 	err := w.accountProcessor.ProcessAccount(ctx, job.AccountID)
@@ -28,7 +20,7 @@ func (w *Watcher) processAccountJob(ctx context.Context, job models.AccountSyncJ
 	}
 
 	// Mark as completed
-	if err := w.accountJobRepo.UpdateStatus(ctx, job.ID, models.StatusCompleted, nil); err != nil {
+	if err := w.accountJobRepo.UpdateStatus(ctx, job.ID, w.cfg.WorkerID, models.StatusCompleted, nil); err != nil {
 		return err
 	}
 
@@ -44,11 +36,16 @@ func (w *Watcher) processAccountJob(ctx context.Context, job models.AccountSyncJ
 }
 
 // handleAccountJobError handles account job processing errors
-// Sets status to failed for infinite retry
+// Schedules a retry with exponential backoff, or marks the job dead once MaxRetries is exceeded
 func (w *Watcher) handleAccountJobError(ctx context.Context, job models.AccountSyncJob, err error) error {
 	errMsg := err.Error()
-	newAttempts := job.Attempts + 1
 
-	log.Printf("Account job %s failed (attempt %d): %v", job.ID, newAttempts, err)
-	return w.accountJobRepo.UpdateStatus(ctx, job.ID, models.StatusFailed, &errMsg)
+	nextAttemptAt, retry := w.retryPolicy.NextAttemptAt(job.Attempts, time.Now())
+	if !retry {
+		log.Printf("Account job %s is dead after %d attempts: %v", job.ID, job.Attempts, err)
+		return w.accountJobRepo.MarkDead(ctx, job.ID, w.cfg.WorkerID, errMsg)
+	}
+
+	log.Printf("Account job %s failed (attempt %d), retrying at %s: %v", job.ID, job.Attempts, nextAttemptAt.Format(time.RFC3339), err)
+	return w.accountJobRepo.MarkFailed(ctx, job.ID, w.cfg.WorkerID, errMsg, nextAttemptAt)
 }
@@ -2,26 +2,20 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
 )
 
 // processEmailJob processes a single email sync job
 func (w *Watcher) processEmailJob(ctx context.Context, job models.EmailSyncJob) error {
+	// Job is already marked as processing (and attempts incremented) by ClaimJobs
 	log.Printf("Processing email job %s for account %s (type: %s, fetched: %d)",
 		job.ID, job.AccountID, job.SyncType, job.EmailsFetched)
 
-	// Mark as processing
-	if err := w.emailJobRepo.UpdateStatus(ctx, job.ID, models.EmailStatusProcessing, nil); err != nil {
-		return err
-	}
-
-	// Increment attempt counter
-	if err := w.emailJobRepo.IncrementAttempts(ctx, job.ID); err != nil {
-		log.Printf("Warning: failed to increment attempts: %v", err)
-	}
-
 	// Process the email sync job
 	// ProcessEmailSyncJob updates the job object in-place with new values
 	err := w.emailProcessor.ProcessEmailSyncJob(ctx, &job)
@@ -29,47 +23,70 @@ func (w *Watcher) processEmailJob(ctx context.Context, job models.EmailSyncJob)
 		return w.handleEmailJobError(ctx, job, err)
 	}
 
-	// Check if historical sync is complete
-	if job.PageToken == nil && job.EmailsFetched >= 10000 {
-		// Reached max emails and no more pages
-		if err := w.emailJobRepo.UpdateStatus(ctx, job.ID, models.EmailStatusSynced, nil); err != nil {
-			return err
+	if job.PageToken == nil {
+		// No more pages: initial sync fetched all historical emails (or reached max emails),
+		// or an incremental sync caught up
+		if job.WatchExpiration != nil && time.Now().Before(*job.WatchExpiration) {
+			// Watch is set up (ProcessEmailSyncJob), later syncs are triggered by push notifications
+			// Sources without push notifications (IMAP, Microsoft Graph) have no watch and keep syncing periodically
+			if err := w.emailJobRepo.MarkCompleted(ctx, job.ID, w.cfg.WorkerID); err != nil {
+				return err
+			}
+			log.Printf("Email sync job %s completed (type: %s, %d total), waiting for push notifications",
+				job.ID, job.SyncType, job.EmailsFetched)
+			return nil
 		}
-		log.Printf("Email sync job %s completed: reached max emails (%d)", job.ID, job.EmailsFetched)
-		return nil
-	}
 
-	if job.PageToken == nil {
-		// No more pages, but haven't reached max emails
-		// This means we've fetched all available historical emails
-		if err := w.emailJobRepo.UpdateStatus(ctx, job.ID, models.EmailStatusSynced, nil); err != nil {
+		// Without push notifications the job continues with periodic incremental sync
+		if err := w.emailJobRepo.MarkSynced(ctx, job.ID, w.cfg.WorkerID); err != nil {
 			return err
 		}
-		log.Printf("Email sync job %s completed: no more emails to fetch (%d total)", job.ID, job.EmailsFetched)
+		log.Printf("Email sync job %s synced (type: %s, %d total), next incremental sync in %ds",
+			job.ID, job.SyncType, job.EmailsFetched, w.cfg.IncrementalSyncInterval)
 		return nil
 	}
 
 	// Partial success: more pages to fetch
-	// Stay in processing state (already set), last_synced_at updated by UpdateProgress
-	// Job will be picked up again in next round (goes to back of queue due to last_synced_at)
+	// Stay in processing state (set by ClaimJobs), last_synced_at updated by UpdateProgress
+	// Release the lease so the job is picked up again in next round (goes to back of queue due to last_synced_at)
+	if err := w.emailJobRepo.ReleaseLease(ctx, job.ID, w.cfg.WorkerID); err != nil {
+		return err
+	}
 	log.Printf("Email sync job %s has more pages, staying in processing (fetched: %d)", job.ID, job.EmailsFetched)
 	return nil
 }
 
 // handleEmailJobError handles email job processing errors
-// Sets status to failed and updates last_synced_at to push to back of queue
+// Schedules a retry with exponential backoff (or marks the job dead once MaxRetries is exceeded)
+// and updates last_synced_at to push the job to back of queue
+// Rate limits reschedule the job without using up a retry, revoked tokens mark it dead until the user re-authenticates
 func (w *Watcher) handleEmailJobError(ctx context.Context, job models.EmailSyncJob, err error) error {
 	errMsg := err.Error()
-	newAttempts := job.Attempts + 1
-
-	log.Printf("Email job %s failed (attempt %d): %v", job.ID, newAttempts, err)
 
 	// Update last_synced_at to push failed job to back of queue
 	// This prevents failed jobs from blocking the queue
-	if err := w.emailJobRepo.UpdateProgress(ctx, job.ID, job.EmailsFetched, job.PageToken); err != nil {
+	if err := w.emailJobRepo.UpdateProgress(ctx, job.ID, w.cfg.WorkerID, job.EmailsFetched, job.PageToken); err != nil {
 		log.Printf("Warning: failed to update progress after error: %v", err)
 	}
 
-	// Set to failed status (will be picked up again in next round)
-	return w.emailJobRepo.UpdateStatus(ctx, job.ID, models.EmailStatusFailed, &errMsg)
+	if delay, ok := service.RescheduleDelay(err); ok {
+		nextAttemptAt := time.Now().Add(delay)
+		log.Printf("Email job %s rescheduled for %s: %v", job.ID, nextAttemptAt.Format(time.RFC3339), err)
+		return w.emailJobRepo.Reschedule(ctx, job.ID, w.cfg.WorkerID, errMsg, nextAttemptAt)
+	}
+
+	// Revived by the account trigger once the user re-authenticates
+	if errors.Is(err, service.ErrTokenRevoked) {
+		log.Printf("Email job %s is dead until the account is re-authenticated: %v", job.ID, err)
+		return w.emailJobRepo.MarkDead(ctx, job.ID, w.cfg.WorkerID, errMsg)
+	}
+
+	nextAttemptAt, retry := w.retryPolicy.NextAttemptAt(job.Attempts, time.Now())
+	if !retry {
+		log.Printf("Email job %s is dead after %d attempts: %v", job.ID, job.Attempts, err)
+		return w.emailJobRepo.MarkDead(ctx, job.ID, w.cfg.WorkerID, errMsg)
+	}
+
+	log.Printf("Email job %s failed (attempt %d), retrying at %s: %v", job.ID, job.Attempts, nextAttemptAt.Format(time.RFC3339), err)
+	return w.emailJobRepo.MarkFailed(ctx, job.ID, w.cfg.WorkerID, errMsg, nextAttemptAt)
 }
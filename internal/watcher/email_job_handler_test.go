@@ -0,0 +1,218 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/graph"
+	"github.com/vipul43/kiwis-worker/internal/imap"
+	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
+)
+
+// mockEmailJobRepository keeps one job the way the email_sync_job table would
+type mockEmailJobRepository struct {
+	job models.EmailSyncJob
+}
+
+func (m *mockEmailJobRepository) ClaimJobs(ctx context.Context, limit int, workerID string, lease time.Duration, incrementalInterval time.Duration, watchRenewBefore time.Duration) ([]models.EmailSyncJob, error) {
+	m.job.Status = models.EmailStatusProcessing
+	m.job.ClaimedBy = &workerID
+	m.job.Attempts++
+	return []models.EmailSyncJob{m.job}, nil
+}
+
+func (m *mockEmailJobRepository) ExtendLease(ctx context.Context, jobIDs []string, workerID string, lease time.Duration) error {
+	return nil
+}
+
+func (m *mockEmailJobRepository) ReleaseLease(ctx context.Context, jobID string, workerID string) error {
+	m.job.Attempts = 0
+	return nil
+}
+
+func (m *mockEmailJobRepository) UpdateProgress(ctx context.Context, jobID string, workerID string, emailsFetched int, pageToken *string) error {
+	m.job.EmailsFetched = emailsFetched
+	m.job.PageToken = pageToken
+	return nil
+}
+
+func (m *mockEmailJobRepository) MarkSynced(ctx context.Context, jobID string, workerID string) error {
+	m.job.Status = models.EmailStatusSynced
+	return nil
+}
+
+func (m *mockEmailJobRepository) MarkCompleted(ctx context.Context, jobID string, workerID string) error {
+	m.job.Status = models.EmailStatusCompleted
+	return nil
+}
+
+func (m *mockEmailJobRepository) MarkFailed(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	m.job.Status = models.EmailStatusFailed
+	return nil
+}
+
+func (m *mockEmailJobRepository) MarkDead(ctx context.Context, jobID string, workerID string, lastError string) error {
+	m.job.Status = models.EmailStatusDead
+	return nil
+}
+
+func (m *mockEmailJobRepository) Reschedule(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error {
+	m.job.Status = models.EmailStatusFailed
+	return nil
+}
+
+// mockEmailSyncer fetches one page per call, failing the pages listed in fail
+type mockEmailSyncer struct {
+	repo  *mockEmailJobRepository
+	pages int
+	fail  map[int]bool
+}
+
+func (m *mockEmailSyncer) CreateInitialEmailSyncJob(ctx context.Context, accountID string) error {
+	return nil
+}
+
+func (m *mockEmailSyncer) ProcessEmailSyncJob(ctx context.Context, job *models.EmailSyncJob) error {
+	m.pages++
+	if m.fail[m.pages] {
+		return errors.New("gmail: 503 backend error")
+	}
+	next := "page-token"
+	job.EmailsFetched += 50
+	job.PageToken = &next
+	return m.repo.UpdateProgress(ctx, job.ID, *job.ClaimedBy, job.EmailsFetched, job.PageToken)
+}
+
+func TestProcessEmailJob_RetriesAfterManyPages(t *testing.T) {
+	cfg := &config.Config{MaxRetries: 3}
+	repo := &mockEmailJobRepository{job: models.EmailSyncJob{ID: "job-1", AccountID: "acc-1", Status: models.EmailStatusPending}}
+	syncer := &mockEmailSyncer{repo: repo, fail: map[int]bool{cfg.MaxRetries + 2: true}}
+	w := &Watcher{cfg: cfg, emailJobRepo: repo, emailProcessor: syncer, retryPolicy: service.NewRetryPolicy(cfg.MaxRetries)}
+
+	// More pages than MaxRetries succeed, then one page fails
+	for range cfg.MaxRetries + 2 {
+		jobs, _ := repo.ClaimJobs(context.Background(), 1, "worker-1", time.Minute, time.Hour, time.Hour)
+		if err := w.processEmailJob(context.Background(), jobs[0]); err != nil {
+			t.Fatalf("processEmailJob() error = %v", err)
+		}
+	}
+
+	if repo.job.Status != models.EmailStatusFailed {
+		t.Errorf("job status = %s after a failed page, want failed (retried)", repo.job.Status)
+	}
+}
+
+// failingSyncer fails every page with err
+type failingSyncer struct {
+	err error
+}
+
+func (s *failingSyncer) CreateInitialEmailSyncJob(ctx context.Context, accountID string) error {
+	return nil
+}
+
+func (s *failingSyncer) ProcessEmailSyncJob(ctx context.Context, job *models.EmailSyncJob) error {
+	return s.err
+}
+
+func TestProcessEmailJob_TokenRevokedIsDead(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus models.EmailSyncStatus
+	}{
+		{"token revoked", fmt.Errorf("failed to list messages: %w: invalid_grant", service.ErrTokenRevoked), models.EmailStatusDead},
+		{"rate limited", &service.RateLimitError{RetryAfter: time.Minute}, models.EmailStatusFailed},
+		{"other error", errors.New("connection reset"), models.EmailStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{MaxRetries: 3}
+			repo := &mockEmailJobRepository{job: models.EmailSyncJob{ID: "job-1", AccountID: "acc-1", Status: models.EmailStatusPending}}
+			w := &Watcher{cfg: cfg, emailJobRepo: repo, emailProcessor: &failingSyncer{err: tt.err}, retryPolicy: service.NewRetryPolicy(cfg.MaxRetries)}
+
+			jobs, _ := repo.ClaimJobs(context.Background(), 1, "worker-1", time.Minute, time.Hour, time.Hour)
+			if err := w.processEmailJob(context.Background(), jobs[0]); err != nil {
+				t.Fatalf("processEmailJob() error = %v", err)
+			}
+
+			if repo.job.Status != tt.wantStatus {
+				t.Errorf("job status = %s after the first failure, want %s", repo.job.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// watchSource is a mail source whose push notifications expire at expiration, unsupported when it is zero
+type watchSource struct {
+	service.MailSource
+	expiration time.Time
+}
+
+func (s *watchSource) Watch(ctx context.Context, accountID string, topicName string) (*service.WatchResult, error) {
+	if s.expiration.IsZero() {
+		return nil, service.ErrWatchUnsupported
+	}
+	return &service.WatchResult{Expiration: s.expiration}, nil
+}
+
+// watchingSyncer catches up in one page and starts push notifications through source like service.EmailProcessor,
+// sources without push notifications leave the job without a watch
+type watchingSyncer struct {
+	source service.MailSource
+	topic  string
+}
+
+func (s *watchingSyncer) CreateInitialEmailSyncJob(ctx context.Context, accountID string) error {
+	return nil
+}
+
+func (s *watchingSyncer) ProcessEmailSyncJob(ctx context.Context, job *models.EmailSyncJob) error {
+	job.PageToken = nil
+	watch, err := s.source.Watch(ctx, job.AccountID, s.topic)
+	if errors.Is(err, service.ErrWatchUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	job.WatchExpiration = &watch.Expiration
+	return nil
+}
+
+func TestProcessEmailJob_CompletesOnlyWatchedMailboxes(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     service.MailSource
+		wantStatus models.EmailSyncStatus
+	}{
+		{"push notifications", &watchSource{expiration: time.Now().Add(7 * 24 * time.Hour)}, models.EmailStatusCompleted},
+		{"no push notifications", &watchSource{}, models.EmailStatusSynced},
+		{"imap", imap.NewClient(nil, nil, nil), models.EmailStatusSynced},
+		{"microsoft graph", graph.NewClient("client-id", "client-secret", "", nil), models.EmailStatusSynced},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A Pub/Sub topic is configured, but only mailboxes with a watch get push notifications
+			cfg := &config.Config{MaxRetries: 3, GmailPubSubTopic: "projects/kiwis/topics/gmail"}
+			repo := &mockEmailJobRepository{job: models.EmailSyncJob{ID: "job-1", AccountID: "acc-1", SyncType: models.SyncTypeInitial}}
+			syncer := &watchingSyncer{source: tt.source, topic: cfg.GmailPubSubTopic}
+			w := &Watcher{cfg: cfg, emailJobRepo: repo, emailProcessor: syncer, retryPolicy: service.NewRetryPolicy(cfg.MaxRetries)}
+
+			jobs, _ := repo.ClaimJobs(context.Background(), 1, "worker-1", time.Minute, time.Hour, time.Hour)
+			if err := w.processEmailJob(context.Background(), jobs[0]); err != nil {
+				t.Fatalf("processEmailJob() error = %v", err)
+			}
+			if repo.job.Status != tt.wantStatus {
+				t.Errorf("job status = %s, want %s", repo.job.Status, tt.wantStatus)
+			}
+		})
+	}
+}
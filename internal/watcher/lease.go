@@ -0,0 +1,56 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/repository"
+)
+
+// leaseDuration returns how long a claimed job is leased to this worker
+func (w *Watcher) leaseDuration() time.Duration {
+	return time.Duration(w.cfg.JobLeaseDuration) * time.Second
+}
+
+// startHeartbeat extends the lease on claimed jobs while they are being processed
+// Heartbeats every third of the lease duration, so a single missed beat doesn't lose the lease
+// Returns the context to process the jobs under, cancelled once the lease is lost to another worker,
+// and a function that stops the heartbeat and waits for it to exit
+func (w *Watcher) startHeartbeat(ctx context.Context, stage string, extend func(ctx context.Context, lease time.Duration) error) (context.Context, func()) {
+	jobCtx, cancelJob := context.WithCancelCause(ctx)
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(w.leaseDuration() / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+				err := extend(heartbeatCtx, w.leaseDuration())
+				if errors.Is(err, repository.ErrLeaseLost) {
+					// Another worker reclaimed the jobs, stop working on them
+					log.Printf("Lost %s job lease, cancelling processing: %v", stage, err)
+					cancelJob(err)
+					return
+				}
+				if err != nil {
+					log.Printf("Warning: failed to extend %s job lease: %v", stage, err)
+				}
+			}
+		}
+	}()
+
+	return jobCtx, func() {
+		cancel()
+		<-done
+		cancelJob(nil)
+	}
+}
@@ -0,0 +1,51 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/repository"
+)
+
+func TestStartHeartbeat_LeaseLostCancelsJob(t *testing.T) {
+	w := &Watcher{cfg: &config.Config{JobLeaseDuration: 1}}
+
+	ctx, stop := w.startHeartbeat(context.Background(), "test", func(ctx context.Context, lease time.Duration) error {
+		return fmt.Errorf("failed to extend lease: %w", repository.ErrLeaseLost)
+	})
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("job context not cancelled after the lease was lost")
+	}
+	if !errors.Is(context.Cause(ctx), repository.ErrLeaseLost) {
+		t.Errorf("cancel cause = %v, want ErrLeaseLost", context.Cause(ctx))
+	}
+}
+
+func TestStartHeartbeat_ExtendErrorKeepsJob(t *testing.T) {
+	w := &Watcher{cfg: &config.Config{JobLeaseDuration: 1}}
+
+	beats := make(chan struct{}, 10)
+	ctx, stop := w.startHeartbeat(context.Background(), "test", func(ctx context.Context, lease time.Duration) error {
+		beats <- struct{}{}
+		return errors.New("connection reset")
+	})
+
+	<-beats
+	<-beats
+	if ctx.Err() != nil {
+		t.Errorf("job context cancelled after a failed heartbeat: %v", ctx.Err())
+	}
+
+	stop()
+	if ctx.Err() == nil {
+		t.Error("job context not released after the heartbeat stopped")
+	}
+}
@@ -3,58 +3,60 @@ package watcher
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/vipul43/kiwis-worker/internal/models"
+	"github.com/vipul43/kiwis-worker/internal/service"
 )
 
-// processLLMSyncJobs processes pending, failed, and processing LLM sync jobs (round-robin batch)
-func (w *Watcher) processLLMSyncJobs(ctx context.Context) error {
-	// Fetch pending jobs (batch of 3)
-	pendingJobs, err := w.llmJobRepo.GetPendingJobs(ctx, 3)
-	if err != nil {
-		return err
-	}
-
-	// Fetch failed jobs (batch of 3)
-	failedJobs, err := w.llmJobRepo.GetFailedJobs(ctx, 3)
-	if err != nil {
-		return err
-	}
-
-	// Fetch processing jobs (stuck jobs, batch of 3)
-	processingJobs, err := w.llmJobRepo.GetProcessingJobs(ctx, 3)
-	if err != nil {
-		return err
-	}
+// claimLLMSyncJobs claims up to limit batches of LLM sync jobs (one task per batch)
+func (w *Watcher) claimLLMSyncJobs(ctx context.Context, limit int) ([]task, error) {
+	tasks := make([]task, 0, limit)
+	for range limit {
+		// Claim pending, failed, and stuck processing jobs atomically
+		// Claimed jobs are already marked as processing with attempts incremented
+		jobs, err := w.llmJobRepo.ClaimJobs(ctx, service.LLMBatchSize, w.cfg.WorkerID, w.leaseDuration())
+		if err != nil && len(tasks) > 0 {
+			// Batches already claimed are processed, not left in processing until their lease expires
+			log.Printf("Error claiming more LLM sync jobs, processing the %d batch(es) claimed: %v", len(tasks), err)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			break
+		}
 
-	// Combine all jobs (already sorted by last_synced_at in individual queries)
-	allJobs := append(pendingJobs, failedJobs...)
-	allJobs = append(allJobs, processingJobs...)
+		log.Printf("Claimed %d LLM sync jobs to process (worker: %s)", len(jobs), w.cfg.WorkerID)
 
-	if len(allJobs) == 0 {
-		return nil
+		tasks = append(tasks, func(ctx context.Context) {
+			if err := w.processLLMBatch(ctx, jobs); err != nil {
+				log.Printf("Error processing LLM sync jobs batch: %v", err)
+			}
+		})
 	}
 
-	log.Printf("Found %d LLM sync jobs to process (pending: %d, failed: %d, processing: %d)",
-		len(allJobs), len(pendingJobs), len(failedJobs), len(processingJobs))
+	return tasks, nil
+}
 
-	// Mark all jobs as processing
-	for _, job := range allJobs {
-		if err := w.llmJobRepo.UpdateStatus(ctx, job.ID, models.LLMStatusProcessing, nil); err != nil {
-			log.Printf("Warning: failed to update job %s to processing: %v", job.ID, err)
-		}
-		if err := w.llmJobRepo.IncrementAttempts(ctx, job.ID); err != nil {
-			log.Printf("Warning: failed to increment attempts for job %s: %v", job.ID, err)
-		}
+// processLLMBatch processes a batch of claimed LLM sync jobs
+func (w *Watcher) processLLMBatch(ctx context.Context, jobs []models.LLMSyncJob) error {
+	// LLM calls can take minutes, keep the lease alive until the batch is done
+	jobIDs := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		jobIDs = append(jobIDs, job.ID)
 	}
+	ctx, stopHeartbeat := w.startHeartbeat(ctx, "LLM", func(ctx context.Context, lease time.Duration) error {
+		return w.llmJobRepo.ExtendLease(ctx, jobIDs, w.cfg.WorkerID, lease)
+	})
+	defer stopHeartbeat()
 
 	// Process batch
-	err = w.llmProcessor.ProcessLLMSyncJobs(ctx, allJobs)
-	if err != nil {
-		log.Printf("Error processing LLM sync jobs batch: %v", err)
+	if err := w.llmProcessor.ProcessLLMSyncJobs(ctx, jobs); err != nil {
 		return err
 	}
 
-	log.Printf("Completed processing %d LLM sync jobs", len(allJobs))
+	log.Printf("Completed processing %d LLM sync jobs", len(jobs))
 	return nil
 }
@@ -0,0 +1,16 @@
+package watcher
+
+import (
+	"context"
+	"log"
+)
+
+// claimPaymentAging returns the aging run as the stage's only task
+// Aging is idempotent and payments are locked while moved, so replicas may run it concurrently
+func (w *Watcher) claimPaymentAging(ctx context.Context, limit int) ([]task, error) {
+	return []task{func(ctx context.Context) {
+		if _, err := w.paymentStatusProcessor.AgePayments(ctx); err != nil {
+			log.Printf("Error aging payment statuses: %v", err)
+		}
+	}}, nil
+}
@@ -0,0 +1,16 @@
+package watcher
+
+import (
+	"context"
+	"log"
+)
+
+// claimRecurrenceDetection returns the detection run over all accounts as the stage's only task
+// Series are upserted and projections created by fingerprint, so replicas may run it concurrently
+func (w *Watcher) claimRecurrenceDetection(ctx context.Context, limit int) ([]task, error) {
+	return []task{func(ctx context.Context) {
+		if _, err := w.recurrenceDetector.DetectAll(ctx); err != nil {
+			log.Printf("Error detecting recurring payments: %v", err)
+		}
+	}}, nil
+}
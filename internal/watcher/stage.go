@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// task is a unit of claimed work run by a stage worker (one job, or one LLM batch)
+type task func(ctx context.Context)
+
+// stage is an independent loop for one job type with its own poll interval and bounded worker pool
+type stage struct {
+	name     string
+	interval time.Duration
+	workers  int
+	wake     <-chan struct{} // nil without a listener
+	// claim claims up to limit tasks, one per free worker; tasks returned with an error were claimed before it
+	// and are run
+	claim func(ctx context.Context, limit int) ([]task, error)
+}
+
+// run polls for work on every tick or wake-up and runs claimed tasks on free workers
+// Stops claiming when ctx is cancelled, then waits for in-flight tasks, which run under jobCtx
+func (s *stage) run(ctx, jobCtx context.Context) {
+	log.Printf("Starting %s stage (workers: %d, poll interval: %s)", s.name, s.workers, s.interval)
+
+	slots := make(chan struct{}, s.workers)
+	var wg sync.WaitGroup
+
+	dispatch := func() {
+		free := s.workers - len(slots)
+		if free == 0 {
+			return
+		}
+
+		tasks, err := s.claim(ctx, free)
+		if err != nil {
+			log.Printf("Error claiming %s jobs: %v", s.name, err)
+		}
+
+		for _, t := range tasks {
+			// Never blocks: only this goroutine acquires slots and at most free tasks were claimed
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				t(jobCtx)
+			}()
+		}
+	}
+
+	// Process any pending jobs from previous runs
+	dispatch()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("%s stage draining %d in-flight job(s)...", s.name, len(slots))
+			wg.Wait()
+			log.Printf("%s stage stopped", s.name)
+			return
+		case <-ticker.C:
+			dispatch()
+		case <-s.wake:
+			dispatch()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStage_RunsTasksClaimedBeforeAnError(t *testing.T) {
+	ran := make(chan struct{}, 1)
+	s := &stage{
+		name:     "test",
+		interval: time.Hour,
+		workers:  2,
+		claim: func(ctx context.Context, limit int) ([]task, error) {
+			return []task{func(ctx context.Context) { ran <- struct{}{} }}, errors.New("connection reset")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, context.Background())
+	}()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Error("expected the task claimed before the error to run")
+	}
+	cancel()
+	<-done
+}
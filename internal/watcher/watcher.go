@@ -2,24 +2,48 @@ package watcher
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/vipul43/kiwis-worker/internal/config"
+	"github.com/vipul43/kiwis-worker/internal/database"
 	"github.com/vipul43/kiwis-worker/internal/models"
 	"github.com/vipul43/kiwis-worker/internal/repository"
 	"github.com/vipul43/kiwis-worker/internal/service"
 )
 
+// EmailJobRepository interface for dependency injection
+type EmailJobRepository interface {
+	ClaimJobs(ctx context.Context, limit int, workerID string, lease time.Duration, incrementalInterval time.Duration, watchRenewBefore time.Duration) ([]models.EmailSyncJob, error)
+	ExtendLease(ctx context.Context, jobIDs []string, workerID string, lease time.Duration) error
+	ReleaseLease(ctx context.Context, jobID string, workerID string) error
+	UpdateProgress(ctx context.Context, jobID string, workerID string, emailsFetched int, pageToken *string) error
+	MarkSynced(ctx context.Context, jobID string, workerID string) error
+	MarkCompleted(ctx context.Context, jobID string, workerID string) error
+	MarkFailed(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error
+	MarkDead(ctx context.Context, jobID string, workerID string, lastError string) error
+	Reschedule(ctx context.Context, jobID string, workerID string, lastError string, nextAttemptAt time.Time) error
+}
+
+// EmailSyncer interface for dependency injection
+type EmailSyncer interface {
+	CreateInitialEmailSyncJob(ctx context.Context, accountID string) error
+	ProcessEmailSyncJob(ctx context.Context, job *models.EmailSyncJob) error
+}
+
 type Watcher struct {
-	cfg              *config.Config
-	accountJobRepo   *repository.AccountSyncJobRepository
-	emailJobRepo     *repository.EmailSyncJobRepository
-	llmJobRepo       *repository.LLMSyncJobRepository
-	accountProcessor *service.AccountProcessor
-	emailProcessor   *service.EmailProcessor
-	llmProcessor     *service.LLMProcessor
+	cfg                    *config.Config
+	accountJobRepo         *repository.AccountSyncJobRepository
+	emailJobRepo           EmailJobRepository
+	llmJobRepo             *repository.LLMSyncJobRepository
+	accountProcessor       *service.AccountProcessor
+	emailProcessor         EmailSyncer
+	llmProcessor           *service.LLMProcessor
+	retryPolicy            service.RetryPolicy
+	paymentStatusProcessor *service.PaymentStatusProcessor
+	recurrenceDetector     *service.RecurrenceDetector
+	listener               *database.Listener // Optional: nil means ticker-only polling
 }
 
 func New(
@@ -30,146 +54,143 @@ func New(
 	accountProcessor *service.AccountProcessor,
 	emailProcessor *service.EmailProcessor,
 	llmProcessor *service.LLMProcessor,
+	paymentStatusProcessor *service.PaymentStatusProcessor,
+	recurrenceDetector *service.RecurrenceDetector,
+	listener *database.Listener,
 ) *Watcher {
 	return &Watcher{
-		cfg:              cfg,
-		accountJobRepo:   accountJobRepo,
-		emailJobRepo:     emailJobRepo,
-		llmJobRepo:       llmJobRepo,
-		accountProcessor: accountProcessor,
-		emailProcessor:   emailProcessor,
-		llmProcessor:     llmProcessor,
-	}
-}
-
-// Start begins watching for pending jobs (both account and email sync)
-func (w *Watcher) Start(ctx context.Context) error {
-	log.Println("Starting watcher for account and email sync jobs...")
-
-	// Process any pending jobs from previous runs
-	if err := w.processAllPendingJobs(ctx); err != nil {
-		log.Printf("Warning: failed to process pending jobs on startup: %v", err)
-	}
-
-	// Start polling loop
-	ticker := time.NewTicker(time.Duration(w.cfg.PollInterval) * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Watcher shutting down...")
-			return ctx.Err()
-		case <-ticker.C:
-			if err := w.processAllPendingJobs(ctx); err != nil {
-				log.Printf("Error processing jobs: %v", err)
-			}
-		}
+		cfg:                    cfg,
+		accountJobRepo:         accountJobRepo,
+		emailJobRepo:           emailJobRepo,
+		llmJobRepo:             llmJobRepo,
+		accountProcessor:       accountProcessor,
+		emailProcessor:         emailProcessor,
+		llmProcessor:           llmProcessor,
+		retryPolicy:            service.NewRetryPolicy(cfg.MaxRetries),
+		paymentStatusProcessor: paymentStatusProcessor,
+		recurrenceDetector:     recurrenceDetector,
+		listener:               listener,
 	}
 }
 
-// processAllPendingJobs processes both account sync and email sync jobs
-func (w *Watcher) processAllPendingJobs(ctx context.Context) error {
-	// Process account sync jobs first (new accounts)
-	if err := w.processAccountSyncJobs(ctx); err != nil {
-		log.Printf("Error processing account sync jobs: %v", err)
-	}
-
-	// Process email sync jobs (round-robin by priority)
-	if err := w.processEmailSyncJobs(ctx); err != nil {
-		log.Printf("Error processing email sync jobs: %v", err)
-	}
-
-	// Process LLM sync jobs (batch processing)
-	if err := w.processLLMSyncJobs(ctx); err != nil {
-		log.Printf("Error processing LLM sync jobs: %v", err)
-	}
-
-	return nil
+// Start runs the account, email and LLM stages and the payment status and recurrence stages concurrently,
+// each with its own loop and worker pool
+// Cancelling ctx stops claiming new jobs; in-flight jobs run under jobCtx so they can finish (drain)
+// Returns once every stage has drained
+func (w *Watcher) Start(ctx, jobCtx context.Context) error {
+	log.Println("Starting watcher for account, email and LLM sync jobs, payment statuses and recurring payments...")
+
+	// Notifications wake the matching stage immediately (nil channels never fire without a listener)
+	// Polling is kept as a fallback for missed notifications, retries and lease expiry
+	stages := []*stage{
+		{
+			name:     "account",
+			interval: time.Duration(w.cfg.AccountPollInterval) * time.Second,
+			workers:  w.cfg.AccountWorkers,
+			wake:     w.listener.Wake(repository.ChannelAccountSyncJob),
+			claim:    w.claimAccountSyncJobs,
+		},
+		{
+			name:     "email",
+			interval: time.Duration(w.cfg.EmailPollInterval) * time.Second,
+			workers:  w.cfg.EmailWorkers,
+			wake:     w.listener.Wake(repository.ChannelEmailSyncJob),
+			claim:    w.claimEmailSyncJobs,
+		},
+		{
+			name:     "LLM",
+			interval: time.Duration(w.cfg.LLMPollInterval) * time.Second,
+			workers:  w.cfg.LLMWorkers,
+			wake:     w.listener.Wake(repository.ChannelLLMSyncJob),
+			claim:    w.claimLLMSyncJobs,
+		},
+		{
+			// Moves upcoming payments to due and overdue, nothing notifies it
+			name:     "payment status",
+			interval: time.Duration(w.cfg.PaymentStatusInterval) * time.Second,
+			workers:  1,
+			claim:    w.claimPaymentAging,
+		},
+		{
+			// Detects recurring payments and projects their next occurrences
+			name:     "recurrence",
+			interval: time.Duration(w.cfg.RecurrenceInterval) * time.Second,
+			workers:  1,
+			claim:    w.claimRecurrenceDetection,
+		},
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range stages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx, jobCtx)
+		}()
+	}
+
+	<-ctx.Done()
+	log.Println("Watcher shutting down, waiting for in-flight jobs...")
+	wg.Wait()
+	return ctx.Err()
 }
 
-// processAccountSyncJobs processes pending, failed, and processing account sync jobs
-func (w *Watcher) processAccountSyncJobs(ctx context.Context) error {
-	// Get pending jobs
-	pendingJobs, err := w.accountJobRepo.GetPendingJobs(ctx, 5)
+// claimAccountSyncJobs claims pending, failed, and stuck account sync jobs (one task per job)
+func (w *Watcher) claimAccountSyncJobs(ctx context.Context, limit int) ([]task, error) {
+	// Claim jobs atomically so other workers skip them
+	jobs, err := w.accountJobRepo.ClaimJobs(ctx, limit, w.cfg.WorkerID, w.leaseDuration())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Get failed jobs for retry
-	failedJobs, err := w.accountJobRepo.GetFailedJobs(ctx, 5)
-	if err != nil {
-		return err
+	if len(jobs) > 0 {
+		log.Printf("Claimed %d account sync job(s) to process (worker: %s)", len(jobs), w.cfg.WorkerID)
 	}
 
-	// Get processing jobs (stuck jobs from crashes or errors)
-	processingJobs, err := w.accountJobRepo.GetProcessingJobs(ctx, 5)
-	if err != nil {
-		return err
-	}
-
-	// Combine all lists
-	jobs := append(pendingJobs, failedJobs...)
-	jobs = append(jobs, processingJobs...)
-
-	if len(jobs) == 0 {
-		return nil
-	}
-
-	log.Printf("Found %d account sync job(s) to process", len(jobs))
-
+	tasks := make([]task, 0, len(jobs))
 	for _, job := range jobs {
-		if err := w.processAccountJob(ctx, job); err != nil {
-			log.Printf("Failed to process account job %s: %v", job.ID, err)
-		}
+		tasks = append(tasks, func(ctx context.Context) {
+			// Keep the lease while the job is processed
+			ctx, stopHeartbeat := w.startHeartbeat(ctx, "account", func(ctx context.Context, lease time.Duration) error {
+				return w.accountJobRepo.ExtendLease(ctx, []string{job.ID}, w.cfg.WorkerID, lease)
+			})
+			defer stopHeartbeat()
+
+			if err := w.processAccountJob(ctx, job); err != nil {
+				log.Printf("Failed to process account job %s: %v", job.ID, err)
+			}
+		})
 	}
 
-	return nil
+	return tasks, nil
 }
 
-// processEmailSyncJobs processes pending, failed, and processing email sync jobs (round-robin)
-func (w *Watcher) processEmailSyncJobs(ctx context.Context) error {
-	// Fetch pending jobs
-	pendingJobs, err := w.emailJobRepo.GetPendingJobs(ctx, 1)
+// claimEmailSyncJobs claims the next email sync jobs in round-robin order (one task per job)
+func (w *Watcher) claimEmailSyncJobs(ctx context.Context, limit int) ([]task, error) {
+	// Query sorts by last_synced_at ASC NULLS FIRST
+	// Synced jobs are claimed again once due for an incremental sync,
+	// completed (webhook) jobs on push notification or when their watch needs renewing
+	jobs, err := w.emailJobRepo.ClaimJobs(ctx, limit, w.cfg.WorkerID, w.leaseDuration(),
+		time.Duration(w.cfg.IncrementalSyncInterval)*time.Second, service.WatchRenewBefore)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Fetch failed jobs
-	failedJobs, err := w.emailJobRepo.GetFailedJobs(ctx, 1)
-	if err != nil {
-		return err
-	}
-
-	// Fetch processing jobs (stuck jobs)
-	processingJobs, err := w.emailJobRepo.GetProcessingJobs(ctx, 1)
-	if err != nil {
-		return err
-	}
-
-	// Combine all jobs (already sorted by last_synced_at in individual queries)
-	allJobs := append(pendingJobs, failedJobs...)
-	allJobs = append(allJobs, processingJobs...)
-
-	if len(allJobs) == 0 {
-		return nil
-	}
-
-	// Pick the first job (queries already sort by last_synced_at ASC NULLS FIRST)
-	job := allJobs[0]
-
-	statusMsg := ""
-	if job.Status == models.EmailStatusProcessing {
-		statusMsg = " (stuck in processing)"
-	} else if job.Status == models.EmailStatusFailed {
-		statusMsg = fmt.Sprintf(" (failed, attempt %d)", job.Attempts)
-	}
+	tasks := make([]task, 0, len(jobs))
+	for _, job := range jobs {
+		log.Printf("Claimed email sync job: %s (account: %s, attempt %d, worker: %s)", job.ID, job.AccountID, job.Attempts, w.cfg.WorkerID)
 
-	log.Printf("Found email sync job: %s (account: %s, status: %s%s)", job.ID, job.AccountID, job.Status, statusMsg)
+		tasks = append(tasks, func(ctx context.Context) {
+			ctx, stopHeartbeat := w.startHeartbeat(ctx, "email", func(ctx context.Context, lease time.Duration) error {
+				return w.emailJobRepo.ExtendLease(ctx, []string{job.ID}, w.cfg.WorkerID, lease)
+			})
+			defer stopHeartbeat()
 
-	if err := w.processEmailJob(ctx, job); err != nil {
-		log.Printf("Failed to process email job %s: %v", job.ID, err)
+			if err := w.processEmailJob(ctx, job); err != nil {
+				log.Printf("Failed to process email job %s: %v", job.ID, err)
+			}
+		})
 	}
 
-	return nil
+	return tasks, nil
 }
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxBodyBytes limits push request bodies (Gmail notifications are tiny)
+const maxBodyBytes = 64 * 1024
+
+// SyncRequester flags the email sync jobs of a mailbox for a history sync
+type SyncRequester interface {
+	RequestSync(ctx context.Context, emailAddress string) (int64, error)
+}
+
+// Handler accepts Gmail push notifications delivered by a Pub/Sub push subscription
+// The subscription's push endpoint must include the shared token: /webhooks/gmail?token=<WEBHOOK_TOKEN>
+type Handler struct {
+	token     string
+	requester SyncRequester
+}
+
+func NewHandler(token string, requester SyncRequester) *Handler {
+	return &Handler{
+		token:     token,
+		requester: requester,
+	}
+}
+
+// PushEnvelope is the body Pub/Sub POSTs to push endpoints
+type PushEnvelope struct {
+	Message struct {
+		Data        string `json:"data"` // base64-encoded Notification
+		MessageID   string `json:"messageId"`
+		PublishTime string `json:"publishTime"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// Notification is the Gmail payload inside a push message
+type Notification struct {
+	EmailAddress string      `json:"emailAddress"`
+	HistoryID    json.Number `json:"historyId"`
+}
+
+// ServeHTTP verifies the push token, decodes the notification and requests a sync for the mailbox
+// Responds 2xx for anything that should not be redelivered (including unknown mailboxes)
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.validToken(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Malformed messages are acknowledged, redelivering them would fail the same way forever
+	var envelope PushEnvelope
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&envelope); err != nil {
+		log.Printf("Warning: invalid Pub/Sub push envelope acknowledged: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	notification, err := decodeNotification(envelope.Message.Data)
+	if err != nil {
+		log.Printf("Warning: invalid Gmail push notification acknowledged (message %s): %v", envelope.Message.MessageID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flagged, err := h.requester.RequestSync(r.Context(), notification.EmailAddress)
+	if err != nil {
+		// Non-2xx makes Pub/Sub redeliver the message
+		log.Printf("Error requesting sync for %s: %v", notification.EmailAddress, err)
+		http.Error(w, "failed to request sync", http.StatusInternalServerError)
+		return
+	}
+
+	if flagged == 0 {
+		log.Printf("Gmail push for unknown mailbox %s ignored (history %s)", notification.EmailAddress, notification.HistoryID)
+	} else {
+		log.Printf("Gmail push for %s (history %s), requested sync for %d job(s)", notification.EmailAddress, notification.HistoryID, flagged)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validToken compares the token query parameter with the configured token in constant time
+func (h *Handler) validToken(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	return h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// decodeNotification decodes the base64 message data into a Gmail notification
+func decodeNotification(data string) (*Notification, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(decoded, &notification); err != nil {
+		return nil, err
+	}
+	if notification.EmailAddress == "" {
+		return nil, errors.New("missing emailAddress")
+	}
+	if notification.HistoryID != "" {
+		if _, err := strconv.ParseUint(notification.HistoryID.String(), 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return &notification, nil
+}
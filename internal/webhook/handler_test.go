@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockSyncRequester struct {
+	requested []string
+	flagged   int64
+	err       error
+}
+
+func (m *mockSyncRequester) RequestSync(ctx context.Context, emailAddress string) (int64, error) {
+	m.requested = append(m.requested, emailAddress)
+	return m.flagged, m.err
+}
+
+// pushEnvelope builds a Pub/Sub push body the way Pub/Sub delivers Gmail notifications
+func pushEnvelope(t *testing.T, notification string) []byte {
+	t.Helper()
+	var envelope PushEnvelope
+	envelope.Message.Data = base64.StdEncoding.EncodeToString([]byte(notification))
+	envelope.Message.MessageID = "msg-1"
+	envelope.Subscription = "projects/test/subscriptions/gmail-push"
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("failed to marshal envelope: %v", err)
+	}
+	return body
+}
+
+// post sends a push request to a local test server running the handler
+func post(t *testing.T, handler http.Handler, url string, body []byte) *http.Response {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHandler_RequestsSync(t *testing.T) {
+	requester := &mockSyncRequester{flagged: 1}
+	handler := NewHandler("secret", requester)
+
+	body := pushEnvelope(t, `{"emailAddress":"user@example.com","historyId":9876543210}`)
+	resp := post(t, handler, "/webhooks/gmail?token=secret", body)
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+	if len(requester.requested) != 1 || requester.requested[0] != "user@example.com" {
+		t.Errorf("expected sync requested for user@example.com, got %v", requester.requested)
+	}
+}
+
+func TestHandler_StringHistoryID(t *testing.T) {
+	requester := &mockSyncRequester{flagged: 1}
+	handler := NewHandler("secret", requester)
+
+	body := pushEnvelope(t, `{"emailAddress":"user@example.com","historyId":"9876543210"}`)
+	resp := post(t, handler, "/webhooks/gmail?token=secret", body)
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_UnknownMailboxAcknowledged(t *testing.T) {
+	requester := &mockSyncRequester{flagged: 0}
+	handler := NewHandler("secret", requester)
+
+	body := pushEnvelope(t, `{"emailAddress":"stranger@example.com","historyId":1}`)
+	resp := post(t, handler, "/webhooks/gmail?token=secret", body)
+
+	// Acknowledged so Pub/Sub doesn't redeliver it
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_InvalidToken(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"missing token", "/webhooks/gmail"},
+		{"wrong token", "/webhooks/gmail?token=wrong"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requester := &mockSyncRequester{flagged: 1}
+			handler := NewHandler("secret", requester)
+
+			body := pushEnvelope(t, `{"emailAddress":"user@example.com","historyId":1}`)
+			resp := post(t, handler, tt.url, body)
+
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected status 401, got %d", resp.StatusCode)
+			}
+			if len(requester.requested) != 0 {
+				t.Errorf("expected no sync requested, got %v", requester.requested)
+			}
+		})
+	}
+}
+
+func TestHandler_InvalidPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"not json", []byte("not json")},
+		{"data not base64", []byte(`{"message":{"data":"%%%"}}`)},
+		{"data not json", pushEnvelope(t, "not json")},
+		{"missing email address", pushEnvelope(t, `{"historyId":1}`)},
+		{"invalid history id", pushEnvelope(t, `{"emailAddress":"user@example.com","historyId":"abc"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requester := &mockSyncRequester{flagged: 1}
+			handler := NewHandler("secret", requester)
+
+			resp := post(t, handler, "/webhooks/gmail?token=secret", tt.body)
+
+			// Acknowledged so Pub/Sub does not redeliver a poison message
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("expected status 204, got %d", resp.StatusCode)
+			}
+			if len(requester.requested) != 0 {
+				t.Errorf("expected no sync requested, got %v", requester.requested)
+			}
+		})
+	}
+}
+
+func TestHandler_RequestSyncError(t *testing.T) {
+	requester := &mockSyncRequester{err: errors.New("database unavailable")}
+	handler := NewHandler("secret", requester)
+
+	body := pushEnvelope(t, `{"emailAddress":"user@example.com","historyId":1}`)
+	resp := post(t, handler, "/webhooks/gmail?token=secret", body)
+
+	// Non-2xx so Pub/Sub redelivers
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_MethodNotAllowed(t *testing.T) {
+	handler := NewHandler("secret", &mockSyncRequester{})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/webhooks/gmail?token=secret")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", resp.StatusCode)
+	}
+}
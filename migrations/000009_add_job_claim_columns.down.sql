@@ -0,0 +1,12 @@
+-- Drop job claim columns
+ALTER TABLE llm_sync_job
+    DROP COLUMN IF EXISTS claimed_at,
+    DROP COLUMN IF EXISTS claimed_by;
+
+ALTER TABLE email_sync_job
+    DROP COLUMN IF EXISTS claimed_at,
+    DROP COLUMN IF EXISTS claimed_by;
+
+ALTER TABLE account_sync_job
+    DROP COLUMN IF EXISTS claimed_at,
+    DROP COLUMN IF EXISTS claimed_by;
@@ -0,0 +1,14 @@
+-- Track which worker claimed each job
+-- Jobs are claimed atomically with SELECT ... FOR UPDATE SKIP LOCKED so that
+-- multiple kiwis-worker replicas never pick up the same job
+ALTER TABLE account_sync_job
+    ADD COLUMN claimed_by VARCHAR(255),
+    ADD COLUMN claimed_at TIMESTAMP;
+
+ALTER TABLE email_sync_job
+    ADD COLUMN claimed_by VARCHAR(255),
+    ADD COLUMN claimed_at TIMESTAMP;
+
+ALTER TABLE llm_sync_job
+    ADD COLUMN claimed_by VARCHAR(255),
+    ADD COLUMN claimed_at TIMESTAMP;
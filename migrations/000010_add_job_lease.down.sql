@@ -0,0 +1,8 @@
+-- Drop lease indexes and columns
+DROP INDEX IF EXISTS idx_llm_sync_job_lease;
+DROP INDEX IF EXISTS idx_email_sync_job_lease;
+DROP INDEX IF EXISTS idx_account_sync_job_lease;
+
+ALTER TABLE llm_sync_job DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE account_sync_job DROP COLUMN IF EXISTS lease_expires_at;
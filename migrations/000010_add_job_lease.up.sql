@@ -0,0 +1,11 @@
+-- Lease-based crash recovery
+-- A claimed job holds a lease that is extended by a heartbeat while the worker processes it
+-- Jobs in processing are only reclaimed once their lease has expired (worker crashed or hung)
+ALTER TABLE account_sync_job ADD COLUMN lease_expires_at TIMESTAMP;
+ALTER TABLE email_sync_job ADD COLUMN lease_expires_at TIMESTAMP;
+ALTER TABLE llm_sync_job ADD COLUMN lease_expires_at TIMESTAMP;
+
+-- Indexes for finding expired leases
+CREATE INDEX idx_account_sync_job_lease ON account_sync_job(status, lease_expires_at);
+CREATE INDEX idx_email_sync_job_lease ON email_sync_job(status, lease_expires_at);
+CREATE INDEX idx_llm_sync_job_lease ON llm_sync_job(status, lease_expires_at);
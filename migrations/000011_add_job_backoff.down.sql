@@ -0,0 +1,56 @@
+-- Restore account trigger function without backoff reset
+CREATE OR REPLACE FUNCTION handle_account_sync_job()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        -- Create new account sync job for new account
+        INSERT INTO account_sync_job (id, account_id, status, created_at, updated_at)
+        VALUES (
+            gen_random_uuid()::text,
+            NEW.id,
+            'pending',
+            NOW(),
+            NOW()
+        );
+    ELSIF TG_OP = 'UPDATE' THEN
+        -- Reset existing account sync job to pending status
+        -- This will rerun the entire sync process from the beginning
+        UPDATE account_sync_job
+        SET status = 'pending',
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id;
+    END IF;
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop backoff indexes
+DROP INDEX IF EXISTS idx_llm_sync_job_next_attempt;
+DROP INDEX IF EXISTS idx_email_sync_job_next_attempt;
+DROP INDEX IF EXISTS idx_account_sync_job_next_attempt;
+
+-- Dead jobs go back to failed (infinite retry)
+UPDATE account_sync_job SET status = 'failed' WHERE status = 'dead';
+UPDATE email_sync_job SET status = 'failed' WHERE status = 'dead';
+UPDATE llm_sync_job SET status = 'failed' WHERE status = 'dead';
+
+-- Restore original status constraints
+ALTER TABLE llm_sync_job DROP CONSTRAINT IF EXISTS chk_llm_sync_job_status;
+ALTER TABLE llm_sync_job ADD CONSTRAINT llm_sync_job_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed'));
+
+ALTER TABLE email_sync_job DROP CONSTRAINT IF EXISTS chk_status;
+ALTER TABLE email_sync_job ADD CONSTRAINT chk_status
+    CHECK (status IN ('pending', 'processing', 'synced', 'completed', 'failed'));
+
+ALTER TABLE account_sync_job DROP CONSTRAINT IF EXISTS chk_status;
+ALTER TABLE account_sync_job ADD CONSTRAINT chk_status
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed'));
+
+-- Drop backoff columns
+ALTER TABLE llm_sync_job DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE account_sync_job DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,57 @@
+-- Exponential backoff and dead-lettering for failed jobs
+-- next_attempt_at: failed jobs are not retried before this time
+-- dead: terminal status once attempts exceed MaxRetries (last_error is kept)
+ALTER TABLE account_sync_job ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE email_sync_job ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE llm_sync_job ADD COLUMN next_attempt_at TIMESTAMP;
+
+-- Allow dead status
+ALTER TABLE account_sync_job DROP CONSTRAINT IF EXISTS chk_status;
+ALTER TABLE account_sync_job ADD CONSTRAINT chk_status
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'dead'));
+
+ALTER TABLE email_sync_job DROP CONSTRAINT IF EXISTS chk_status;
+ALTER TABLE email_sync_job ADD CONSTRAINT chk_status
+    CHECK (status IN ('pending', 'processing', 'synced', 'completed', 'failed', 'dead'));
+
+-- llm_sync_job used an inline (auto-named) CHECK constraint
+ALTER TABLE llm_sync_job DROP CONSTRAINT IF EXISTS llm_sync_job_status_check;
+ALTER TABLE llm_sync_job ADD CONSTRAINT chk_llm_sync_job_status
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'dead'));
+
+-- Indexes for finding retryable jobs
+CREATE INDEX idx_account_sync_job_next_attempt ON account_sync_job(status, next_attempt_at);
+CREATE INDEX idx_email_sync_job_next_attempt ON email_sync_job(status, next_attempt_at);
+CREATE INDEX idx_llm_sync_job_next_attempt ON llm_sync_job(status, next_attempt_at);
+
+-- Reset attempts and backoff when the account is updated (e.g. user re-authenticates)
+-- This revives dead account sync jobs once the user fixes a revoked token
+CREATE OR REPLACE FUNCTION handle_account_sync_job()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        -- Create new account sync job for new account
+        INSERT INTO account_sync_job (id, account_id, status, created_at, updated_at)
+        VALUES (
+            gen_random_uuid()::text,
+            NEW.id,
+            'pending',
+            NOW(),
+            NOW()
+        );
+    ELSIF TG_OP = 'UPDATE' THEN
+        -- Reset existing account sync job to pending status
+        -- This will rerun the entire sync process from the beginning
+        UPDATE account_sync_job
+        SET status = 'pending',
+            attempts = 0,
+            next_attempt_at = NULL,
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id;
+    END IF;
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
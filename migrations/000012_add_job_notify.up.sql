@@ -0,0 +1,36 @@
+-- Notify listening workers when an account sync job is created or reset
+-- Workers LISTEN on one channel per job type (account_sync_job, email_sync_job, llm_sync_job)
+-- Email and LLM sync job notifications are sent by the worker when it inserts those jobs
+-- The payload is the account ID
+CREATE OR REPLACE FUNCTION handle_account_sync_job()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        -- Create new account sync job for new account
+        INSERT INTO account_sync_job (id, account_id, status, created_at, updated_at)
+        VALUES (
+            gen_random_uuid()::text,
+            NEW.id,
+            'pending',
+            NOW(),
+            NOW()
+        );
+    ELSIF TG_OP = 'UPDATE' THEN
+        -- Reset existing account sync job to pending status
+        -- This will rerun the entire sync process from the beginning
+        UPDATE account_sync_job
+        SET status = 'pending',
+            attempts = 0,
+            next_attempt_at = NULL,
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id;
+    END IF;
+
+    -- Wake up workers listening for account sync jobs (delivered on commit)
+    PERFORM pg_notify('account_sync_job', NEW.id);
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
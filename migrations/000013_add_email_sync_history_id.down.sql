@@ -0,0 +1 @@
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS history_id;
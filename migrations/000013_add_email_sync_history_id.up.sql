@@ -0,0 +1,3 @@
+-- Gmail historyId for incremental sync via users.history.list
+-- Recorded at the start of the initial sync, advanced after each incremental sync
+ALTER TABLE email_sync_job ADD COLUMN history_id BIGINT;
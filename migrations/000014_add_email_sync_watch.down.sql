@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_email_sync_job_watch_expiration;
+DROP INDEX IF EXISTS idx_email_sync_job_email_address;
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS sync_requested_at;
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS watch_expiration;
+ALTER TABLE email_sync_job DROP COLUMN IF EXISTS email_address;
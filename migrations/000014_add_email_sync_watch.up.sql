@@ -0,0 +1,10 @@
+-- Gmail push notifications (users.watch + Pub/Sub push)
+-- email_address: mailbox address, push notifications only identify the mailbox by address
+-- watch_expiration: when the current watch expires (renewed before expiry)
+-- sync_requested_at: set by a push notification, the job is claimed for a history sync while set
+ALTER TABLE email_sync_job ADD COLUMN email_address VARCHAR(255);
+ALTER TABLE email_sync_job ADD COLUMN watch_expiration TIMESTAMP;
+ALTER TABLE email_sync_job ADD COLUMN sync_requested_at TIMESTAMP;
+
+CREATE INDEX idx_email_sync_job_email_address ON email_sync_job(email_address);
+CREATE INDEX idx_email_sync_job_watch_expiration ON email_sync_job(status, watch_expiration);
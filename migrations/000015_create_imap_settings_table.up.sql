@@ -0,0 +1,19 @@
+-- IMAP server settings for accounts with providerId 'imap' (Fastmail, Zoho, self-hosted, ...)
+-- Credentials stay on the account: the app password in "password" (auth_method 'password')
+-- or an OAuth access token in "accessToken" (auth_method 'xoauth2')
+CREATE TABLE imap_settings (
+    account_id TEXT PRIMARY KEY,
+    host TEXT NOT NULL,
+    port INTEGER NOT NULL DEFAULT 993,
+    tls BOOLEAN NOT NULL DEFAULT TRUE, -- TRUE: implicit TLS, FALSE: STARTTLS when the server offers it
+    username TEXT NOT NULL,
+    auth_method TEXT NOT NULL DEFAULT 'password' CHECK (auth_method IN ('password', 'xoauth2')),
+    mailbox TEXT NOT NULL DEFAULT 'INBOX',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_imap_settings_account
+        FOREIGN KEY (account_id)
+        REFERENCES account(id)
+        ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+-- Cursors that do not fit the old columns are cleared (the sync restarts its page, or falls back to a query sync)
+ALTER TABLE email_sync_job ALTER COLUMN page_token TYPE VARCHAR(255)
+    USING CASE WHEN LENGTH(page_token) <= 255 THEN page_token END;
+ALTER TABLE email_sync_job ALTER COLUMN history_id TYPE BIGINT
+    USING CASE WHEN history_id ~ '^[0-9]+$' THEN history_id::BIGINT END;
@@ -0,0 +1,4 @@
+-- History IDs and page tokens are opaque per mail source
+-- Microsoft Graph uses delta and next links (full URLs) for both, longer than 255 characters
+ALTER TABLE email_sync_job ALTER COLUMN history_id TYPE TEXT USING history_id::TEXT;
+ALTER TABLE email_sync_job ALTER COLUMN page_token TYPE TEXT;
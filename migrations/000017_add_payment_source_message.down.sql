@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payment_source_message;
+ALTER TABLE payment DROP COLUMN IF EXISTS source_message_id;
@@ -0,0 +1,7 @@
+-- Link payments to the email they were extracted from, one email can yield several payments
+-- (credit card total and minimum due, EMI instalments, separate charges of an order)
+ALTER TABLE payment ADD COLUMN source_message_id TEXT;
+
+-- Index for finding the payments of an email
+CREATE INDEX idx_payment_source_message
+    ON payment(account_id, source_message_id);
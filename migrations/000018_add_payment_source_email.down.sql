@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_payment_llm_sync_job_id;
+DROP INDEX IF EXISTS idx_payment_source_thread;
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS fk_payment_llm_sync_job;
+ALTER TABLE payment DROP COLUMN IF EXISTS llm_sync_job_id;
+ALTER TABLE payment DROP COLUMN IF EXISTS email_received_at;
+ALTER TABLE payment DROP COLUMN IF EXISTS source_thread_id;
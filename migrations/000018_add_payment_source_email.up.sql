@@ -0,0 +1,19 @@
+-- Link payments to the thread and LLM sync job of their email, for deep links to the original email
+-- and for reprocessing or deleting the payments of a message
+ALTER TABLE payment ADD COLUMN source_thread_id TEXT;
+ALTER TABLE payment ADD COLUMN email_received_at TIMESTAMPTZ;
+ALTER TABLE payment ADD COLUMN llm_sync_job_id TEXT;
+
+-- Payments outlive the job they were extracted by
+ALTER TABLE payment ADD CONSTRAINT fk_payment_llm_sync_job
+    FOREIGN KEY (llm_sync_job_id)
+    REFERENCES llm_sync_job(id)
+    ON DELETE SET NULL;
+
+-- Index for finding the payments of a thread
+CREATE INDEX idx_payment_source_thread
+    ON payment(account_id, source_thread_id);
+
+-- Index for finding the payments of an LLM sync job
+CREATE INDEX idx_payment_llm_sync_job_id
+    ON payment(llm_sync_job_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_payment_dedup;
+DROP INDEX IF EXISTS idx_payment_fingerprint;
+ALTER TABLE payment DROP COLUMN IF EXISTS fingerprint;
+ALTER TABLE payment DROP COLUMN IF EXISTS merchant_key;
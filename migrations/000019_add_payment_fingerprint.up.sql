@@ -0,0 +1,12 @@
+-- Deduplication: payments are upserted by fingerprint (account, merchant, amount, currency, date bucket, reference)
+-- and merged with payments of the same bill dated a few days apart (reminder and receipt)
+-- Payments stored before this migration have no fingerprint and are not matched
+ALTER TABLE payment ADD COLUMN merchant_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE payment ADD COLUMN fingerprint TEXT;
+
+CREATE UNIQUE INDEX idx_payment_fingerprint
+    ON payment(fingerprint);
+
+-- Index for finding duplicates within the date window
+CREATE INDEX idx_payment_dedup
+    ON payment(account_id, merchant_key, currency, amount, date);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payment_status_date;
+DROP TABLE IF EXISTS payment_status_history;
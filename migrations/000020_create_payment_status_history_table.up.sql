@@ -0,0 +1,29 @@
+-- Payment status history: every status change of a payment and its cause
+-- (extracted from an email, merged from a later email about the same payment, aged by the status job)
+CREATE TABLE payment_status_history (
+    id TEXT PRIMARY KEY,
+    payment_id TEXT NOT NULL,
+    from_status TEXT, -- NULL when the payment was created
+    to_status TEXT NOT NULL,
+    cause TEXT NOT NULL CHECK (cause IN ('extracted', 'merged', 'aged')),
+    source_message_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_payment_status_history_payment
+        FOREIGN KEY (payment_id)
+        REFERENCES payment(id)
+        ON DELETE CASCADE
+);
+
+-- Index for reading the history of a payment in order
+CREATE INDEX idx_payment_status_history_payment
+    ON payment_status_history(payment_id, created_at);
+
+-- Index for the aging job (scheduled, upcoming and due payments by date)
+CREATE INDEX idx_payment_status_date
+    ON payment(status, date);
+
+-- Start the history of existing payments with their current status
+INSERT INTO payment_status_history (id, payment_id, from_status, to_status, cause, source_message_id, created_at)
+SELECT gen_random_uuid()::text, id, NULL, status, 'extracted', source_message_id, created_at
+FROM payment;
@@ -0,0 +1,6 @@
+DELETE FROM payment_status_history WHERE cause IN ('reconciled', 'manual');
+ALTER TABLE payment_status_history DROP CONSTRAINT payment_status_history_cause_check;
+ALTER TABLE payment_status_history ADD CONSTRAINT payment_status_history_cause_check
+    CHECK (cause IN ('extracted', 'merged', 'aged'));
+
+DROP TABLE IF EXISTS payment_link;
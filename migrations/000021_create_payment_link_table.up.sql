@@ -0,0 +1,44 @@
+-- Reconciliation: links an obligation (bill, due payment) to the paid payment that settles it
+-- Suggested links wait for manual review, rejected links keep the pair from being linked again
+CREATE TABLE payment_link (
+    id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL,
+    obligation_id TEXT NOT NULL,
+    settlement_id TEXT NOT NULL,
+    settles TEXT NOT NULL CHECK (settles IN ('paid', 'partially_paid')),
+    confidence NUMERIC(3, 2) NOT NULL,
+    reasons TEXT,
+    status TEXT NOT NULL CHECK (status IN ('suggested', 'linked', 'rejected')),
+    source TEXT NOT NULL CHECK (source IN ('auto', 'manual')),
+    previous_status TEXT, -- Obligation status before the link, restored when it is rejected
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_payment_link_account
+        FOREIGN KEY (account_id)
+        REFERENCES account(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_payment_link_obligation
+        FOREIGN KEY (obligation_id)
+        REFERENCES payment(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_payment_link_settlement
+        FOREIGN KEY (settlement_id)
+        REFERENCES payment(id)
+        ON DELETE CASCADE,
+    CONSTRAINT uq_payment_link_pair
+        UNIQUE (obligation_id, settlement_id)
+);
+
+-- Index for finding the links of a settlement
+CREATE INDEX idx_payment_link_settlement
+    ON payment_link(settlement_id);
+
+-- Index for listing suggested links of an account for review
+CREATE INDEX idx_payment_link_account_status
+    ON payment_link(account_id, status);
+
+-- Status changes caused by reconciliation and manual overrides
+ALTER TABLE payment_status_history DROP CONSTRAINT payment_status_history_cause_check;
+ALTER TABLE payment_status_history ADD CONSTRAINT payment_status_history_cause_check
+    CHECK (cause IN ('extracted', 'merged', 'aged', 'reconciled', 'manual'));
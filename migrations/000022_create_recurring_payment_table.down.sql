@@ -0,0 +1,12 @@
+DELETE FROM payment WHERE projected;
+DELETE FROM payment_status_history WHERE cause = 'projected';
+ALTER TABLE payment_status_history DROP CONSTRAINT payment_status_history_cause_check;
+ALTER TABLE payment_status_history ADD CONSTRAINT payment_status_history_cause_check
+    CHECK (cause IN ('extracted', 'merged', 'aged', 'reconciled', 'manual'));
+
+DROP INDEX IF EXISTS idx_payment_recurring_payment_id;
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS fk_payment_recurring_payment;
+ALTER TABLE payment DROP COLUMN IF EXISTS recurring_payment_id;
+ALTER TABLE payment DROP COLUMN IF EXISTS projected;
+
+DROP TABLE IF EXISTS recurring_payment;
@@ -0,0 +1,50 @@
+-- Recurring payments detected from an account's payment history (one series per merchant and currency)
+-- and projected payments for their next occurrences
+CREATE TABLE recurring_payment (
+    id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL,
+    merchant_key TEXT NOT NULL,
+    merchant TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    recurrence TEXT NOT NULL CHECK (
+        recurrence IN ('daily', 'weekly', 'biweekly', 'monthly', 'bimonthly', 'quarterly', 'semiannual', 'annual')
+    ),
+    typical_amount NUMERIC(12, 2) NOT NULL,
+    last_amount NUMERIC(12, 2) NOT NULL,
+    fixed_amount BOOLEAN NOT NULL DEFAULT TRUE,
+    previous_amount NUMERIC(12, 2), -- Amount before the last price change
+    price_changed_at TIMESTAMPTZ,
+    day_of_month INTEGER,
+    occurrences INTEGER NOT NULL,
+    confidence NUMERIC(3, 2) NOT NULL,
+    last_date TIMESTAMPTZ NOT NULL,
+    next_date TIMESTAMPTZ,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_recurring_payment_account
+        FOREIGN KEY (account_id)
+        REFERENCES account(id)
+        ON DELETE CASCADE,
+    CONSTRAINT uq_recurring_payment_merchant
+        UNIQUE (account_id, merchant_key, currency)
+);
+
+-- Projected payments are scheduled payments not extracted from an email yet
+ALTER TABLE payment ADD COLUMN projected BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE payment ADD COLUMN recurring_payment_id TEXT;
+
+ALTER TABLE payment ADD CONSTRAINT fk_payment_recurring_payment
+    FOREIGN KEY (recurring_payment_id)
+    REFERENCES recurring_payment(id)
+    ON DELETE SET NULL;
+
+-- Index for finding the payments and projections of a series
+CREATE INDEX idx_payment_recurring_payment_id
+    ON payment(recurring_payment_id);
+
+-- Status history of projected payments
+ALTER TABLE payment_status_history DROP CONSTRAINT payment_status_history_cause_check;
+ALTER TABLE payment_status_history ADD CONSTRAINT payment_status_history_cause_check
+    CHECK (cause IN ('extracted', 'merged', 'aged', 'reconciled', 'manual', 'projected'));
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_payment_account_merchant_id;
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS fk_payment_merchant;
+ALTER TABLE payment DROP COLUMN IF EXISTS sender_domain;
+ALTER TABLE payment DROP COLUMN IF EXISTS merchant_id;
+
+DROP TABLE IF EXISTS merchant_domain;
+DROP TABLE IF EXISTS merchant_alias;
+DROP TABLE IF EXISTS merchant;
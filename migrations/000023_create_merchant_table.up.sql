@@ -0,0 +1,56 @@
+-- Canonical merchants: payments keep the merchant name as written in the email and refer to the merchant
+-- it normalises to (by alias, sender domain or fuzzy match)
+CREATE TABLE merchant (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    default_category TEXT CHECK (
+        default_category IN ('subscription', 'utility', 'emi', 'credit_card_bill', 'loan', 'insurance', 'rent', 'misc')
+        OR default_category IS NULL
+    ),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Normalised names of a merchant, each refers to one merchant
+CREATE TABLE merchant_alias (
+    alias TEXT PRIMARY KEY,
+    merchant_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_merchant_alias_merchant
+        FOREIGN KEY (merchant_id)
+        REFERENCES merchant(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_merchant_alias_merchant_id
+    ON merchant_alias(merchant_id);
+
+-- Sender domains of a merchant's emails, each belongs to one merchant
+CREATE TABLE merchant_domain (
+    domain TEXT PRIMARY KEY,
+    merchant_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_merchant_domain_merchant
+        FOREIGN KEY (merchant_id)
+        REFERENCES merchant(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_merchant_domain_merchant_id
+    ON merchant_domain(merchant_id);
+
+-- Canonical merchant of a payment (NULL until normalised, see kiwis-worker merchants backfill)
+-- and the domain of the email it was extracted from
+ALTER TABLE payment ADD COLUMN merchant_id TEXT;
+ALTER TABLE payment ADD COLUMN sender_domain TEXT;
+
+ALTER TABLE payment ADD CONSTRAINT fk_payment_merchant
+    FOREIGN KEY (merchant_id)
+    REFERENCES merchant(id)
+    ON DELETE SET NULL;
+
+-- Index for grouping an account's payments by canonical merchant
+CREATE INDEX idx_payment_account_merchant_id
+    ON payment(account_id, merchant_id);
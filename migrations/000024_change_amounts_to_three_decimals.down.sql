@@ -0,0 +1,6 @@
+-- Revert amounts to two decimals, third decimals are rounded
+ALTER TABLE recurring_payment ALTER COLUMN previous_amount TYPE NUMERIC(12, 2);
+ALTER TABLE recurring_payment ALTER COLUMN last_amount TYPE NUMERIC(12, 2);
+ALTER TABLE recurring_payment ALTER COLUMN typical_amount TYPE NUMERIC(12, 2);
+
+ALTER TABLE payment ALTER COLUMN amount TYPE NUMERIC(12, 2);
@@ -0,0 +1,7 @@
+-- Store amounts with three decimals: KWD, BHD and other currencies have minor units of a thousandth
+-- (models.CurrencyExponent); amounts are validated against their currency's decimals before they are stored
+ALTER TABLE payment ALTER COLUMN amount TYPE NUMERIC(15, 3);
+
+ALTER TABLE recurring_payment ALTER COLUMN typical_amount TYPE NUMERIC(15, 3);
+ALTER TABLE recurring_payment ALTER COLUMN last_amount TYPE NUMERIC(15, 3);
+ALTER TABLE recurring_payment ALTER COLUMN previous_amount TYPE NUMERIC(15, 3);
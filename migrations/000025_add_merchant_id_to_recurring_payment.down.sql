@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS uq_recurring_payment_merchant_key;
+DROP INDEX IF EXISTS uq_recurring_payment_merchant_id;
+
+-- Series of canonical merchants may share a merchant key, they are re-detected per merchant key on the next run
+DELETE FROM payment
+    WHERE projected AND recurring_payment_id IN (SELECT id FROM recurring_payment WHERE merchant_id IS NOT NULL);
+DELETE FROM recurring_payment WHERE merchant_id IS NOT NULL;
+
+ALTER TABLE recurring_payment ADD CONSTRAINT uq_recurring_payment_merchant
+    UNIQUE (account_id, merchant_key, currency);
+
+ALTER TABLE recurring_payment DROP CONSTRAINT IF EXISTS fk_recurring_payment_merchant;
+ALTER TABLE recurring_payment DROP COLUMN IF EXISTS merchant_id;
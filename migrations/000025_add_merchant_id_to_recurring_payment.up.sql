@@ -0,0 +1,20 @@
+-- Recurring payments are detected per canonical merchant when their payments have one, per merchant key otherwise:
+-- "Amazon" and "AMZN Mktp" are one series once normalised to the same merchant
+ALTER TABLE recurring_payment ADD COLUMN merchant_id TEXT;
+
+-- A series is re-detected from its payments, it goes with its merchant
+ALTER TABLE recurring_payment ADD CONSTRAINT fk_recurring_payment_merchant
+    FOREIGN KEY (merchant_id)
+    REFERENCES merchant(id)
+    ON DELETE CASCADE;
+
+-- One series per account, canonical merchant (or merchant key without one) and currency
+ALTER TABLE recurring_payment DROP CONSTRAINT uq_recurring_payment_merchant;
+
+CREATE UNIQUE INDEX uq_recurring_payment_merchant_id
+    ON recurring_payment(account_id, merchant_id, currency)
+    WHERE merchant_id IS NOT NULL;
+
+CREATE UNIQUE INDEX uq_recurring_payment_merchant_key
+    ON recurring_payment(account_id, merchant_key, currency)
+    WHERE merchant_id IS NULL;
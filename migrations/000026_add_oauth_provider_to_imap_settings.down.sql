@@ -0,0 +1 @@
+ALTER TABLE imap_settings DROP COLUMN IF EXISTS oauth_provider;
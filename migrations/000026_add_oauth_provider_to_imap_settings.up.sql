@@ -0,0 +1,4 @@
+-- XOAUTH2 access tokens expire after an hour, oauth_provider names the OAuth app refreshing them
+-- with the account's refresh token: 'google' (imap.gmail.com) or 'microsoft' (outlook.office365.com)
+ALTER TABLE imap_settings ADD COLUMN oauth_provider TEXT
+    CHECK (oauth_provider IN ('google', 'microsoft'));
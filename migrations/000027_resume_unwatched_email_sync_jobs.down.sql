@@ -0,0 +1,2 @@
+-- Nothing to undo, synced jobs without a watch are valid
+SELECT 1;
@@ -0,0 +1,4 @@
+-- Mailboxes without push notifications (IMAP, Microsoft Graph) were marked completed once caught up when a Pub/Sub
+-- topic was configured, and never synced again: they go back to periodic incremental sync
+UPDATE email_sync_job SET status = 'synced', updated_at = NOW()
+    WHERE status = 'completed' AND watch_expiration IS NULL;
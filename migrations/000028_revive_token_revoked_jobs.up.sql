@@ -0,0 +1,59 @@
+-- Email and LLM sync jobs failing with a revoked token are marked dead instead of being rescheduled forever
+-- Updating the account (e.g. user re-authenticates) revives them together with the account sync job
+CREATE OR REPLACE FUNCTION handle_account_sync_job()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        -- Create new account sync job for new account
+        INSERT INTO account_sync_job (id, account_id, status, created_at, updated_at)
+        VALUES (
+            gen_random_uuid()::text,
+            NEW.id,
+            'pending',
+            NOW(),
+            NOW()
+        );
+    ELSIF TG_OP = 'UPDATE' THEN
+        -- Reset existing account sync job to pending status
+        -- This will rerun the entire sync process from the beginning
+        UPDATE account_sync_job
+        SET status = 'pending',
+            attempts = 0,
+            next_attempt_at = NULL,
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id;
+
+        -- Revive email and LLM sync jobs that died on the revoked token, they resume where they stopped
+        UPDATE email_sync_job
+        SET status = 'pending',
+            attempts = 0,
+            next_attempt_at = NULL,
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id AND status = 'dead' AND last_error LIKE '%token revoked%';
+        IF FOUND THEN
+            PERFORM pg_notify('email_sync_job', NEW.id);
+        END IF;
+
+        UPDATE llm_sync_job
+        SET status = 'pending',
+            attempts = 0,
+            next_attempt_at = NULL,
+            last_error = NULL,
+            processed_at = NULL,
+            updated_at = NOW()
+        WHERE account_id = NEW.id AND status = 'dead' AND last_error LIKE '%token revoked%';
+        IF FOUND THEN
+            PERFORM pg_notify('llm_sync_job', NEW.id);
+        END IF;
+    END IF;
+
+    -- Wake up workers listening for account sync jobs (delivered on commit)
+    PERFORM pg_notify('account_sync_job', NEW.id);
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;